@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/rpc/v2/json2"
+)
+
+// Commit - a dot commit, as returned by DotmeshRPC.Commits
+type Commit struct {
+	Id       string
+	Metadata map[string]string
+}
+
+// Backend - the dot operations the driver is built on. rpcBackend talks to
+// the local dotmesh server, fakeBackend keeps everything in a directory so
+// the driver can be run against csi-sanity without ZFS.
+type Backend interface {
+	// Exists - returns the filesystem ID of the branch, or "" if the dot or
+	// branch doesn't exist. An empty branch means master.
+	Exists(namespace, name, branch string) (string, error)
+	Create(namespace, name string) error
+	Delete(namespace, name string) error
+	// Procure - makes sure the branch is available on this node and returns
+	// the path it's mounted at
+	Procure(namespace, name, branch string) (string, error)
+	Branches(namespace, name string) ([]string, error)
+	Branch(namespace, name, sourceBranch, newBranch, commitId string) error
+	Commit(namespace, name, branch, message string, metadata map[string]string) (string, error)
+	Commits(namespace, name, branch string) ([]Commit, error)
+	// List - returns the names of every dot, by namespace
+	List() (map[string][]string, error)
+	SetQuota(namespace, name string, limitBytes int64) error
+	// Quota - the dot's quota, 0 if it doesn't have one
+	Quota(namespace, name string) (int64, error)
+}
+
+type rpcBackend struct {
+	socketPath string
+}
+
+func newRPCBackend(socketPath string) *rpcBackend {
+	return &rpcBackend{socketPath: socketPath}
+}
+
+func (b *rpcBackend) Exists(namespace, name, branch string) (string, error) {
+	var result string
+	err := doRPC(b.socketPath, "DotmeshRPC.Exists", struct{ Namespace, Name, Branch string }{namespace, name, branch}, &result)
+	return result, err
+}
+
+func (b *rpcBackend) Create(namespace, name string) error {
+	var result bool
+	return doRPC(b.socketPath, "DotmeshRPC.Create", struct{ Namespace, Name string }{namespace, name}, &result)
+}
+
+func (b *rpcBackend) Delete(namespace, name string) error {
+	var result bool
+	return doRPC(b.socketPath, "DotmeshRPC.Delete", struct{ Namespace, Name string }{namespace, name}, &result)
+}
+
+func (b *rpcBackend) Procure(namespace, name, branch string) (string, error) {
+	if branch != "" {
+		// Procure takes the branch as part of the name
+		name = name + "@" + branch
+	}
+	var result string
+	err := doRPC(b.socketPath, "DotmeshRPC.Procure", struct {
+		Namespace string
+		Name      string
+		Subdot    string
+	}{namespace, name, ""}, &result)
+	return result, err
+}
+
+func (b *rpcBackend) Branches(namespace, name string) ([]string, error) {
+	var result []string
+	err := doRPC(b.socketPath, "DotmeshRPC.Branches", struct{ Namespace, Name string }{namespace, name}, &result)
+	return result, err
+}
+
+func (b *rpcBackend) Branch(namespace, name, sourceBranch, newBranch, commitId string) error {
+	if sourceBranch == "" {
+		sourceBranch = "master"
+	}
+	var result bool
+	return doRPC(b.socketPath, "DotmeshRPC.Branch", struct{ Namespace, Name, SourceBranch, NewBranchName, SourceCommitId string }{
+		namespace, name, sourceBranch, newBranch, commitId,
+	}, &result)
+}
+
+func (b *rpcBackend) Commit(namespace, name, branch, message string, metadata map[string]string) (string, error) {
+	var result string
+	err := doRPC(b.socketPath, "DotmeshRPC.Commit", struct {
+		Namespace string
+		Name      string
+		Branch    string
+		Message   string
+		Metadata  map[string]string
+	}{namespace, name, branch, message, metadata}, &result)
+	return result, err
+}
+
+func (b *rpcBackend) Commits(namespace, name, branch string) ([]Commit, error) {
+	var result []Commit
+	err := doRPC(b.socketPath, "DotmeshRPC.Commits", struct{ Namespace, Name, Branch string }{namespace, name, branch}, &result)
+	return result, err
+}
+
+func (b *rpcBackend) List() (map[string][]string, error) {
+	var result map[string]map[string]struct{}
+	err := doRPC(b.socketPath, "DotmeshRPC.List", struct{}{}, &result)
+	if err != nil {
+		return nil, err
+	}
+	dots := map[string][]string{}
+	for namespace, names := range result {
+		for name := range names {
+			dots[namespace] = append(dots[namespace], name)
+		}
+	}
+	return dots, nil
+}
+
+func (b *rpcBackend) SetQuota(namespace, name string, limitBytes int64) error {
+	var result bool
+	return doRPC(b.socketPath, "DotmeshRPC.SetQuota", struct {
+		Namespace  string
+		Name       string
+		LimitBytes int64
+	}{namespace, name, limitBytes}, &result)
+}
+
+func (b *rpcBackend) Quota(namespace, name string) (int64, error) {
+	var result struct{ LimitBytes int64 }
+	err := doRPC(b.socketPath, "DotmeshRPC.GetQuota", struct{ Namespace, Name string }{namespace, name}, &result)
+	return result.LimitBytes, err
+}
+
+// doRPC - JSON-RPC over the server's unix socket, as the flexvolume driver
+// does; requests over the socket are made as the admin user
+func doRPC(socketPath, method string, args interface{}, result interface{}) error {
+	message, err := json2.EncodeClientRequest(method, args)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", "http://unix-socket/rpc", bytes.NewBuffer(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Error reading body: %s", err)
+	}
+	err = json2.DecodeClientResponse(bytes.NewBuffer(b), result)
+	if err != nil {
+		return fmt.Errorf("%s: %s", method, err)
+	}
+	return nil
+}
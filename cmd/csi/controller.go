@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// commit metadata marking a clone as deleted, as there's no way to delete a
+// single branch of a dot
+const deletedCloneKey = "csi-deleted"
+
+// commit metadata marking the snapshot made as another commit as deleted, as
+// commits can't be deleted either
+const deletedSnapshotKey = "csi-deleted-snapshot"
+
+type controllerServer struct {
+	csi.UnimplementedControllerServer
+	driver *Driver
+}
+
+func (s *controllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	caps := []*csi.ControllerServiceCapability{}
+	for _, c := range []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+	} {
+		caps = append(caps, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{Type: c},
+			},
+		})
+	}
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: caps}, nil
+}
+
+// CreateVolume - creates a dot, or for volumes created from a snapshot or
+// another volume, a branch of the source's dot
+func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume name not set")
+	}
+	err := checkCapabilities(req.GetVolumeCapabilities())
+	if err != nil {
+		return nil, err
+	}
+	namespace := defaultNamespace
+	if ns, ok := req.GetParameters()["namespace"]; ok {
+		if !validName.MatchString(ns) {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid namespace %q", ns)
+		}
+		namespace = ns
+	}
+	capacity := req.GetCapacityRange().GetLimitBytes()
+	if capacity == 0 {
+		capacity = req.GetCapacityRange().GetRequiredBytes()
+	}
+	if capacity < req.GetCapacityRange().GetRequiredBytes() {
+		return nil, status.Error(codes.InvalidArgument, "Capacity limit is less than the required capacity")
+	}
+
+	name := dotName(req.GetName())
+	var vol volumeId
+	switch {
+	case req.GetVolumeContentSource().GetSnapshot() != nil:
+		source, commitId, err := parseSnapshotId(req.GetVolumeContentSource().GetSnapshot().GetSnapshotId())
+		if err != nil {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		commit, err := s.findCommit(source, commitId)
+		if err != nil {
+			return nil, err
+		}
+		vol, err = s.clone(source, name, commit.Id)
+		if err != nil {
+			return nil, err
+		}
+	case req.GetVolumeContentSource().GetVolume() != nil:
+		source, err := s.existingVolume(req.GetVolumeContentSource().GetVolume().GetVolumeId())
+		if err != nil {
+			return nil, err
+		}
+		vol, err = s.clone(source, name, "")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		vol = volumeId{Namespace: namespace, Name: name}
+		fsId, err := s.driver.backend.Exists(vol.Namespace, vol.Name, "")
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if fsId == "" {
+			err = s.driver.backend.Create(vol.Namespace, vol.Name)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "Unable to create dot %s: %s", vol, err)
+			}
+		} else {
+			// creating it again is fine, as long as it's asked for the same way
+			existing, err := s.driver.backend.Quota(vol.Namespace, vol.Name)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			if existing != capacity {
+				return nil, status.Errorf(codes.AlreadyExists,
+					"Volume %s already exists with a capacity of %d bytes", vol, existing)
+			}
+		}
+		// quotas are per dot, so clones share their origin's
+		if capacity > 0 {
+			err = s.driver.backend.SetQuota(vol.Namespace, vol.Name, capacity)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "Unable to set quota on dot %s: %s", vol, err)
+			}
+		}
+	}
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      vol.String(),
+			CapacityBytes: capacity,
+			ContentSource: req.GetVolumeContentSource(),
+		},
+	}, nil
+}
+
+// clone - branches the source volume at a commit, or at a new commit of its
+// current state if commitId is empty. Branching again with the same name
+// returns the existing branch, so retried requests are idempotent.
+func (s *controllerServer) clone(source volumeId, name, commitId string) (volumeId, error) {
+	vol := volumeId{Namespace: source.Namespace, Name: source.Name, Branch: name}
+	fsId, err := s.driver.backend.Exists(vol.Namespace, vol.Name, vol.Branch)
+	if err != nil {
+		return vol, status.Error(codes.Internal, err.Error())
+	}
+	if fsId != "" {
+		return vol, nil
+	}
+	if commitId == "" {
+		commitId, err = s.driver.backend.Commit(source.Namespace, source.Name, source.Branch,
+			fmt.Sprintf("Clone for CSI volume %s", name), nil)
+		if err != nil {
+			return vol, status.Errorf(codes.Internal, "Unable to commit %s to clone it: %s", source, err)
+		}
+	}
+	err = s.driver.backend.Branch(source.Namespace, source.Name, source.Branch, vol.Branch, commitId)
+	if err != nil {
+		return vol, status.Errorf(codes.Internal, "Unable to branch %s: %s", source, err)
+	}
+	return vol, nil
+}
+
+// DeleteVolume - deletes the dot. Clones can't be deleted on their own, so
+// they're marked as deleted and go when their dot does.
+func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not set")
+	}
+	vol, err := parseVolumeId(req.GetVolumeId())
+	if err != nil {
+		// can't be one of ours, so it's already gone
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	fsId, err := s.driver.backend.Exists(vol.Namespace, vol.Name, vol.Branch)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if fsId == "" {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if vol.Branch != "" {
+		deleted, err := s.isDeletedClone(vol)
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			_, err = s.driver.backend.Commit(vol.Namespace, vol.Name, vol.Branch,
+				"CSI volume deleted", map[string]string{deletedCloneKey: "true"})
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "Unable to mark %s as deleted: %s", vol, err)
+			}
+		}
+		log.Printf("Marked clone %s as deleted, it will be removed along with its dot", vol)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	branches, err := s.driver.backend.Branches(vol.Namespace, vol.Name)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	for _, branch := range branches {
+		deleted, err := s.isDeletedClone(volumeId{Namespace: vol.Namespace, Name: vol.Name, Branch: branch})
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"Volume %s has a clone on branch %s, which would be deleted along with it", vol, branch)
+		}
+	}
+	err = s.driver.backend.Delete(vol.Namespace, vol.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to delete dot %s: %s", vol, err)
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (s *controllerServer) isDeletedClone(vol volumeId) (bool, error) {
+	commits, err := s.driver.backend.Commits(vol.Namespace, vol.Name, vol.Branch)
+	if err != nil {
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	return len(commits) > 0 && commits[len(commits)-1].Metadata[deletedCloneKey] == "true", nil
+}
+
+func (s *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	_, err := s.existingVolume(req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume capabilities not set")
+	}
+	resp := &csi.ValidateVolumeCapabilitiesResponse{}
+	if checkCapabilities(req.GetVolumeCapabilities()) == nil {
+		resp.Confirmed = &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
+		}
+	}
+	return resp, nil
+}
+
+// CreateSnapshot - commits the volume, recording the snapshot's name in the
+// commit metadata so that retries find the same commit
+func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot name not set")
+	}
+	if req.GetSourceVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Source volume ID not set")
+	}
+	source, err := s.existingVolume(req.GetSourceVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.snapshots(func(commit Commit) bool {
+		return commit.Metadata[snapshotNameKey] == req.GetName()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		if existing[0].SourceVolumeId != source.String() {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"Snapshot %s already exists for volume %s", req.GetName(), existing[0].SourceVolumeId)
+		}
+		return &csi.CreateSnapshotResponse{Snapshot: existing[0]}, nil
+	}
+
+	commitId, err := s.driver.backend.Commit(source.Namespace, source.Name, source.Branch,
+		fmt.Sprintf("CSI snapshot %s", req.GetName()), map[string]string{snapshotNameKey: req.GetName()})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to commit %s: %s", source, err)
+	}
+	commit, err := s.findCommit(source, commitId)
+	if err != nil {
+		return nil, err
+	}
+	return &csi.CreateSnapshotResponse{Snapshot: toSnapshot(source, commit)}, nil
+}
+
+// DeleteSnapshot - commits are part of the dot's history and can't be
+// deleted, so the snapshot is marked as deleted by a later commit and no
+// longer listed, but the commit stays with its dot
+func (s *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if req.GetSnapshotId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot ID not set")
+	}
+	source, commitId, err := parseSnapshotId(req.GetSnapshotId())
+	if err != nil {
+		// can't be one of ours, so it's already gone
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+	_, err = s.findCommit(source, commitId)
+	if status.Code(err) == codes.NotFound {
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.driver.backend.Commit(source.Namespace, source.Name, source.Branch,
+		"CSI snapshot deleted", map[string]string{deletedSnapshotKey: commitId})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to mark snapshot %s as deleted: %s", req.GetSnapshotId(), err)
+	}
+	log.Printf("Marked snapshot %s as deleted, its commit is kept with its dot", req.GetSnapshotId())
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// ListSnapshots - lists the commits made by CreateSnapshot, or any commit
+// when asked for one by ID
+func (s *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	var snapshots []*csi.Snapshot
+	switch {
+	case req.GetSnapshotId() != "":
+		source, commitId, err := parseSnapshotId(req.GetSnapshotId())
+		if err != nil {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		commit, err := s.findCommit(source, commitId)
+		if status.Code(err) == codes.NotFound {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshots = []*csi.Snapshot{toSnapshot(source, commit)}
+	case req.GetSourceVolumeId() != "":
+		source, err := parseVolumeId(req.GetSourceVolumeId())
+		if err != nil {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		fsId, err := s.driver.backend.Exists(source.Namespace, source.Name, source.Branch)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if fsId == "" {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		commits, err := s.driver.backend.Commits(source.Namespace, source.Name, source.Branch)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		for _, commit := range snapshotCommits(commits) {
+			snapshots = append(snapshots, toSnapshot(source, commit))
+		}
+	default:
+		var err error
+		snapshots, err = s.snapshots(func(commit Commit) bool {
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	start := 0
+	if req.GetStartingToken() != "" {
+		var err error
+		start, err = strconv.Atoi(req.GetStartingToken())
+		if err != nil || start < 0 || start > len(snapshots) {
+			return nil, status.Errorf(codes.Aborted, "Invalid starting token %q", req.GetStartingToken())
+		}
+	}
+	end := len(snapshots)
+	nextToken := ""
+	if req.GetMaxEntries() > 0 && start+int(req.GetMaxEntries()) < end {
+		end = start + int(req.GetMaxEntries())
+		nextToken = strconv.Itoa(end)
+	}
+	resp := &csi.ListSnapshotsResponse{NextToken: nextToken}
+	for _, snapshot := range snapshots[start:end] {
+		resp.Entries = append(resp.Entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+	return resp, nil
+}
+
+// snapshots - walks every branch of every dot, returning the snapshots that
+// match in a stable order for paging through
+func (s *controllerServer) snapshots(match func(Commit) bool) ([]*csi.Snapshot, error) {
+	dots, err := s.driver.backend.List()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	vols := []volumeId{}
+	for namespace, names := range dots {
+		for _, name := range names {
+			vols = append(vols, volumeId{Namespace: namespace, Name: name})
+			branches, err := s.driver.backend.Branches(namespace, name)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			for _, branch := range branches {
+				vols = append(vols, volumeId{Namespace: namespace, Name: name, Branch: branch})
+			}
+		}
+	}
+	sort.Slice(vols, func(i, j int) bool { return vols[i].String() < vols[j].String() })
+
+	snapshots := []*csi.Snapshot{}
+	for _, vol := range vols {
+		commits, err := s.driver.backend.Commits(vol.Namespace, vol.Name, vol.Branch)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		for _, commit := range snapshotCommits(commits) {
+			if match(commit) {
+				snapshots = append(snapshots, toSnapshot(vol, commit))
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+// existingVolume - parses a volume ID, returning NotFound unless the dot or
+// branch exists
+func (s *controllerServer) existingVolume(id string) (volumeId, error) {
+	if id == "" {
+		return volumeId{}, status.Error(codes.InvalidArgument, "Volume ID not set")
+	}
+	vol, err := parseVolumeId(id)
+	if err != nil {
+		return vol, status.Error(codes.NotFound, err.Error())
+	}
+	fsId, err := s.driver.backend.Exists(vol.Namespace, vol.Name, vol.Branch)
+	if err != nil {
+		return vol, status.Error(codes.Internal, err.Error())
+	}
+	if fsId == "" {
+		return vol, status.Errorf(codes.NotFound, "Volume %s not found", vol)
+	}
+	return vol, nil
+}
+
+func (s *controllerServer) findCommit(vol volumeId, commitId string) (Commit, error) {
+	_, err := s.existingVolume(vol.String())
+	if err != nil {
+		return Commit{}, err
+	}
+	commits, err := s.driver.backend.Commits(vol.Namespace, vol.Name, vol.Branch)
+	if err != nil {
+		return Commit{}, status.Error(codes.Internal, err.Error())
+	}
+	deleted := deletedSnapshots(commits)
+	for _, commit := range commits {
+		if commit.Id == commitId && !deleted[commit.Id] {
+			return commit, nil
+		}
+	}
+	return Commit{}, status.Errorf(codes.NotFound, "Snapshot %s not found", snapshotId(vol, commitId))
+}
+
+// deletedSnapshots - the IDs of the commits DeleteSnapshot has marked as
+// deleted
+func deletedSnapshots(commits []Commit) map[string]bool {
+	deleted := map[string]bool{}
+	for _, commit := range commits {
+		if id := commit.Metadata[deletedSnapshotKey]; id != "" {
+			deleted[id] = true
+		}
+	}
+	return deleted
+}
+
+// snapshotCommits - the commits made by CreateSnapshot that haven't been
+// deleted since
+func snapshotCommits(commits []Commit) []Commit {
+	deleted := deletedSnapshots(commits)
+	snapshots := []Commit{}
+	for _, commit := range commits {
+		if commit.Metadata[snapshotNameKey] != "" && !deleted[commit.Id] {
+			snapshots = append(snapshots, commit)
+		}
+	}
+	return snapshots
+}
+
+func toSnapshot(vol volumeId, commit Commit) *csi.Snapshot {
+	snapshot := &csi.Snapshot{
+		SnapshotId:     snapshotId(vol, commit.Id),
+		SourceVolumeId: vol.String(),
+		ReadyToUse:     true,
+	}
+	nanos, err := strconv.ParseInt(commit.Metadata["timestamp"], 10, 64)
+	if err == nil {
+		snapshot.CreationTime = timestamppb.New(time.Unix(0, nanos))
+	}
+	return snapshot
+}
+
+// checkCapabilities - dots are filesystems that are mounted on one node at a
+// time, so block access and multi-node access modes aren't supported
+func checkCapabilities(caps []*csi.VolumeCapability) error {
+	if len(caps) == 0 {
+		return status.Error(codes.InvalidArgument, "Volume capabilities not set")
+	}
+	for _, c := range caps {
+		err := checkCapability(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkCapability(c *csi.VolumeCapability) error {
+	if c.GetBlock() != nil {
+		return status.Error(codes.InvalidArgument, "Block volumes are not supported")
+	}
+	if c.GetMount() == nil {
+		return status.Error(codes.InvalidArgument, "Volume access type not set")
+	}
+	switch c.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		return nil
+	}
+	return status.Errorf(codes.InvalidArgument, "Access mode %s is not supported", c.GetAccessMode().GetMode())
+}
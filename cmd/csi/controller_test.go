@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestController(t *testing.T) (*controllerServer, *fakeBackend) {
+	// nothing's procured, so the backend needs no directory
+	backend := newFakeBackend("")
+	return &controllerServer{driver: NewDriver("node-1", backend, newFakeMounter())}, backend
+}
+
+func createVolume(s *controllerServer, name string, source *csi.VolumeContentSource) (*csi.CreateVolumeResponse, error) {
+	return s.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:       name,
+		Parameters: map[string]string{"namespace": "apps"},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		VolumeContentSource: source,
+	})
+}
+
+func fromSnapshot(snapshotId string) *csi.VolumeContentSource {
+	return &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotId},
+		},
+	}
+}
+
+func snapshot(t *testing.T, s *controllerServer, name, volumeId string) string {
+	resp, err := s.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name: name, SourceVolumeId: volumeId,
+	})
+	if err != nil {
+		t.Fatalf("unable to snapshot %s: %s", volumeId, err)
+	}
+	return resp.GetSnapshot().GetSnapshotId()
+}
+
+// head - the latest commit on a branch
+func head(t *testing.T, b *fakeBackend, namespace, name, branch string) string {
+	commits, err := b.Commits(namespace, name, branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) == 0 {
+		return ""
+	}
+	return commits[len(commits)-1].Id
+}
+
+// TestCreateVolumeFromSnapshot - what the provisioner asks for when a claim's
+// dataSource is a VolumeSnapshot: a branch of the snapshotted dot, from the
+// snapshot's commit rather than the dot's latest
+func TestCreateVolumeFromSnapshot(t *testing.T) {
+	s, backend := newTestController(t)
+	resp, err := createVolume(s, "app-data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := resp.GetVolume().GetVolumeId()
+
+	before := snapshot(t, s, "before-migration", original)
+	_, commitId, err := parseSnapshotId(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backend.Commit("apps", "app-data", "", "migrated", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = createVolume(s, "app-data-restored", fromSnapshot(before))
+	if err != nil {
+		t.Fatalf("unable to restore %s: %s", before, err)
+	}
+	restored, err := parseVolumeId(resp.GetVolume().GetVolumeId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != (volumeId{Namespace: "apps", Name: "app-data", Branch: "app-data-restored"}) {
+		t.Errorf("expected a branch of apps/app-data, got %s", restored)
+	}
+	if got := head(t, backend, "apps", "app-data", "app-data-restored"); got != commitId {
+		t.Errorf("expected the branch to be made from %s, got %s", commitId, got)
+	}
+	if resp.GetVolume().GetContentSource().GetSnapshot().GetSnapshotId() != before {
+		t.Errorf("expected the volume to say it came from %s, got %v", before, resp.GetVolume().GetContentSource())
+	}
+
+	// retried requests get the same branch
+	resp, err = createVolume(s, "app-data-restored", fromSnapshot(before))
+	if err != nil {
+		t.Fatalf("unable to retry restoring %s: %s", before, err)
+	}
+	if resp.GetVolume().GetVolumeId() != restored.String() {
+		t.Errorf("expected the retry to get %s, got %s", restored, resp.GetVolume().GetVolumeId())
+	}
+
+	// snapshots of a restored volume are restored from its branch
+	_, err = backend.Commit("apps", "app-data", "app-data-restored", "fixed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := snapshot(t, s, "fixed", restored.String())
+	_, fixedId, err := parseSnapshotId(fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = createVolume(s, "app-data-fixed", fromSnapshot(fixed))
+	if err != nil {
+		t.Fatalf("unable to restore %s: %s", fixed, err)
+	}
+	if got := head(t, backend, "apps", "app-data", "app-data-fixed"); got != fixedId {
+		t.Errorf("expected the branch to be made from %s on the restored branch, got %s", fixedId, got)
+	}
+}
+
+func TestCreateVolumeFromMissingSnapshot(t *testing.T) {
+	s, backend := newTestController(t)
+	resp, err := createVolume(s, "app-data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol := resp.GetVolume().GetVolumeId()
+	deleted := snapshot(t, s, "deleted", vol)
+	_, err = s.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: deleted})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{
+		deleted,
+		vol + "#no-such-commit",
+		"apps/no-such-dot#commit-1",
+		"not a snapshot",
+	} {
+		_, err := createVolume(s, "restored", fromSnapshot(id))
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("%s: expected NotFound, got %v", id, err)
+		}
+	}
+	branches, err := backend.Branches("apps", "app-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 0 {
+		t.Errorf("expected no branches to be made, got %v", branches)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+)
+
+const (
+	driverName    = "dotmesh.io"
+	driverVersion = "0.1.0"
+
+	defaultNamespace = "admin"
+	// maximum length of a dot or branch name
+	maxNameLength = 64
+
+	// commit metadata recording which CSI snapshot a commit was taken for
+	snapshotNameKey = "csi-snapshot-name"
+)
+
+// matches the server's rules for namespace, dot and branch names
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,64}$`)
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+
+// Driver - a CSI plugin providing the identity, controller and node services
+// for dots. Volumes are dots (or, for clones, branches of them) and snapshots
+// are commits.
+type Driver struct {
+	nodeId  string
+	backend Backend
+	mounter Mounter
+}
+
+func NewDriver(nodeId string, backend Backend, mounter Mounter) *Driver {
+	return &Driver{
+		nodeId:  nodeId,
+		backend: backend,
+		mounter: mounter,
+	}
+}
+
+// Serve - serves all three services on endpoint, a unix:// URL or a path to
+// a socket, until the listener fails
+func (d *Driver) Serve(endpoint string) error {
+	listener, err := listen(endpoint)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer(grpc.UnaryInterceptor(logRequests))
+	csi.RegisterIdentityServer(server, &identityServer{driver: d})
+	csi.RegisterControllerServer(server, &controllerServer{driver: d})
+	csi.RegisterNodeServer(server, &nodeServer{driver: d})
+	log.Printf("Serving CSI driver %s on %s", driverName, endpoint)
+	return server.Serve(listener)
+}
+
+func listen(endpoint string) (net.Listener, error) {
+	path := endpoint
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "unix" {
+			return nil, fmt.Errorf("Unsupported endpoint scheme %q, expected unix", u.Scheme)
+		}
+		path = u.Path
+		if u.Host != "" {
+			path = u.Host + path
+		}
+	}
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Unable to remove stale socket %s: %s", path, err)
+	}
+	return net.Listen("unix", path)
+}
+
+func logRequests(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		log.Printf("%s: %+v -> %s", info.FullMethod, req, err)
+	}
+	return resp, err
+}
+
+// volumeId - identifies a volume as namespace/name for a dot, or
+// namespace/name@branch for a clone
+type volumeId struct {
+	Namespace string
+	Name      string
+	Branch    string
+}
+
+func (v volumeId) String() string {
+	if v.Branch == "" {
+		return v.Namespace + "/" + v.Name
+	}
+	return v.Namespace + "/" + v.Name + "@" + v.Branch
+}
+
+func parseVolumeId(id string) (volumeId, error) {
+	var v volumeId
+	shrapnel := strings.Split(id, "/")
+	if len(shrapnel) != 2 {
+		return v, fmt.Errorf("Invalid volume ID %q", id)
+	}
+	v.Namespace = shrapnel[0]
+	v.Name = shrapnel[1]
+	if i := strings.Index(v.Name, "@"); i >= 0 {
+		v.Branch = v.Name[i+1:]
+		v.Name = v.Name[:i]
+		if !validName.MatchString(v.Branch) {
+			return v, fmt.Errorf("Invalid volume ID %q", id)
+		}
+	}
+	if !validName.MatchString(v.Namespace) || !validName.MatchString(v.Name) {
+		return v, fmt.Errorf("Invalid volume ID %q", id)
+	}
+	return v, nil
+}
+
+// snapshotId - identifies a snapshot as the volume ID and commit ID,
+// separated by a #
+func snapshotId(v volumeId, commitId string) string {
+	return v.String() + "#" + commitId
+}
+
+func parseSnapshotId(id string) (volumeId, string, error) {
+	i := strings.LastIndex(id, "#")
+	if i < 0 || i == len(id)-1 {
+		return volumeId{}, "", fmt.Errorf("Invalid snapshot ID %q", id)
+	}
+	v, err := parseVolumeId(id[:i])
+	if err != nil {
+		return volumeId{}, "", fmt.Errorf("Invalid snapshot ID %q", id)
+	}
+	return v, id[i+1:], nil
+}
+
+// dotName - turns a CSI volume name, which can be any string, into a valid
+// dot or branch name. Names that are already valid are used as they are so
+// that volumes provisioned by Kubernetes (pvc-<uid>) are easy to recognise.
+func dotName(name string) string {
+	if validName.MatchString(name) {
+		return name
+	}
+	sum := fmt.Sprintf("%x", sha1.Sum([]byte(name)))[:12]
+	prefix := invalidNameChars.ReplaceAllString(name, "-")
+	if len(prefix) > maxNameLength-len(sum)-1 {
+		prefix = prefix[:maxNameLength-len(sum)-1]
+	}
+	return prefix + "-" + sum
+}
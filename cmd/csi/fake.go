@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fakeBackend - keeps dots in memory with a directory for each branch under
+// root, standing in for the server and ZFS when running csi-sanity
+type fakeBackend struct {
+	root string
+
+	mu     sync.Mutex
+	dots   map[string]*fakeDot
+	nextId int
+}
+
+type fakeDot struct {
+	quota    int64
+	branches map[string][]Commit
+}
+
+func newFakeBackend(root string) *fakeBackend {
+	return &fakeBackend{root: root, dots: map[string]*fakeDot{}}
+}
+
+func (b *fakeBackend) get(namespace, name, branch string) (*fakeDot, bool) {
+	dot, ok := b.dots[namespace+"/"+name]
+	if !ok {
+		return nil, false
+	}
+	if branch == "master" {
+		branch = ""
+	}
+	_, ok = dot.branches[branch]
+	return dot, ok
+}
+
+func (b *fakeBackend) Exists(namespace, name, branch string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.get(namespace, name, branch); !ok {
+		return "", nil
+	}
+	return volumeId{Namespace: namespace, Name: name, Branch: branch}.String(), nil
+}
+
+func (b *fakeBackend) Create(namespace, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.dots[namespace+"/"+name]; ok {
+		return fmt.Errorf("A volume called %s/%s already exists", namespace, name)
+	}
+	b.dots[namespace+"/"+name] = &fakeDot{branches: map[string][]Commit{"": {}}}
+	return nil
+}
+
+func (b *fakeBackend) Delete(namespace, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.dots[namespace+"/"+name]; !ok {
+		return fmt.Errorf("No such volume %s/%s", namespace, name)
+	}
+	delete(b.dots, namespace+"/"+name)
+	return os.RemoveAll(filepath.Join(b.root, namespace, name))
+}
+
+func (b *fakeBackend) Procure(namespace, name, branch string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.get(namespace, name, branch); !ok {
+		return "", fmt.Errorf("No such volume %s", volumeId{Namespace: namespace, Name: name, Branch: branch})
+	}
+	if branch == "" {
+		branch = "master"
+	}
+	path := filepath.Join(b.root, namespace, name, branch)
+	return path, os.MkdirAll(path, 0755)
+}
+
+func (b *fakeBackend) Branches(namespace, name string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dot, ok := b.get(namespace, name, "")
+	if !ok {
+		return nil, fmt.Errorf("No such volume %s/%s", namespace, name)
+	}
+	branches := []string{}
+	for branch := range dot.branches {
+		if branch != "" {
+			branches = append(branches, branch)
+		}
+	}
+	sort.Strings(branches)
+	return branches, nil
+}
+
+func (b *fakeBackend) Branch(namespace, name, sourceBranch, newBranch, commitId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dot, ok := b.get(namespace, name, sourceBranch)
+	if !ok {
+		return fmt.Errorf("No such branch %s", volumeId{Namespace: namespace, Name: name, Branch: sourceBranch})
+	}
+	if _, ok := dot.branches[newBranch]; ok {
+		return fmt.Errorf("Branch %s already exists", newBranch)
+	}
+	if sourceBranch == "master" {
+		sourceBranch = ""
+	}
+	for i, commit := range dot.branches[sourceBranch] {
+		if commit.Id == commitId {
+			dot.branches[newBranch] = append([]Commit{}, dot.branches[sourceBranch][:i+1]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("No such commit %s", commitId)
+}
+
+func (b *fakeBackend) Commit(namespace, name, branch, message string, metadata map[string]string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dot, ok := b.get(namespace, name, branch)
+	if !ok {
+		return "", fmt.Errorf("No such volume %s", volumeId{Namespace: namespace, Name: name, Branch: branch})
+	}
+	if branch == "master" {
+		branch = ""
+	}
+	b.nextId++
+	commit := Commit{
+		Id: fmt.Sprintf("commit-%d", b.nextId),
+		Metadata: map[string]string{
+			"message":   message,
+			"timestamp": strconv.FormatInt(time.Now().UnixNano(), 10),
+		},
+	}
+	for k, v := range metadata {
+		commit.Metadata[k] = v
+	}
+	dot.branches[branch] = append(dot.branches[branch], commit)
+	return commit.Id, nil
+}
+
+func (b *fakeBackend) Commits(namespace, name, branch string) ([]Commit, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dot, ok := b.get(namespace, name, branch)
+	if !ok {
+		return nil, fmt.Errorf("No such volume %s", volumeId{Namespace: namespace, Name: name, Branch: branch})
+	}
+	if branch == "master" {
+		branch = ""
+	}
+	return append([]Commit{}, dot.branches[branch]...), nil
+}
+
+func (b *fakeBackend) List() (map[string][]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dots := map[string][]string{}
+	for id := range b.dots {
+		vol, err := parseVolumeId(id)
+		if err != nil {
+			return nil, err
+		}
+		dots[vol.Namespace] = append(dots[vol.Namespace], vol.Name)
+	}
+	return dots, nil
+}
+
+func (b *fakeBackend) SetQuota(namespace, name string, limitBytes int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dot, ok := b.get(namespace, name, "")
+	if !ok {
+		return fmt.Errorf("No such volume %s/%s", namespace, name)
+	}
+	dot.quota = limitBytes
+	return nil
+}
+
+func (b *fakeBackend) Quota(namespace, name string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dot, ok := b.get(namespace, name, "")
+	if !ok {
+		return 0, fmt.Errorf("No such volume %s/%s", namespace, name)
+	}
+	return dot.quota, nil
+}
+
+// fakeMounter - records mounts without making them, so the fake backend
+// can be used without root
+type fakeMounter struct {
+	mu     sync.Mutex
+	mounts map[string]string
+}
+
+func newFakeMounter() *fakeMounter {
+	return &fakeMounter{mounts: map[string]string{}}
+}
+
+func (m *fakeMounter) Mount(source, target string, readonly bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mounts[target] = source
+	return nil
+}
+
+func (m *fakeMounter) Unmount(target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *fakeMounter) IsMounted(target string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.mounts[target]
+	return ok, nil
+}
@@ -0,0 +1,33 @@
+// CSI driver for dotmesh: provisions dots as Kubernetes volumes, maps
+// volume snapshots to commits and volume clones to branches, and mounts dots
+// into pods. Talks to the dotmesh server on the same node over its unix
+// socket, as the flexvolume driver it replaces does.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	hostname, _ := os.Hostname()
+
+	endpoint := flag.String("endpoint", "unix:///csi/csi.sock", "CSI endpoint to serve on")
+	nodeId := flag.String("node-id", hostname, "ID of the node the driver is running on")
+	socketPath := flag.String("dotmesh-socket", "/run/dotmesh/dm.sock", "Path to the dotmesh server's unix socket")
+	fakeRoot := flag.String("fake-backend", "", "Keep volumes in this directory instead of using dotmesh, for running csi-sanity")
+	flag.Parse()
+
+	var driver *Driver
+	if *fakeRoot != "" {
+		log.Printf("Using fake backend in %s", *fakeRoot)
+		driver = NewDriver(*nodeId, newFakeBackend(*fakeRoot), newFakeMounter())
+	} else {
+		driver = NewDriver(*nodeId, newRPCBackend(*socketPath), bindMounter{})
+	}
+	err := driver.Serve(*endpoint)
+	if err != nil {
+		log.Fatalf("Unable to serve CSI driver: %s", err)
+	}
+}
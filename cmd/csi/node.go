@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type nodeServer struct {
+	csi.UnimplementedNodeServer
+	driver *Driver
+}
+
+func (s *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: s.driver.nodeId}, nil
+}
+
+// NodeGetCapabilities - there's no staging step, dots are procured and bind
+// mounted straight into place by NodePublishVolume
+func (s *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: []*csi.NodeServiceCapability{}}, nil
+}
+
+func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not set")
+	}
+	if req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Target path not set")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability not set")
+	}
+	err := checkCapability(req.GetVolumeCapability())
+	if err != nil {
+		return nil, err
+	}
+	vol, err := parseVolumeId(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	fsId, err := s.driver.backend.Exists(vol.Namespace, vol.Name, vol.Branch)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if fsId == "" {
+		return nil, status.Errorf(codes.NotFound, "Volume %s not found", vol)
+	}
+
+	mounted, err := s.driver.mounter.IsMounted(req.GetTargetPath())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if mounted {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	// moves the dot (or branch) to this node if it's mastered elsewhere
+	source, err := s.driver.backend.Procure(vol.Namespace, vol.Name, vol.Branch)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to procure %s: %s", vol, err)
+	}
+	err = os.MkdirAll(req.GetTargetPath(), 0750)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = s.driver.mounter.Mount(source, req.GetTargetPath(), req.GetReadonly())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Unable to mount %s at %s: %s", vol, req.GetTargetPath(), err)
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not set")
+	}
+	if req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Target path not set")
+	}
+	mounted, err := s.driver.mounter.IsMounted(req.GetTargetPath())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if mounted {
+		err = s.driver.mounter.Unmount(req.GetTargetPath())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Unable to unmount %s: %s", req.GetTargetPath(), err)
+		}
+	}
+	err = os.Remove(req.GetTargetPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// Mounter - puts a procured dot in place for a pod
+type Mounter interface {
+	Mount(source, target string, readonly bool) error
+	Unmount(target string) error
+	IsMounted(target string) (bool, error)
+}
+
+// bindMounter - bind mounts the dot's mount point, as returned by Procure,
+// onto the target. Uses the syscalls directly as the image has no mount
+// binary.
+type bindMounter struct{}
+
+func (bindMounter) Mount(source, target string, readonly bool) error {
+	err := syscall.Mount(source, target, "", syscall.MS_BIND, "")
+	if err != nil {
+		return err
+	}
+	if !readonly {
+		return nil
+	}
+	// bind mounts take the source's flags, so read-only takes a remount
+	err = syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+	if err != nil {
+		syscall.Unmount(target, 0)
+		return err
+	}
+	return nil
+}
+
+func (bindMounter) Unmount(target string) error {
+	return syscall.Unmount(target, 0)
+}
+
+func (bindMounter) IsMounted(target string) (bool, error) {
+	target, err := filepath.EvalSymlinks(target)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[1] == target {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
+)
+
+// TestSanity - runs the csi-sanity suite against the driver, with the fake
+// backend and mounter standing in for dotmesh, ZFS and mount(8)
+func TestSanity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotmesh-csi")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	driver := NewDriver("node-1", newFakeBackend(filepath.Join(dir, "dots")), newFakeMounter())
+	endpoint := "unix://" + filepath.Join(dir, "csi.sock")
+	go driver.Serve(endpoint)
+
+	config := sanity.NewTestConfig()
+	config.Address = endpoint
+	config.TargetPath = filepath.Join(dir, "target")
+	config.StagingPath = filepath.Join(dir, "staging")
+	config.TestVolumeSize = 1 << 20
+	config.TestVolumeParameters = map[string]string{"namespace": "sanity"}
+	sanity.Test(t, config)
+}
@@ -32,6 +32,13 @@ func NewCmdBranch(out io.Writer) *cobra.Command {
 				if err != nil {
 					return err
 				}
+				if structuredOutput() {
+					listings := []branchListing{}
+					for _, branch := range bs {
+						listings = append(listings, branchListing{Name: branch, Current: branch == b})
+					}
+					return printStructured(out, listings)
+				}
 				for _, branch := range bs {
 					if branch == b {
 						branch = "* " + branch
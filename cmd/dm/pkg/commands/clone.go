@@ -50,7 +50,7 @@ Online help: https://docs.dotmesh.com/references/cli/#clone-dm-clone-local-name-
 				if err != nil {
 					return err
 				}
-				err = dm.PollTransfer(transferId, out)
+				err = pollTransfer(dm, transferId, out)
 				if err != nil {
 					return err
 				}
@@ -21,6 +21,7 @@ import (
 	"github.com/blang/semver"
 	"github.com/dotmesh-io/dotmesh/cmd/dm/pkg/pki"
 	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/spf13/cobra"
 )
 
@@ -54,6 +55,7 @@ const DISCOVERY_PASSWORD = "...ADMIN_PASSWORD"
 var (
 	serverCount        int
 	traceAddr          string
+	otlpEndpoint       string
 	logAddr            string
 	etcdInitialCluster string
 	offline            bool
@@ -67,6 +69,14 @@ var (
 	discoveryUrl       string
 	port               int
 	kernelZFSVersion   string
+	rotateNewCA        bool
+	rotateTrustNewCA   bool
+	rotateDropOldCA    bool
+	tlsEnabled         bool
+	tlsCertFile        string
+	tlsRollout         bool
+	tlsKeyFile         string
+	forceDrain         bool
 )
 
 // names of environment variables we pass from the content of `dm cluster {init,join}`
@@ -75,6 +85,7 @@ var (
 var inheritedEnvironment = []string{
 	"FILESYSTEM_METADATA_TIMEOUT",
 	"EXTRA_HOST_COMMANDS",
+	"METRICS_PER_DOT",
 }
 
 var timings map[string]float64
@@ -127,10 +138,19 @@ another.`,
 	cmd.AddCommand(NewCmdClusterBackupEtcd(os.Stdout))
 	cmd.AddCommand(NewCmdClusterRestoreEtcd(os.Stdout, os.Stdin))
 
+	cmd.AddCommand(NewCmdClusterDrain(os.Stdout))
+	cmd.AddCommand(NewCmdClusterUncordon(os.Stdout))
+
+	cmd.AddCommand(NewCmdClusterRotateCerts(os.Stdout))
+
 	cmd.PersistentFlags().StringVar(
 		&traceAddr, "trace", "",
 		"Hostname for Zipkin host to enable distributed tracing",
 	)
+	cmd.PersistentFlags().StringVar(
+		&otlpEndpoint, "otlp-endpoint", "",
+		"URL of an OpenTelemetry collector (e.g. http://collector:4318) to export traces to over OTLP",
+	)
 	cmd.PersistentFlags().StringVar(
 		&logAddr, "log", "",
 		"Hostname for dotmesh logs to be forwarded to enable log aggregation",
@@ -233,6 +253,472 @@ func NewCmdClusterRestoreEtcd(out io.Writer, in io.Reader) *cobra.Command {
 	return cmd
 }
 
+func NewCmdClusterDrain(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "drain <node>",
+		Short: "Move every branch master off a node, so it can be taken out of service",
+		Long: `Cordon a node of the cluster (the current remote), given by its id or one
+of its addresses, so that it doesn't procure any more dots, then move each of
+the branches it's the master of to whichever other node already has the most
+of its commits.
+
+Branches in use by containers on the node can't be moved, and are reported.
+Run 'dm cluster uncordon <node>' once the node is back in service.
+
+With --force, a branch whose master doesn't hand it off (because the node is
+unreachable, say) is moved anyway if the other node has all of its commits.
+Anything written to it since its last commit is lost.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := clusterDrain(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(
+		&forceDrain, "force", false,
+		"Move branches whose master doesn't hand them off, if another node has all their commits",
+	)
+	return cmd
+}
+
+func NewCmdClusterUncordon(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "uncordon <node>",
+		Short: "Allow a drained node to procure dots again",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := clusterUncordon(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
+// findNode - the id of the server given by id or address, and the ids of
+// every other server that's up. An id is taken over an address, which is
+// only taken if just one server has it.
+func findNode(servers []types.Server, node string) (string, []string, error) {
+	id := ""
+	byAddress := []string{}
+	for _, server := range servers {
+		if server.Id == node {
+			id = server.Id
+		}
+		for _, address := range server.Addresses {
+			if address != "" && address == node {
+				byAddress = append(byAddress, server.Id)
+				break
+			}
+		}
+	}
+	if id == "" {
+		switch len(byAddress) {
+		case 0:
+			return "", nil, fmt.Errorf("No node %s in the cluster", node)
+		case 1:
+			id = byAddress[0]
+		default:
+			return "", nil, fmt.Errorf("Nodes %s all have the address %s, please give the id of the one you mean", strings.Join(byAddress, ", "), node)
+		}
+	}
+	others := []string{}
+	for _, server := range servers {
+		if server.Id != id && len(server.Addresses) > 0 && server.Addresses[0] != "" {
+			others = append(others, server.Id)
+		}
+	}
+	return id, others, nil
+}
+
+func clusterDrain(cmd *cobra.Command, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Please specify a node to drain.")
+	}
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	vab, err := dm.DotsAndBranches()
+	if err != nil {
+		return err
+	}
+	node, others, err := findNode(vab.Servers, args[0])
+	if err != nil {
+		return err
+	}
+
+	names := map[string]string{}
+	for _, dot := range vab.Dots {
+		name := dot.MasterBranch.Name.StringWithoutAdmin()
+		names[dot.MasterBranch.Id] = name
+		for _, branch := range dot.OtherBranches {
+			names[branch.Id] = name + "@" + branch.Branch
+		}
+	}
+
+	fmt.Fprintf(out, "Cordoning node %s... ", node)
+	err = dm.Cordon(node)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "done.\n")
+
+	status, err := dm.NodeStatus(node)
+	if err != nil {
+		return err
+	}
+	if len(status.Masters) > 0 && len(others) == 0 {
+		return fmt.Errorf("There are no other nodes to move the %d branches on node %s to.", len(status.Masters), node)
+	}
+
+	failed := 0
+	for i, fsId := range status.Masters {
+		name, ok := names[fsId]
+		if !ok {
+			name = fsId
+		}
+		fmt.Fprintf(out, "Moving %s (%d/%d)... ", name, i+1, len(status.Masters))
+		target, err := dm.MoveBranchMaster(fsId, others, forceDrain)
+		if err != nil {
+			fmt.Fprintf(out, "failed: %s\n", err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "moved to %s.\n", target)
+	}
+
+	if len(status.RunningTransfers) > 0 {
+		fmt.Fprintf(out, "%d transfers started on node %s are still running.\n", len(status.RunningTransfers), node)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d branches couldn't be moved off node %s; it stays cordoned, run 'dm cluster drain %s' to try again.", failed, len(status.Masters), node, args[0])
+	}
+	fmt.Fprintf(out, "Node %s is drained. Run 'dm cluster uncordon %s' to put it back into service.\n", node, args[0])
+	return nil
+}
+
+func clusterUncordon(cmd *cobra.Command, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Please specify a node to uncordon.")
+	}
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	vab, err := dm.DotsAndBranches()
+	if err != nil {
+		return err
+	}
+	node, _, err := findNode(vab.Servers, args[0])
+	if err != nil {
+		return err
+	}
+	err = dm.Uncordon(node)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Node %s can procure dots again.\n", node)
+	return nil
+}
+
+func NewCmdClusterRotateCerts(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-certs",
+		Short: "Reissue the certificates of the dotmesh server and etcd on this node",
+		Long: `Reissue the server certificate that etcd and the dotmesh server on this node
+use, from the cluster's CA, then restart etcd and the dotmesh server to pick it
+up, waiting for each to be healthy again. Run it on each node in turn before
+the certificates expire, a year after they were issued. The old certificates
+are kept in a backup next to them.
+
+To rotate to a new CA as well, do each of these steps on every node, one node
+at a time, before starting the next step:
+
+1. 'dm cluster rotate-certs --new-ca' on one node, to make a new CA and hand
+   it to the cluster, and 'dm cluster rotate-certs --trust-new-ca' on the
+   others, so every node trusts both CAs.
+2. 'dm cluster rotate-certs', to reissue every node's certificate from the
+   new CA.
+3. 'dm cluster rotate-certs --drop-old-ca', so only the new CA is trusted.
+   The first node to do so deletes the new CA's key from etcd.
+
+The 'local' remote must be an admin of the cluster, and the cluster must serve
+TLS to rotate to a new CA, as its key is handed over through the API.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := clusterRotateCerts(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(
+		&rotateNewCA, "new-ca", false,
+		"Make a new CA to issue certificates from, and hand it to the other nodes",
+	)
+	cmd.Flags().BoolVar(
+		&rotateTrustNewCA, "trust-new-ca", false,
+		"Trust the new CA made with --new-ca on another node, and issue certificates from it",
+	)
+	cmd.Flags().BoolVar(
+		&rotateDropOldCA, "drop-old-ca", false,
+		"Stop trusting any CA but the one certificates are issued from",
+	)
+	return cmd
+}
+
+func clusterRotateCerts(cmd *cobra.Command, args []string, out io.Writer) error {
+	steps := 0
+	for _, step := range []bool{rotateNewCA, rotateTrustNewCA, rotateDropOldCA} {
+		if step {
+			steps++
+		}
+	}
+	if steps > 1 {
+		return fmt.Errorf("Please give only one of --new-ca, --trust-new-ca and --drop-old-ca.")
+	}
+
+	pkiPath := getPkiPath()
+	_, caCerts, err := pki.LoadCA(pkiPath)
+	if err != nil {
+		return fmt.Errorf("Unable to load the CA from %s, is this node in a cluster? %s", pkiPath, err)
+	}
+	dm, err := localDotmeshAPI()
+	if err != nil {
+		return err
+	}
+
+	backupPath := fmt.Sprintf("%s.bak-%s", pkiPath, time.Now().UTC().Format("20060102T150405Z"))
+	fmt.Fprintf(out, "Backing up PKI assets to %s... ", backupPath)
+	err = copyPKIAssets(pkiPath, backupPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "done.\n")
+
+	next := ""
+	err = func() error {
+		switch {
+		case rotateNewCA:
+			fmt.Fprintf(out, "Making a new CA... ")
+			err := pki.NewCA(pkiPath)
+			if err != nil {
+				return err
+			}
+			certPEM, keyPEM, err := pki.ReadCA(pkiPath)
+			if err != nil {
+				return err
+			}
+			err = dm.PublishCA(types.ClusterCA{Cert: string(certPEM), Key: string(keyPEM)})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "done.\n")
+			next = "Now run 'dm cluster rotate-certs --trust-new-ca' on each other node, one at a time."
+		case rotateTrustNewCA:
+			fmt.Fprintf(out, "Fetching the new CA... ")
+			ca, err := dm.PublishedCA()
+			if err != nil {
+				return err
+			}
+			err = pki.TrustCA(pkiPath, []byte(ca.Cert), []byte(ca.Key))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "done.\n")
+			next = "Once every node trusts the new CA, run 'dm cluster rotate-certs' on each, one at a time."
+		case rotateDropOldCA:
+			fmt.Fprintf(out, "Dropping old CAs... ")
+			dropped, err := pki.DropOldCAs(pkiPath)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "dropped %d.\n", dropped)
+		default:
+			fmt.Fprintf(out, "Reissuing certificates... ")
+			err := generatePKI(true)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "done.\n")
+			if len(caCerts) > 1 {
+				next = "Once every node's certificates have been reissued, run " +
+					"'dm cluster rotate-certs --drop-old-ca' on each, one at a time."
+			}
+		}
+		if err := refreshLocalRemoteCA(); err != nil {
+			return err
+		}
+		return restartForNewCerts(out)
+	}()
+	if err != nil {
+		fmt.Fprintf(out, "\nFailed, restoring PKI assets from %s... ", backupPath)
+		restoreErr := copyPKIAssets(backupPath, pkiPath)
+		if restoreErr == nil {
+			restoreErr = refreshLocalRemoteCA()
+		}
+		if restoreErr != nil {
+			return fmt.Errorf("%s (and unable to restore PKI assets: %s)", err, restoreErr)
+		}
+		fmt.Fprintf(out, "done. Once the problem is fixed, restart etcd and dotmesh on this node "+
+			"if they're unhealthy, and try again.\n")
+		return err
+	}
+
+	if rotateDropOldCA {
+		// every node has the new CA by now, so its key needn't stay in etcd.
+		// the server restarted, so talk to it afresh
+		dm, err = localDotmeshAPI()
+		if err == nil {
+			err = dm.UnpublishCA()
+		}
+		if err != nil {
+			return fmt.Errorf("Dropped old CAs, but unable to delete the new CA from etcd: %s", err)
+		}
+	}
+
+	expiries, err := pki.Expiries(pkiPath)
+	if err != nil {
+		return err
+	}
+	for _, e := range expiries {
+		fmt.Fprintf(out, "%s (%s) expires %s.\n", e.File, e.Subject, e.NotAfter.Local().Format(time.RFC1123))
+	}
+	if next != "" {
+		fmt.Fprintln(out, next)
+	}
+	return nil
+}
+
+// localDotmeshAPI - a client of the dotmesh server on this node
+func localDotmeshAPI() (*client.DotmeshAPI, error) {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return nil, err
+	}
+	if !dm.Configuration.RemoteExists("local") {
+		return nil, fmt.Errorf("You don't have a `local` remote in your Dotmesh configuration, so I cannot reach the dotmesh server on this node!")
+	}
+	dm.Client, err = dm.Configuration.ClusterFromRemote("local", verboseOutput)
+	if err != nil {
+		return nil, err
+	}
+	return dm, nil
+}
+
+// refreshLocalRemoteCA - has the 'local' remote trust the CAs in the PKI
+// directory, if it's trusting the cluster CA to check the server's TLS
+// certificate
+func refreshLocalRemoteCA() error {
+	config, err := client.NewConfiguration(configPath)
+	if err != nil {
+		return err
+	}
+	local, ok := config.GetRemotes()["local"]
+	if !ok || local.TLS == nil || local.TLS.CACert == "" {
+		return nil
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(getPkiPath(), "ca.pem"))
+	if err != nil {
+		return err
+	}
+	return config.SetRemoteTLS("local", &types.RemoteTLS{
+		CACert:      string(ca),
+		Fingerprint: local.TLS.Fingerprint,
+	})
+}
+
+func copyPKIAssets(from, to string) error {
+	err := os.MkdirAll(to, 0700)
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		c, err := ioutil.ReadFile(filepath.Join(from, file.Name()))
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(to, file.Name()), c, file.Mode())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restartForNewCerts - restarts etcd and then the dotmesh server on this
+// node, so they use the certificates in the PKI directory, waiting for the
+// dotmesh server to be able to read from etcd after each
+func restartForNewCerts(out io.Writer) error {
+	fmt.Fprintf(out, "Restarting etcd... ")
+	resp, err := exec.Command("docker", "restart", "dotmesh-etcd").CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(out, "response: %s\n", resp)
+		return err
+	}
+	err = waitForLocalCheck(out, 2*time.Minute)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "done.\n")
+
+	// stop the outer container first, or it'd start another inner one as
+	// soon as this one's gone
+	fmt.Fprintf(out, "Restarting dotmesh server... ")
+	for _, args := range [][]string{
+		{"stop", "dotmesh-server"},
+		{"rm", "-f", "dotmesh-server-inner"},
+		{"start", "dotmesh-server"},
+	} {
+		resp, err := exec.Command("docker", args...).CombinedOutput()
+		if err != nil {
+			fmt.Fprintf(out, "response: %s\n", resp)
+			return err
+		}
+	}
+	err = waitForLocalCheck(out, 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "done.\n")
+	return nil
+}
+
+// waitForLocalCheck - waits for the liveness check of the dotmesh server on
+// this node, which reads from etcd, to pass
+func waitForLocalCheck(out io.Writer, timeout time.Duration) error {
+	checkUrl := fmt.Sprintf("http://%s:%s/check", getHostFromEnv(), client.LIVENESS_PORT)
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(checkUrl)
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("%s", strings.TrimSpace(string(body)))
+		}
+		lastErr = err
+		fmt.Fprintf(out, ".")
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf(
+		"The dotmesh server on this node wasn't healthy after %s, please check "+
+			"`docker logs dotmesh-server-inner` and `docker logs dotmesh-etcd`: %s",
+		timeout, lastErr,
+	)
+}
+
 func NewCmdClusterInit(out io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "init",
@@ -259,6 +745,7 @@ func NewCmdClusterInit(out io.Writer) *cobra.Command {
 		&kernelZFSVersion, "zfs", "",
 		"Version of ZFS already available in the kernel (inhibits automatic loading and detection)",
 	)
+	addTLSFlags(cmd)
 	return cmd
 }
 
@@ -288,6 +775,7 @@ func NewCmdClusterJoin(out io.Writer) *cobra.Command {
 		&kernelZFSVersion, "zfs", "",
 		"Version of ZFS already available in the kernel (inhibits automatic loading and detection)",
 	)
+	addTLSFlags(cmd)
 	return cmd
 }
 
@@ -312,9 +800,33 @@ func NewCmdClusterUpgrade(out io.Writer) *cobra.Command {
 		&kernelZFSVersion, "zfs", "",
 		"Version of ZFS already available in the kernel (inhibits automatic loading and detection)",
 	)
+	addTLSFlags(cmd)
 	return cmd
 }
 
+// addTLSFlags - the flags for serving the API over TLS, which, like --port,
+// need giving again to 'dm cluster upgrade'
+func addTLSFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(
+		&tlsEnabled, "tls", false,
+		"Serve the API over TLS, with a certificate issued from the cluster CA",
+	)
+	cmd.Flags().StringVar(
+		&tlsCertFile, "tls-cert", "",
+		"PEM encoded certificate to serve the API over TLS with to clients "+
+			"connecting by a name it's valid for (implies --tls)",
+	)
+	cmd.Flags().StringVar(
+		&tlsKeyFile, "tls-key", "",
+		"PEM encoded private key of the --tls-cert certificate",
+	)
+	cmd.Flags().BoolVar(
+		&tlsRollout, "tls-rollout", false,
+		"While TLS is being turned on one node at a time, fall back to plain "+
+			"HTTP for nodes that haven't been seen serving it yet",
+	)
+}
+
 func NewCmdClusterReset(out io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "reset",
@@ -377,7 +889,7 @@ func clusterUpgrade(cmd *cobra.Command, args []string, out io.Writer) error {
 		return err
 	}
 	fmt.Printf("done.\n")
-	return nil
+	return setLocalRemoteTLS()
 }
 
 func clusterCommonPreflight() error {
@@ -569,6 +1081,9 @@ func startDotmeshContainer(pkiPath, adminKey, adminPassword string) error {
 	if traceAddr != "" {
 		fmt.Printf("Trace address: %s\n", traceAddr)
 	}
+	if otlpEndpoint != "" {
+		fmt.Printf("OTLP endpoint: %s\n", otlpEndpoint)
+	}
 	if logAddr != "" {
 		fmt.Printf("Log address: %s\n", logAddr)
 	}
@@ -598,6 +1113,7 @@ func startDotmeshContainer(pkiPath, adminKey, adminPassword string) error {
 		"-e", fmt.Sprintf("DOCKER_API_VERSION=%s", dockerApiVersion),
 		// Allow centralized tracing and logging.
 		"-e", fmt.Sprintf("TRACE_ADDR=%s", traceAddr),
+		"-e", fmt.Sprintf("OTEL_EXPORTER_OTLP_ENDPOINT=%s", otlpEndpoint),
 		"-e", fmt.Sprintf("LOG_ADDR=%s", logAddr),
 		// Set env var so that sub-container executor can bind-mount the right
 		// certificates in.
@@ -621,6 +1137,22 @@ func startDotmeshContainer(pkiPath, adminKey, adminPassword string) error {
 		args = append(args, "-e")
 		args = append(args, fmt.Sprintf("KERNEL_ZFS_VERSION=%s", kernelZFSVersion))
 	}
+	err := installTLSCertificate(pkiPath)
+	if err != nil {
+		return err
+	}
+	if tlsEnabled || tlsCertFile != "" {
+		args = append(args, "-e", "DOTMESH_TLS=1")
+	}
+	if tlsCertFile != "" {
+		args = append(args,
+			"-e", "DOTMESH_TLS_CERT_FILE=/pki/tls.pem",
+			"-e", "DOTMESH_TLS_KEY_FILE=/pki/tls-key.pem",
+		)
+	}
+	if tlsRollout {
+		args = append(args, "-e", "DOTMESH_TLS_ROLLOUT=1")
+	}
 
 	// inject the inherited env variables from the context of the dm binary into require_zfs.sh
 	for _, envName := range inheritedEnvironment {
@@ -646,6 +1178,76 @@ func startDotmeshContainer(pkiPath, adminKey, adminPassword string) error {
 	return nil
 }
 
+// installTLSCertificate - copies the --tls-cert certificate and key into the
+// PKI directory, which the server sees as /pki, or removes any left from a
+// previous run without. It's done as the server is started rather than with
+// the rest of the PKI assets so that the key isn't shared with other nodes
+func installTLSCertificate(pkiPath string) error {
+	certPath := filepath.Join(pkiPath, "tls.pem")
+	keyPath := filepath.Join(pkiPath, "tls-key.pem")
+	if tlsCertFile == "" {
+		if tlsKeyFile != "" {
+			return fmt.Errorf("Please give --tls-cert with --tls-key.")
+		}
+		for _, p := range []string{certPath, keyPath} {
+			err := os.Remove(p)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	if tlsKeyFile == "" {
+		return fmt.Errorf("Please give --tls-key with --tls-cert.")
+	}
+	_, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load the certificate in %s: %s", tlsCertFile, err)
+	}
+	for from, to := range map[string]string{tlsCertFile: certPath, tlsKeyFile: keyPath} {
+		c, err := ioutil.ReadFile(from)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(to, c, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localRemoteTLS - how the dm CLI checks the certificate of the server on
+// this node, nil if it doesn't serve TLS. dm connects to it by address, so
+// it's presented the certificate issued from the cluster CA
+func localRemoteTLS() (*types.RemoteTLS, error) {
+	if !tlsEnabled && tlsCertFile == "" {
+		return nil, nil
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(getPkiPath(), "ca.pem"))
+	if err != nil {
+		return nil, err
+	}
+	return &types.RemoteTLS{CACert: string(ca)}, nil
+}
+
+// setLocalRemoteTLS - has the 'local' remote check the server's certificate
+// as localRemoteTLS says
+func setLocalRemoteTLS() error {
+	config, err := client.NewConfiguration(configPath)
+	if err != nil {
+		return err
+	}
+	if !config.RemoteExists("local") {
+		return nil
+	}
+	t, err := localRemoteTLS()
+	if err != nil {
+		return err
+	}
+	return config.SetRemoteTLS("local", t)
+}
+
 // exists returns whether the given file or directory exists or not
 func exists(path string) (bool, error) {
 	_, err := os.Stat(path)
@@ -673,7 +1275,11 @@ func saveLocalAuthDetails(configPath, adminPassword, adminKey string) error {
 			return err
 		}
 	}
-	err = config.AddRemote("local", "admin", getHostFromEnv(), port, adminKey)
+	t, err := localRemoteTLS()
+	if err != nil {
+		return err
+	}
+	err = config.AddRemote("local", "admin", getHostFromEnv(), port, adminKey, t)
 	if err != nil {
 		return err
 	}
@@ -838,6 +1444,10 @@ func clusterCommonSetup(clusterUrl, adminPassword, adminKey, pkiPath string) err
 	if err != nil {
 		return err
 	}
+	err = setLocalRemoteTLS()
+	if err != nil {
+		return err
+	}
 
 	// - Start dotmesh-server.
 	fmt.Printf("Starting dotmesh server... ")
@@ -944,8 +1554,10 @@ func clusterReset(cmd *cobra.Command, args []string, out io.Writer) error {
 	fmt.Printf("done.\n")
 	fmt.Printf("Deleting cached PKI assets... ")
 	pkiPath := getPkiPath()
+	// and the backups 'dm cluster rotate-certs' made
+	backups, _ := filepath.Glob(pkiPath + ".bak-*")
 	clientVersion, err := exec.Command(
-		"rm", "-rf", pkiPath,
+		"rm", append([]string{"-rf", pkiPath}, backups...)...,
 	).CombinedOutput()
 	if err != nil {
 		fmt.Printf("response: %s\n", clientVersion)
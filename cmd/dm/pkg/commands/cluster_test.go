@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestFindNode(t *testing.T) {
+	servers := []types.Server{
+		{Id: "node-a", Addresses: []string{"10.0.0.1", "192.168.0.1"}},
+		{Id: "node-b", Addresses: []string{"10.0.0.2", "192.168.0.1"}},
+		// a node whose id is another node's address
+		{Id: "10.0.0.2", Addresses: []string{"10.0.0.3"}},
+		// down
+		{Id: "node-d", Addresses: []string{""}},
+		{Id: "node-e"},
+	}
+	tests := []struct {
+		name   string
+		node   string
+		want   string
+		others []string
+		err    bool
+	}{
+		{"by id", "node-a", "node-a", []string{"node-b", "10.0.0.2"}, false},
+		{"by address", "10.0.0.1", "node-a", []string{"node-b", "10.0.0.2"}, false},
+		{"by second address", "10.0.0.3", "10.0.0.2", []string{"node-a", "node-b"}, false},
+		{"id over address", "10.0.0.2", "10.0.0.2", []string{"node-a", "node-b"}, false},
+		{"shared address", "192.168.0.1", "", nil, true},
+		{"down", "node-d", "node-d", []string{"node-a", "node-b", "10.0.0.2"}, false},
+		{"missing", "node-z", "", nil, true},
+		{"no address", "", "", nil, true},
+	}
+	for _, tt := range tests {
+		id, others, err := findNode(servers, tt.node)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", tt.name, id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if id != tt.want || strings.Join(others, ",") != strings.Join(tt.others, ",") {
+			t.Errorf("%s: expected %s and %v, got %s and %v", tt.name, tt.want, tt.others, id, others)
+		}
+	}
+}
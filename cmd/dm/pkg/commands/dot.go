@@ -6,9 +6,11 @@ import (
 	"io"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/spf13/cobra"
 )
 
@@ -89,6 +91,153 @@ func NewCmdDotDelete(out io.Writer) *cobra.Command {
 	return cmd
 }
 
+func NewCmdDotSetQuota(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set-quota",
+		Short: "Set the storage quota for a dot or a namespace (admin only)",
+		Long:  "Online help: https://docs.dotmesh.com/references/cli/#FIXME",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			err := dotSetQuota(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(
+		&quotaNamespace, "namespace", "n", "",
+		"set the quota for the whole of this namespace rather than for a dot.",
+	)
+	return cmd
+}
+
+func NewCmdDotAddHook(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add-hook",
+		Short: "Add a policy hook that can reject commits or pushes of a dot",
+		Long:  "Online help: https://docs.dotmesh.com/references/cli/#FIXME",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			err := dotAddHook(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(
+		&hookURL, "url", "",
+		"POST the commit or push to this URL, any 2xx response allows it.",
+	)
+	cmd.Flags().IntVar(
+		&hookTimeout, "timeout", 0,
+		"seconds to wait for the hook before rejecting, defaults to 60, at most 120.",
+	)
+	return cmd
+}
+
+func NewCmdDotHooks(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hooks",
+		Short: "List the policy hooks of a dot",
+		Long:  "Online help: https://docs.dotmesh.com/references/cli/#FIXME",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			err := dotHooks(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
+func NewCmdDotRemoveHook(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove-hook",
+		Short: "Remove a policy hook from a dot",
+		Long:  "Online help: https://docs.dotmesh.com/references/cli/#FIXME",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			err := dotRemoveHook(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
+func NewCmdDotProtect(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "protect",
+		Short: "Set the protection rules of a branch of a dot",
+		Long:  "Online help: https://docs.dotmesh.com/references/cli/#FIXME",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			err := dotProtect(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(
+		&protectNoRollback, "no-rollback", false,
+		"refuse to roll back commits on the branch ('dm reset --hard').",
+	)
+	cmd.Flags().BoolVar(
+		&protectNoDelete, "no-delete", false,
+		"refuse to delete the branch, or the dot it's part of.",
+	)
+	cmd.Flags().BoolVar(
+		&protectNoForcePush, "no-force-push", false,
+		"refuse pushes and pulls that stash commits the branch has diverged with.",
+	)
+	cmd.Flags().StringSliceVar(
+		&protectRequiredMetadata, "require-metadata", nil,
+		"metadata keys new commits on the branch must have, comma separated.",
+	)
+	return cmd
+}
+
+func NewCmdDotUnprotect(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unprotect",
+		Short: "Remove the protection rules of a branch of a dot",
+		Long:  "Online help: https://docs.dotmesh.com/references/cli/#FIXME",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			err := dotUnprotect(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
+func NewCmdDotProtections(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "protections",
+		Short: "List the protection rules of the branches of a dot",
+		Long:  "Online help: https://docs.dotmesh.com/references/cli/#FIXME",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			err := dotProtections(cmd, args, out)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
 func NewCmdDot(out io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "dot",
@@ -100,6 +249,28 @@ change the default remote dot for <dot> on <remote>.
 
 Run 'dm dot show [<dot>]' to show information about the dot.
 
+Run 'dm dot set-quota [<dot>] <bytes>' to limit the total size of all
+of the dot's branches, or 'dm dot set-quota -n <namespace> <bytes>' to
+limit the total size of all the dots in a namespace. A limit of 0
+removes the quota. The total is checked when data is pushed or uploaded
+to the dot; writes from containers are only capped per branch, each
+branch being allowed up to the dot's whole limit.
+
+Run 'dm dot add-hook [<dot>] <pre-commit|pre-push> --url=<url>' to have
+commits or pushes of the dot checked by POSTing them to <url>, or
+'dm dot add-hook [<dot>] <pre-commit|pre-push> -- <command> [<args>...]'
+to check them by running <command> on the server (admin only). Hooks
+can reject commits and pushes, with a message that's shown to the user.
+'dm dot hooks [<dot>]' lists hooks and 'dm dot remove-hook [<dot>] <id>'
+removes one.
+
+Run 'dm dot protect [<dot>] <branch> [--no-rollback] [--no-delete]
+[--no-force-push] [--require-metadata=<key>,...]' to protect a branch from
+being rolled back, deleted or having diverged commits stashed by a push or
+pull, or to require metadata on its new commits. The rules apply to
+everyone, admins included. 'dm dot protections [<dot>]' lists them and
+'dm dot unprotect [<dot>] <branch>' removes them.
+
 Where '[<dot>]' is omitted, the current dot (selected by 'dm switch')
 is used.`,
 	}
@@ -108,6 +279,13 @@ is used.`,
 	cmd.AddCommand(NewCmdDotShow(os.Stdout))
 	cmd.AddCommand(NewCmdDotDelete(os.Stdout))
 	cmd.AddCommand(NewCmdDotForceBranchMaster(os.Stdout))
+	cmd.AddCommand(NewCmdDotSetQuota(os.Stdout))
+	cmd.AddCommand(NewCmdDotAddHook(os.Stdout))
+	cmd.AddCommand(NewCmdDotHooks(os.Stdout))
+	cmd.AddCommand(NewCmdDotRemoveHook(os.Stdout))
+	cmd.AddCommand(NewCmdDotProtect(os.Stdout))
+	cmd.AddCommand(NewCmdDotUnprotect(os.Stdout))
+	cmd.AddCommand(NewCmdDotProtections(os.Stdout))
 
 	return cmd
 }
@@ -155,6 +333,218 @@ func dotSetUpstream(cmd *cobra.Command, args []string, out io.Writer) error {
 	return nil
 }
 
+func dotSetQuota(cmd *cobra.Command, args []string, out io.Writer) error {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+
+	var namespace, dot, limit string
+
+	switch {
+	case quotaNamespace != "" && len(args) == 1:
+		namespace = quotaNamespace
+		limit = args[0]
+	case quotaNamespace == "" && len(args) == 1:
+		dot, err = dm.CurrentVolume()
+		if err != nil {
+			return err
+		}
+		limit = args[0]
+	case quotaNamespace == "" && len(args) == 2:
+		dot = args[0]
+		limit = args[1]
+	default:
+		return fmt.Errorf("Please specify [<dot>] <bytes>, or -n <namespace> <bytes> as arguments.")
+	}
+
+	limitBytes, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil || limitBytes < 0 {
+		return fmt.Errorf("Quota must be a whole number of bytes, got %q", limit)
+	}
+
+	if dot != "" {
+		namespace, dot, err = client.ParseNamespacedVolume(dot)
+		if err != nil {
+			return err
+		}
+	}
+
+	return dm.SetQuota(namespace, dot, limitBytes)
+}
+
+// dotOrCurrent - the dot named in args if there are wantArgs+1 of them,
+// otherwise the current dot, along with the remaining args
+func dotOrCurrent(dm *client.DotmeshAPI, args []string, wantArgs int) (string, string, []string, error) {
+	var dot string
+	var err error
+	switch len(args) {
+	case wantArgs:
+		dot, err = dm.CurrentVolume()
+		if err != nil {
+			return "", "", nil, err
+		}
+		if dot == "" {
+			return "", "", nil, fmt.Errorf("No current dot. Try 'dm switch' or name a dot.")
+		}
+	case wantArgs + 1:
+		dot = args[0]
+		args = args[1:]
+	default:
+		return "", "", nil, fmt.Errorf("Unexpected number of arguments, see 'dm dot --help'.")
+	}
+	namespace, name, err := client.ParseNamespacedVolume(dot)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return namespace, name, args, nil
+}
+
+func dotAddHook(cmd *cobra.Command, args []string, out io.Writer) error {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+
+	var command []string
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		command = args[dash:]
+		args = args[:dash]
+	}
+	if (hookURL == "") == (len(command) == 0) {
+		return fmt.Errorf("Please specify either --url=<url> or -- <command> [<args>...] for the hook.")
+	}
+
+	namespace, name, args, err := dotOrCurrent(dm, args, 1)
+	if err != nil {
+		return err
+	}
+
+	hook, err := dm.AddHook(namespace, name, args[0], hookURL, command, hookTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Added %s hook %s\n", hook.Stage, hook.Id)
+	return nil
+}
+
+func dotHooks(cmd *cobra.Command, args []string, out io.Writer) error {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	namespace, name, _, err := dotOrCurrent(dm, args, 0)
+	if err != nil {
+		return err
+	}
+	hooks, err := dm.Hooks(namespace, name)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].Stage != hooks[j].Stage {
+			return hooks[i].Stage < hooks[j].Stage
+		}
+		return hooks[i].Id < hooks[j].Id
+	})
+	for _, hook := range hooks {
+		check := hook.URL
+		if check == "" {
+			check = strings.Join(hook.Command, " ")
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\n", hook.Id, hook.Stage, check)
+	}
+	return nil
+}
+
+func dotRemoveHook(cmd *cobra.Command, args []string, out io.Writer) error {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	namespace, name, args, err := dotOrCurrent(dm, args, 1)
+	if err != nil {
+		return err
+	}
+	return dm.DeleteHook(namespace, name, args[0])
+}
+
+func dotProtect(cmd *cobra.Command, args []string, out io.Writer) error {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	namespace, name, args, err := dotOrCurrent(dm, args, 1)
+	if err != nil {
+		return err
+	}
+	protection := types.BranchProtection{
+		NoRollback:       protectNoRollback,
+		NoDelete:         protectNoDelete,
+		NoForcePush:      protectNoForcePush,
+		RequiredMetadata: protectRequiredMetadata,
+	}
+	if protection.IsZero() {
+		return fmt.Errorf("Please specify the rules to protect the branch with, or use 'dm dot unprotect' to remove them.")
+	}
+	return dm.SetBranchProtection(namespace, name, args[0], protection)
+}
+
+func dotUnprotect(cmd *cobra.Command, args []string, out io.Writer) error {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	namespace, name, args, err := dotOrCurrent(dm, args, 1)
+	if err != nil {
+		return err
+	}
+	return dm.SetBranchProtection(namespace, name, args[0], types.BranchProtection{})
+}
+
+func dotProtections(cmd *cobra.Command, args []string, out io.Writer) error {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	namespace, name, _, err := dotOrCurrent(dm, args, 0)
+	if err != nil {
+		return err
+	}
+	protections, err := dm.BranchProtections(namespace, name)
+	if err != nil {
+		return err
+	}
+	if structuredOutput() {
+		return printStructured(out, protections)
+	}
+
+	branches := []string{}
+	for branch := range protections {
+		branches = append(branches, branch)
+	}
+	sort.Strings(branches)
+	for _, branch := range branches {
+		p := protections[branch]
+		rules := []string{}
+		if p.NoRollback {
+			rules = append(rules, types.ProtectionNoRollback)
+		}
+		if p.NoDelete {
+			rules = append(rules, types.ProtectionNoDelete)
+		}
+		if p.NoForcePush {
+			rules = append(rules, types.ProtectionNoForcePush)
+		}
+		if len(p.RequiredMetadata) > 0 {
+			rules = append(rules, types.ProtectionRequiredMetadata+"="+strings.Join(p.RequiredMetadata, ","))
+		}
+		fmt.Fprintf(out, "%s\t%s\n", branch, strings.Join(rules, " "))
+	}
+	return nil
+}
+
 func branchSetMaster(cmd *cobra.Command, args []string, out io.Writer) error {
 	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
 	if err != nil {
@@ -251,6 +641,13 @@ func dotShow(cmd *cobra.Command, args []string, out io.Writer) error {
 	if err != nil {
 		return err
 	}
+	if structuredOutput() {
+		details, err := getDotDetails(dm, qualifiedDotName, namespace, dot)
+		if err != nil {
+			return err
+		}
+		return printStructured(out, details)
+	}
 	if scriptingMode {
 		fmt.Fprintf(out, "namespace\t%s\n", namespace)
 		fmt.Fprintf(out, "name\t%s\n", dot)
@@ -269,6 +666,18 @@ func dotShow(cmd *cobra.Command, args []string, out io.Writer) error {
 		fmt.Fprintf(out, "Master branch ID: %s\n", masterDot.Id)
 	}
 
+	if masterDot.ForkParentId != "" {
+		upstream, err := dm.ForkUpstream(namespace, dot)
+		if err != nil {
+			return err
+		}
+		if scriptingMode {
+			fmt.Fprintf(out, "upstream\t%s\n", upstream)
+		} else {
+			fmt.Fprintf(out, "Forked from: %s\n", upstream)
+		}
+	}
+
 	activeQualified, err := dm.CurrentVolume()
 	if err != nil {
 		return err
@@ -429,3 +838,117 @@ func dotShow(cmd *cobra.Command, args []string, out io.Writer) error {
 	}
 	return nil
 }
+
+// getDotDetails - what 'dm dot show' shows, for structured output
+func getDotDetails(dm *client.DotmeshAPI, qualifiedDotName, namespace, dot string) (dotDetails, error) {
+	masterDot, err := dm.BranchInfo(namespace, dot, "")
+	if err != nil {
+		return dotDetails{}, err
+	}
+	details := dotDetails{
+		Namespace:        namespace,
+		Name:             dot,
+		MasterBranchId:   masterDot.Id,
+		CommitCount:      masterDot.CommitCount,
+		SizeBytes:        masterDot.SizeBytes,
+		DirtyBytes:       masterDot.DirtyBytes,
+		Branches:         []branchDetails{},
+		DefaultUpstreams: []defaultUpstream{},
+	}
+
+	if masterDot.ForkParentId != "" {
+		upstream, err := dm.ForkUpstream(namespace, dot)
+		if err != nil {
+			return dotDetails{}, err
+		}
+		details.Upstream = &upstream
+	}
+
+	activeQualified, err := dm.CurrentVolume()
+	if err != nil {
+		return dotDetails{}, err
+	}
+	activeNamespace, activeDot, err := client.ParseNamespacedVolume(activeQualified)
+	if err != nil {
+		return dotDetails{}, err
+	}
+	details.Selected = namespace == activeNamespace && dot == activeDot
+
+	details.CurrentBranch, err = dm.CurrentBranch(qualifiedDotName)
+	if err != nil {
+		return dotDetails{}, err
+	}
+	bs, err := dm.AllBranches(qualifiedDotName)
+	if err != nil {
+		return dotDetails{}, err
+	}
+	for _, branch := range bs {
+		b := branchDetails{Name: branch, Containers: []string{}}
+		if branch == "master" {
+			b.Volume = masterDot
+		} else {
+			b.Volume, err = dm.BranchInfo(namespace, dot, branch)
+			if err != nil {
+				return dotDetails{}, err
+			}
+		}
+		if branch == details.CurrentBranch {
+			containerInfo, err := dm.RelatedContainers(b.Volume.Name, branch)
+			if err != nil {
+				return dotDetails{}, err
+			}
+			for _, container := range containerInfo {
+				b.Containers = append(b.Containers, container.Name)
+			}
+		}
+		if dm.IsUserPriveledged() {
+			branchInternalName := branch
+			if branchInternalName == "master" {
+				branchInternalName = ""
+			}
+			latency, err := dm.GetReplicationLatencyForBranch(qualifiedDotName, branchInternalName)
+			if err != nil {
+				return dotDetails{}, err
+			}
+			servers := []string{}
+			for server := range latency {
+				servers = append(servers, server)
+			}
+			sort.Strings(servers)
+			for _, server := range servers {
+				serverStatus, ok := b.Volume.ServerStatuses[server]
+				if !ok {
+					serverStatus = "unknown"
+				}
+				missingCommits := latency[server]
+				if missingCommits == nil {
+					missingCommits = []string{}
+				}
+				b.Replication = append(b.Replication, replicaStatus{
+					Server:         server,
+					Master:         b.Volume.Master == server,
+					Status:         serverStatus,
+					MissingCommits: missingCommits,
+				})
+			}
+		}
+		details.Branches = append(details.Branches, b)
+	}
+
+	remotes := dm.Configuration.GetRemotes()
+	keys := []string{}
+	for k := range remotes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		remoteNamespace, remoteDot, ok := dm.Configuration.DefaultRemoteVolumeFor(k, namespace, dot)
+		if ok {
+			details.DefaultUpstreams = append(details.DefaultUpstreams, defaultUpstream{
+				Remote: k,
+				Dot:    client.VolumeName{Namespace: remoteNamespace, Name: remoteDot},
+			})
+		}
+	}
+	return details, nil
+}
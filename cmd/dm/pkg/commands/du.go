@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+var duBranch string
+var duCommits bool
+var duRange string
+
+func NewCmdDu(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "du [<dot>]",
+		Short: "Show the space used by a dot's branches and commits",
+		Long: `Show the space each branch of a dot uses, as ZFS accounts for it.
+
+USED is the space that would be freed by deleting just that branch or
+commit, REFERENCED the size of all the data it holds (including data
+shared with other commits), WRITTEN the data written since the previous
+commit (for a branch, its uncommitted changes) and LOGICAL the size of
+USED before compression.
+
+Run 'dm du [<dot>] [-b <branch>] [--commits]' to show one branch, or
+every commit as well.
+
+Run 'dm du reclaim [<dot>] [-b <branch>] [--range <commit>[..<commit>]]'
+to show how much space deleting a branch (along with the branches made
+from it) or a range of its commits would free.
+
+Where '[<dot>]' is omitted, the current dot (selected by 'dm switch') is
+used.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runHandlingError(func() error {
+				return showSpaceUsage(args, out)
+			})
+		},
+	}
+	cmd.AddCommand(NewCmdDuReclaim(out))
+	cmd.Flags().StringVarP(&duBranch, "branch", "b", "", "only show this branch of the dot")
+	cmd.Flags().BoolVar(&duCommits, "commits", false, "show the space used by each commit too")
+	cmd.Flags().BoolVarP(
+		&scriptingMode, "scripting", "H", false,
+		"scripting mode. Do not print headers, separate fields by "+
+			"a single tab instead of arbitrary whitespace, and show sizes in bytes.",
+	)
+	return cmd
+}
+
+func NewCmdDuReclaim(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reclaim [<dot>] [-b <branch>] [--range <commit>[..<commit>]]",
+		Short: "Show how much space deleting a branch or some commits would free",
+		Run: func(cmd *cobra.Command, args []string) {
+			runHandlingError(func() error {
+				dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+				if err != nil {
+					return err
+				}
+				namespace, name, _, err := dotOrCurrent(dm, args, 0)
+				if err != nil {
+					return err
+				}
+				branch := duBranch
+				if branch == "" {
+					branch, err = dm.CurrentBranch(namespace + "/" + name)
+					if err != nil {
+						return err
+					}
+				}
+				var fromCommitId, toCommitId string
+				if duRange != "" {
+					parts := strings.SplitN(duRange, "..", 2)
+					fromCommitId = parts[0]
+					if len(parts) == 2 {
+						toCommitId = parts[1]
+					}
+				}
+				reclaim, err := dm.ReclaimableSpace(namespace, name, branch, fromCommitId, toCommitId)
+				if err != nil {
+					return err
+				}
+				if structuredOutput() {
+					return printStructured(out, reclaimEstimate{
+						Branch:       branch,
+						FromCommitId: fromCommitId,
+						ToCommitId:   toCommitId,
+						Bytes:        reclaim,
+					})
+				}
+				if scriptingMode {
+					fmt.Fprintf(out, "%d\n", reclaim)
+				} else if duRange != "" {
+					fmt.Fprintf(out, "Deleting commits %s of branch %s would free %s\n", duRange, branch, prettyPrintSize(reclaim))
+				} else {
+					fmt.Fprintf(out, "Deleting branch %s would free %s\n", branch, prettyPrintSize(reclaim))
+				}
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&duBranch, "branch", "b", "", "branch to delete, or to delete commits from (defaults to the current branch)")
+	cmd.Flags().StringVar(&duRange, "range", "", "commit, or first and last commit separated by '..', to delete")
+	cmd.Flags().BoolVarP(&scriptingMode, "scripting", "H", false, "scripting mode. Just print the number of bytes.")
+	return cmd
+}
+
+func showSpaceUsage(args []string, out io.Writer) error {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	namespace, name, _, err := dotOrCurrent(dm, args, 0)
+	if err != nil {
+		return err
+	}
+	usages, err := dm.SpaceUsage(namespace, name, duBranch)
+	if err != nil {
+		return err
+	}
+	if structuredOutput() {
+		// always with every commit, as they cost nothing extra to fetch
+		return printStructured(out, usages)
+	}
+
+	var target io.Writer
+	if scriptingMode {
+		target = out
+	} else {
+		target = tabwriter.NewWriter(out, 3, 8, 2, ' ', 0)
+		fmt.Fprintf(target, "BRANCH\tCOMMIT\tUSED\tREFERENCED\tWRITTEN\tLOGICAL\n")
+	}
+	row := func(branch, commit string, usage types.SpaceUsage) {
+		sizes := []int64{usage.Used, usage.Referenced, usage.Written, usage.LogicalUsed}
+		cells := []string{branch, commit}
+		for _, size := range sizes {
+			if scriptingMode {
+				cells = append(cells, fmt.Sprintf("%d", size))
+			} else {
+				cells = append(cells, prettyPrintSize(size))
+			}
+		}
+		fmt.Fprintf(target, "%s\n", strings.Join(cells, "\t"))
+	}
+	for _, branch := range usages {
+		row(branch.Branch, "-", branch.SpaceUsage)
+		if duCommits {
+			for _, commit := range branch.Commits {
+				row(branch.Branch, commit.CommitId, commit.SpaceUsage)
+			}
+		}
+	}
+	if w, ok := target.(*tabwriter.Writer); ok {
+		w.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+var changeRequestDescription string
+var changeRequestApprove bool
+
+func NewCmdForkSync(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync [<fork>]",
+		Short: "Bring a fork up to date with the dot it was forked from",
+		Run: func(cmd *cobra.Command, args []string) {
+			runHandlingError(func() error {
+				dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+				if err != nil {
+					return err
+				}
+				namespace, name, _, err := dotOrCurrent(dm, args, 0)
+				if err != nil {
+					return err
+				}
+				upstream, err := dm.ForkUpstream(namespace, name)
+				if err != nil {
+					return err
+				}
+				commits, err := dm.SyncFork(namespace, name)
+				if err != nil {
+					return err
+				}
+				if commits == 0 {
+					fmt.Fprintf(out, "%s/%s is up to date with %s\n", namespace, name, upstream)
+				} else {
+					fmt.Fprintf(out, "Pulled %d commits from %s into %s/%s\n", commits, upstream, namespace, name)
+				}
+				return nil
+			})
+		},
+	}
+	return cmd
+}
+
+func NewCmdFork(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fork <dot> [<fork>]",
+		Short: "Fork a dot into your own namespace",
+		Long: `Copy a dot, with all of its commits, into your own namespace.
+
+Run 'dm fork <dot> [<fork>]' to fork <dot> to <fork>, which defaults to
+the same name in your namespace. You can fork any dot you own or
+collaborate on. The fork remembers the dot it was forked from, its
+upstream, which 'dm dot show' shows.
+
+Run 'dm fork sync [<fork>]' to pull the commits made upstream since
+into the fork. That only works while the fork has no commits of its own
+since it was forked (or last synced); dotmesh doesn't merge.
+
+To offer the fork's commits back upstream, use 'dm change-request'.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runHandlingError(func() error {
+				return forkDot(args, out)
+			})
+		},
+	}
+	cmd.AddCommand(NewCmdForkSync(out))
+	return cmd
+}
+
+func forkDot(args []string, out io.Writer) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("Please specify <dot> [<fork>] as arguments.")
+	}
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	namespace, name, err := client.ParseNamespacedVolume(args[0])
+	if err != nil {
+		return err
+	}
+
+	// forks go in the namespace of the user we're talking to the remote as
+	remote, err := dm.Configuration.GetRemote(dm.Configuration.GetCurrentRemote())
+	if err != nil {
+		return err
+	}
+	forkNamespace, forkName := remote.DefaultNamespace(), name
+	if len(args) == 2 {
+		forkNamespace, forkName, err = client.ParseNamespacedVolumeWithDefault(args[1], remote.DefaultNamespace())
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = dm.Fork(namespace, name, forkNamespace, forkName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Forked %s/%s to %s/%s\n", namespace, name, forkNamespace, forkName)
+	return nil
+}
+
+func NewCmdChangeRequest(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "change-request",
+		Aliases: []string{"cr"},
+		Short:   "Propose, review and accept changes from forks",
+		Long: `Propose merging a fork's commits back into the dot it was forked
+from, and review and accept those proposals.
+
+Run 'dm change-request create [<fork>] -m <title> [-d <description>]'
+to propose bringing the dot the fork was forked from up to the fork's
+latest commit.
+
+Run 'dm change-request list [<dot>]' to list the change requests
+proposed to <dot>. Its owner sees all of them, anyone else just their
+own.
+
+Run 'dm change-request review [<dot>] <id> [--approve] [-m <comment>]'
+to review one, as the dot's owner or a collaborator.
+
+Run 'dm change-request accept [<dot>] <id>' to accept one, as the dot's
+owner. The dot mustn't have commits of its own since the fork was
+forked (or last synced), and mustn't be in use by any containers.
+'dm change-request close [<dot>] <id>' closes one without accepting it.
+
+Where '[<dot>]' or '[<fork>]' is omitted, the current dot (selected by
+'dm switch') is used.`,
+	}
+	cmd.AddCommand(NewCmdChangeRequestCreate(out))
+	cmd.AddCommand(NewCmdChangeRequestList(out))
+	cmd.AddCommand(NewCmdChangeRequestReview(out))
+	cmd.AddCommand(NewCmdChangeRequestAccept(out, true))
+	cmd.AddCommand(NewCmdChangeRequestAccept(out, false))
+	return cmd
+}
+
+func NewCmdChangeRequestCreate(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create [<fork>] -m <title>",
+		Short: "Propose merging a fork's commits into the dot it was forked from",
+		Run: func(cmd *cobra.Command, args []string) {
+			runHandlingError(func() error {
+				if commitMsg == "" {
+					return fmt.Errorf("Please give the change request a title with -m.")
+				}
+				dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+				if err != nil {
+					return err
+				}
+				namespace, name, _, err := dotOrCurrent(dm, args, 0)
+				if err != nil {
+					return err
+				}
+				upstream, err := dm.ForkUpstream(namespace, name)
+				if err != nil {
+					return err
+				}
+				cr, err := dm.CreateChangeRequest(namespace, name, commitMsg, changeRequestDescription)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "Created change request %s to bring %s up to commit %s\n", cr.Id, upstream, cr.CommitId)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&commitMsg, "message", "m", "", "title of the change request")
+	cmd.Flags().StringVarP(&changeRequestDescription, "description", "d", "", "longer description of the change request")
+	return cmd
+}
+
+func NewCmdChangeRequestList(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list [<dot>]",
+		Short: "List the change requests proposed to a dot",
+		Run: func(cmd *cobra.Command, args []string) {
+			runHandlingError(func() error {
+				dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+				if err != nil {
+					return err
+				}
+				namespace, name, _, err := dotOrCurrent(dm, args, 0)
+				if err != nil {
+					return err
+				}
+				crs, err := dm.ChangeRequests(namespace, name)
+				if err != nil {
+					return err
+				}
+				for _, cr := range crs {
+					approvals := 0
+					for _, review := range cr.Reviews {
+						if review.Approved {
+							approvals++
+						}
+					}
+					fmt.Fprintf(
+						out, "%s\t%s\t%s\t%d/%d approved\t%s\t%s\n",
+						cr.Id, cr.State, time.Unix(cr.Created, 0).Format(time.RFC3339),
+						approvals, len(cr.Reviews), cr.CommitId, cr.Title,
+					)
+				}
+				return nil
+			})
+		},
+	}
+	return cmd
+}
+
+func NewCmdChangeRequestReview(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "review [<dot>] <id> [--approve] [-m <comment>]",
+		Short: "Review a change request",
+		Run: func(cmd *cobra.Command, args []string) {
+			runHandlingError(func() error {
+				dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+				if err != nil {
+					return err
+				}
+				namespace, name, args, err := dotOrCurrent(dm, args, 1)
+				if err != nil {
+					return err
+				}
+				cr, err := dm.ReviewChangeRequest(namespace, name, args[0], changeRequestApprove, commitMsg)
+				if err != nil {
+					return err
+				}
+				printChangeRequest(out, "Reviewed", cr)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&changeRequestApprove, "approve", false, "approve of the change request, rather than objecting to it")
+	cmd.Flags().StringVarP(&commitMsg, "message", "m", "", "review comment")
+	return cmd
+}
+
+// NewCmdChangeRequestAccept - accept, or close if accept is false
+func NewCmdChangeRequestAccept(out io.Writer, accept bool) *cobra.Command {
+	use, short, done := "accept", "Accept a change request, merging its commits", "Accepted"
+	if !accept {
+		use, short, done = "close", "Close a change request without accepting it", "Closed"
+	}
+	cmd := &cobra.Command{
+		Use:   use + " [<dot>] <id>",
+		Short: short,
+		Run: func(cmd *cobra.Command, args []string) {
+			runHandlingError(func() error {
+				dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+				if err != nil {
+					return err
+				}
+				namespace, name, args, err := dotOrCurrent(dm, args, 1)
+				if err != nil {
+					return err
+				}
+				cr, err := dm.AcceptChangeRequest(namespace, name, args[0], accept)
+				if err != nil {
+					return err
+				}
+				printChangeRequest(out, done, cr)
+				return nil
+			})
+		},
+	}
+	return cmd
+}
+
+func printChangeRequest(out io.Writer, done string, cr *types.ChangeRequest) {
+	fmt.Fprintf(out, "%s change request %s (%s), now %s\n", done, cr.Id, cr.Title, cr.State)
+}
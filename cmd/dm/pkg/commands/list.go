@@ -27,6 +27,10 @@ func NewCmdList(out io.Writer) *cobra.Command {
 					return fmt.Errorf("Please specify no arguments.")
 				}
 
+				if structuredOutput() {
+					return printDotListings(dm, out)
+				}
+
 				if !scriptingMode {
 					fmt.Fprintf(
 						out,
@@ -35,7 +39,7 @@ func NewCmdList(out io.Writer) *cobra.Command {
 					)
 				}
 
-				columnNames := []string{"  DOT", "BRANCH", "SERVER", "CONTAINERS", "SIZE", "COMMITS", "DIRTY"}
+				columnNames := []string{"  DOT", "BRANCH", "SERVER", "CONTAINERS", "SIZE", "COMMITS", "DIRTY", "QUOTA"}
 
 				var target io.Writer
 				if scriptingMode {
@@ -86,18 +90,28 @@ func NewCmdList(out io.Writer) *cobra.Command {
 						containerNames = append(containerNames, container.Name)
 					}
 
+					// quota usage covers all the dot's branches, not just the
+					// size of the current one
+					quotaString := "-"
 					var dirtyString, sizeString string
 					if scriptingMode {
 						dirtyString = fmt.Sprintf("%d", v.DirtyBytes)
 						sizeString = fmt.Sprintf("%d", v.SizeBytes)
+						if v.QuotaBytes > 0 {
+							quotaString = fmt.Sprintf("%d/%d", v.QuotaUsedBytes, v.QuotaBytes)
+						}
 					} else {
 						dirtyString = prettyPrintSize(v.DirtyBytes)
 						sizeString = prettyPrintSize(v.SizeBytes)
+						if v.QuotaBytes > 0 {
+							quotaString = prettyPrintSize(v.QuotaUsedBytes) + " / " + prettyPrintSize(v.QuotaBytes)
+						}
 					}
 
 					cells := []string{
 						v.Name.String(), b, v.Master, strings.Join(containerNames, ","),
 						sizeString, fmt.Sprintf("%d", v.CommitCount), dirtyString,
+						quotaString,
 					}
 					fmt.Fprintf(target, start)
 					for _, cell := range cells {
@@ -125,3 +139,38 @@ func NewCmdList(out io.Writer) *cobra.Command {
 	)
 	return cmd
 }
+
+func printDotListings(dm *client.DotmeshAPI, out io.Writer) error {
+	vcs, err := dm.AllVolumesWithContainers()
+	if err != nil {
+		return err
+	}
+	activeQualified, err := dm.CurrentVolume()
+	if err != nil {
+		return err
+	}
+	activeNamespace, activeVolume, err := client.ParseNamespacedVolume(activeQualified)
+	if err != nil {
+		return err
+	}
+	active := client.VolumeName{Namespace: activeNamespace, Name: activeVolume}
+
+	listings := []dotListing{}
+	for _, vc := range vcs {
+		b, err := dm.CurrentBranch(vc.Volume.Name.String())
+		if err != nil {
+			return err
+		}
+		containers := vc.Containers
+		if containers == nil {
+			containers = []client.Container{}
+		}
+		listings = append(listings, dotListing{
+			Volume:     vc.Volume,
+			Branch:     b,
+			Current:    vc.Volume.Name == active,
+			Containers: containers,
+		})
+	}
+	return printStructured(out, listings)
+}
@@ -4,71 +4,419 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/spf13/cobra"
 )
 
+var logAuthor string
+var logSince string
+var logUntil string
+var logGrep string
+var logMeta []string
+var logMaxCount int
+var logOneline bool
+var logAll bool
+var logGraph bool
+
 func NewCmdLog(out io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "log",
 		Short: "Show commit logs",
-		Long:  "Online help: https://docs.dotmesh.com/references/cli/#list-commits-dm-log",
+		Long: `Show the commits of the current branch of the current dot, oldest first.
+
+Show only some commits with --author, --since, --until, --grep and --meta,
+which can be combined, and only the latest N of those with -n N. --since and
+--until take a date (2006-01-02), a time (2006-01-02T15:04:05Z07:00) or a
+duration ago (36h).
+
+--all shows the commits of every branch, in the order they were made, and
+--graph draws lines to the left of them showing which commit each branch was
+made from.
+
+Online help: https://docs.dotmesh.com/references/cli/#list-commits-dm-log`,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := func() error {
-				dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
-				if err != nil {
-					return err
-				}
-				activeVolume, err := dm.StrictCurrentVolume()
-				if err != nil {
-					return err
-				}
-				if activeVolume == "" {
-					return fmt.Errorf(
-						"No current dot. Try 'dm list' and " +
-							"'dm switch' to switch to a dot.",
-					)
-				}
-
-				activeBranch, err := dm.CurrentBranch(activeVolume)
-				if err != nil {
-					return err
-				}
-
-				commits, err := dm.ListCommits(activeVolume, activeBranch)
-				if err != nil {
-					return err
-				}
-				for _, commit := range commits {
-					fmt.Fprintf(out, "commit %s\n", commit.Id)
-					fmt.Fprintf(out, "author: %s\n", (*commit.Metadata)["author"])
-					fmt.Fprintf(out, "date: %s\n", (*commit.Metadata)["timestamp"])
-
-					sortedNames := []string{}
-					for name, _ := range *commit.Metadata {
-						sortedNames = append(sortedNames, name)
-					}
-					sort.Strings(sortedNames)
-
-					for _, name := range sortedNames {
-						value := (*commit.Metadata)[name]
-						if name != "author" && name != "message" && name != "timestamp" {
-							fmt.Fprintf(out, "%s: %s\n", name, value)
-						}
-					}
-
-					fmt.Fprintf(out, "\n")
-					fmt.Fprintf(out, "    %s\n\n", (*commit.Metadata)["message"])
-				}
-				return nil
-			}()
+			err := showLog(out)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err.Error())
 				os.Exit(1)
 			}
 		},
 	}
+	cmd.Flags().StringVar(&logAuthor, "author", "", "only show commits whose author contains this")
+	cmd.Flags().StringVar(&logSince, "since", "", "only show commits made at or after this time")
+	cmd.Flags().StringVar(&logUntil, "until", "", "only show commits made at or before this time")
+	cmd.Flags().StringVar(&logGrep, "grep", "", "only show commits whose message matches this regular expression")
+	cmd.Flags().StringArrayVar(&logMeta, "meta", []string{}, "only show commits with this key=value metadata (may be repeated)")
+	cmd.Flags().IntVarP(&logMaxCount, "max-count", "n", 0, "only show the latest N commits")
+	cmd.Flags().BoolVar(&logOneline, "oneline", false, "show each commit on one line")
+	cmd.Flags().BoolVar(&logAll, "all", false, "show the commits of all branches")
+	cmd.Flags().BoolVar(&logGraph, "graph", false, "draw where branches were made from")
 	return cmd
 }
+
+func showLog(out io.Writer) error {
+	dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+	if err != nil {
+		return err
+	}
+	activeVolume, err := dm.StrictCurrentVolume()
+	if err != nil {
+		return err
+	}
+	if activeVolume == "" {
+		return fmt.Errorf(
+			"No current dot. Try 'dm list' and " +
+				"'dm switch' to switch to a dot.",
+		)
+	}
+	filter, err := newCommitFilter()
+	if err != nil {
+		return err
+	}
+
+	activeBranch, err := dm.CurrentBranch(activeVolume)
+	if err != nil {
+		return err
+	}
+	branches := []string{activeBranch}
+	if logAll {
+		branches, err = dm.AllBranches(activeVolume)
+		if err != nil {
+			return err
+		}
+	}
+
+	commits := []loggedCommit{}
+	for _, branch := range branches {
+		branchCommits, err := dm.ListCommitsMatching(activeVolume, branch, filter.query)
+		if err != nil {
+			return err
+		}
+		for _, commit := range branchCommits {
+			if filter.matches(commit) {
+				commits = append(commits, loggedCommit{Snapshot: commit, Branch: branch})
+			}
+		}
+	}
+	if len(branches) > 1 {
+		sort.SliceStable(commits, func(i, j int) bool {
+			return commitTime(commits[i].Snapshot) < commitTime(commits[j].Snapshot)
+		})
+	}
+	if logMaxCount > 0 && len(commits) > logMaxCount {
+		commits = commits[len(commits)-logMaxCount:]
+	}
+
+	if structuredOutput() {
+		return printStructured(out, commits)
+	}
+
+	var graph *commitGraph
+	if logGraph {
+		origins, err := branchOrigins(dm, activeVolume)
+		if err != nil {
+			return err
+		}
+		graph = newCommitGraph(commits, origins)
+	}
+	printLog(out, commits, graph)
+	return nil
+}
+
+// printLog - prints commits, with the graph to the left of them if there is
+// one
+func printLog(out io.Writer, commits []loggedCommit, graph *commitGraph) {
+	for _, commit := range commits {
+		lines := formatCommit(commit)
+		if graph == nil {
+			for _, line := range lines {
+				fmt.Fprintln(out, line)
+			}
+			continue
+		}
+		prefix, continuation, forks := graph.draw(commit)
+		// keep the rest of the commit lined up with its first line, even
+		// when lanes to the right of the rest of it have finished
+		if len(continuation) < len(prefix) {
+			continuation += strings.Repeat(" ", len(prefix)-len(continuation))
+		}
+		for i, line := range lines {
+			if i == 0 {
+				line = prefix + " " + line
+			} else if line != "" {
+				line = continuation + " " + line
+			} else {
+				line = continuation
+			}
+			fmt.Fprintln(out, strings.TrimRight(line, " "))
+		}
+		for _, fork := range forks {
+			fmt.Fprintln(out, fork)
+		}
+	}
+}
+
+// formatCommit - the lines 'dm log' shows for a commit
+func formatCommit(commit loggedCommit) []string {
+	meta := map[string]string{}
+	if commit.Metadata != nil {
+		meta = *commit.Metadata
+	}
+	var branch string
+	if logAll {
+		branch = " (" + commit.Branch + ")"
+	}
+	if logOneline {
+		message := strings.SplitN(meta["message"], "\n", 2)[0]
+		return []string{fmt.Sprintf("%s%s %s", commit.Id, branch, message)}
+	}
+
+	lines := []string{
+		fmt.Sprintf("commit %s%s", commit.Id, branch),
+		fmt.Sprintf("author: %s", meta["author"]),
+		fmt.Sprintf("date: %s", meta["timestamp"]),
+	}
+	sortedNames := []string{}
+	for name := range meta {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+	for _, name := range sortedNames {
+		if name != "author" && name != "message" && name != "timestamp" {
+			lines = append(lines, fmt.Sprintf("%s: %s", name, meta[name]))
+		}
+	}
+	return append(lines, "", "    "+meta["message"], "")
+}
+
+// commitTime - when a commit was made, in nanoseconds since the epoch, or 0
+// if it doesn't say
+func commitTime(commit client.Snapshot) int64 {
+	if commit.Metadata == nil {
+		return 0
+	}
+	nanos, err := strconv.ParseInt((*commit.Metadata)["timestamp"], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return nanos
+}
+
+// commitFilter - the server finds the commits matching query, and --grep is
+// applied to those here
+type commitFilter struct {
+	query types.CommitQuery
+	grep  *regexp.Regexp
+}
+
+func newCommitFilter() (*commitFilter, error) {
+	f := &commitFilter{query: types.CommitQuery{Author: logAuthor, Metadata: types.Metadata{}}}
+	var err error
+	if logSince != "" {
+		f.query.Since, err = parseLogTime(logSince)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if logUntil != "" {
+		f.query.Until, err = parseLogTime(logUntil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if logGrep != "" {
+		f.grep, err = regexp.Compile(logGrep)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --grep pattern: %s", err)
+		}
+	}
+	for _, pair := range logMeta {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Please specify --meta as key=value, got %s", pair)
+		}
+		f.query.Metadata[parts[0]] = parts[1]
+	}
+	return f, nil
+}
+
+// parseLogTime - nanoseconds since the epoch of a date, time or duration ago
+func parseLogTime(value string) (int64, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UnixNano(), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t.UnixNano(), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d).UnixNano(), nil
+	}
+	return 0, fmt.Errorf(
+		"Can't understand the time %s, please use a date (2006-01-02), a time "+
+			"(2006-01-02T15:04:05Z07:00) or a duration ago (36h)", value,
+	)
+}
+
+func (f *commitFilter) matches(commit client.Snapshot) bool {
+	if f.grep == nil {
+		return true
+	}
+	message := ""
+	if commit.Metadata != nil {
+		message = (*commit.Metadata)["message"]
+	}
+	return f.grep.MatchString(message)
+}
+
+// commitOrigin - the commit a branch was made from
+type commitOrigin struct {
+	Branch   string
+	CommitId string
+}
+
+// branchOrigins - where each branch of a dot other than master was made from
+func branchOrigins(dm *client.DotmeshAPI, volumeName string) (map[string]commitOrigin, error) {
+	namespace, name, err := client.ParseNamespacedVolume(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	dotsAndBranches, err := dm.DotsAndBranches()
+	if err != nil {
+		return nil, err
+	}
+	origins := map[string]commitOrigin{}
+	for _, dot := range dotsAndBranches.Dots {
+		if dot.MasterBranch.Name != (types.VolumeName{Namespace: namespace, Name: name}) {
+			continue
+		}
+		branchNames := map[string]string{dot.MasterBranch.Id: client.DEFAULT_BRANCH}
+		for _, branch := range dot.OtherBranches {
+			branchNames[branch.Id] = branch.Branch
+		}
+		for _, branch := range dot.OtherBranches {
+			if branch.Origin.FilesystemId == "" {
+				continue
+			}
+			origins[branch.Branch] = commitOrigin{
+				Branch:   branchNames[branch.Origin.FilesystemId],
+				CommitId: branch.Origin.SnapshotId,
+			}
+		}
+	}
+	return origins, nil
+}
+
+// commitGraph - draws branches as lanes to the left of their commits. A
+// branch gets a lane just right of the commit it was made from (or, if that
+// isn't shown, at its first commit), which lasts until its last commit shown.
+type commitGraph struct {
+	// the branch in each lane, or "" for a lane that's finished
+	lanes     []string
+	remaining map[string]int
+	// branches by the commit they were made from
+	forks map[string][]string
+}
+
+func newCommitGraph(commits []loggedCommit, origins map[string]commitOrigin) *commitGraph {
+	g := &commitGraph{remaining: map[string]int{}, forks: map[string][]string{}}
+	for _, commit := range commits {
+		g.remaining[commit.Branch]++
+	}
+	for branch, origin := range origins {
+		if g.remaining[branch] > 0 {
+			g.forks[origin.CommitId] = append(g.forks[origin.CommitId], branch)
+		}
+	}
+	for _, branches := range g.forks {
+		sort.Strings(branches)
+	}
+	return g
+}
+
+func (g *commitGraph) lane(branch string) int {
+	for i, b := range g.lanes {
+		if b == branch {
+			return i
+		}
+	}
+	return -1
+}
+
+// row - the lanes, with mark drawn in lane i
+func (g *commitGraph) row(i int, mark string) string {
+	cells := []string{}
+	for j, branch := range g.lanes {
+		switch {
+		case j == i:
+			cells = append(cells, mark)
+		case branch != "":
+			cells = append(cells, "|")
+		default:
+			cells = append(cells, " ")
+		}
+	}
+	return strings.TrimRight(strings.Join(cells, " "), " ")
+}
+
+// draw - the graph to the left of the first line of a commit and of the
+// rest of its lines, and the lines to draw after it for branches made from it
+func (g *commitGraph) draw(commit loggedCommit) (string, string, []string) {
+	l := g.lane(commit.Branch)
+	if l == -1 {
+		// a lane of its own, to the right of those still in use
+		for len(g.lanes) > 0 && g.lanes[len(g.lanes)-1] == "" {
+			g.lanes = g.lanes[:len(g.lanes)-1]
+		}
+		g.lanes = append(g.lanes, commit.Branch)
+		l = len(g.lanes) - 1
+	}
+	prefix := g.row(l, "*")
+	g.remaining[commit.Branch]--
+	if g.remaining[commit.Branch] == 0 {
+		g.lanes[l] = ""
+		// the first branch made from the commit carries on in its lane
+		for _, branch := range g.forks[commit.Id] {
+			if g.lane(branch) == -1 {
+				g.lanes[l] = branch
+				break
+			}
+		}
+	}
+	continuation := g.row(-1, "")
+
+	forks := []string{}
+	for _, branch := range g.forks[commit.Id] {
+		if g.lane(branch) != -1 {
+			continue
+		}
+		// insert a lane for the branch next to the commit's, drawing the
+		// fork and the lanes to the right of it moving over
+		var line strings.Builder
+		for i, b := range g.lanes {
+			cell := " "
+			if b != "" {
+				cell = "|"
+			}
+			switch {
+			case i < l:
+				line.WriteString(cell + " ")
+			case i == l:
+				line.WriteString(cell + "\\")
+			case b != "":
+				line.WriteString(" \\")
+			default:
+				line.WriteString("  ")
+			}
+		}
+		forks = append(forks, strings.TrimRight(line.String(), " "))
+		g.lanes = append(g.lanes[:l+1], append([]string{branch}, g.lanes[l+1:]...)...)
+	}
+	return prefix, continuation, forks
+}
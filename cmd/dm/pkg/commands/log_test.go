@@ -0,0 +1,221 @@
+package commands
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func testCommit(id, branch string, timestamp int64, message string) loggedCommit {
+	meta := client.Metadata{
+		"author":    "alice",
+		"message":   message,
+		"timestamp": strconv.FormatInt(timestamp, 10),
+	}
+	return loggedCommit{Snapshot: client.Snapshot{Id: id, Metadata: &meta}, Branch: branch}
+}
+
+func TestCommitFilter(t *testing.T) {
+	defer func(author, since, until, grep string, meta []string) {
+		logAuthor, logSince, logUntil, logGrep, logMeta = author, since, until, grep, meta
+	}(logAuthor, logSince, logUntil, logGrep, logMeta)
+
+	day, err := time.ParseInLocation("2006-01-02", "2018-06-01", time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noon := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                       string
+		author, since, until, grep string
+		meta                       []string
+		query                      types.CommitQuery
+		matching, notMatching      []string
+		err                        bool
+	}{
+		{
+			name:     "everything",
+			query:    types.CommitQuery{Metadata: types.Metadata{}},
+			matching: []string{"", "anything"},
+		},
+		{
+			name:   "author",
+			author: "ali",
+			query:  types.CommitQuery{Author: "ali", Metadata: types.Metadata{}},
+		},
+		{
+			name:  "since a date",
+			since: "2018-06-01",
+			query: types.CommitQuery{Since: day.UnixNano(), Metadata: types.Metadata{}},
+		},
+		{
+			name:  "until a time",
+			until: "2018-06-01T12:00:00Z",
+			query: types.CommitQuery{Until: noon.UnixNano(), Metadata: types.Metadata{}},
+		},
+		{name: "bad time", since: "last tuesday", err: true},
+		{
+			name:        "grep",
+			grep:        "^fix",
+			query:       types.CommitQuery{Metadata: types.Metadata{}},
+			matching:    []string{"fix the tests", "fixed"},
+			notMatching: []string{"prefix", ""},
+		},
+		{name: "bad grep", grep: "(", err: true},
+		{
+			name:  "metadata",
+			meta:  []string{"ticket=123", "equation=a=b"},
+			query: types.CommitQuery{Metadata: types.Metadata{"ticket": "123", "equation": "a=b"}},
+		},
+		{name: "metadata without a value", meta: []string{"ticket"}, err: true},
+	}
+	for _, tt := range tests {
+		logAuthor, logSince, logUntil, logGrep, logMeta = tt.author, tt.since, tt.until, tt.grep, tt.meta
+		filter, err := newCommitFilter()
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", tt.name, filter.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(filter.query, tt.query) {
+			t.Errorf("%s: expected query %+v, got %+v", tt.name, tt.query, filter.query)
+		}
+		for _, message := range tt.matching {
+			if !filter.matches(testCommit("c1", "master", 0, message).Snapshot) {
+				t.Errorf("%s: expected %q to match", tt.name, message)
+			}
+		}
+		for _, message := range tt.notMatching {
+			if filter.matches(testCommit("c1", "master", 0, message).Snapshot) {
+				t.Errorf("%s: expected %q not to match", tt.name, message)
+			}
+		}
+	}
+
+	// commits without metadata only match without --grep
+	logAuthor, logSince, logUntil, logGrep, logMeta = "", "", "", "", nil
+	filter, _ := newCommitFilter()
+	if !filter.matches(client.Snapshot{Id: "c1"}) {
+		t.Error("expected a commit without metadata to match no --grep")
+	}
+	logGrep = "."
+	filter, _ = newCommitFilter()
+	if filter.matches(client.Snapshot{Id: "c1"}) {
+		t.Error("expected a commit without metadata not to match --grep")
+	}
+}
+
+func TestParseLogTime(t *testing.T) {
+	before := time.Now().Add(-36 * time.Hour).UnixNano()
+	ago, err := parseLogTime("36h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ago < before || ago > time.Now().Add(-36*time.Hour).UnixNano() {
+		t.Errorf("expected 36h ago, got %s", time.Unix(0, ago))
+	}
+	at, err := parseLogTime("2018-06-01T12:00:00+01:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at != time.Date(2018, 6, 1, 11, 0, 0, 0, time.UTC).UnixNano() {
+		t.Errorf("expected 11:00 UTC, got %s", time.Unix(0, at).UTC())
+	}
+	for _, value := range []string{"", "yesterday", "2018-13-01", "-"} {
+		if _, err := parseLogTime(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestLogGraph(t *testing.T) {
+	defer func(oneline, all bool) { logOneline, logAll = oneline, all }(logOneline, logAll)
+	logOneline, logAll = true, true
+
+	// feature is made from c2 on master, and bugfix from f1 on feature
+	commits := []loggedCommit{
+		testCommit("c1", "master", 1, "first"),
+		testCommit("c2", "master", 2, "second"),
+		testCommit("f1", "feature", 3, "start a feature"),
+		testCommit("c3", "master", 4, "third"),
+		testCommit("b1", "bugfix", 5, "fix the feature"),
+		testCommit("f2", "feature", 6, "finish the feature"),
+		testCommit("c4", "master", 7, "fourth"),
+	}
+	origins := map[string]commitOrigin{
+		"feature": {Branch: "master", CommitId: "c2"},
+		"bugfix":  {Branch: "feature", CommitId: "f1"},
+	}
+	tests := []struct {
+		name    string
+		commits []loggedCommit
+		want    string
+	}{
+		{"whole history", commits, "" +
+			"* c1 (master) first\n" +
+			"* c2 (master) second\n" +
+			"|\\\n" +
+			"| * f1 (feature) start a feature\n" +
+			"| |\\\n" +
+			"* | | c3 (master) third\n" +
+			"| | * b1 (bugfix) fix the feature\n" +
+			"| * f2 (feature) finish the feature\n" +
+			"* c4 (master) fourth\n",
+		},
+		// branches whose origin isn't shown get a lane at their first
+		// commit, reusing finished lanes
+		{"latest commits", commits[3:], "" +
+			"* c3 (master) third\n" +
+			"| * b1 (bugfix) fix the feature\n" +
+			"| * f2 (feature) finish the feature\n" +
+			"* c4 (master) fourth\n",
+		},
+		// a branch carries on in the lane of the commit it was made from if
+		// that's the last of its branch
+		{"made from the last commit", []loggedCommit{commits[0], commits[1], commits[2], commits[5]}, "" +
+			"* c1 (master) first\n" +
+			"* c2 (master) second\n" +
+			"* f1 (feature) start a feature\n" +
+			"* f2 (feature) finish the feature\n",
+		},
+	}
+	for _, tt := range tests {
+		out := &strings.Builder{}
+		printLog(out, tt.commits, newCommitGraph(tt.commits, origins))
+		if out.String() != tt.want {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", tt.name, tt.want, out.String())
+		}
+	}
+
+	// the graph's drawn beside every line of a commit
+	logOneline = false
+	out := &strings.Builder{}
+	printLog(out, commits[1:3], newCommitGraph(commits[1:3], origins))
+	want := "" +
+		"* commit c2 (master)\n" +
+		"| author: alice\n" +
+		"| date: 2\n" +
+		"|\n" +
+		"|     second\n" +
+		"|\n" +
+		"* commit f1 (feature)\n" +
+		"  author: alice\n" +
+		"  date: 3\n" +
+		"\n" +
+		"      start a feature\n" +
+		"\n"
+	if out.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out.String())
+	}
+}
@@ -18,6 +18,13 @@ var parseDebugParamsJSON bool
 var commitMsg string
 var commitMetadata *[]string
 var resetHard bool
+var quotaNamespace string
+var hookURL string
+var hookTimeout int
+var protectNoRollback bool
+var protectNoDelete bool
+var protectNoForcePush bool
+var protectRequiredMetadata []string
 
 var MainCmd = &cobra.Command{
 	Use:   "dm",
@@ -27,6 +34,9 @@ var MainCmd = &cobra.Command{
 This is the client. Configure it to talk to a dotmesh cluster with 'dm remote
 add'. Create a dotmesh cluster with 'dm cluster init'.`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOutputFormat(); err != nil {
+			return err
+		}
 		configPathInner, err := homedir.Expand(configPath)
 		configPath = configPathInner
 		if err != nil {
@@ -50,6 +60,7 @@ func Initialise() {
 	MainCmd.AddCommand(NewCmdRemote(os.Stdout))
 	MainCmd.AddCommand(NewCmdS3(os.Stdout))
 	MainCmd.AddCommand(NewCmdList(os.Stdout))
+	MainCmd.AddCommand(NewCmdDu(os.Stdout))
 	MainCmd.AddCommand(NewCmdInit(os.Stdout))
 	MainCmd.AddCommand(NewCmdSwitch(os.Stdout))
 	MainCmd.AddCommand(NewCmdCommit(os.Stdout))
@@ -58,12 +69,15 @@ func Initialise() {
 	MainCmd.AddCommand(NewCmdCheckout(os.Stdout))
 	MainCmd.AddCommand(NewCmdReset(os.Stdout))
 	MainCmd.AddCommand(NewCmdClone(os.Stdout))
+	MainCmd.AddCommand(NewCmdFork(os.Stdout))
+	MainCmd.AddCommand(NewCmdChangeRequest(os.Stdout))
 	MainCmd.AddCommand(NewCmdPull(os.Stdout))
 	MainCmd.AddCommand(NewCmdPush(os.Stdout))
 	MainCmd.AddCommand(NewCmdDebug(os.Stdout))
 	MainCmd.AddCommand(NewCmdDot(os.Stdout))
 	MainCmd.AddCommand(NewCmdVersion(os.Stdout))
 	MainCmd.AddCommand(NewCmdMount(os.Stdout))
+	MainCmd.AddCommand(NewCmdWatch(os.Stdout))
 
 	MainCmd.PersistentFlags().StringVarP(
 		&configPath, "config", "c",
@@ -76,4 +90,10 @@ func Initialise() {
 		false,
 		"Display details of RPC requests and responses to the dotmesh server",
 	)
+
+	MainCmd.PersistentFlags().StringVarP(
+		&outputFormat, "output", "o",
+		outputTable,
+		"Output format of commands that show things: table, json or yaml",
+	)
 }
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/dotmesh-io/dotmesh/pkg/client"
 	"github.com/spf13/cobra"
@@ -19,6 +21,10 @@ func NewCmdMount(out io.Writer) *cobra.Command {
 
 Run 'dm mount <dot> <mountpoint>' to expose the dot's filesystem at <mountpoint> on your host.
 
+Run 'dm mount <dot>@<commit> <mountpoint>' (or <dot>@<branch>@<commit>) to
+expose a commit instead, read-only. The commit stays mounted until the
+<mountpoint> symlink is removed.
+
 NOTE: this currently only works for Linux.`,
 
 		Run: func(cmd *cobra.Command, args []string) {
@@ -55,6 +61,10 @@ func mountDot(cmd *cobra.Command, args []string, out io.Writer) error {
 		return err
 	}
 
+	if strings.Contains(dot, "@") {
+		return mountCommit(dm, localDot, mountpoint, out)
+	}
+
 	exists, err := dm.VolumeExists(localDot)
 	if err != nil {
 		return err
@@ -82,3 +92,26 @@ If you want to create it - use the '--create' flag`, localDot)
 
 	return nil
 }
+
+// mountCommit - mounts dot@commit read-only. The server keeps it mounted for
+// as long as the symlink at mountpoint points at it.
+func mountCommit(dm *client.DotmeshAPI, localDot, mountpoint string, out io.Writer) error {
+	mountpoint, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return err
+	}
+
+	localDotPath, err := dm.ProcureCommit(localDot, "link:"+mountpoint)
+	if err != nil {
+		return err
+	}
+
+	err = os.Symlink(localDotPath, mountpoint)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "symlinked commit: %s read-only from %s to %s\n", localDot, localDotPath, mountpoint)
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/ghodss/yaml"
+)
+
+// STRUCTURED OUTPUT
+//
+// With '-o json' or '-o yaml', the commands that show things print one
+// document describing them instead of text, for scripts. The documents are
+// built from the client types (DotmeshVolume, Snapshot, TransferPollResult
+// and so on) and the output types below, and keep their field names, so
+// they're stable: fields may be added, but not renamed or removed. YAML uses
+// the same field names as JSON. docs/cli-output.md describes each schema.
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var outputFormat string
+
+func checkOutputFormat() error {
+	switch outputFormat {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf(
+		"Unknown output format %q, please use one of %s, %s or %s",
+		outputFormat, outputTable, outputJSON, outputYAML,
+	)
+}
+
+// structuredOutput - whether to print a document rather than text
+func structuredOutput() bool {
+	return outputFormat == outputJSON || outputFormat == outputYAML
+}
+
+// printStructured - prints v in the chosen output format
+func printStructured(out io.Writer, v interface{}) error {
+	bts, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if outputFormat == outputYAML {
+		bts, err = yaml.JSONToYAML(bts)
+		if err != nil {
+			return err
+		}
+	} else {
+		bts = append(bts, '\n')
+	}
+	_, err = out.Write(bts)
+	return err
+}
+
+// pollTransfer - follows a transfer to the end, printing its progress, or
+// just how it ended as a TransferPollResult for structured output
+func pollTransfer(dm *client.DotmeshAPI, transferId string, out io.Writer) error {
+	if !structuredOutput() {
+		return dm.PollTransfer(transferId, out)
+	}
+	pollErr := dm.PollTransfer(transferId, ioutil.Discard)
+	result, err := dm.GetTransfer(transferId)
+	if err != nil {
+		if pollErr != nil {
+			return pollErr
+		}
+		return err
+	}
+	err = printStructured(out, result)
+	if err != nil {
+		return err
+	}
+	return pollErr
+}
+
+// dotListing - a dot in 'dm list', on its current branch
+type dotListing struct {
+	Volume     client.DotmeshVolume
+	Branch     string
+	Current    bool
+	Containers []client.Container
+}
+
+// loggedCommit - a commit in 'dm log', and the branch it's on
+type loggedCommit struct {
+	client.Snapshot
+	Branch string
+}
+
+// branchListing - a branch in 'dm branch'
+type branchListing struct {
+	Name    string
+	Current bool
+}
+
+// remoteListing - a remote in 'dm remote', without its credentials
+type remoteListing struct {
+	Name    string
+	Current bool
+	// "dotmesh" or "s3"
+	Type     string
+	User     string `json:",omitempty"`
+	Hostname string `json:",omitempty"`
+	Port     int    `json:",omitempty"`
+	// whether the remote serves TLS, and the certificate fingerprint it's
+	// pinned to if any
+	TLS         bool   `json:",omitempty"`
+	Fingerprint string `json:",omitempty"`
+	KeyID       string `json:",omitempty"`
+	Endpoint    string `json:",omitempty"`
+}
+
+// dotDetails - a dot in 'dm dot show'
+type dotDetails struct {
+	Namespace      string
+	Name           string
+	MasterBranchId string
+	// the dot this one was forked from, if it's a fork
+	Upstream      *client.VolumeName `json:",omitempty"`
+	Selected      bool
+	CommitCount   int64
+	SizeBytes     int64
+	DirtyBytes    int64
+	CurrentBranch string
+	Branches      []branchDetails
+	// the dots on other remotes this one is pushed to and pulled from by
+	// default
+	DefaultUpstreams []defaultUpstream
+}
+
+type branchDetails struct {
+	Name   string
+	Volume client.DotmeshVolume
+	// only known for the current branch
+	Containers []string
+	// only for admins
+	Replication []replicaStatus `json:",omitempty"`
+}
+
+type replicaStatus struct {
+	Server         string
+	Master         bool
+	Status         string
+	MissingCommits []string
+}
+
+type defaultUpstream struct {
+	Remote string
+	Dot    client.VolumeName
+}
+
+// reclaimEstimate - what 'dm du reclaim' predicts
+type reclaimEstimate struct {
+	Branch       string
+	FromCommitId string `json:",omitempty"`
+	ToCommitId   string `json:",omitempty"`
+	Bytes        int64
+}
@@ -0,0 +1,378 @@
+package commands
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// testDots - what the fake server lists, in the namespaces and names
+// DotmeshRPC.ListWithContainers returns them under
+var testDots = map[string]map[string]client.DotmeshVolumeAndContainers{
+	"alice": {
+		"db": {
+			Volume: client.DotmeshVolume{
+				Id: "db-id", Name: client.VolumeName{Namespace: "alice", Name: "db"},
+				Master: "node1", SizeBytes: 3 * 1024 * 1024, DirtyBytes: 512, CommitCount: 2,
+				QuotaBytes: 1024 * 1024 * 1024, QuotaUsedBytes: 4 * 1024 * 1024,
+			},
+			Containers: []client.Container{{Id: "c1", Name: "postgres"}},
+		},
+	},
+	"admin": {
+		"cache": {
+			Volume: client.DotmeshVolume{
+				Id: "cache-id", Name: client.VolumeName{Namespace: "admin", Name: "cache"},
+				Master: "node2",
+			},
+		},
+	},
+}
+
+// startTestServer - a dotmesh server with testDots, and a config with it as
+// the current remote, selecting alice/db on its feature branch
+func startTestServer(t *testing.T) (string, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Id     uint64 `json:"id"`
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		var result interface{}
+		switch request.Method {
+		case "DotmeshRPC.ListWithContainers":
+			result = testDots
+		case "DotmeshRPC.Branches":
+			result = []string{"feature", "bugfix"}
+		default:
+			t.Errorf("unexpected call to %s", request.Method)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0", "id": request.Id, "result": result,
+		})
+	}))
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeTestConfig(t, map[string]interface{}{
+		"CurrentRemote": "local",
+		"Remotes": map[string]interface{}{
+			"local": map[string]interface{}{
+				"User": "admin", "Hostname": host, "Port": p, "ApiKey": "secret",
+				"CurrentVolume":   "alice/db",
+				"CurrentBranches": map[string]string{"alice/db": "feature"},
+			},
+		},
+	})
+	return path, func() {
+		server.Close()
+		os.RemoveAll(filepath.Dir(path))
+	}
+}
+
+func writeTestConfig(t *testing.T, config interface{}) string {
+	dir, err := ioutil.TempDir("", "dm-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config")
+	err = ioutil.WriteFile(path, bts, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runCommand - what a command prints with the config at path, in format
+func runCommand(path, format string, newCmd func(out *strings.Builder) *cobra.Command, args ...string) string {
+	defer func(path, format string, scripting bool) {
+		configPath, outputFormat, scriptingMode = path, format, scripting
+	}(configPath, outputFormat, scriptingMode)
+	configPath, outputFormat = path, format
+
+	out := &strings.Builder{}
+	cmd := newCmd(out)
+	cmd.SetArgs(args)
+	cmd.SetOutput(ioutil.Discard)
+	cmd.Execute()
+	return out.String()
+}
+
+func TestCheckOutputFormat(t *testing.T) {
+	defer func(format string) { outputFormat = format }(outputFormat)
+	for format, valid := range map[string]bool{
+		"table": true, "json": true, "yaml": true, "": false, "JSON": false, "xml": false,
+	} {
+		outputFormat = format
+		if err := checkOutputFormat(); (err == nil) != valid {
+			t.Errorf("%q: expected valid=%t, got %v", format, valid, err)
+		}
+	}
+}
+
+func TestListOutput(t *testing.T) {
+	path, stop := startTestServer(t)
+	defer stop()
+	newCmd := func(out *strings.Builder) *cobra.Command { return NewCmdList(out) }
+
+	tests := []struct {
+		format string
+		args   []string
+		want   string
+	}{
+		{"table", nil, "" +
+			"Current remote: local (use 'dm remote -v' to list and 'dm remote switch' to switch)\n" +
+			"\n" +
+			"  DOT       BRANCH   SERVER  CONTAINERS  SIZE      COMMITS  DIRTY     QUOTA\n" +
+			"* alice/db  feature  node1   postgres    3.00 MiB  2        0.50 kiB  4.00 MiB / 1.00 GiB  \n" +
+			"  cache     master   node2               -         0        -         -                    \n",
+		},
+		{"table", []string{"-H"}, "" +
+			"alice/db\tfeature\tnode1\tpostgres\t3145728\t2\t512\t4194304/1073741824\t\n" +
+			"cache\tmaster\tnode2\t\t0\t0\t0\t-\t\n",
+		},
+		{"json", nil, `[
+  {
+    "Volume": {
+      "Id": "db-id",
+      "Name": {
+        "Namespace": "alice",
+        "Name": "db"
+      },
+      "Clone": "",
+      "Master": "node1",
+      "SizeBytes": 3145728,
+      "DirtyBytes": 512,
+      "CommitCount": 2,
+      "ServerStatuses": null,
+      "QuotaBytes": 1073741824,
+      "QuotaUsedBytes": 4194304,
+      "ForkParentId": ""
+    },
+    "Branch": "feature",
+    "Current": true,
+    "Containers": [
+      {
+        "Id": "c1",
+        "Name": "postgres"
+      }
+    ]
+  },
+  {
+    "Volume": {
+      "Id": "cache-id",
+      "Name": {
+        "Namespace": "admin",
+        "Name": "cache"
+      },
+      "Clone": "",
+      "Master": "node2",
+      "SizeBytes": 0,
+      "DirtyBytes": 0,
+      "CommitCount": 0,
+      "ServerStatuses": null,
+      "QuotaBytes": 0,
+      "QuotaUsedBytes": 0,
+      "ForkParentId": ""
+    },
+    "Branch": "master",
+    "Current": false,
+    "Containers": []
+  }
+]
+`,
+		},
+		{"yaml", nil, `- Branch: feature
+  Containers:
+  - Id: c1
+    Name: postgres
+  Current: true
+  Volume:
+    Clone: ""
+    CommitCount: 2
+    DirtyBytes: 512
+    ForkParentId: ""
+    Id: db-id
+    Master: node1
+    Name:
+      Name: db
+      Namespace: alice
+    QuotaBytes: 1073741824
+    QuotaUsedBytes: 4194304
+    ServerStatuses: null
+    SizeBytes: 3145728
+- Branch: master
+  Containers: []
+  Current: false
+  Volume:
+    Clone: ""
+    CommitCount: 0
+    DirtyBytes: 0
+    ForkParentId: ""
+    Id: cache-id
+    Master: node2
+    Name:
+      Name: cache
+      Namespace: admin
+    QuotaBytes: 0
+    QuotaUsedBytes: 0
+    ServerStatuses: null
+    SizeBytes: 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		got := runCommand(path, tt.format, newCmd, tt.args...)
+		if got != tt.want {
+			t.Errorf("-o %s %v: expected:\n%s\ngot:\n%s", tt.format, tt.args, tt.want, got)
+		}
+	}
+}
+
+func TestBranchOutput(t *testing.T) {
+	path, stop := startTestServer(t)
+	defer stop()
+	newCmd := func(out *strings.Builder) *cobra.Command { return NewCmdBranch(out) }
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"table", "  bugfix\n* feature\n  master\n"},
+		{"json", `[
+  {
+    "Name": "bugfix",
+    "Current": false
+  },
+  {
+    "Name": "feature",
+    "Current": true
+  },
+  {
+    "Name": "master",
+    "Current": false
+  }
+]
+`,
+		},
+		{"yaml", `- Current: false
+  Name: bugfix
+- Current: true
+  Name: feature
+- Current: false
+  Name: master
+`,
+		},
+	}
+	for _, tt := range tests {
+		got := runCommand(path, tt.format, newCmd)
+		if got != tt.want {
+			t.Errorf("-o %s: expected:\n%s\ngot:\n%s", tt.format, tt.want, got)
+		}
+	}
+}
+
+func TestRemoteOutput(t *testing.T) {
+	path := writeTestConfig(t, map[string]interface{}{
+		"CurrentRemote": "local",
+		"Remotes": map[string]interface{}{
+			"local": map[string]interface{}{
+				"User": "admin", "Hostname": "127.0.0.1", "Port": 32607, "ApiKey": "secret",
+			},
+			"hub": map[string]interface{}{
+				"User": "alice", "Hostname": "dothub.com", "ApiKey": "secret",
+				"TLS": map[string]string{"Fingerprint": "ab:cd"},
+			},
+		},
+		"S3Remotes": map[string]interface{}{
+			"backups": map[string]interface{}{
+				"KeyID": "AKIA", "SecretKey": "secret", "Endpoint": "https://s3.example.com",
+			},
+		},
+	})
+	defer os.RemoveAll(filepath.Dir(path))
+	newCmd := func(out *strings.Builder) *cobra.Command { return NewCmdRemote(out) }
+
+	tests := []struct {
+		format string
+		args   []string
+		want   string
+	}{
+		{"table", nil, "backups\nhub\nlocal\n"},
+		{"table", []string{"-v"}, "backups\tAKIA\n  hub\thttps://alice@dothub.com\n* local\tadmin@127.0.0.1\n"},
+		// credentials are never printed
+		{"json", nil, `[
+  {
+    "Name": "backups",
+    "Current": false,
+    "Type": "s3",
+    "KeyID": "AKIA",
+    "Endpoint": "https://s3.example.com"
+  },
+  {
+    "Name": "hub",
+    "Current": false,
+    "Type": "dotmesh",
+    "User": "alice",
+    "Hostname": "dothub.com",
+    "TLS": true,
+    "Fingerprint": "ab:cd"
+  },
+  {
+    "Name": "local",
+    "Current": true,
+    "Type": "dotmesh",
+    "User": "admin",
+    "Hostname": "127.0.0.1",
+    "Port": 32607
+  }
+]
+`,
+		},
+		{"yaml", nil, `- Current: false
+  Endpoint: https://s3.example.com
+  KeyID: AKIA
+  Name: backups
+  Type: s3
+- Current: false
+  Fingerprint: ab:cd
+  Hostname: dothub.com
+  Name: hub
+  TLS: true
+  Type: dotmesh
+  User: alice
+- Current: true
+  Hostname: 127.0.0.1
+  Name: local
+  Port: 32607
+  Type: dotmesh
+  User: admin
+`,
+		},
+	}
+	for _, tt := range tests {
+		got := runCommand(path, tt.format, newCmd, tt.args...)
+		if got != tt.want {
+			t.Errorf("-o %s %v: expected:\n%s\ngot:\n%s", tt.format, tt.args, tt.want, got)
+		}
+	}
+}
@@ -54,7 +54,7 @@ Online help: https://docs.dotmesh.com/references/cli/#pull-dm-pull-remote-dot-br
 				if err != nil {
 					return err
 				}
-				err = dm.PollTransfer(transferId, out)
+				err = pollTransfer(dm, transferId, out)
 				if err != nil {
 					return err
 				}
@@ -3,9 +3,12 @@ package commands
 import (
 	"fmt"
 	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/howeyc/gopass"
 	"github.com/spf13/cobra"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"sort"
 	"strconv"
@@ -14,6 +17,7 @@ import (
 
 func NewCmdRemote(out io.Writer) *cobra.Command {
 	var verbose bool
+	var caCertFile, fingerprint string
 	cmd := &cobra.Command{
 		Use:   "remote [-v]",
 		Short: "List remote clusters. Use dm remote -v to see remotes",
@@ -39,6 +43,29 @@ func NewCmdRemote(out io.Writer) *cobra.Command {
 					keys = append(keys, k)
 				}
 				sort.Strings(keys)
+				if structuredOutput() {
+					currentRemote := dm.Configuration.GetCurrentRemote()
+					listings := []remoteListing{}
+					for _, k := range keys {
+						listing := remoteListing{Name: k, Current: k == currentRemote}
+						if remote, ok := remotes[k]; ok {
+							listing.Type = "dotmesh"
+							listing.User = remote.User
+							listing.Hostname = remote.Hostname
+							listing.Port = remote.Port
+							if remote.TLS != nil {
+								listing.TLS = true
+								listing.Fingerprint = remote.TLS.Fingerprint
+							}
+						} else {
+							listing.Type = "s3"
+							listing.KeyID = s3Remotes[k].KeyID
+							listing.Endpoint = s3Remotes[k].Endpoint
+						}
+						listings = append(listings, listing)
+					}
+					return printStructured(out, listings)
+				}
 				if verbose {
 					currentRemote := dm.Configuration.GetCurrentRemote()
 					for _, k := range keys {
@@ -50,9 +77,13 @@ func NewCmdRemote(out io.Writer) *cobra.Command {
 						}
 						remote, ok := remotes[k]
 						if ok {
+							scheme := ""
+							if remote.TLS != nil {
+								scheme = "https://"
+							}
 							fmt.Fprintf(
-								out, "%s%s\t%s@%s\n",
-								current, k, remote.User, remote.Hostname,
+								out, "%s%s\t%s%s@%s\n",
+								current, k, scheme, remote.User, remote.Hostname,
 							)
 						} else {
 							fmt.Fprintf(
@@ -70,10 +101,15 @@ func NewCmdRemote(out io.Writer) *cobra.Command {
 			})
 		},
 	}
-	cmd.AddCommand(&cobra.Command{
-		Use:   "add <remote-name> <user@cluster-hostname>[:<port-number>]",
+	addCmd := &cobra.Command{
+		Use:   "add <remote-name> [https://]<user@cluster-hostname>[:<port-number>]",
 		Short: "Add a remote",
-		Long:  "Online help: https://docs.dotmesh.com/references/cli/#add-a-new-remote-dm-remote-add-name-user-hostname",
+		Long: `Add a remote. Prefix the cluster with https:// if it serves its API over TLS,
+with a certificate signed by a CA this machine trusts. Otherwise give the CA
+with --ca-cert (e.g. the cluster's ~/.dotmesh/pki/ca.pem), or pin the
+certificate with --fingerprint, which both imply https://.
+
+Online help: https://docs.dotmesh.com/references/cli/#add-a-new-remote-dm-remote-add-name-user-hostname`,
 
 		Run: func(cmd *cobra.Command, args []string) {
 			runHandlingError(func() error {
@@ -83,7 +119,21 @@ func NewCmdRemote(out io.Writer) *cobra.Command {
 					)
 				}
 				remote := args[0]
-				shrapnel := strings.SplitN(args[1], "@", 2)
+				var remoteTLS *types.RemoteTLS
+				if strings.HasPrefix(args[1], "https://") || caCertFile != "" || fingerprint != "" {
+					remoteTLS = &types.RemoteTLS{}
+				}
+				if caCertFile != "" {
+					caCert, err := ioutil.ReadFile(caCertFile)
+					if err != nil {
+						return err
+					}
+					remoteTLS.CACert = string(caCert)
+				}
+				if fingerprint != "" {
+					remoteTLS.Fingerprint = client.NormalizeFingerprint(fingerprint)
+				}
+				shrapnel := strings.SplitN(strings.TrimPrefix(args[1], "https://"), "@", 2)
 				if len(shrapnel) != 2 {
 					return fmt.Errorf(
 						"Please specify user@cluster-hostname, got %s", shrapnel,
@@ -123,19 +173,23 @@ func NewCmdRemote(out io.Writer) *cobra.Command {
 					}
 					apiKey = string(enteredApiKey)
 				}
-				client := &client.JsonRpcClient{
+				rpcClient := &client.JsonRpcClient{
 					User:     user,
 					Hostname: hostname,
 					Port:     port,
 					ApiKey:   apiKey,
+					TLS:      remoteTLS,
 				}
-				_, err = client.Ping()
+				_, err = rpcClient.Ping()
 
 				if err != nil {
+					if remoteTLS != nil && client.IsCertificateError(err) {
+						printCertificateFingerprint(out, hostname, port, remoteTLS)
+					}
 					return err
 				}
 
-				err = dm.Configuration.AddRemote(remote, user, hostname, port, string(apiKey))
+				err = dm.Configuration.AddRemote(remote, user, hostname, port, string(apiKey), remoteTLS)
 				if err != nil {
 					return err
 				}
@@ -151,7 +205,16 @@ func NewCmdRemote(out io.Writer) *cobra.Command {
 				return nil
 			})
 		},
-	})
+	}
+	addCmd.Flags().StringVar(
+		&caCertFile, "ca-cert", "",
+		"PEM encoded CA bundle to check the cluster's certificate with, as well as the CAs this machine trusts",
+	)
+	addCmd.Flags().StringVar(
+		&fingerprint, "fingerprint", "",
+		"SHA-256 fingerprint the cluster's certificate must have, whoever signed it",
+	)
+	cmd.AddCommand(addCmd)
 	cmd.AddCommand(&cobra.Command{
 		Use:   "rm <remote>",
 		Short: "Remove a remote",
@@ -194,3 +257,25 @@ func NewCmdRemote(out io.Writer) *cobra.Command {
 	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose list of remotes")
 	return cmd
 }
+
+// printCertificateFingerprint - tells the user the fingerprint of the
+// certificate the cluster presented when it wasn't trusted, so that once
+// they've checked it's the cluster's they can pin it
+func printCertificateFingerprint(out io.Writer, hostname string, port int, remoteTLS *types.RemoteTLS) {
+	base, _ := client.BaseURL(hostname, port, remoteTLS)
+	u, err := url.Parse(base)
+	if err != nil {
+		return
+	}
+	cert, err := client.FetchCertificate(u.Host)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(
+		out, "The cluster presented a certificate for %s with SHA-256 fingerprint\n\n"+
+			"    %s\n\n"+
+			"If you trust it, add the remote with --fingerprint %s\n",
+		cert.Subject.CommonName, client.CertificateFingerprint(cert.Raw),
+		client.CertificateFingerprint(cert.Raw),
+	)
+}
@@ -99,7 +99,7 @@ func NewCmdS3(out io.Writer) *cobra.Command {
 				if err != nil {
 					return err
 				}
-				err = dm.PollTransfer(transferId, out)
+				err = pollTransfer(dm, transferId, out)
 				if err != nil {
 					return err
 				}
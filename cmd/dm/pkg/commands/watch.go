@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+var watchBranch string
+var watchTypes []string
+
+func NewCmdWatch(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch [<dot>] [--branch=<branch>] [--type=<type>]",
+		Short: "Follow activity on dots as it happens",
+		Long: `Print commits, new branches, deleted dots, transfers and state changes
+as they happen on the current remote, until interrupted.
+
+With a <dot>, only show activity on that dot, optionally only on
+--branch. Use --type (repeatable) to only show some kinds of activity:
+` + strings.Join(watchableEventTypes(), ", "),
+		Run: func(cmd *cobra.Command, args []string) {
+			runHandlingError(func() error {
+				if len(args) > 1 {
+					return fmt.Errorf("Please specify at most one dot.")
+				}
+				dm, err := client.NewDotmeshAPI(configPath, verboseOutput)
+				if err != nil {
+					return err
+				}
+
+				filter := client.ActivityFilter{
+					Types: watchTypes,
+				}
+				if len(args) == 1 {
+					filter.Namespace, filter.Name, err = client.ParseNamespacedVolume(args[0])
+					if err != nil {
+						return err
+					}
+					filter.Branch = watchBranch
+				} else if watchBranch != "" {
+					return fmt.Errorf("Please specify a dot to watch a branch of.")
+				}
+
+				stream, err := dm.WatchActivity(context.Background(), filter)
+				if err != nil {
+					return err
+				}
+				defer stream.Close()
+
+				for {
+					event, err := stream.Next()
+					if err != nil {
+						if err == io.EOF {
+							return fmt.Errorf("Server closed the activity stream.")
+						}
+						return err
+					}
+					fmt.Fprintln(out, formatActivity(time.Now(), event))
+				}
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&watchBranch, "branch", "b", "", "Only show activity on this branch of the dot")
+	cmd.Flags().StringSliceVarP(&watchTypes, "type", "t", []string{}, "Only show this kind of activity")
+	return cmd
+}
+
+func watchableEventTypes() []string {
+	return append(append([]string{}, types.EventTypes...), types.EventTypeTransferProgress, types.EventTypeStateChanged)
+}
+
+// formatActivity - one line per event, with the time it was received
+func formatActivity(at time.Time, event *types.EventNotification) string {
+	dot := event.Namespace + "/" + event.Name
+	if event.Branch != "" {
+		dot += "@" + event.Branch
+	}
+
+	var detail string
+	switch event.Type {
+	case types.EventTypeCommit:
+		detail = event.CommitId
+		if event.Metadata != nil && event.Metadata["message"] != "" {
+			detail += fmt.Sprintf(" %q", event.Metadata["message"])
+		}
+	case types.EventTypeTransferProgress, types.EventTypeTransferFinished:
+		if t := event.Transfer; t != nil {
+			detail = fmt.Sprintf(
+				"%s %s %s (%d/%d) %s/%s",
+				t.Direction, t.Peer, t.Status, t.Index, t.Total,
+				prettyPrintSize(t.Sent), prettyPrintSize(t.Size),
+			)
+		}
+	case types.EventTypeStateChanged:
+		detail = event.State
+		if event.Status != "" {
+			detail += ": " + event.Status
+		}
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s  %-18s %s  %s", at.Format("15:04:05"), event.Type, dot, detail))
+}
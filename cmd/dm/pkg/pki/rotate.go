@@ -0,0 +1,169 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"time"
+
+	certutil "github.com/dotmesh-io/dotmesh/cmd/dm/pkg/cert"
+)
+
+// CA ROTATION
+//
+// ca.pem holds the certificates of every CA a node trusts, and ca-key.pem
+// the key of the first, which issues the node's certificates. Rotating to a
+// new CA happens in three steps, each done on every node before the next
+// is started, so that every pair of nodes can always talk to each other:
+//
+// 1. NewCA on one node, and TrustCA with its new CA on the others, so that
+//    every node trusts both CAs and issues certificates with the new one.
+// 2. CreatePKIAssets with ExtantCA on every node, to reissue its server
+//    certificate with the new CA.
+// 3. DropOldCAs on every node, so that only the new CA is trusted.
+
+// LoadCA - the key of the CA that issues certificates, and the certificates
+// of every CA trusted, the issuing one first
+func LoadCA(pkiPath string) (*rsa.PrivateKey, []*x509.Certificate, error) {
+	_, prv, cert := pathsKeysCerts(pkiPath, "ca")
+	caCerts, err := certutil.CertsFromFile(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	caKeyBytes, err := ioutil.ReadFile(prv)
+	if err != nil {
+		return nil, nil, err
+	}
+	caKey, err := parseCAKey(caKeyBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", prv, err)
+	}
+	return caKey, caCerts, nil
+}
+
+func parseCAKey(keyPEM []byte) (*rsa.PrivateKey, error) {
+	key, err := certutil.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unable to cast %T to *rsa.PrivateKey", key)
+	}
+	return rsaKey, nil
+}
+
+// ReadCA - the PEM encoded certificate and key of the CA that issues
+// certificates, to hand to TrustCA on other nodes
+func ReadCA(pkiPath string) ([]byte, []byte, error) {
+	caKey, caCerts, err := LoadCA(pkiPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certutil.EncodeCertPEM(caCerts[0]), certutil.EncodePrivateKeyPEM(caKey), nil
+}
+
+// NewCA - makes a new CA to issue certificates with, still trusting the
+// CAs trusted before
+func NewCA(pkiPath string) error {
+	_, caCerts, err := LoadCA(pkiPath)
+	if err != nil {
+		return err
+	}
+	caKey, caCert, err := newCertificateAuthority()
+	if err != nil {
+		return fmt.Errorf("failure while creating CA keys and certificate - %v", err)
+	}
+	return writeCA(pkiPath, caKey, append([]*x509.Certificate{caCert}, caCerts...))
+}
+
+// TrustCA - like NewCA, but with a CA made by NewCA on another node. It does
+// nothing if the CA already issues certificates here.
+func TrustCA(pkiPath string, certPEM, keyPEM []byte) error {
+	_, caCerts, err := LoadCA(pkiPath)
+	if err != nil {
+		return err
+	}
+	newCerts, err := certutil.ParseCertsPEM(certPEM)
+	if err != nil {
+		return err
+	}
+	caKey, err := parseCAKey(keyPEM)
+	if err != nil {
+		return err
+	}
+	caCert := newCerts[0]
+	if bytes.Equal(caCert.Raw, caCerts[0].Raw) {
+		return nil
+	}
+	trusted := []*x509.Certificate{caCert}
+	for _, c := range caCerts {
+		if !bytes.Equal(c.Raw, caCert.Raw) {
+			trusted = append(trusted, c)
+		}
+	}
+	return writeCA(pkiPath, caKey, trusted)
+}
+
+// DropOldCAs - stops trusting every CA but the one that issues certificates,
+// returning how many were dropped
+func DropOldCAs(pkiPath string) (int, error) {
+	caKey, caCerts, err := LoadCA(pkiPath)
+	if err != nil {
+		return 0, err
+	}
+	if len(caCerts) == 1 {
+		return 0, nil
+	}
+	return len(caCerts) - 1, writeCA(pkiPath, caKey, caCerts[:1])
+}
+
+func writeCA(pkiPath string, caKey *rsa.PrivateKey, caCerts []*x509.Certificate) error {
+	if err := writeKeysAndCert(pkiPath, "ca", caKey, nil); err != nil {
+		return fmt.Errorf("failure while saving CA keys - %v", err)
+	}
+	bundle := []byte{}
+	for _, c := range caCerts {
+		bundle = append(bundle, certutil.EncodeCertPEM(c)...)
+	}
+	_, _, cert := pathsKeysCerts(pkiPath, "ca")
+	if err := certutil.WriteCert(cert, bundle); err != nil {
+		return fmt.Errorf("unable to write certificate file (%q) [%v]", cert, err)
+	}
+	return nil
+}
+
+// Expiry - when a certificate in the PKI directory expires
+type Expiry struct {
+	File     string
+	Subject  string
+	NotAfter time.Time
+}
+
+// Expiries - when each of the CA and server certificates expire, soonest
+// first
+func Expiries(pkiPath string) ([]Expiry, error) {
+	result := []Expiry{}
+	for _, name := range []string{"ca", "apiserver"} {
+		_, _, cert := pathsKeysCerts(pkiPath, name)
+		certs, err := certutil.CertsFromFile(cert)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range certs {
+			result = append(result, Expiry{
+				File:     path.Base(cert),
+				Subject:  c.Subject.CommonName,
+				NotAfter: c.NotAfter,
+			})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].NotAfter.Before(result[j].NotAfter)
+	})
+	return result, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/dotmesh-io/dotmesh/pkg/user"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how often a comment is sent down idle activity streams, so that proxies
+// don't time them out and clients notice dead connections
+const activityKeepAliveInterval = 15 * time.Second
+
+// ActivityHandler - streams activity on dots as Server-Sent Events, so that
+// clients can follow commits, branches, transfers and state changes rather
+// than polling for them. Events can be filtered with the query parameters:
+//
+//	namespace, name - only events about this namespace, or dot in it
+//	branch          - only events about this branch
+//	types           - comma separated event types, defaults to all of them
+//	transfer        - only events about this transfer request
+//
+// Only events about dots the authenticated user owns or collaborates on are
+// sent.
+type ActivityHandler struct {
+	state *InMemoryState
+}
+
+func NewActivityHandler(state *InMemoryState) http.Handler {
+	return &ActivityHandler{state: state}
+}
+
+type activityFilter struct {
+	namespace  string
+	name       string
+	branch     string
+	types      map[string]bool
+	transferId string
+}
+
+func (f *activityFilter) matches(event *types.EventNotification) bool {
+	if f.namespace != "" && event.Namespace != f.namespace {
+		return false
+	}
+	if f.name != "" && event.Name != f.name {
+		return false
+	}
+	if f.branch != "" && event.Branch != f.branch {
+		return false
+	}
+	if len(f.types) > 0 && !f.types[event.Type] {
+		return false
+	}
+	if f.transferId != "" && (event.Transfer == nil || event.Transfer.TransferRequestId != f.transferId) {
+		return false
+	}
+	return true
+}
+
+func (h *ActivityHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		http.Error(resp, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	if h.state.messenger == nil {
+		http.Error(resp, "Messaging is not available on this node", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := req.URL.Query()
+	filter := &activityFilter{
+		namespace:  query.Get("namespace"),
+		name:       query.Get("name"),
+		branch:     query.Get("branch"),
+		types:      map[string]bool{},
+		transferId: query.Get("transfer"),
+	}
+	if t := query.Get("types"); t != "" {
+		for _, eventType := range strings.Split(t, ",") {
+			filter.types[eventType] = true
+		}
+	}
+
+	// narrow the subscription down to the dot if we can, which also tells
+	// the user up front if they can't see it
+	subscribeQuery := &types.SubscribeQuery{Type: types.EventTypeActivity}
+	if filter.namespace != "" && filter.name != "" {
+		tlf, err := h.state.registry.LookupFilesystem(VolumeName{Namespace: filter.namespace, Name: filter.name})
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusNotFound)
+			return
+		}
+		authorized, err := tlf.Authorize(req.Context())
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !authorized {
+			http.Error(resp, "Permission denied", http.StatusForbidden)
+			return
+		}
+		subscribeQuery.FilesystemID = tlf.MasterBranch.Id
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	events, err := h.state.messenger.Subscribe(ctx, subscribeQuery)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		// the messenger blocks until events are received, so keep receiving
+		// until it's noticed we've gone
+		go func() {
+			for range events {
+			}
+		}()
+	}()
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(activityKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(resp, ": keep-alive\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			event, decodeErr := activityFromEvent(e)
+			if decodeErr != nil {
+				log.WithFields(log.Fields{
+					"error":    decodeErr,
+					"event_id": e.ID,
+				}).Warn("[ActivityHandler] failed to decode activity event")
+				continue
+			}
+			if !filter.matches(event) {
+				continue
+			}
+			authorized, authErr := authorizeActivity(req.Context(), event)
+			if authErr != nil || !authorized {
+				continue
+			}
+			data, marshalErr := json.Marshal(event)
+			if marshalErr != nil {
+				continue
+			}
+			_, err = fmt.Fprintf(resp, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, event.Type, data)
+		}
+		if err != nil {
+			// client went away
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// activityFromEvent - events come over the messenger as JSON, so the
+// EventNotification in their args arrives as a map
+func activityFromEvent(e *types.Event) (*types.EventNotification, error) {
+	if e.Args == nil {
+		return nil, fmt.Errorf("activity event has no args")
+	}
+	bts, err := json.Marshal((*e.Args)["Event"])
+	if err != nil {
+		return nil, err
+	}
+	var event types.EventNotification
+	err = json.Unmarshal(bts, &event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// authorizeActivity - applies the same rules as for reading the dot, using
+// the owner and collaborators recorded in the event, as the dot may be gone
+// by the time it's sent (e.g. when it's been deleted)
+func authorizeActivity(ctx context.Context, event *types.EventNotification) (bool, error) {
+	tlf := types.TopLevelFilesystem{
+		Owner: user.SafeUser{Id: event.OwnerID},
+	}
+	for _, id := range event.CollaboratorIDs {
+		tlf.Collaborators = append(tlf.Collaborators, user.SafeUser{Id: id})
+	}
+	return tlf.Authorize(ctx)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// activityMessenger - has every subscription receive events, and then end
+type activityMessenger struct {
+	events  []*types.Event
+	queries []*types.SubscribeQuery
+}
+
+func (m *activityMessenger) Publish(e *types.Event) error {
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *activityMessenger) Subscribe(ctx context.Context, q *types.SubscribeQuery) (chan *types.Event, error) {
+	m.queries = append(m.queries, q)
+	ch := make(chan *types.Event, len(m.events))
+	for _, e := range m.events {
+		ch <- e
+	}
+	close(ch)
+	return ch, nil
+}
+
+// activityEvent - an activity event as it arrives over the messenger, having
+// been through JSON
+func activityEvent(t *testing.T, id string, event types.EventNotification) *types.Event {
+	bts, err := json.Marshal(&types.Event{
+		ID:           id,
+		Name:         event.Type,
+		FilesystemID: event.FilesystemId,
+		Type:         types.EventTypeActivity,
+		Args:         &types.EventArgs{"Event": event},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e types.Event
+	err = json.Unmarshal(bts, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &e
+}
+
+func TestActivityFromEvent(t *testing.T) {
+	e := activityEvent(t, "e1", types.EventNotification{
+		Type:            types.EventTypeTransferProgress,
+		FilesystemId:    testDotId,
+		Namespace:       "alice",
+		Name:            "db",
+		OwnerID:         "alice-id",
+		CollaboratorIDs: []string{"bob-id"},
+		Transfer:        &types.TransferNotification{TransferRequestId: "t1", Index: 1, Total: 2},
+	})
+	event, err := activityFromEvent(e)
+	if err != nil {
+		t.Fatalf("expected the event to decode, got: %s", err)
+	}
+	if event.Type != types.EventTypeTransferProgress || event.Namespace != "alice" || event.Name != "db" {
+		t.Errorf("unexpected event: %#v", event)
+	}
+	if event.Transfer == nil || event.Transfer.TransferRequestId != "t1" || event.Transfer.Total != 2 {
+		t.Errorf("unexpected transfer: %#v", event.Transfer)
+	}
+	if len(event.CollaboratorIDs) != 1 || event.CollaboratorIDs[0] != "bob-id" {
+		t.Errorf("unexpected collaborators: %v", event.CollaboratorIDs)
+	}
+
+	_, err = activityFromEvent(&types.Event{ID: "e2"})
+	if err == nil {
+		t.Error("expected an event without args not to decode")
+	}
+}
+
+func TestActivityFilterMatches(t *testing.T) {
+	event := &types.EventNotification{
+		Type:      types.EventTypeTransferProgress,
+		Namespace: "alice",
+		Name:      "db",
+		Branch:    "feature",
+		Transfer:  &types.TransferNotification{TransferRequestId: "t1"},
+	}
+	tests := []struct {
+		name   string
+		filter activityFilter
+		want   bool
+	}{
+		{"everything", activityFilter{}, true},
+		{"namespace", activityFilter{namespace: "alice"}, true},
+		{"other namespace", activityFilter{namespace: "bob"}, false},
+		{"dot", activityFilter{namespace: "alice", name: "db"}, true},
+		{"other dot", activityFilter{namespace: "alice", name: "web"}, false},
+		{"branch", activityFilter{branch: "feature"}, true},
+		{"other branch", activityFilter{branch: "master"}, false},
+		{"type", activityFilter{types: map[string]bool{types.EventTypeCommit: true, types.EventTypeTransferProgress: true}}, true},
+		{"other type", activityFilter{types: map[string]bool{types.EventTypeCommit: true}}, false},
+		{"transfer", activityFilter{transferId: "t1"}, true},
+		{"other transfer", activityFilter{transferId: "t2"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.matches(event); got != tt.want {
+			t.Errorf("%s: expected %t, got %t", tt.name, tt.want, got)
+		}
+	}
+
+	// events that aren't about a transfer never match one
+	filter := activityFilter{transferId: "t1"}
+	if filter.matches(&types.EventNotification{Type: types.EventTypeCommit}) {
+		t.Error("expected a commit not to match a transfer filter")
+	}
+}
+
+func TestAuthorizeActivity(t *testing.T) {
+	event := &types.EventNotification{OwnerID: "alice-id", CollaboratorIDs: []string{"bob-id"}}
+	tests := []struct {
+		userId string
+		want   bool
+	}{
+		{"alice-id", true},
+		{"bob-id", true},
+		{"carol-id", false},
+		{ADMIN_USER_UUID, true},
+	}
+	for _, tt := range tests {
+		authorized, err := authorizeActivity(requestAs(tt.userId).Context(), event)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.userId, err)
+			continue
+		}
+		if authorized != tt.want {
+			t.Errorf("%s: expected authorized=%t, got %t", tt.userId, tt.want, authorized)
+		}
+	}
+
+	_, err := authorizeActivity(context.Background(), event)
+	if err == nil {
+		t.Error("expected authorizing activity without a user to fail")
+	}
+}
+
+func TestActivityHandler(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	messenger := &activityMessenger{}
+	c.rpc.state.messenger = messenger
+	handler := NewActivityHandler(c.rpc.state)
+
+	aliceDb := types.EventNotification{
+		Type: types.EventTypeCommit, FilesystemId: testDotId, Namespace: "alice", Name: "db",
+		OwnerID: c.alice.Id, CollaboratorIDs: []string{c.bob.Id},
+	}
+	bobDb := types.EventNotification{
+		Type: types.EventTypeCommit, FilesystemId: testForkId, Namespace: "bob", Name: "db",
+		OwnerID: c.bob.Id,
+	}
+	branch := aliceDb
+	branch.Type = types.EventTypeBranchCreated
+	branch.Branch = "feature"
+	messenger.events = []*types.Event{
+		activityEvent(t, "e1", aliceDb),
+		activityEvent(t, "e2", bobDb),
+		activityEvent(t, "e3", branch),
+		{ID: "e4", Type: types.EventTypeActivity},
+	}
+
+	stream := func(userId, query string) *httptest.ResponseRecorder {
+		r := requestAs(userId)
+		r = httptest.NewRequest("GET", "/activity?"+query, nil).WithContext(r.Context())
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, r)
+		return resp
+	}
+	ids := func(body string) []string {
+		result := []string{}
+		for _, line := range strings.Split(body, "\n") {
+			if strings.HasPrefix(line, "id: ") {
+				result = append(result, strings.TrimPrefix(line, "id: "))
+			}
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		userId string
+		query  string
+		want   []string
+	}{
+		// events that don't decode are skipped
+		{"admin", ADMIN_USER_UUID, "", []string{"e1", "e2", "e3"}},
+		{"collaborator", c.bob.Id, "", []string{"e1", "e2", "e3"}},
+		{"owner", c.alice.Id, "", []string{"e1", "e3"}},
+		{"not a collaborator", c.carol.Id, "", []string{}},
+		{"filtered by type", c.bob.Id, "types=branch.created", []string{"e3"}},
+		{"filtered by branch", c.bob.Id, "branch=feature", []string{"e3"}},
+		{"filtered by namespace", c.bob.Id, "namespace=bob", []string{"e2"}},
+		{"filtered by dot", c.bob.Id, "namespace=alice&name=db&types=commit", []string{"e1"}},
+	}
+	for _, tt := range tests {
+		resp := stream(tt.userId, tt.query)
+		if resp.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d: %s", tt.name, resp.Code, resp.Body.String())
+			continue
+		}
+		if resp.Header().Get("Content-Type") != "text/event-stream" {
+			t.Errorf("%s: expected an event stream, got %s", tt.name, resp.Header().Get("Content-Type"))
+		}
+		got := ids(resp.Body.String())
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: expected events %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	// asking for a dot subscribes to just its events...
+	messenger.queries = nil
+	stream(c.bob.Id, "namespace=alice&name=db")
+	if len(messenger.queries) != 1 || messenger.queries[0].FilesystemID != testDotId {
+		t.Errorf("expected to subscribe to %s's events, got %#v", testDotId, messenger.queries)
+	}
+	// ...if the user can see it
+	messenger.queries = nil
+	resp := stream(c.carol.Id, "namespace=alice&name=db")
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected someone who isn't a collaborator to be refused, got %d", resp.Code)
+	}
+	if len(messenger.queries) != 0 {
+		t.Error("expected no subscription for someone who isn't a collaborator")
+	}
+	resp = stream(c.bob.Id, "namespace=alice&name=missing")
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected a dot that doesn't exist not to be found, got %d", resp.Code)
+	}
+
+	// the SSE framing
+	resp = stream(c.bob.Id, "types=branch.created")
+	data, err := json.Marshal(&branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id: e3\nevent: branch.created\ndata: " + string(data) + "\n\n"
+	if resp.Body.String() != want {
+		t.Errorf("expected %q, got %q", want, resp.Body.String())
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+// COMMIT MOUNTS
+//
+// Commits are mounted read-only, as zfs snapshots at mnt(filesystemId@commit),
+// for as long as anything is using them. Each user is a holder:
+//
+// * docker:MOUNTID - a docker volume named dot@commit (or pinned to a commit
+//   by options or labels), until docker unmounts it
+// * link:PATH - a symlink on the host made by `dm mount dot@commit PATH`, until
+//   it's removed or pointed somewhere else
+// * anything else a MountCommit caller chooses, until it calls UnmountCommit
+//
+// Once the last holder has gone, the commit is unmounted. Holders are kept in
+// a file under the container mount prefix, so commits in use aren't forgotten
+// when the server restarts.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/utils"
+	"github.com/dotmesh-io/dotmesh/pkg/zfs"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const COMMIT_MOUNTS_FILE = ".commit-mounts.json"
+
+// HOST_ROOT - the host's root filesystem as seen from here, where the symlinks
+// dm mount makes can be checked (the server runs in the host's pid namespace)
+const HOST_ROOT = "/proc/1/root"
+
+const COMMIT_MOUNT_DOCKER_HOLDER = "docker:"
+const COMMIT_MOUNT_LINK_HOLDER = "link:"
+
+// COMMIT_MOUNT_LINK_GRACE - how long dm mount has to make its symlink after
+// mounting a commit, before the commit is let go of for want of one
+const COMMIT_MOUNT_LINK_GRACE = time.Minute
+
+// COMMIT_MOUNT_TIMEOUT - how long to wait for a filesystem's state machine to
+// mount or unmount a commit, longer than a policy hook can keep it busy
+const COMMIT_MOUNT_TIMEOUT = 3 * time.Minute
+
+type commitMount struct {
+	FilesystemId string
+	CommitId     string
+	// holder => when it started using the commit
+	Holders map[string]time.Time
+}
+
+func (c *commitMount) path() string {
+	return utils.Mnt(zfs.FullIdWithSnapshot(c.FilesystemId, c.CommitId))
+}
+
+// rpcCommitHolder - the holder for a MountCommit or UnmountCommit caller.
+// Docker and symlink holders are only let go of when docker unmounts or the
+// symlink goes, so callers can't claim to be one.
+func rpcCommitHolder(holder string) (string, error) {
+	if holder == "" {
+		// callers that don't say who they are share a holder, and are
+		// expected to UnmountCommit it when they're done
+		return "rpc", nil
+	}
+	if strings.HasPrefix(holder, COMMIT_MOUNT_DOCKER_HOLDER) || strings.HasPrefix(holder, COMMIT_MOUNT_LINK_HOLDER) {
+		return "", fmt.Errorf("Commit mount holders starting %s or %s are reserved", COMMIT_MOUNT_DOCKER_HOLDER, COMMIT_MOUNT_LINK_HOLDER)
+	}
+	return holder, nil
+}
+
+func commitMountsPath() string {
+	return filepath.Join(CONTAINER_MOUNT_PREFIX, COMMIT_MOUNTS_FILE)
+}
+
+// loadCommitMounts - picks up the commit mounts from before a restart
+func (s *InMemoryState) loadCommitMounts() error {
+	serialized, err := ioutil.ReadFile(commitMountsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	mounts := map[string]*commitMount{}
+	err = json.Unmarshal(serialized, &mounts)
+	if err != nil {
+		return err
+	}
+	s.commitMountsLock.Lock()
+	defer s.commitMountsLock.Unlock()
+	s.commitMounts = mounts
+	return nil
+}
+
+// saveCommitMounts - call with commitMountsLock held
+func (s *InMemoryState) saveCommitMounts() error {
+	serialized, err := json.Marshal(s.commitMounts)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(CONTAINER_MOUNT_PREFIX, 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(commitMountsPath(), serialized, 0600)
+}
+
+// commitMountLock - serializes mounting and unmounting one commit, which
+// means asking its filesystem's state machine to, without holding
+// commitMountsLock meanwhile. Commits of other filesystems can be mounted
+// while one filesystem's state machine is busy (e.g. running a policy hook).
+type commitMountLock struct {
+	sync.Mutex
+	// how many are holding or waiting for it, so it can be forgotten
+	users int
+}
+
+// lockCommitMount - returns the function to unlock it again
+func (s *InMemoryState) lockCommitMount(key string) func() {
+	s.commitMountsLock.Lock()
+	l, ok := s.commitMountLocks[key]
+	if !ok {
+		l = &commitMountLock{}
+		s.commitMountLocks[key] = l
+	}
+	l.users++
+	s.commitMountsLock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.commitMountsLock.Lock()
+		defer s.commitMountsLock.Unlock()
+		l.users--
+		if l.users == 0 {
+			delete(s.commitMountLocks, key)
+		}
+	}
+}
+
+// commitMountRequest - asks a filesystem's state machine to mount or unmount
+// a commit, giving up if it hasn't after COMMIT_MOUNT_TIMEOUT
+func (s *InMemoryState) commitMountRequest(ctx context.Context, filesystemId string, event *Event, expected string) (*Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, COMMIT_MOUNT_TIMEOUT)
+	defer cancel()
+	responseChan, err := s.globalFsRequest(ctx, filesystemId, event)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case e := <-responseChan:
+		if e == nil {
+			return nil, fmt.Errorf("No response to %s of %s", event.Name, filesystemId)
+		}
+		if e.Name != expected {
+			return nil, maybeError(e, expected)
+		}
+		return e, nil
+	case <-ctx.Done():
+		// the response may yet come, and needs somewhere to go
+		go func() {
+			for range responseChan {
+			}
+		}()
+		return nil, fmt.Errorf("Gave up waiting for %s of %s: %s", event.Name, filesystemId, ctx.Err())
+	}
+}
+
+// mountCommit - mounts a commit, if it isn't already, for holder, returning
+// where it's mounted
+func (s *InMemoryState) mountCommit(ctx context.Context, filesystemId, commitId, holder string) (string, error) {
+	if holder == "" {
+		return "", fmt.Errorf("A commit mount needs a holder")
+	}
+	if strings.HasPrefix(holder, COMMIT_MOUNT_LINK_HOLDER) && !filepath.IsAbs(strings.TrimPrefix(holder, COMMIT_MOUNT_LINK_HOLDER)) {
+		return "", fmt.Errorf("A commit mount held by a symlink needs its absolute path: '%s'", holder)
+	}
+	err := s.checkCommitExists(filesystemId, commitId)
+	if err != nil {
+		return "", err
+	}
+
+	key := zfs.FullIdWithSnapshot(filesystemId, commitId)
+	unlock := s.lockCommitMount(key)
+	defer unlock()
+
+	e, err := s.commitMountRequest(
+		ctx,
+		filesystemId,
+		&Event{Name: "mount-snapshot", Args: &EventArgs{"snapId": commitId}},
+		"mounted",
+	)
+	if err != nil {
+		return "", err
+	}
+
+	s.commitMountsLock.Lock()
+	defer s.commitMountsLock.Unlock()
+	mount, ok := s.commitMounts[key]
+	if !ok {
+		mount = &commitMount{FilesystemId: filesystemId, CommitId: commitId, Holders: map[string]time.Time{}}
+		s.commitMounts[key] = mount
+	}
+	if _, ok := mount.Holders[holder]; !ok {
+		mount.Holders[holder] = time.Now().UTC()
+	}
+	log.Printf("[mountCommit] %s mounted for %s, %d holders", key, holder, len(mount.Holders))
+	err = s.saveCommitMounts()
+	if err != nil {
+		return "", err
+	}
+	return (*e.Args)["mount-path"].(string), nil
+}
+
+// unmountCommit - holder has finished with a commit, which is unmounted if
+// nothing else is using it
+func (s *InMemoryState) unmountCommit(filesystemId, commitId, holder string) error {
+	key := zfs.FullIdWithSnapshot(filesystemId, commitId)
+	unlock := s.lockCommitMount(key)
+	defer unlock()
+
+	err := func() error {
+		s.commitMountsLock.Lock()
+		defer s.commitMountsLock.Unlock()
+		mount, ok := s.commitMounts[key]
+		if !ok {
+			return fmt.Errorf("Commit %s of %s isn't mounted", commitId, filesystemId)
+		}
+		if _, ok := mount.Holders[holder]; !ok {
+			return fmt.Errorf("Commit %s of %s isn't mounted for %s", commitId, filesystemId, holder)
+		}
+		delete(mount.Holders, holder)
+		return s.saveCommitMounts()
+	}()
+	if err != nil {
+		return err
+	}
+	s.maybeUnmountCommit(key)
+	return nil
+}
+
+// releaseCommitMounts - holder has finished with whichever commits it was
+// using
+func (s *InMemoryState) releaseCommitMounts(holder string) error {
+	keys := []string{}
+	s.commitMountsLock.Lock()
+	for key, mount := range s.commitMounts {
+		if _, ok := mount.Holders[holder]; ok {
+			keys = append(keys, key)
+		}
+	}
+	s.commitMountsLock.Unlock()
+
+	var lastErr error
+	for _, key := range keys {
+		err := func() error {
+			unlock := s.lockCommitMount(key)
+			defer unlock()
+			err := func() error {
+				s.commitMountsLock.Lock()
+				defer s.commitMountsLock.Unlock()
+				mount, ok := s.commitMounts[key]
+				if !ok {
+					return nil
+				}
+				delete(mount.Holders, holder)
+				return s.saveCommitMounts()
+			}()
+			s.maybeUnmountCommit(key)
+			return err
+		}()
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// maybeUnmountCommit - unmounts a commit that has no holders left. If that
+// fails, it's left for reapCommitMounts to try again. Call with the commit's
+// lockCommitMount held, and commitMountsLock not.
+func (s *InMemoryState) maybeUnmountCommit(key string) {
+	s.commitMountsLock.Lock()
+	mount, ok := s.commitMounts[key]
+	if !ok || len(mount.Holders) > 0 {
+		s.commitMountsLock.Unlock()
+		return
+	}
+	filesystemId, commitId := mount.FilesystemId, mount.CommitId
+	s.commitMountsLock.Unlock()
+
+	_, err := s.commitMountRequest(
+		context.Background(),
+		filesystemId,
+		&Event{Name: "unmount-snapshot", Args: &EventArgs{"snapId": commitId}},
+		"unmounted",
+	)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":         err,
+			"filesystem_id": filesystemId,
+			"commit_id":     commitId,
+		}).Warn("[maybeUnmountCommit] failed to unmount commit nothing's using, will try again")
+		return
+	}
+	log.Printf("[maybeUnmountCommit] unmounted %s, which nothing's using any more", key)
+
+	s.commitMountsLock.Lock()
+	defer s.commitMountsLock.Unlock()
+	delete(s.commitMounts, key)
+	err = s.saveCommitMounts()
+	if err != nil {
+		log.WithError(err).Error("[maybeUnmountCommit] failed to save commit mounts")
+	}
+}
+
+// reapCommitMounts - lets go of commits on behalf of the dm mount symlinks
+// that have been removed, and retries unmounting commits that failed to
+// unmount. Runs forever.
+func (s *InMemoryState) reapCommitMounts() error {
+	keys := []string{}
+	s.commitMountsLock.Lock()
+	for key := range s.commitMounts {
+		keys = append(keys, key)
+	}
+	s.commitMountsLock.Unlock()
+
+	var lastErr error
+	for _, key := range keys {
+		err := s.reapCommitMount(key)
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (s *InMemoryState) reapCommitMount(key string) error {
+	unlock := s.lockCommitMount(key)
+	defer unlock()
+
+	err := func() error {
+		s.commitMountsLock.Lock()
+		defer s.commitMountsLock.Unlock()
+		mount, ok := s.commitMounts[key]
+		if !ok {
+			return nil
+		}
+		changed := false
+		for holder, since := range mount.Holders {
+			if !strings.HasPrefix(holder, COMMIT_MOUNT_LINK_HOLDER) || time.Since(since) < COMMIT_MOUNT_LINK_GRACE {
+				continue
+			}
+			link := strings.TrimPrefix(holder, COMMIT_MOUNT_LINK_HOLDER)
+			target, err := os.Readlink(filepath.Join(HOST_ROOT, link))
+			if err == nil && strings.HasPrefix(target+"/", mount.path()+"/") {
+				continue
+			}
+			log.Printf("[reapCommitMounts] %s no longer links to %s, letting go of it", link, key)
+			delete(mount.Holders, holder)
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		return s.saveCommitMounts()
+	}()
+	s.maybeUnmountCommit(key)
+	return err
+}
+
+// commitRef - if the ref after a dot's @ (dot@commit, or dot@branch@commit)
+// names a commit rather than a branch, the dot or branch it's on, and the
+// commit
+func (s *InMemoryState) commitRef(name VolumeName) (VolumeName, string, bool) {
+	parts := strings.Split(name.Name, "@")
+	switch len(parts) {
+	case 3:
+		return VolumeName{Namespace: name.Namespace, Name: parts[0] + "@" + parts[1]}, parts[2], true
+	case 2:
+		dot := VolumeName{Namespace: name.Namespace, Name: parts[0]}
+		if parts[1] == DEFAULT_BRANCH {
+			return name, "", false
+		}
+		if _, err := s.registry.MaybeCloneFilesystemId(dot, parts[1]); err == nil {
+			return name, "", false
+		}
+		tlf, err := s.registry.LookupFilesystem(dot)
+		if err != nil {
+			return name, "", false
+		}
+		if s.checkCommitExists(tlf.MasterBranch.Id, parts[1]) == nil {
+			return dot, parts[1], true
+		}
+		branches := []string{}
+		for branch := range s.registry.ClonesFor(tlf.MasterBranch.Id) {
+			branches = append(branches, branch)
+		}
+		sort.Strings(branches)
+		for _, branch := range branches {
+			filesystemId, err := s.registry.MaybeCloneFilesystemId(dot, branch)
+			if err == nil && s.checkCommitExists(filesystemId, parts[1]) == nil {
+				return VolumeName{Namespace: name.Namespace, Name: parts[0] + "@" + branch}, parts[1], true
+			}
+		}
+	}
+	return name, "", false
+}
+
+// branchFilesystemId - the filesystem of a dot or dot@branch, without
+// procuring it
+func (s *InMemoryState) branchFilesystemId(name VolumeName) (string, error) {
+	parts := strings.SplitN(name.Name, "@", 2)
+	branch := ""
+	if len(parts) == 2 && parts[1] != DEFAULT_BRANCH {
+		branch = parts[1]
+	}
+	return s.registry.MaybeCloneFilesystemId(VolumeName{Namespace: name.Namespace, Name: parts[0]}, branch)
+}
+
+// procureCommit - moves the branch a commit is on here, if need be, and
+// mounts the commit for holder
+func (s *InMemoryState) procureCommit(ctx context.Context, branch VolumeName, commitId, holder string) (string, error) {
+	filesystemId, err := s.procureFilesystem(ctx, branch)
+	if err != nil {
+		return "", err
+	}
+	return s.mountCommit(ctx, filesystemId, commitId, holder)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestRPCCommitHolder(t *testing.T) {
+	tests := []struct {
+		holder  string
+		want    string
+		allowed bool
+	}{
+		{"", "rpc", true},
+		{"backup-job", "backup-job", true},
+		{"docker:abc123", "", false},
+		{"link:/home/alice/data", "", false},
+	}
+	for _, tt := range tests {
+		holder, err := rpcCommitHolder(tt.holder)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%q: expected allowed=%t, got error: %v", tt.holder, tt.allowed, err)
+			continue
+		}
+		if holder != tt.want {
+			t.Errorf("%q: expected holder %q, got %q", tt.holder, tt.want, holder)
+		}
+	}
+}
+
+func TestCommitRef(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	c.registry.UpdateCloneFromEtcd("feature", testDotId, types.Clone{FilesystemId: "feature-id"})
+	c.setCommits(testDotId, &types.Snapshot{Id: "c1"})
+	c.setCommits("feature-id", &types.Snapshot{Id: "c1"}, &types.Snapshot{Id: "c2"})
+
+	tests := []struct {
+		name       string
+		wantName   string
+		wantCommit string
+	}{
+		{"db", "db", ""},
+		{"db@master", "db@master", ""},
+		{"db@feature", "db@feature", ""},
+		{"db@c1", "db", "c1"},
+		{"db@c2", "db@feature", "c2"},
+		{"db@feature@c2", "db@feature", "c2"},
+		{"db@missing", "db@missing", ""},
+		{"nothing@c1", "nothing@c1", ""},
+	}
+	for _, tt := range tests {
+		name, commit, ok := c.rpc.state.commitRef(VolumeName{Namespace: "alice", Name: tt.name})
+		if ok != (tt.wantCommit != "") || name.Name != tt.wantName || commit != tt.wantCommit {
+			t.Errorf("%s: expected %s at %q, got %s at %q (%t)", tt.name, tt.wantName, tt.wantCommit, name.Name, commit, ok)
+		}
+	}
+}
+
+// fakeMessenger - answers requests to filesystems' state machines with
+// respond, which returns nil for those that never answer
+type fakeMessenger struct {
+	sync.Mutex
+	respond   func(e *types.Event) *types.Event
+	requests  []*types.Event
+	responses map[string]*types.Event
+	waiting   map[string]chan *types.Event
+}
+
+func newFakeMessenger(respond func(e *types.Event) *types.Event) *fakeMessenger {
+	return &fakeMessenger{
+		respond:   respond,
+		responses: map[string]*types.Event{},
+		waiting:   map[string]chan *types.Event{},
+	}
+}
+
+func (m *fakeMessenger) Publish(e *types.Event) error {
+	m.Lock()
+	defer m.Unlock()
+	m.requests = append(m.requests, e)
+	response := m.respond(e)
+	if response == nil {
+		return nil
+	}
+	// the requester may not have subscribed yet
+	if ch, ok := m.waiting[e.ID]; ok {
+		ch <- response
+	} else {
+		m.responses[e.ID] = response
+	}
+	return nil
+}
+
+func (m *fakeMessenger) Subscribe(ctx context.Context, q *types.SubscribeQuery) (chan *types.Event, error) {
+	m.Lock()
+	defer m.Unlock()
+	ch := make(chan *types.Event, 1)
+	if response, ok := m.responses[q.RequestID]; ok {
+		ch <- response
+	} else {
+		m.waiting[q.RequestID] = ch
+	}
+	return ch, nil
+}
+
+func (m *fakeMessenger) sent(name, filesystemId string) int {
+	m.Lock()
+	defer m.Unlock()
+	n := 0
+	for _, e := range m.requests {
+		if e.Name == name && e.FilesystemID == filesystemId {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCommitMountsDontWaitForOtherFilesystems(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	dir, err := ioutil.TempDir("", "commit-mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(prefix string) { CONTAINER_MOUNT_PREFIX = prefix }(CONTAINER_MOUNT_PREFIX)
+	CONTAINER_MOUNT_PREFIX = dir
+
+	s := c.rpc.state
+	s.commitMounts = map[string]*commitMount{}
+	s.commitMountLocks = map[string]*commitMountLock{}
+	s.commitMountsLock = &sync.Mutex{}
+	messenger := newFakeMessenger(func(e *types.Event) *types.Event {
+		switch {
+		case e.FilesystemID == testDotId:
+			return nil
+		case e.Name == "mount-snapshot":
+			return &types.Event{Name: "mounted", Args: &types.EventArgs{"mount-path": "/mnt/" + e.FilesystemID}}
+		default:
+			return &types.Event{Name: "unmounted", Args: &types.EventArgs{}}
+		}
+	})
+	s.messenger = messenger
+	c.setCommits(testDotId, &types.Snapshot{Id: "c1"})
+	c.setCommits("feature-id", &types.Snapshot{Id: "c2"})
+
+	// the dot's state machine is busy and doesn't answer...
+	ctx, cancel := context.WithCancel(context.Background())
+	stuck := make(chan error)
+	go func() {
+		_, err := s.mountCommit(ctx, testDotId, "c1", "backup-job")
+		stuck <- err
+	}()
+	for messenger.sent("mount-snapshot", testDotId) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// ...which doesn't stop other filesystems' commits being mounted and
+	// unmounted, or the reaper
+	path, err := s.mountCommit(context.Background(), "feature-id", "c2", "backup-job")
+	if err != nil {
+		t.Fatalf("expected to mount a commit while another filesystem is busy, got: %s", err)
+	}
+	if path != "/mnt/feature-id" {
+		t.Errorf("expected the commit to be mounted at /mnt/feature-id, got %s", path)
+	}
+	err = s.reapCommitMounts()
+	if err != nil {
+		t.Errorf("expected reaping commit mounts to work, got: %s", err)
+	}
+	err = s.unmountCommit("feature-id", "c2", "backup-job")
+	if err != nil {
+		t.Errorf("expected to unmount the commit, got: %s", err)
+	}
+	if messenger.sent("unmount-snapshot", "feature-id") != 1 {
+		t.Error("expected the commit nothing's using to be unmounted")
+	}
+	if _, ok := s.commitMounts["feature-id@c2"]; ok {
+		t.Error("expected the unmounted commit to be forgotten")
+	}
+
+	cancel()
+	select {
+	case err := <-stuck:
+		if err == nil {
+			t.Error("expected mounting a commit to fail when its filesystem doesn't answer")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected mounting a commit to give up")
+	}
+	if len(s.commitMounts) != 0 || len(s.commitMountLocks) != 0 {
+		t.Errorf("expected nothing left mounted or locked, got %v and %v", s.commitMounts, s.commitMountLocks)
+	}
+}
@@ -15,11 +15,16 @@ import (
 	"github.com/nu7hatch/gouuid"
 	"golang.org/x/net/context"
 
+	"github.com/dotmesh-io/dotmesh/pkg/changerequests"
 	"github.com/dotmesh-io/dotmesh/pkg/container"
 	"github.com/dotmesh-io/dotmesh/pkg/fsm"
+	"github.com/dotmesh-io/dotmesh/pkg/hooks"
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
 	"github.com/dotmesh-io/dotmesh/pkg/messaging"
 	"github.com/dotmesh-io/dotmesh/pkg/notification"
+	"github.com/dotmesh-io/dotmesh/pkg/notification/webhook"
 	"github.com/dotmesh-io/dotmesh/pkg/observer"
+	"github.com/dotmesh-io/dotmesh/pkg/quota"
 	"github.com/dotmesh-io/dotmesh/pkg/registry"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/user"
@@ -36,13 +41,36 @@ type InMemoryState struct {
 	serverAddressesCache     map[string]string
 	serverAddressesCacheLock *sync.RWMutex
 
+	// nodes that have been drained, and so mustn't procure anything,
+	// node id -> when they were cordoned
+	cordonedNodes     map[string]string
+	cordonedNodesLock *sync.RWMutex
+
+	// for failing masters over off dead nodes, and fencing this node off if
+	// it's the one that's presumed dead; see failover.go
+	lastHeartbeat     time.Time
+	deadNodes         map[string]time.Time
+	fencedFilesystems map[string]struct{}
+	failoverLock      *sync.Mutex
+
 	globalContainerCache     map[string]containerInfo
 	globalContainerCacheLock *sync.RWMutex
 
+	// read-only docker volumes' bind mounts,
+	// volume name -> docker mount id -> path
+	dockerVolumeMounts map[string]map[string]string
+	// mounts of each volume by docker that doesn't give them IDs
+	dockerAnonymousMounts  map[string]int
+	dockerVolumeMountsLock *sync.Mutex
+	// read-only commit mounts, by filesystemId@commitId
+	commitMounts     map[string]*commitMount
+	commitMountLocks map[string]*commitMountLock
+	commitMountsLock *sync.Mutex
+
 	messenger       messaging.Messenger
 	messagingServer messaging.MessagingServer
 
-	etcdClient                 client.KeysAPI
+	etcdClient                 kv.Store
 	etcdWaitTimestamp          int64
 	etcdWaitState              string
 	etcdWaitTimestampLock      *sync.Mutex
@@ -58,8 +86,16 @@ type InMemoryState struct {
 	globalDirtyCacheLock       *sync.RWMutex
 	globalDirtyCache           map[string]dirtyInfo
 	userManager                user.UserManager
+	quotaManager               quota.Manager
+	webhookManager             webhook.Manager
+	hookManager                hooks.Manager
+	changeRequestManager       changerequests.Manager
 	publisher                  notification.Publisher
 
+	// refquotas set on this node's filesystems, by filesystem ID
+	appliedQuotas     map[string]int64
+	appliedQuotasLock *sync.Mutex
+
 	debugPartialFailCreateFilesystem bool
 	versionInfo                      *VersionInfo
 	zfs                              zfs.ZFS
@@ -68,16 +104,27 @@ type InMemoryState struct {
 // typically methods on the InMemoryState "god object"
 
 func NewInMemoryState(config Config) *InMemoryState {
-	dockerClient, err := container.New(&container.Options{
+	var s *InMemoryState
+	containerClient, err := container.NewClient(&container.Options{
 		ContainerMountPrefix:  CONTAINER_MOUNT_PREFIX,
 		ContainerMountDirLock: &containerMountDirLock,
+		Runtime:               os.Getenv("CONTAINER_RUNTIME"),
+		CRIEndpoint:           os.Getenv("CRI_RUNTIME_ENDPOINT"),
+		FilesystemName: func(filesystemId string) (string, bool) {
+			tlf, _, err := s.registry.LookupFilesystemById(filesystemId)
+			if err != nil {
+				return "", false
+			}
+			return tlf.MasterBranch.Name.String(), true
+		},
 	})
 	if err != nil {
 		// why do we panic so much here?
 		log.WithFields(log.Fields{
 			"error":                  err,
 			"container_mount_prefix": CONTAINER_MOUNT_PREFIX,
-		}).Fatal("inMemoryState: failed to configure docker client")
+			"container_runtime":      os.Getenv("CONTAINER_RUNTIME"),
+		}).Fatal("inMemoryState: failed to configure container client")
 		os.Exit(1)
 	}
 
@@ -87,15 +134,26 @@ func NewInMemoryState(config Config) *InMemoryState {
 		panic(err)
 	}
 
-	s := &InMemoryState{
+	s = &InMemoryState{
 		config:                   config,
 		filesystems:              make(map[string]fsm.FSM),
 		filesystemsLock:          &sync.RWMutex{},
 		serverAddressesCache:     make(map[string]string),
 		serverAddressesCacheLock: &sync.RWMutex{},
+		cordonedNodes:            make(map[string]string),
+		cordonedNodesLock:        &sync.RWMutex{},
+		deadNodes:                make(map[string]time.Time),
+		fencedFilesystems:        make(map[string]struct{}),
+		failoverLock:             &sync.Mutex{},
 		// global container state (what containers are running where), filesystemId -> containerInfo
 		globalContainerCache:     make(map[string]containerInfo),
 		globalContainerCacheLock: &sync.RWMutex{},
+		dockerVolumeMounts:       make(map[string]map[string]string),
+		dockerAnonymousMounts:    make(map[string]int),
+		dockerVolumeMountsLock:   &sync.Mutex{},
+		commitMounts:             make(map[string]*commitMount),
+		commitMountLocks:         make(map[string]*commitMountLock),
+		commitMountsLock:         &sync.Mutex{},
 		// When did we start waiting for etcd?
 		etcdClient:            config.EtcdClient,
 		etcdWaitTimestamp:     0,
@@ -108,7 +166,7 @@ func NewInMemoryState(config Config) *InMemoryState {
 		localReceiveProgress: observer.NewObserver("localReceiveProgress"),
 		deathObserver:        observer.NewObserver("deathObserver"),
 		// containers that are running with dotmesh volumes by filesystem id
-		containers:     dockerClient,
+		containers:     containerClient,
 		containersLock: &sync.RWMutex{},
 		// channel to send on to hint that a new container is using a dotmesh
 		// volume
@@ -119,11 +177,22 @@ func NewInMemoryState(config Config) *InMemoryState {
 		globalDirtyCacheLock:      &sync.RWMutex{},
 		globalDirtyCache:          make(map[string]dirtyInfo),
 		userManager:               config.UserManager,
+		quotaManager:              config.QuotaManager,
+		appliedQuotas:             make(map[string]int64),
+		appliedQuotasLock:         &sync.Mutex{},
+		webhookManager:            config.WebhookManager,
+		hookManager:               config.HookManager,
+		changeRequestManager:      config.ChangeRequestManager,
 		// publisher:                 ,
 		versionInfo: &VersionInfo{InstalledVersion: serverVersion},
 		zfs:         zfsInterface,
 	}
 
+	// webhooks are configured through the API, so unlike the other
+	// publishers this one is always registered
+	if config.WebhookManager != nil {
+		notification.RegisterPublisher("webhook", webhook.NewPublisher(config.WebhookManager, config.WebhookAllowedNetworks))
+	}
 	publisher := notification.New(context.Background())
 	_, err = publisher.Configure(&notification.Config{Attempts: 5})
 	if err != nil {
@@ -264,6 +333,22 @@ func (s *InMemoryState) getOne(ctx context.Context, fs string) (DotmeshVolume, e
 			ForkParentId:         tlf.ForkParentId,
 			ForkParentSnapshotId: tlf.ForkParentSnapshotId,
 		}
+		if clone != "" {
+			c, err := s.registry.LookupCloneById(fs)
+			if err == nil {
+				d.Origin = c.Origin
+			}
+		}
+		q, err := s.quotaManager.GetFilesystemQuota(tlf.MasterBranch.Id)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":         err,
+				"filesystem_id": fs,
+			}).Warn("[getOne] failed to get quota")
+		} else if !q.Unlimited() {
+			d.QuotaBytes = q.LimitBytes
+			d.QuotaUsedBytes = s.dotUsage(tlf.MasterBranch.Id)
+		}
 		s.serverAddressesCacheLock.Lock()
 		defer s.serverAddressesCacheLock.Unlock()
 
@@ -560,6 +645,7 @@ func (state *InMemoryState) reallyProcureFilesystem(ctx context.Context, name Vo
 			// put in a request for the current master of the filesystem to
 			// move it to me
 			responseChan, err := state.globalFsRequest(
+				ctx,
 				filesystemId,
 				&Event{
 					Name: "move",
@@ -627,6 +713,12 @@ func (state *InMemoryState) reallyProcureFilesystem(ctx context.Context, name Vo
 			}
 		}
 	} else {
+		// creating a new dot is refused outright once its namespace is over
+		// quota; existing dots can still be used
+		err = state.checkNamespaceQuota(name.Namespace, 0)
+		if err != nil {
+			return "", err
+		}
 		fsMachine, ch, err := state.CreateFilesystem(ctx, &name)
 		if err != nil {
 			return "", err
@@ -646,6 +738,12 @@ func (state *InMemoryState) reallyProcureFilesystem(ctx context.Context, name Vo
 }
 
 func (state *InMemoryState) procureFilesystem(ctx context.Context, name VolumeName) (string, error) {
+	if state.isCordoned(state.NodeID()) {
+		return "", fmt.Errorf(
+			"This node (%s) has been drained, so can't procure %s. Run 'dm cluster uncordon %s' to allow it to again.",
+			state.NodeID(), name, state.NodeID(),
+		)
+	}
 	var s string
 	err := tryUntilSucceeds(func() error {
 		ss, err := state.reallyProcureFilesystem(ctx, name)
@@ -655,6 +753,68 @@ func (state *InMemoryState) procureFilesystem(ctx context.Context, name VolumeNa
 	return s, err
 }
 
+// createBranch - creates newBranch from a commit on sourceBranch of a dot,
+// returning the new branch's filesystem id
+func (s *InMemoryState) createBranch(ctx context.Context, namespace, name, sourceBranch, newBranch, sourceCommitId string) (string, error) {
+	tlf, err := s.registry.LookupFilesystem(VolumeName{Namespace: namespace, Name: name})
+	if err != nil {
+		return "", err
+	}
+	var originFilesystemId string
+
+	// find whether branch refers to top-level fs or a clone, by guessing based
+	// on name convention. XXX this shouldn't be dealing with "master" and
+	// branches
+	if sourceBranch == DEFAULT_BRANCH {
+		originFilesystemId = tlf.MasterBranch.Id
+	} else {
+		clone, err := s.registry.LookupClone(
+			tlf.MasterBranch.Id, sourceBranch,
+		)
+		originFilesystemId = clone.FilesystemId
+		if err != nil {
+			return "", err
+		}
+	}
+	// target node is responsible for creating registry entry (so that they're
+	// as close as possible to eachother), so give it all the info it needs to
+	// do that.
+	responseChan, err := s.globalFsRequest(
+		ctx,
+		originFilesystemId,
+		&Event{Name: "clone",
+			Args: &EventArgs{
+				"topLevelFilesystemId": tlf.MasterBranch.Id,
+				"originFilesystemId":   originFilesystemId,
+				"originSnapshotId":     sourceCommitId,
+				"newBranchName":        newBranch,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	// TODO this may never succeed, if the master for it never shows up. maybe
+	// this response should have a timeout associated with it.
+	e := <-responseChan
+	if e.Name != "cloned" {
+		return "", maybeError(e, "cloned")
+	}
+	newFilesystemId := (*e.Args)["newFilesystemId"].(string)
+	log.Printf(
+		"Cloned %s:%s@%s (%s) to %s", name,
+		sourceBranch, sourceCommitId, originFilesystemId, newFilesystemId,
+	)
+	s.publishEvent(tlf, &types.EventNotification{
+		Type:         types.EventTypeBranchCreated,
+		FilesystemId: newFilesystemId,
+		Branch:       newBranch,
+		CommitId:     sourceCommitId,
+	})
+	return newFilesystemId, nil
+}
+
 func (s *InMemoryState) CreateFilesystem(ctx context.Context, filesystemName *VolumeName) (fsm.FSM, chan *Event, error) {
 
 	// Check to see if it already partially exists, eg. in the registry but without a master
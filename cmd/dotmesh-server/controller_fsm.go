@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/dotmesh-io/dotmesh/pkg/fsm"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
 
 	"github.com/coreos/etcd/client"
 
@@ -77,6 +78,7 @@ func (s *InMemoryState) InitFilesystemMachine(filesystemId string) (fsm.FSM, err
 			LocalReceiveProgress:      s.localReceiveProgress,
 			NewSnapsOnMaster:          s.newSnapsOnMaster,
 			DeathObserver:             s.deathObserver,
+			HookManager:               s.hookManager,
 			FilesystemMetadataTimeout: s.config.FilesystemMetadataTimeout,
 			ZFSPath:                   ZFS,
 			ZPoolPath:                 ZPOOL,
@@ -266,6 +268,20 @@ func (s *InMemoryState) SnapshotsForCurrentMaster(filesystemId string) ([]Snapsh
 	return s.SnapshotsFor(master, filesystemId)
 }
 
+// QuerySnapshotsForCurrentMaster - a page of the commits on the current
+// master matching query
+func (s *InMemoryState) QuerySnapshotsForCurrentMaster(filesystemId string, query types.CommitQuery) (types.CommitPage, error) {
+	master, err := s.registry.CurrentMasterNode(filesystemId)
+	if err != nil {
+		return types.CommitPage{}, err
+	}
+	fsm, err := s.GetFilesystemMachine(filesystemId)
+	if err != nil {
+		return types.CommitPage{}, err
+	}
+	return fsm.QuerySnapshots(master, query)
+}
+
 func (s *InMemoryState) SnapshotsFor(server string, filesystemId string) ([]Snapshot, error) {
 	snaps := []Snapshot{}
 	fsm, err := s.GetFilesystemMachine(filesystemId)
@@ -12,9 +12,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/dotmesh-io/dotmesh/pkg/auth"
+	"github.com/dotmesh-io/dotmesh/pkg/container"
+	"github.com/dotmesh-io/dotmesh/pkg/utils"
+	"github.com/dotmesh-io/dotmesh/pkg/zfs"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -34,8 +38,10 @@ type RequestCreate struct {
 }
 
 type RequestMount struct {
-	// A request to mount a volume for Docker
+	// A request to mount a volume for Docker, ID identifies the mount so
+	// that it can be matched with the request to unmount it
 	Name string
+	ID   string
 }
 
 type RequestGet struct {
@@ -203,6 +209,78 @@ func newContainerMountSymlink(name VolumeName, filesystemId string, subvolume st
 	return result, nil
 }
 
+// newMountSymlink - gives a docker mount its own symlink to a filesystem, in
+// dir under the container mount prefix, so that switching a dot's branch can
+// move just the mounts that follow it
+func newMountSymlink(dir, mountId, target, subvolume string) (string, error) {
+	containerMountDirLock.Lock()
+	defer containerMountDirLock.Unlock()
+
+	parent := filepath.Join(CONTAINER_MOUNT_PREFIX, dir)
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		return "", err
+	}
+	mountpoint := filepath.Join(parent, mountId)
+	if err := os.Remove(mountpoint); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	log.Printf("[newMountSymlink] Creating symlink %s -> %s", mountpoint, target)
+	if err := os.Symlink(target, mountpoint); err != nil {
+		return "", err
+	}
+	if subvolume == "" {
+		return mountpoint, nil
+	}
+	result := filepath.Join(mountpoint, subvolume)
+	if err := os.MkdirAll(result, 0777); err != nil {
+		log.Printf("[newMountSymlink] error creating subdot %s: %+v", result, err)
+		return "", err
+	}
+	return result, nil
+}
+
+// removeMountSymlinks - removes a docker mount's own symlink, once it's
+// unmounted
+func removeMountSymlinks(mountId string) error {
+	containerMountDirLock.Lock()
+	defer containerMountDirLock.Unlock()
+
+	for _, dir := range []string{container.FOLLOWING_MOUNTS_DIR, container.PINNED_MOUNTS_DIR} {
+		err := os.Remove(filepath.Join(CONTAINER_MOUNT_PREFIX, dir, mountId))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// switchContainerMountSymlink - points a dot's own symlink, which says which
+// of its branches new mounts get, at another filesystem, if it has one
+func switchContainerMountSymlink(name VolumeName, filesystemId string) error {
+	containerMountDirLock.Lock()
+	defer containerMountDirLock.Unlock()
+
+	mountpoint := containerMnt(name)
+	stat, err := os.Lstat(mountpoint)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if stat.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("mountpoint %s contains something other than a symlink: %+v", mountpoint, stat)
+	}
+	if target, err := os.Readlink(mountpoint); err == nil && target == mnt(filesystemId) {
+		return nil
+	}
+	log.Printf("[switchContainerMountSymlink] Switching %s to %s", mountpoint, mnt(filesystemId))
+	if err := os.Remove(mountpoint); err != nil {
+		return err
+	}
+	return os.Symlink(mnt(filesystemId), mountpoint)
+}
+
 // Annotate a context with admin-level authorization.
 func AdminContext(ctx context.Context) context.Context {
 	return auth.SetUserIDCtx(ctx, ADMIN_USER_UUID)
@@ -237,6 +315,21 @@ func (state *InMemoryState) runPlugin() {
 			writeResponseErr(err, w)
 			return
 		}
+		options, err := parseDockerVolumeOptions(request.Name, request.Opts)
+		if err != nil {
+			writeResponseErr(err, w)
+			return
+		}
+		if options != nil {
+			if err := state.createDockerVolume(ctx, request.Name, options); err != nil {
+				writeResponseErr(err, w)
+				return
+			}
+			writeResponseOK(w)
+			go func() { state.fetchRelatedContainersChan <- true }()
+			return
+		}
+
 		namespace, localName, _, err := parseNamespacedVolumeWithSubvolumes(request.Name)
 		if err != nil {
 			writeResponseErr(err, w)
@@ -248,6 +341,10 @@ func (state *InMemoryState) runPlugin() {
 		// for now, just name the volumes as requested by the user. later,
 		// adding ids and per-fs metadata may be useful.
 
+		if branch, _, ok := state.commitRef(name); ok {
+			// dot@commit is mounted when it's used
+			name = branch
+		}
 		if _, err := state.procureFilesystem(ctx, name); err != nil {
 			writeResponseErr(err, w)
 			return
@@ -265,8 +362,23 @@ func (state *InMemoryState) runPlugin() {
 			We do not actually want to remove the dm volume when Docker
 			references to them are removed.
 
-			This is a no-op.
+			This is a no-op, apart from forgetting any options the volume was
+			created with.
 		*/
+		requestJSON, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeResponseErr(err, w)
+			return
+		}
+		request := new(RequestRemove)
+		if err := json.Unmarshal(requestJSON, request); err != nil {
+			writeResponseErr(err, w)
+			return
+		}
+		if err := removeDockerVolumeOptions(request.Name); err != nil {
+			writeResponseErr(err, w)
+			return
+		}
 		writeResponseOK(w)
 		// asynchronously notify dotmesh that the containers running on a
 		// volume may have changed
@@ -286,19 +398,39 @@ func (state *InMemoryState) runPlugin() {
 			writeResponseErr(err, w)
 			return
 		}
-		namespace, localName, subvolume, err := parseNamespacedVolumeWithSubvolumes(request.Name)
+		var mountPoint string
+		options, err := loadDockerVolumeOptions(request.Name)
 		if err != nil {
 			writeResponseErr(err, w)
 			return
 		}
+		if options != nil {
+			mountPoint, err = state.dockerVolumeMountpoint(request.Name, options)
+			if err != nil {
+				writeResponseErr(err, w)
+				return
+			}
+		} else {
+			namespace, localName, subvolume, err := parseNamespacedVolumeWithSubvolumes(request.Name)
+			if err != nil {
+				writeResponseErr(err, w)
+				return
+			}
 
-		name := VolumeName{
-			Namespace: namespace,
-			Name:      localName,
+			name := VolumeName{
+				Namespace: namespace,
+				Name:      localName,
+			}
+			mountPoint = containerMntSubvolume(name, subvolume)
+			if branch, commitId, ok := state.commitRef(name); ok {
+				filesystemId, err := state.branchFilesystemId(branch)
+				if err == nil {
+					mountPoint = filepath.Join(utils.Mnt(zfs.FullIdWithSnapshot(filesystemId, commitId)), subvolume)
+				}
+			}
 		}
-		mountPoint := containerMntSubvolume(name, subvolume)
 
-		log.Printf("Mountpoint for %s: %s", name, mountPoint)
+		log.Printf("Mountpoint for %s: %s", request.Name, mountPoint)
 		responseJSON, _ := json.Marshal(&ResponseMount{
 			Mountpoint: mountPoint,
 			Err:        "",
@@ -324,23 +456,27 @@ func (state *InMemoryState) runPlugin() {
 			return
 		}
 
-		namespace, localName, subvolume, err := parseNamespacedVolumeWithSubvolumes(request.Name)
+		options, err := loadDockerVolumeOptions(request.Name)
 		if err != nil {
 			writeResponseErr(err, w)
 			return
 		}
-
-		name := VolumeName{Namespace: namespace, Name: localName}
-
-		filesystemId, err := state.procureFilesystem(ctx, name)
-		if err != nil {
-			writeResponseErr(err, w)
-			return
+		var mountpoint string
+		if options != nil {
+			mountpoint, err = state.mountDockerVolume(ctx, request.Name, request.ID, options)
+			if err != nil {
+				writeResponseErr(err, w)
+				return
+			}
+		} else {
+			mountpoint, err = state.mountDockerDot(ctx, request.Name, request.ID)
+			if err != nil {
+				writeResponseErr(err, w)
+				return
+			}
 		}
-		mountpoint, err := newContainerMountSymlink(name, filesystemId, subvolume)
-		if err != nil {
-			writeResponseErr(err, w)
-			return
+		if request.ID == "" {
+			state.noteAnonymousDockerMount(request.Name)
 		}
 		// Allow things that don't want containers to start during their
 		// operations to delay the start of a container. Commented out because
@@ -350,7 +486,7 @@ func (state *InMemoryState) runPlugin() {
 			defer state.containersLock.Unlock()
 		*/
 
-		log.Printf("Mountpoint for %s: %s", name, mountpoint)
+		log.Printf("Mountpoint for %s: %s", request.Name, mountpoint)
 		responseJSON, _ := json.Marshal(&ResponseMount{
 			Mountpoint: mountpoint,
 			Err:        "",
@@ -372,6 +508,34 @@ func (state *InMemoryState) runPlugin() {
 	http.HandleFunc("/VolumeDriver.Unmount", func(w http.ResponseWriter, r *http.Request) {
 		// TODO acquire containerRuntimeLock and update our state and etcd with
 		// the fact that one less container is now running on this volume...
+		requestJSON, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeResponseErr(err, w)
+			return
+		}
+		request := new(RequestMount)
+		if err := json.Unmarshal(requestJSON, request); err != nil {
+			writeResponseErr(err, w)
+			return
+		}
+		// the container's gone whether or not this all works, so let go of
+		// everything it was using and report the first thing that failed
+		var errs []error
+		if request.ID != "" {
+			errs = append(errs, removeMountSymlinks(request.ID))
+		}
+		if request.ID != "" || state.releaseAnonymousDockerMount(request.Name) {
+			errs = append(errs,
+				state.unmountDockerVolume(request.Name, request.ID),
+				state.releaseCommitMounts(dockerCommitHolder(request.Name, request.ID)),
+			)
+		}
+		for _, err := range errs {
+			if err != nil {
+				writeResponseErr(err, w)
+				return
+			}
+		}
 		writeResponseOK(w)
 		// asynchronously notify dotmesh that the containers running on a
 		// volume may have changed
@@ -417,6 +581,36 @@ func (state *InMemoryState) runPlugin() {
 			writeResponseErr(err, w)
 			return
 		}
+		var response = ResponseGet{
+			Err: "",
+		}
+
+		options, err := loadDockerVolumeOptions(request.Name)
+		if err != nil {
+			writeResponseErr(err, w)
+			return
+		}
+		if options != nil {
+			mountpoint, err := state.dockerVolumeMountpoint(request.Name, options)
+			if err != nil {
+				response.Err = fmt.Sprintf("Error getting volume: %v", err)
+			}
+			response.Volume = ResponseListVolume{
+				Name:       request.Name,
+				Mountpoint: mountpoint,
+				Status: map[string]string{
+					"dot":      options.Dot,
+					"branch":   options.Branch,
+					"commit":   options.Commit,
+					"readonly": strconv.FormatBool(options.ReadOnly),
+				},
+			}
+			responseJSON, _ := json.Marshal(response)
+			log.Printf("=> %s", string(responseJSON))
+			w.Write(responseJSON)
+			return
+		}
+
 		namespace, localName, subvolume, err := parseNamespacedVolumeWithSubvolumes(request.Name)
 		if err != nil {
 			writeResponseErr(err, w)
@@ -425,10 +619,6 @@ func (state *InMemoryState) runPlugin() {
 
 		name := VolumeName{Namespace: namespace, Name: localName}
 
-		var response = ResponseGet{
-			Err: "",
-		}
-
 		// Technically, fetching the TopLevelFilesystem object from the
 		// registry isn't necessary, but maybe one day we'll get additional
 		// Status information from that call that we want to use here, so
@@ -553,7 +743,9 @@ func (state *InMemoryState) cleanupDockerFilesystemState() error {
 		if info == nil {
 			log.Debugf("[cleanupDockerFilesystemState] found something with no fileinfo: %s", symlinkPath)
 		} else {
-			if !info.IsDir() {
+			// symlinks, rather than the directories they're in, volume
+			// options or read-only mounts
+			if info.Mode()&os.ModeSymlink != 0 {
 				target, err := os.Readlink(symlinkPath)
 				log.Debugf("[cleanupDockerFilesystemState] Found %s -> %s", symlinkPath, target)
 				if err != nil {
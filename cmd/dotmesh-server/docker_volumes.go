@@ -0,0 +1,459 @@
+package main
+
+// options for docker volumes, e.g.
+// docker volume create -d dm -o branch=x -o readonly=true myvolume
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dotmesh-io/dotmesh/pkg/container"
+	"github.com/dotmesh-io/dotmesh/pkg/utils"
+	"github.com/dotmesh-io/dotmesh/pkg/validator"
+	"github.com/dotmesh-io/dotmesh/pkg/zfs"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Docker only passes a volume's options when it's created, so they're kept
+// here, under the container mount prefix, for when it's mounted
+const DOCKER_VOLUME_OPTIONS_DIR = ".volumes"
+
+// dockerVolumeOptions - what to mount for a docker volume, instead of the
+// current branch of the dot it's named after
+type dockerVolumeOptions struct {
+	// [namespace/]name[.subdot] of the dot, if it isn't the volume's name
+	Dot string
+	// branch to mount, whatever the dot's current branch is
+	Branch string
+	// commit to mount, read-only, from Branch
+	Commit   string
+	ReadOnly bool
+	// branch to create Branch from, at its latest commit, if it doesn't
+	// exist yet
+	CreateBranchFrom string
+}
+
+// parseDockerVolumeOptions - the options from `docker volume create -o`, nil
+// if there aren't any
+func parseDockerVolumeOptions(volumeName string, opts map[string]string) (*dockerVolumeOptions, error) {
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	options := &dockerVolumeOptions{Dot: volumeName}
+	for key, value := range opts {
+		switch key {
+		case "dot":
+			options.Dot = value
+		case "branch":
+			options.Branch = value
+		case "commit":
+			options.Commit = value
+		case "readonly":
+			readonly, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid readonly option %q, expected true or false", value)
+			}
+			options.ReadOnly = readonly
+		case "create-branch-from":
+			options.CreateBranchFrom = value
+		default:
+			return nil, fmt.Errorf("Unknown volume option %q, expected dot, branch, commit, readonly or create-branch-from", key)
+		}
+	}
+	if options.Branch == DEFAULT_BRANCH {
+		options.Branch = ""
+	}
+	if strings.Contains(options.Dot, "@") {
+		return nil, fmt.Errorf("Use the branch option rather than @ in the name of a volume with options: '%s'", options.Dot)
+	}
+	if _, _, _, err := parseNamespacedVolumeWithSubvolumes(options.Dot); err != nil {
+		return nil, err
+	}
+	if err := validator.IsValidBranchName(options.Branch); err != nil {
+		return nil, err
+	}
+	if options.CreateBranchFrom != "" {
+		if options.Branch == "" {
+			return nil, fmt.Errorf("The create-branch-from option needs a branch option naming the branch to create")
+		}
+		if err := validator.IsValidBranchName(options.CreateBranchFrom); err != nil {
+			return nil, err
+		}
+	}
+	if options.Commit != "" {
+		// commits can't be changed
+		options.ReadOnly = true
+	}
+	return options, nil
+}
+
+func dockerVolumeOptionsPath(volumeName string) string {
+	return filepath.Join(CONTAINER_MOUNT_PREFIX, DOCKER_VOLUME_OPTIONS_DIR, url.PathEscape(volumeName)+".json")
+}
+
+func saveDockerVolumeOptions(volumeName string, options *dockerVolumeOptions) error {
+	err := os.MkdirAll(filepath.Dir(dockerVolumeOptionsPath(volumeName)), 0700)
+	if err != nil {
+		return err
+	}
+	serialized, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dockerVolumeOptionsPath(volumeName), serialized, 0600)
+}
+
+// loadDockerVolumeOptions - the options the volume was created with, nil if
+// it wasn't created with any
+func loadDockerVolumeOptions(volumeName string) (*dockerVolumeOptions, error) {
+	serialized, err := ioutil.ReadFile(dockerVolumeOptionsPath(volumeName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	options := &dockerVolumeOptions{}
+	err = json.Unmarshal(serialized, options)
+	if err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+func removeDockerVolumeOptions(volumeName string) error {
+	err := os.Remove(dockerVolumeOptionsPath(volumeName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// dot - the dot, branch (as a name for procureFilesystem) and
+// subdot that a volume with options mounts
+func (options *dockerVolumeOptions) dot() (VolumeName, string, error) {
+	namespace, localName, subvolume, err := parseNamespacedVolumeWithSubvolumes(options.Dot)
+	if err != nil {
+		return VolumeName{}, "", err
+	}
+	name := VolumeName{Namespace: namespace, Name: localName}
+	if options.Branch != "" {
+		name.Name = localName + "@" + options.Branch
+	}
+	return name, subvolume, nil
+}
+
+// readonlyMountpoint - where the read-only view of a filesystem is bind
+// mounted for a volume
+func readonlyMountpoint(volumeName, filesystemId string) string {
+	return filepath.Join(CONTAINER_MOUNT_PREFIX, container.READONLY_MOUNTS_DIR, url.PathEscape(volumeName), filesystemId)
+}
+
+// createDockerVolume - sets up what a volume with options mounts, creating
+// its branch if need be, so that mistakes show up as the volume is created
+func (state *InMemoryState) createDockerVolume(ctx context.Context, volumeName string, options *dockerVolumeOptions) error {
+	name, _, err := options.dot()
+	if err != nil {
+		return err
+	}
+	if options.CreateBranchFrom != "" {
+		err = state.maybeCreateBranch(ctx, name, options)
+		if err != nil {
+			return err
+		}
+	}
+	filesystemId, err := state.procureFilesystem(ctx, name)
+	if err != nil {
+		return err
+	}
+	if options.Commit != "" {
+		err = state.checkCommitExists(filesystemId, options.Commit)
+		if err != nil {
+			return err
+		}
+	}
+	return saveDockerVolumeOptions(volumeName, options)
+}
+
+// maybeCreateBranch - creates the volume's branch from the latest commit on
+// CreateBranchFrom, if it doesn't exist yet
+func (state *InMemoryState) maybeCreateBranch(ctx context.Context, name VolumeName, options *dockerVolumeOptions) error {
+	dot := VolumeName{Namespace: name.Namespace, Name: strings.Split(name.Name, "@")[0]}
+	if _, err := state.registry.MaybeCloneFilesystemId(dot, options.Branch); err == nil {
+		return nil
+	}
+	source := options.CreateBranchFrom
+	if source == DEFAULT_BRANCH {
+		source = ""
+	}
+	sourceId, err := state.registry.MaybeCloneFilesystemId(dot, source)
+	if err != nil {
+		return err
+	}
+	snapshots, err := state.SnapshotsForCurrentMaster(sourceId)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("Branch %s of %s has no commits to create branch %s from", options.CreateBranchFrom, dot, options.Branch)
+	}
+	_, err = state.createBranch(
+		ctx,
+		dot.Namespace, dot.Name, options.CreateBranchFrom, options.Branch, snapshots[len(snapshots)-1].Id,
+	)
+	return err
+}
+
+func (state *InMemoryState) checkCommitExists(filesystemId, commitId string) error {
+	snapshots, err := state.SnapshotsForCurrentMaster(filesystemId)
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Id == commitId {
+			return nil
+		}
+	}
+	return fmt.Errorf("Cannot find commit with id %s for filesystem %s", commitId, filesystemId)
+}
+
+// mountDockerVolume - mounts what a volume with options says to mount here,
+// returning the path to give to docker
+func (state *InMemoryState) mountDockerVolume(ctx context.Context, volumeName, mountId string, options *dockerVolumeOptions) (string, error) {
+	name, subvolume, err := options.dot()
+	if err != nil {
+		return "", err
+	}
+	filesystemId, err := state.procureFilesystem(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	if options.Commit != "" {
+		path, err := state.mountCommit(ctx, filesystemId, options.Commit, dockerCommitHolder(volumeName, mountId))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(path, subvolume), nil
+	}
+
+	mountpoint, err := newContainerMountSymlink(name, filesystemId, subvolume)
+	if err != nil {
+		return "", err
+	}
+	if options.ReadOnly {
+		return state.mountReadonly(volumeName, mountId, filesystemId, subvolume)
+	}
+	if mountId == "" {
+		return mountpoint, nil
+	}
+	return newMountSymlink(container.PINNED_MOUNTS_DIR, mountId, mnt(filesystemId), subvolume)
+}
+
+// mountDockerDot - mounts a volume named after a dot, giving the mount its own
+// symlink to the branch that's current here, unless the volume's name
+// (dot@branch, or dot@commit) or the container's labels pin it to a branch or
+// commit
+func (state *InMemoryState) mountDockerDot(ctx context.Context, volumeName, mountId string) (string, error) {
+	namespace, localName, subvolume, err := parseNamespacedVolumeWithSubvolumes(volumeName)
+	if err != nil {
+		return "", err
+	}
+	name := VolumeName{Namespace: namespace, Name: localName}
+	pinned := strings.Contains(localName, "@")
+
+	if branch, commitId, ok := state.commitRef(name); ok {
+		path, err := state.procureCommit(ctx, branch, commitId, dockerCommitHolder(volumeName, mountId))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(path, subvolume), nil
+	}
+	if !pinned {
+		pin, err := state.containers.Pin(volumeName)
+		if err != nil {
+			return "", err
+		}
+		opts := map[string]string{}
+		if pin.Branch != "" {
+			opts["branch"] = pin.Branch
+		}
+		if pin.Commit != "" {
+			opts["commit"] = pin.Commit
+		}
+		options, err := parseDockerVolumeOptions(volumeName, opts)
+		if err != nil {
+			return "", err
+		}
+		if options != nil {
+			log.Printf("[mountDockerDot] %s is pinned by its container's labels: %+v", volumeName, pin)
+			return state.mountDockerVolume(ctx, volumeName, mountId, options)
+		}
+	}
+
+	filesystemId, err := state.procureFilesystem(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	// the dot's own symlink says which branch is current here
+	mountpoint, err := newContainerMountSymlink(name, filesystemId, subvolume)
+	if err != nil {
+		return "", err
+	}
+	if mountId == "" {
+		return mountpoint, nil
+	}
+	target, err := os.Readlink(containerMnt(name))
+	if err != nil {
+		return "", err
+	}
+	dir := container.FOLLOWING_MOUNTS_DIR
+	if pinned {
+		dir = container.PINNED_MOUNTS_DIR
+	}
+	return newMountSymlink(dir, mountId, target, subvolume)
+}
+
+// mountReadonly - bind mounts a read-only view of the filesystem for the
+// volume, if it isn't already, and notes that mountId is using it
+func (state *InMemoryState) mountReadonly(volumeName, mountId, filesystemId, subvolume string) (string, error) {
+	state.dockerVolumeMountsLock.Lock()
+	defer state.dockerVolumeMountsLock.Unlock()
+
+	target := readonlyMountpoint(volumeName, filesystemId)
+	mounted, err := isMountpoint(target)
+	if err != nil {
+		return "", err
+	}
+	if !mounted {
+		err = os.MkdirAll(target, 0700)
+		if err != nil {
+			return "", err
+		}
+		source := mnt(filesystemId)
+		zfs.LogZFSCommand(filesystemId, fmt.Sprintf("mount --bind %s %s", source, target))
+		out, err := exec.Command("mount", "--bind", source, target).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("Unable to mount %s read-only: %s (%s)", volumeName, err, out)
+		}
+		// bind mounts take the source's flags, so read-only takes a remount
+		out, err = exec.Command("mount", "-o", "remount,bind,ro", target).CombinedOutput()
+		if err != nil {
+			exec.Command("umount", target).Run()
+			return "", fmt.Errorf("Unable to mount %s read-only: %s (%s)", volumeName, err, out)
+		}
+	}
+	if _, ok := state.dockerVolumeMounts[volumeName]; !ok {
+		state.dockerVolumeMounts[volumeName] = map[string]string{}
+	}
+	state.dockerVolumeMounts[volumeName][mountId] = target
+	if subvolume == "" {
+		return target, nil
+	}
+	return filepath.Join(target, subvolume), nil
+}
+
+// unmountDockerVolume - unmounts a volume's read-only view once nothing's
+// using it
+func (state *InMemoryState) unmountDockerVolume(volumeName, mountId string) error {
+	state.dockerVolumeMountsLock.Lock()
+	defer state.dockerVolumeMountsLock.Unlock()
+
+	mounts, ok := state.dockerVolumeMounts[volumeName]
+	if !ok {
+		return nil
+	}
+	target, ok := mounts[mountId]
+	if !ok {
+		return nil
+	}
+	delete(mounts, mountId)
+	for _, other := range mounts {
+		if other == target {
+			return nil
+		}
+	}
+	if len(mounts) == 0 {
+		delete(state.dockerVolumeMounts, volumeName)
+	}
+	log.Printf("[unmountDockerVolume] unmounting %s, which %s was the last to use", target, mountId)
+	out, err := exec.Command("umount", target).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Unable to unmount %s: %s (%s)", target, err, out)
+	}
+	os.Remove(target)
+	return nil
+}
+
+// dockerVolumeMountpoint - where a volume with options is, or would be,
+// mounted
+func (state *InMemoryState) dockerVolumeMountpoint(volumeName string, options *dockerVolumeOptions) (string, error) {
+	name, subvolume, err := options.dot()
+	if err != nil {
+		return "", err
+	}
+	if options.Commit == "" && !options.ReadOnly {
+		return containerMntSubvolume(name, subvolume), nil
+	}
+	dot := VolumeName{Namespace: name.Namespace, Name: strings.Split(name.Name, "@")[0]}
+	filesystemId, err := state.registry.MaybeCloneFilesystemId(dot, options.Branch)
+	if err != nil {
+		return "", err
+	}
+	if options.Commit != "" {
+		return filepath.Join(utils.Mnt(zfs.FullIdWithSnapshot(filesystemId, options.Commit)), subvolume), nil
+	}
+	return filepath.Join(readonlyMountpoint(volumeName, filesystemId), subvolume), nil
+}
+
+func isMountpoint(path string) (bool, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+	code, err := utils.ReturnCode("mountpoint", "-q", path)
+	if err != nil {
+		return false, err
+	}
+	return code == 0, nil
+}
+
+// dockerCommitHolder - what holds a commit mounted for a docker mount. Docker
+// older than 1.13 doesn't say which mount it is, so all of a volume's mounts
+// share a holder, which is counted by noteAnonymousDockerMount
+func dockerCommitHolder(volumeName, mountId string) string {
+	if mountId == "" {
+		return COMMIT_MOUNT_DOCKER_HOLDER + volumeName
+	}
+	return COMMIT_MOUNT_DOCKER_HOLDER + mountId
+}
+
+// noteAnonymousDockerMount - counts mounts of a volume by docker too old to
+// say which mount it is, which share a commit holder and read-only view
+func (state *InMemoryState) noteAnonymousDockerMount(volumeName string) {
+	state.dockerVolumeMountsLock.Lock()
+	defer state.dockerVolumeMountsLock.Unlock()
+	state.dockerAnonymousMounts[volumeName]++
+}
+
+// releaseAnonymousDockerMount - whether the last of a volume's anonymous
+// mounts has been unmounted, so what they shared can be let go of. The count
+// isn't kept across restarts, after which the first unmount lets go.
+func (state *InMemoryState) releaseAnonymousDockerMount(volumeName string) bool {
+	state.dockerVolumeMountsLock.Lock()
+	defer state.dockerVolumeMountsLock.Unlock()
+	if state.dockerAnonymousMounts[volumeName] > 1 {
+		state.dockerAnonymousMounts[volumeName]--
+		return false
+	}
+	delete(state.dockerAnonymousMounts, volumeName)
+	return true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestParseDockerVolumeOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts map[string]string
+		want *dockerVolumeOptions
+		err  bool
+	}{
+		{"no options", nil, nil, false},
+		{"dot", map[string]string{"dot": "alice/db.data"}, &dockerVolumeOptions{Dot: "alice/db.data"}, false},
+		{"branch", map[string]string{"branch": "feature"}, &dockerVolumeOptions{Dot: "vol", Branch: "feature"}, false},
+		{"master is the default branch", map[string]string{"branch": "master"}, &dockerVolumeOptions{Dot: "vol"}, false},
+		{"readonly", map[string]string{"readonly": "true"}, &dockerVolumeOptions{Dot: "vol", ReadOnly: true}, false},
+		{"not readonly", map[string]string{"readonly": "0"}, &dockerVolumeOptions{Dot: "vol"}, false},
+		{"bad readonly", map[string]string{"readonly": "yes please"}, nil, true},
+		{
+			"commits are read-only",
+			map[string]string{"commit": "c1", "readonly": "false"},
+			&dockerVolumeOptions{Dot: "vol", Commit: "c1", ReadOnly: true},
+			false,
+		},
+		{
+			"create branch",
+			map[string]string{"branch": "feature", "create-branch-from": "master"},
+			&dockerVolumeOptions{Dot: "vol", Branch: "feature", CreateBranchFrom: "master"},
+			false,
+		},
+		{"create branch without a branch", map[string]string{"create-branch-from": "master"}, nil, true},
+		{"create master", map[string]string{"branch": "master", "create-branch-from": "feature"}, nil, true},
+		{"bad branch to create from", map[string]string{"branch": "feature", "create-branch-from": "a b"}, nil, true},
+		{"@ in dot", map[string]string{"dot": "db@feature"}, nil, true},
+		{"bad dot", map[string]string{"dot": "a/b/c"}, nil, true},
+		{"bad branch", map[string]string{"branch": "a b"}, nil, true},
+		{"unknown option", map[string]string{"branch": "feature", "size": "10G"}, nil, true},
+	}
+	for _, tt := range tests {
+		options, err := parseDockerVolumeOptions("vol", tt.opts)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", tt.name, options)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if (options == nil) != (tt.want == nil) || (options != nil && *options != *tt.want) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, options)
+		}
+	}
+}
+
+func TestReleaseAnonymousDockerMount(t *testing.T) {
+	state := &InMemoryState{
+		dockerAnonymousMounts:  map[string]int{},
+		dockerVolumeMountsLock: &sync.Mutex{},
+	}
+	state.noteAnonymousDockerMount("vol")
+	state.noteAnonymousDockerMount("vol")
+	state.noteAnonymousDockerMount("other")
+
+	if state.releaseAnonymousDockerMount("vol") {
+		t.Error("expected the volume to be held while another container has it mounted")
+	}
+	if !state.releaseAnonymousDockerMount("vol") {
+		t.Error("expected the volume to be let go of once its last container unmounts it")
+	}
+	if !state.releaseAnonymousDockerMount("other") {
+		t.Error("expected each volume's mounts to be counted separately")
+	}
+	// e.g. mounted before a restart
+	if !state.releaseAnonymousDockerMount("uncounted") {
+		t.Error("expected a volume that wasn't counted to be let go of")
+	}
+	if len(state.dockerAnonymousMounts) != 0 {
+		t.Errorf("expected no counts left, got %v", state.dockerAnonymousMounts)
+	}
+}
@@ -14,6 +14,10 @@ import (
 	"time"
 
 	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/metrics"
+	"github.com/dotmesh-io/dotmesh/pkg/tracing"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/nu7hatch/gouuid"
 	"golang.org/x/net/context"
@@ -23,50 +27,99 @@ import (
 
 // etcd related pieces, including the parts of InMemoryState which interact with etcd
 
-var etcdKeysAPI client.KeysAPI
+var etcdKeysAPI kv.Store
 var etcdConnectionOnce Once
 
-func getEtcdKeysApi() (client.KeysAPI, error) {
+// getEtcdKeysApi - returns the KV store selected by DOTMESH_KV_BACKEND:
+// "etcd" (the default) talks the etcd v2 API to DOTMESH_ETCD_ENDPOINT,
+// "etcd3" talks the v3 API to the same endpoint and "bolt" keeps everything
+// in an embedded database at DOTMESH_KV_BOLT_PATH, which is only suitable for
+// single node clusters.
+func getEtcdKeysApi() (kv.Store, error) {
+	var connErr error
 	etcdConnectionOnce.Do(func() {
-		var err error
-		endpoint := os.Getenv("DOTMESH_ETCD_ENDPOINT")
-		if endpoint == "" {
-			endpoint = "https://dotmesh-etcd:42379"
-		}
-		transport := &http.Transport{}
-		if endpoint[:5] == "https" {
-			// only try to fetch PKI gubbins if we're creating an encrypted
-			// connection.
-			pkiPath := os.Getenv("DOTMESH_PKI_PATH")
-			if pkiPath == "" {
-				pkiPath = "/pki"
-			}
-			transport, err = transportFromTLS(
-				fmt.Sprintf("%s/apiserver.pem", pkiPath),
-				fmt.Sprintf("%s/apiserver-key.pem", pkiPath),
-				fmt.Sprintf("%s/ca.pem", pkiPath),
-			)
-			if err != nil {
-				panic(err)
+		backend := os.Getenv("DOTMESH_KV_BACKEND")
+		switch backend {
+		case "", "etcd":
+			etcdKeysAPI, connErr = getEtcdV2Store()
+		case "etcd3":
+			etcdKeysAPI, connErr = getEtcdV3Store()
+		case "bolt":
+			boltPath := os.Getenv("DOTMESH_KV_BOLT_PATH")
+			if boltPath == "" {
+				boltPath = "/var/lib/dotmesh/kv.db"
 			}
+			etcdKeysAPI, connErr = kv.NewBoltStore(boltPath)
+		default:
+			connErr = fmt.Errorf("Unknown KV backend %q, expected one of etcd, etcd3 or bolt", backend)
 		}
-		cfg := client.Config{
-			Endpoints: []string{endpoint},
-			Transport: transport,
-			// set timeout per request to fail fast when the target endpoint is
-			// unavailable
-			HeaderTimeoutPerRequest: time.Second * 10,
-		}
-		etcdClient, err := client.New(cfg)
-		if err != nil {
+		if connErr != nil {
 			// maybe retry, instead of ending it all
-			panic(err)
+			panic(connErr)
 		}
-		etcdKeysAPI = client.NewKeysAPI(etcdClient)
 	})
 	return etcdKeysAPI, nil
 }
 
+func getEtcdEndpoint() string {
+	endpoint := os.Getenv("DOTMESH_ETCD_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://dotmesh-etcd:42379"
+	}
+	return endpoint
+}
+
+func getEtcdTransport(endpoint string) (*http.Transport, error) {
+	if !strings.HasPrefix(endpoint, "https") {
+		return &http.Transport{}, nil
+	}
+	// only try to fetch PKI gubbins if we're creating an encrypted
+	// connection.
+	pkiPath := getPkiPath()
+	return transportFromTLS(
+		fmt.Sprintf("%s/apiserver.pem", pkiPath),
+		fmt.Sprintf("%s/apiserver-key.pem", pkiPath),
+		fmt.Sprintf("%s/ca.pem", pkiPath),
+	)
+}
+
+func getEtcdV2Store() (kv.Store, error) {
+	endpoint := getEtcdEndpoint()
+	transport, err := getEtcdTransport(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	cfg := client.Config{
+		Endpoints: []string{endpoint},
+		Transport: transport,
+		// set timeout per request to fail fast when the target endpoint is
+		// unavailable
+		HeaderTimeoutPerRequest: time.Second * 10,
+	}
+	etcdClient, err := client.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewKeysAPI(etcdClient), nil
+}
+
+func getEtcdV3Store() (kv.Store, error) {
+	endpoint := getEtcdEndpoint()
+	transport, err := getEtcdTransport(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		TLS:         transport.TLSClientConfig,
+		DialTimeout: time.Second * 10,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kv.NewEtcdV3Store(etcdClient), nil
+}
+
 var onceAgain Once
 
 func transportFromTLS(certFile, keyFile, caFile string) (*http.Transport, error) {
@@ -139,11 +192,12 @@ func (s *InMemoryState) updateAddressesInEtcd() error {
 		context.Background(),
 		fmt.Sprintf("%s/servers/addresses/%s", ETCD_PREFIX, s.zfs.GetPoolID()),
 		strings.Join(addresses, ","),
-		&client.SetOptions{TTL: 60 * time.Second},
+		&client.SetOptions{TTL: SERVER_ADDRESS_TTL},
 	)
 	if err != nil {
 		return err
 	}
+	s.heartbeat()
 	return nil
 }
 
@@ -338,7 +392,7 @@ func (s *InMemoryState) cleanupDeletedFilesystems() error {
 }
 
 // The result is a map from filesystem ID to the VolumeName or branch name it once had.
-func listFilesystemsPendingCleanup(kapi client.KeysAPI) (map[string]NameOrClone, error) {
+func listFilesystemsPendingCleanup(kapi kv.Store) (map[string]NameOrClone, error) {
 	// list ETCD_PREFIX/filesystems/cleanupPending/ID without corresponding
 	// ETCD_PREFIX/filesystems/live/ID
 
@@ -452,6 +506,7 @@ func (s *InMemoryState) handleOneFilesystemMaster(node *client.Node) error {
 		requestId := pieces[len(pieces)-1]
 		if node.Value == s.zfs.GetPoolID() {
 			log.Debugf("MOUNTING: %s=%s", fs, node.Value)
+			s.ensureWritable(fs)
 			responseChan, err = s.dispatchEvent(fs, &types.Event{Name: "mount"}, requestId)
 			if err != nil {
 				return err
@@ -504,7 +559,7 @@ func (s *InMemoryState) handleOneFilesystemDeletion(node *client.Node) error {
 }
 
 // make a global request, returning its id
-func (s *InMemoryState) globalFsRequestId(fs string, event *types.Event) (chan *types.Event, string, error) {
+func (s *InMemoryState) globalFsRequestId(ctx context.Context, fs string, event *types.Event) (chan *types.Event, string, error) {
 	id, err := uuid.NewV4()
 	if err != nil {
 		return nil, "", err
@@ -514,6 +569,11 @@ func (s *InMemoryState) globalFsRequestId(fs string, event *types.Event) (chan *
 	event.ID = requestID
 	event.FilesystemID = fs
 
+	err = tracing.InjectEvent(ctx, event)
+	if err != nil {
+		return nil, "", err
+	}
+
 	// serialized, err := s.serializeEvent(e)
 	// if err != nil {
 	// 	log.Printf("globalFsRequest - error serializing %#v: %#v", e, err)
@@ -630,8 +690,8 @@ func (s *InMemoryState) globalFsRequestId(fs string, event *types.Event) (chan *
 
 // attempt to register an event in etcd upon which the current master for that
 // filesystem will act on it and then respond
-func (s *InMemoryState) globalFsRequest(fs string, e *Event) (chan *Event, error) {
-	c, _, err := s.globalFsRequestId(fs, e)
+func (s *InMemoryState) globalFsRequest(ctx context.Context, fs string, e *Event) (chan *Event, error) {
+	c, _, err := s.globalFsRequestId(ctx, fs, e)
 	// throw away id
 	return c, err
 }
@@ -775,10 +835,19 @@ func (s *InMemoryState) UpdateSnapshotsFromKnownState(server, filesystem string,
 		return fmt.Errorf("[UpdateSnapshotsFromKnownState] Error initialising filesystem machine: %s", err)
 	}
 
+	// the first snapshots we hear of from a server are the ones it had when
+	// we started (or when the filesystem was created there), which aren't
+	// new commits to tell anyone about
+	_, known := fsm.ListSnapshots()[server]
 	oldSnapshots := fsm.GetSnapshots(server)
 
 	fsm.SetSnapshots(server, snapshots)
 
+	if server == s.NodeID() {
+		// the filesystem exists here, maybe for the first time
+		s.ensureFilesystemQuota(filesystem)
+	}
+
 	// s.globalSnapshotCacheLock.Lock()
 	// if _, ok := s.globalSnapshotCache[server]; !ok {
 	// 	s.globalSnapshotCache[server] = map[string][]snapshot{}
@@ -807,7 +876,7 @@ func (s *InMemoryState) UpdateSnapshotsFromKnownState(server, filesystem string,
 			)
 
 			// External pubsub
-			if len(snapshots) > len(oldSnapshots) {
+			if known && len(snapshots) > len(oldSnapshots) {
 				tlf, branch, err := s.registry.LookupFilesystemById(filesystem)
 				if err != nil {
 					return fmt.Errorf("[UpdateSnapshotsFromKnownState] Error looking up filesystem: %s", err)
@@ -815,6 +884,15 @@ func (s *InMemoryState) UpdateSnapshotsFromKnownState(server, filesystem string,
 
 				namespace := tlf.MasterBranch.Name.Namespace
 				name := tlf.MasterBranch.Name.Name
+				for _, ss := range snapshots[len(oldSnapshots):] {
+					s.publishEvent(tlf, &types.EventNotification{
+						Type:         types.EventTypeCommit,
+						FilesystemId: filesystem,
+						Branch:       branch,
+						CommitId:     ss.Id,
+						Metadata:     ss.Metadata,
+					})
+				}
 				go func() {
 					for _, ss := range snapshots[len(oldSnapshots):] {
 						collaborators := make([]string, len(tlf.Collaborators))
@@ -946,6 +1024,20 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 		s.serverAddressesCache[server] = node.Value
 		return nil
 	}
+	updateCordoned := func(node *client.Node) error {
+		// (0)/(1)dotmesh.io/(2)servers/(3)cordoned/(4):server = since
+		pieces := strings.Split(node.Key, "/")
+		server := pieces[4]
+
+		s.cordonedNodesLock.Lock()
+		defer s.cordonedNodesLock.Unlock()
+		if node.Value == "" {
+			delete(s.cordonedNodes, server)
+		} else {
+			s.cordonedNodes[server] = node.Value
+		}
+		return nil
+	}
 	updateStates := func(node *client.Node) error {
 		// (0)/(1)dotmesh.io/(2)servers/
 		//     (3)snapshots/(4):server/(5):filesystem = snapshots
@@ -1012,6 +1104,9 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 			newMeta := *stateMetadata
 			newMeta["version"] = fmt.Sprintf("%d", node.ModifiedIndex)
 			fsm.SetMetadata(server, newMeta)
+			if server == s.NodeID() && (currentMeta["state"] != newMeta["state"] || currentMeta["status"] != newMeta["status"]) {
+				s.publishStateChange(filesystem, newMeta["state"], newMeta["status"])
+			}
 			// s.globalStateCache[server][filesystem] = *stateMetadata
 			// s.globalStateCache[server][filesystem]["version"] = fmt.Sprintf(
 			// "%d", node.ModifiedIndex,
@@ -1095,6 +1190,13 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 				return err
 			}
 			s.registry.UpdateCloneFromEtcd(name, topLevelFilesystemId, *clone)
+			// the branch may have been created on this node before it was
+			// registered
+			if fsm, err := s.GetFilesystemMachine(clone.FilesystemId); err == nil {
+				if _, ok := fsm.ListSnapshots()[s.NodeID()]; ok {
+					go s.ensureFilesystemQuota(clone.FilesystemId)
+				}
+			}
 		}
 		return nil
 	}
@@ -1140,7 +1242,9 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 		}
 		return nil
 	}
-	updateTransfers := func(node *client.Node) error {
+	// transfers are only published as they're updated, not when they're
+	// loaded as the server starts
+	updateTransfers := func(node *client.Node, publish bool) error {
 		// (0)/(1)dotmesh.io/(2)filesystems/
 		//     (3)transfers/(4):transferId = transferRequest
 		pieces := strings.Split(node.Key, "/")
@@ -1157,7 +1261,14 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 			}
 			s.interclusterTransfersLock.Lock()
 			defer s.interclusterTransfersLock.Unlock()
+			var previous *TransferPollResult
+			if p, ok := s.interclusterTransfers[transferId]; ok {
+				previous = &p
+			}
 			s.interclusterTransfers[transferId] = *transferInfo
+			if publish {
+				s.publishTransferUpdate(previous, transferInfo)
+			}
 		}
 		return nil
 	}
@@ -1234,6 +1345,7 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 	var masters *client.Node
 	// var requests *client.Node
 	var serverAddresses *client.Node
+	var serverCordoned *client.Node
 	var serverSnapshots *client.Node
 	var serverStates *client.Node
 	var registryFilesystems *client.Node
@@ -1266,6 +1378,8 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 				masters = child
 			case "servers/addresses":
 				serverAddresses = child
+			case "servers/cordoned":
+				serverCordoned = child
 			case "servers/snapshots":
 				serverSnapshots = child
 			case "servers/states":
@@ -1308,6 +1422,13 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 			}
 		}
 	}
+	if serverCordoned != nil {
+		for _, node := range serverCordoned.Nodes {
+			if err = updateCordoned(node); err != nil {
+				return err
+			}
+		}
+	}
 	if serverStates != nil {
 		for _, servers := range serverStates.Nodes {
 			for _, filesystem := range servers.Nodes {
@@ -1377,7 +1498,7 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 	}
 	if interclusterTransfers != nil {
 		for _, node := range interclusterTransfers.Nodes {
-			if err = updateTransfers(node); err != nil {
+			if err = updateTransfers(node, false); err != nil {
 				return err
 			}
 		}
@@ -1481,6 +1602,12 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 			}
 		}()
 
+		// how far the change we're about to process is behind the cluster's
+		// latest, which grows when we can't keep up with etcd
+		if node != nil && node.Node != nil && node.Index >= node.Node.ModifiedIndex {
+			metrics.EtcdWatchLag.Set(float64(node.Index - node.Node.ModifiedIndex))
+		}
+
 		// From time to time, the entire registry will be deleted (see rpc.go
 		// RestoreEtcd). Detect this case and wipe out the registry records as
 		// commonly dots will be re-owned in this scenario.
@@ -1516,6 +1643,10 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 			if err = updateAddresses(node.Node); err != nil {
 				return err
 			}
+		} else if variant == "servers/cordoned" {
+			if err = updateCordoned(node.Node); err != nil {
+				return err
+			}
 		} else if variant == "servers/snapshots" {
 			if err = updateSnapshots(node.Node); err != nil {
 				return err
@@ -1541,7 +1672,11 @@ func (s *InMemoryState) fetchAndWatchEtcd() error {
 				return err
 			}
 		} else if variant == "filesystems/transfers" {
-			if err = updateTransfers(node.Node); err != nil {
+			if err = updateTransfers(node.Node, true); err != nil {
+				return err
+			}
+		} else if variant == "quotas/filesystems" {
+			if err = s.applyFilesystemQuota(node.Node); err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/dotmesh-io/dotmesh/pkg/registry"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MASTER FAILOVER
+
+// Failover is off unless MASTER_FAILOVER_GRACE_PERIOD is set to a number of
+// seconds, which has to be more than SERVER_ADDRESS_TTL plus
+// FAILOVER_INTERVAL (70s), and should be set the same on every node.
+//
+// Every node heartbeats by refreshing its address in etcd, which expires
+// after SERVER_ADDRESS_TTL. Once a node's address has been gone for the
+// grace period, the branches it's the master of are failed over to
+// whichever live node has the most of their commits. A branch is only
+// failed over to a node missing some of its commits, losing them, if
+// MASTER_FAILOVER_FORCE is set; otherwise it waits for its master to come
+// back, or for a node with every commit to appear. Only one node does
+// that at a time (the live node with the lowest id), and the master is only
+// changed in etcd if it's still the dead node, so two nodes that both think
+// they're in charge can't fail a branch over twice.
+//
+// A node that can't heartbeat can't tell whether it's the one that's
+// presumed dead, so once its own address will have expired it fences itself
+// off, making the filesystems it's the master of read-only. That happens
+// well before the grace period is up, so it can't still be writing to a
+// branch once it's been failed over. When it can heartbeat again, it
+// unfences the ones it's still the master of; the rest are unmounted as the
+// new masters appear.
+
+const SERVER_ADDRESS_TTL = 60 * time.Second
+
+// How often failoverDeadMasters runs, and so how long after its address has
+// expired a node that can't heartbeat may take to fence itself off
+const FAILOVER_INTERVAL = 10 * time.Second
+
+// Where failovers are recorded, by filesystem id
+const FAILOVERS_PREFIX = "filesystems/failovers"
+
+func (s *InMemoryState) heartbeat() {
+	s.failoverLock.Lock()
+	defer s.failoverLock.Unlock()
+	s.lastHeartbeat = time.Now()
+}
+
+// heartbeating - whether this node's address is still in etcd, as far as it
+// knows
+func (s *InMemoryState) heartbeating() bool {
+	s.failoverLock.Lock()
+	defer s.failoverLock.Unlock()
+	return time.Since(s.lastHeartbeat) < SERVER_ADDRESS_TTL
+}
+
+// checkFailoverGracePeriod - the grace period has to leave a dead node time
+// to fence itself off before its branches are failed over, or there would be
+// two writable masters
+func checkFailoverGracePeriod(gracePeriod time.Duration) error {
+	if gracePeriod < 0 {
+		return fmt.Errorf("MASTER_FAILOVER_GRACE_PERIOD can't be negative")
+	}
+	if gracePeriod != 0 && gracePeriod <= SERVER_ADDRESS_TTL+FAILOVER_INTERVAL {
+		return fmt.Errorf(
+			"MASTER_FAILOVER_GRACE_PERIOD must be more than %d seconds, so dead nodes have fenced themselves off before failover",
+			int((SERVER_ADDRESS_TTL+FAILOVER_INTERVAL)/time.Second),
+		)
+	}
+	return nil
+}
+
+// failoverDeadMasters - fences or unfences this node, and if it's the one in
+// charge, fails over branches mastered by nodes that have been gone for
+// longer than the grace period. Runs forever.
+func (s *InMemoryState) failoverDeadMasters() error {
+	if !s.heartbeating() {
+		if s.config.MasterFailoverGracePeriod != 0 {
+			s.fence()
+		}
+		return nil
+	}
+	err := s.unfence()
+	if err != nil {
+		return err
+	}
+
+	masters := s.registry.ListMasterNodes(&registry.ListMasterNodesQuery{})
+	live := s.liveNodes()
+	dead := s.updateDeadNodes(masters, live)
+	if s.config.MasterFailoverGracePeriod == 0 || len(live) == 0 || live[0] != s.NodeID() {
+		return nil
+	}
+
+	fsIds := []string{}
+	for fsId := range masters {
+		fsIds = append(fsIds, fsId)
+	}
+	sort.Strings(fsIds)
+	for _, fsId := range fsIds {
+		since, ok := dead[masters[fsId]]
+		if !ok || time.Since(since) < s.config.MasterFailoverGracePeriod {
+			continue
+		}
+		err := s.failover(fsId, masters[fsId], since)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":         err,
+				"filesystem_id": fsId,
+				"node":          masters[fsId],
+			}).Error("[failoverDeadMasters] failed to fail over branch")
+		}
+	}
+	return nil
+}
+
+// updateDeadNodes - when each node that's the master of something, but isn't
+// live, was first noticed to be gone
+func (s *InMemoryState) updateDeadNodes(masters map[string]string, live []string) map[string]time.Time {
+	isLive := map[string]bool{}
+	for _, node := range live {
+		isLive[node] = true
+	}
+
+	s.failoverLock.Lock()
+	defer s.failoverLock.Unlock()
+	dead := map[string]time.Time{}
+	for _, node := range masters {
+		if isLive[node] {
+			continue
+		}
+		since, ok := s.deadNodes[node]
+		if !ok {
+			since = time.Now()
+			log.Warnf("[failoverDeadMasters] node %s has gone away", node)
+		}
+		dead[node] = since
+	}
+	for node := range s.deadNodes {
+		if _, ok := dead[node]; !ok && isLive[node] {
+			log.Infof("[failoverDeadMasters] node %s is back", node)
+		}
+	}
+	s.deadNodes = dead
+	return dead
+}
+
+// checkFailoverTarget - whether a branch can be failed over to the target,
+// the most up to date live node, which is missing some number of its commits
+func checkFailoverTarget(filesystemId, target string, missing int, force bool) error {
+	if target == "" {
+		return fmt.Errorf("No live node has a copy of %s to fail over to", filesystemId)
+	}
+	if missing > 0 && !force {
+		return fmt.Errorf(
+			"Not failing %s over to %s, the most up to date live node, as it's missing %d commits; set MASTER_FAILOVER_FORCE to fail over anyway, losing them",
+			filesystemId, target, missing,
+		)
+	}
+	return nil
+}
+
+func (s *InMemoryState) failover(filesystemId, deadNode string, since time.Time) error {
+	target, missingCount := s.mostUpToDateNode(filesystemId, deadNode, nil)
+	err := checkFailoverTarget(filesystemId, target, missingCount, s.config.MasterFailoverForce)
+	if err != nil {
+		return err
+	}
+	missing := s.GetReplicationLatency(filesystemId)[target]
+
+	log.Warnf(
+		"[failover] node %s has been gone since %s, failing %s over to %s, which is missing %d commits",
+		deadNode, since.Format(time.RFC3339), filesystemId, target, len(missing),
+	)
+	err = forceBranchMaster(filesystemId, target, deadNode)
+	if err != nil {
+		if client.IsKeyNotFound(err) || isCompareFailed(err) {
+			// deleted or moved since we looked
+			return nil
+		}
+		return err
+	}
+
+	record := types.Failover{
+		FilesystemId:   filesystemId,
+		From:           deadNode,
+		To:             target,
+		At:             time.Now().UTC(),
+		MissingCommits: missing,
+	}
+	serialized, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.etcdClient.Set(
+		context.Background(),
+		fmt.Sprintf("%s/%s/%s", ETCD_PREFIX, FAILOVERS_PREFIX, filesystemId),
+		string(serialized),
+		&client.SetOptions{},
+	)
+	if err != nil {
+		return err
+	}
+
+	tlf, branch, err := s.registry.LookupFilesystemById(filesystemId)
+	if err != nil {
+		log.WithError(err).Warnf("[failover] not notifying of failover of %s, which isn't in the registry", filesystemId)
+		return nil
+	}
+	s.publishEvent(tlf, &types.EventNotification{
+		Type:         types.EventTypeBranchFailover,
+		FilesystemId: filesystemId,
+		Branch:       branch,
+		Failover:     &record,
+	})
+	return nil
+}
+
+func isCompareFailed(err error) bool {
+	if cErr, ok := err.(client.Error); ok {
+		return cErr.Code == client.ErrorCodeTestFailed
+	}
+	return false
+}
+
+// fence - makes every filesystem this node is the master of read-only, as
+// it may have been failed over by now
+func (s *InMemoryState) fence() {
+	s.failoverLock.Lock()
+	defer s.failoverLock.Unlock()
+	for fsId := range s.registry.ListMasterNodes(&registry.ListMasterNodesQuery{NodeID: s.NodeID()}) {
+		if _, ok := s.fencedFilesystems[fsId]; ok {
+			continue
+		}
+		log.Warnf("[fence] can't reach etcd, so making %s read-only in case it's failed over", fsId)
+		out, err := s.zfs.SetReadonly(fsId, true)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":         err,
+				"filesystem_id": fsId,
+				"output":        string(out),
+			}).Error("[fence] failed to make filesystem read-only")
+			continue
+		}
+		s.fencedFilesystems[fsId] = struct{}{}
+	}
+}
+
+// unfence - makes the fenced filesystems that this node is still the master
+// of writable again, asking etcd rather than trusting the registry, which
+// may not have caught up yet
+func (s *InMemoryState) unfence() error {
+	s.failoverLock.Lock()
+	defer s.failoverLock.Unlock()
+	for fsId := range s.fencedFilesystems {
+		resp, err := s.etcdClient.Get(
+			context.Background(),
+			fmt.Sprintf("%s/filesystems/masters/%s", ETCD_PREFIX, fsId),
+			&client.GetOptions{},
+		)
+		if err != nil && !client.IsKeyNotFound(err) {
+			return err
+		}
+		if err == nil && resp.Node.Value == s.NodeID() {
+			log.Infof("[unfence] still the master of %s, making it writable again", fsId)
+			out, err := s.zfs.SetReadonly(fsId, false)
+			if err != nil {
+				return fmt.Errorf("Unable to make %s writable again: %s (%s)", fsId, err, out)
+			}
+		} else {
+			log.Warnf("[unfence] %s was failed over while this node was away, leaving it read-only", fsId)
+		}
+		delete(s.fencedFilesystems, fsId)
+	}
+	return nil
+}
+
+// ensureWritable - called as this node becomes the master of a filesystem,
+// which may have been left read-only by being fenced before it was failed
+// over, or before this node was restarted. Filesystems are only ever fenced
+// with failover on, so otherwise there's nothing to do.
+func (s *InMemoryState) ensureWritable(filesystemId string) {
+	s.failoverLock.Lock()
+	defer s.failoverLock.Unlock()
+	_, fenced := s.fencedFilesystems[filesystemId]
+	if s.config.MasterFailoverGracePeriod == 0 && !fenced {
+		return
+	}
+	out, err := s.zfs.SetReadonly(filesystemId, false)
+	if err != nil {
+		// it may well not exist here yet
+		log.Debugf("[ensureWritable] unable to make %s writable: %s (%s)", filesystemId, err, out)
+	}
+	delete(s.fencedFilesystems, filesystemId)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMostUpToDate(t *testing.T) {
+	missing := map[string][]string{
+		"dead":     {},
+		"behind":   {"c3", "c4"},
+		"nearly":   {"c4"},
+		"cordoned": {},
+	}
+	skip := func(node string) bool { return node == "dead" || node == "cordoned" }
+
+	tests := []struct {
+		name        string
+		candidates  []string
+		wantTarget  string
+		wantMissing int
+	}{
+		{"picks the node missing fewest commits", []string{"behind", "nearly"}, "nearly", 1},
+		{"order doesn't matter", []string{"nearly", "behind"}, "nearly", 1},
+		{"skips excluded and cordoned nodes", []string{"dead", "cordoned", "behind"}, "behind", 2},
+		{"ignores nodes without a copy", []string{"elsewhere"}, "", 0},
+		{"no candidates", []string{}, "", 0},
+	}
+	for _, tt := range tests {
+		target, n := mostUpToDate(missing, tt.candidates, skip)
+		if target != tt.wantTarget || n != tt.wantMissing {
+			t.Errorf("%s: expected %q missing %d, got %q missing %d", tt.name, tt.wantTarget, tt.wantMissing, target, n)
+		}
+	}
+}
+
+func TestCheckFailoverTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		missing int
+		force   bool
+		allowed bool
+	}{
+		{"up to date", "node2", 0, false, true},
+		{"missing commits", "node2", 3, false, false},
+		{"missing commits, forced", "node2", 3, true, true},
+		{"no copy anywhere", "", 0, false, false},
+		{"no copy anywhere, forced", "", 0, true, false},
+	}
+	for _, tt := range tests {
+		err := checkFailoverTarget("fs1", tt.target, tt.missing, tt.force)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got error: %v", tt.name, tt.allowed, err)
+		}
+	}
+}
+
+func TestCheckFailoverGracePeriod(t *testing.T) {
+	tests := []struct {
+		gracePeriod time.Duration
+		allowed     bool
+	}{
+		{0, true},
+		{-time.Second, false},
+		{5 * time.Second, false},
+		{SERVER_ADDRESS_TTL + FAILOVER_INTERVAL, false},
+		{SERVER_ADDRESS_TTL + FAILOVER_INTERVAL + time.Second, true},
+		{5 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		err := checkFailoverGracePeriod(tt.gracePeriod)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got error: %v", tt.gracePeriod, tt.allowed, err)
+		}
+	}
+}
+
+// readonlyZFS - records which filesystems are made writable
+type readonlyZFS struct {
+	fakeZFS
+	writable []string
+}
+
+func (z *readonlyZFS) SetReadonly(filesystemId string, readonly bool) ([]byte, error) {
+	if !readonly {
+		z.writable = append(z.writable, filesystemId)
+	}
+	return nil, nil
+}
+
+func TestEnsureWritable(t *testing.T) {
+	tests := []struct {
+		name        string
+		gracePeriod time.Duration
+		fenced      bool
+		writable    bool
+	}{
+		{"failover off", 0, false, false},
+		{"failover off, but fenced before it was turned off", 0, true, true},
+		{"failover on", 2 * time.Minute, false, true},
+	}
+	for _, tt := range tests {
+		z := &readonlyZFS{}
+		s := &InMemoryState{
+			config:            Config{MasterFailoverGracePeriod: tt.gracePeriod},
+			zfs:               z,
+			fencedFilesystems: map[string]struct{}{},
+			failoverLock:      &sync.Mutex{},
+		}
+		if tt.fenced {
+			s.fencedFilesystems["fs1"] = struct{}{}
+		}
+		s.ensureWritable("fs1")
+		if (len(z.writable) == 1) != tt.writable {
+			t.Errorf("%s: expected writable=%t, got: %v", tt.name, tt.writable, z.writable)
+		}
+		if _, ok := s.fencedFilesystems["fs1"]; ok {
+			t.Errorf("%s: expected fs1 to no longer be fenced", tt.name)
+		}
+	}
+}
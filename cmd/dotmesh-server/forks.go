@@ -0,0 +1,130 @@
+package main
+
+// FORKS
+//
+// A fork is a copy of a dot in another namespace, which remembers the dot it
+// was forked from (its upstream). The two share the commits made before the
+// fork, so either can be fast-forwarded to the other's later commits as long
+// as it hasn't got commits of its own since: `dm fork sync` brings a fork up
+// to date with its upstream, and accepting a change request brings the
+// upstream up to a commit on the fork. Anything else would need a merge,
+// which dotmesh doesn't do.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/dotmesh-io/dotmesh/pkg/auth"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/dotmesh-io/dotmesh/pkg/validator"
+)
+
+// fastForward - brings the master branch of filesystemId up to commitId
+// (empty for the latest commit) of fromFilesystemId, returning how many
+// commits that took. Like a push, it's refused if the commits it would
+// bring are missing metadata the branch requires.
+func (s *InMemoryState) fastForward(ctx context.Context, filesystemId, fromFilesystemId, commitId string) (int, error) {
+	err := s.checkFastForward(filesystemId, fromFilesystemId, commitId)
+	if err != nil {
+		return 0, err
+	}
+	responseChan, err := s.globalFsRequest(
+		ctx,
+		filesystemId,
+		&Event{Name: "fast-forward", Args: &EventArgs{
+			"FromFilesystemId": fromFilesystemId,
+			"ToSnapshotId":     commitId,
+		}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	e := <-responseChan
+	if e.Name != "fast-forwarded" {
+		return 0, maybeError(e, "fast-forwarded")
+	}
+	// events from other nodes have been through JSON
+	switch commits := (*e.Args)["Commits"].(type) {
+	case int:
+		return commits, nil
+	case float64:
+		return int(commits), nil
+	}
+	return 0, nil
+}
+
+// lookupFork - the fork with the given name, and the dot it was forked from,
+// if the authenticated user can see both
+func (d *DotmeshRPC) lookupFork(r *http.Request, namespace, name string) (TopLevelFilesystem, TopLevelFilesystem, error) {
+	err := validator.IsValidVolume(namespace, name)
+	if err != nil {
+		return TopLevelFilesystem{}, TopLevelFilesystem{}, err
+	}
+	fork, err := d.state.registry.LookupFilesystem(VolumeName{Namespace: namespace, Name: name})
+	if err != nil {
+		return TopLevelFilesystem{}, TopLevelFilesystem{}, err
+	}
+	authorized, err := fork.Authorize(r.Context())
+	if err != nil {
+		return TopLevelFilesystem{}, TopLevelFilesystem{}, err
+	}
+	if !authorized {
+		return TopLevelFilesystem{}, TopLevelFilesystem{}, PermissionDenied{}
+	}
+	if fork.ForkParentId == "" {
+		return TopLevelFilesystem{}, TopLevelFilesystem{}, fmt.Errorf("%s/%s isn't a fork", namespace, name)
+	}
+	upstream, _, err := d.state.registry.LookupFilesystemById(fork.ForkParentId)
+	if err != nil {
+		return TopLevelFilesystem{}, TopLevelFilesystem{}, fmt.Errorf(
+			"The dot %s/%s was forked from no longer exists", namespace, name,
+		)
+	}
+	authorized, err = upstream.Authorize(r.Context())
+	if err != nil {
+		return TopLevelFilesystem{}, TopLevelFilesystem{}, err
+	}
+	if !authorized {
+		return TopLevelFilesystem{}, TopLevelFilesystem{}, PermissionDenied{}
+	}
+	return fork, upstream, nil
+}
+
+// authorizeChangeRequests - the dot with the given name, and whether the
+// authenticated user administers it (owns it or its namespace) rather than
+// just collaborating on it
+func (d *DotmeshRPC) authorizeChangeRequests(r *http.Request, namespace, name string) (TopLevelFilesystem, bool, error) {
+	err := validator.IsValidVolume(namespace, name)
+	if err != nil {
+		return TopLevelFilesystem{}, false, err
+	}
+	tlf, err := d.state.registry.LookupFilesystem(VolumeName{Namespace: namespace, Name: name})
+	if err != nil {
+		return TopLevelFilesystem{}, false, err
+	}
+	isAdmin, err := AuthenticatedUserIsNamespaceAdministrator(r.Context(), namespace)
+	if err != nil {
+		return TopLevelFilesystem{}, false, err
+	}
+	if !isAdmin {
+		isAdmin, err = tlf.AuthorizeOwner(r.Context())
+		if err != nil {
+			return TopLevelFilesystem{}, false, err
+		}
+	}
+	return tlf, isAdmin, nil
+}
+
+func changeRequestAuthor(r *http.Request) (string, error) {
+	u := auth.GetUser(r)
+	if u == nil {
+		return "", fmt.Errorf("No user found in request context.")
+	}
+	return u.Id, nil
+}
+
+func isChangeRequestAuthor(r *http.Request, cr *types.ChangeRequest) bool {
+	author, err := changeRequestAuthor(r)
+	return err == nil && author == cr.AuthorId
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/dotmesh-io/dotmesh/pkg/user"
+)
+
+func TestForkRefusals(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+
+	tests := []struct {
+		name      string
+		as        *user.User
+		namespace string
+	}{
+		{"someone who can't see the dot", c.carol, "carol"},
+		{"a collaborator forking into another's namespace", c.bob, "alice"},
+	}
+	for _, tt := range tests {
+		args := &struct {
+			MasterBranchID string
+			ForkNamespace  string
+			ForkName       string
+		}{testDotId, tt.namespace, "db2"}
+		var forkId string
+		err := c.rpc.Fork(requestAsUser(tt.as), args, &forkId)
+		if err == nil {
+			t.Errorf("%s: expected the fork to be refused", tt.name)
+		}
+	}
+}
+
+func TestLookupFork(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+
+	tests := []struct {
+		name      string
+		as        *user.User
+		namespace string
+		allowed   bool
+	}{
+		{"the fork's owner, who collaborates on its upstream", c.bob, "bob", true},
+		{"the upstream's owner, who can't see the fork", c.alice, "bob", false},
+		{"someone who can see neither", c.carol, "bob", false},
+		{"a dot that isn't a fork", c.alice, "alice", false},
+	}
+	for _, tt := range tests {
+		fork, upstream, err := c.rpc.lookupFork(requestAsUser(tt.as), tt.namespace, "db")
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got error: %v", tt.name, tt.allowed, err)
+			continue
+		}
+		if tt.allowed && (fork.MasterBranch.Id != testForkId || upstream.MasterBranch.Id != testDotId) {
+			t.Errorf("%s: expected %s forked from %s, got %s forked from %s",
+				tt.name, testForkId, testDotId, fork.MasterBranch.Id, upstream.MasterBranch.Id)
+		}
+	}
+}
+
+func TestChangeRequestAuthorization(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+
+	cr, err := c.rpc.state.changeRequestManager.Create(&types.ChangeRequest{
+		FilesystemId: testDotId,
+		ForkId:       testForkId,
+		CommitId:     "c1",
+		Title:        "more rows",
+		AuthorId:     c.bob.Id,
+	})
+	if err != nil {
+		t.Fatalf("failed to create change request: %s", err)
+	}
+	args := &struct {
+		Namespace string
+		Name      string
+		Id        string
+	}{"alice", "db", cr.Id}
+
+	// the dot's owner and the change request's author see it, nobody else
+	for _, tt := range []struct {
+		as   *user.User
+		want int
+	}{{c.alice, 1}, {c.bob, 1}, {c.carol, 0}} {
+		var crs []types.ChangeRequest
+		err := c.rpc.ChangeRequests(requestAsUser(tt.as), &struct {
+			Namespace string
+			Name      string
+		}{"alice", "db"}, &crs)
+		if err != nil {
+			t.Errorf("%s: unexpected error listing change requests: %s", tt.as.Name, err)
+		} else if len(crs) != tt.want {
+			t.Errorf("%s: expected to see %d change requests, got %d", tt.as.Name, tt.want, len(crs))
+		}
+	}
+
+	review := func(as *user.User) error {
+		var result types.ChangeRequest
+		return c.rpc.ReviewChangeRequest(requestAsUser(as), &struct {
+			Namespace string
+			Name      string
+			Id        string
+			Approve   bool
+			Comment   string
+		}{"alice", "db", cr.Id, true, "lgtm"}, &result)
+	}
+	if err := review(c.carol); err == nil {
+		t.Error("expected someone who can't see the dot to be refused a review")
+	}
+	if err := review(c.bob); err != nil {
+		t.Errorf("expected a collaborator to be able to review, got: %s", err)
+	}
+
+	var result types.ChangeRequest
+	if err := c.rpc.AcceptChangeRequest(requestAsUser(c.bob), args, &result); err == nil {
+		t.Error("expected a collaborator to be refused accepting a change request")
+	}
+	if err := c.rpc.CloseChangeRequest(requestAsUser(c.carol), args, &result); err == nil {
+		t.Error("expected someone who isn't the author or an administrator to be refused closing it")
+	}
+	if err := c.rpc.CloseChangeRequest(requestAsUser(c.bob), args, &result); err != nil {
+		t.Errorf("expected the author to be able to close it, got: %s", err)
+	} else if result.State != types.ChangeRequestClosed {
+		t.Errorf("expected the change request to be closed, got %s", result.State)
+	}
+}
@@ -56,8 +56,8 @@ func (state *InMemoryState) runServer() {
 
 	router := mux.NewRouter()
 
-	// only use the zipkin middleware if we have a TRACE_ADDR
-	if os.Getenv("TRACE_ADDR") != "" {
+	// only use the zipkin middleware if we have somewhere to send traces
+	if os.Getenv("TRACE_ADDR") != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
 		tracer := opentracing.GlobalTracer()
 
 		router.Handle("/rpc",
@@ -90,6 +90,8 @@ func (state *InMemoryState) runServer() {
 
 		// put file into other branch
 		router.Handle("/s3/{namespace}:{name}@{branch}/{key:.*}", middleware.FromHTTPRequest(tracer, "s3")(Instrument(state)(NewAuthHandler(NewS3Handler(state), state.userManager)))).Methods("PUT")
+
+		router.Handle("/activity", middleware.FromHTTPRequest(tracer, "activity")(Instrument(state)(NewAuthHandler(NewActivityHandler(state), state.userManager)))).Methods("GET")
 	} else {
 		router.Handle("/rpc", Instrument(state)(NewAuthHandler(r, state.userManager)))
 
@@ -113,6 +115,8 @@ func (state *InMemoryState) runServer() {
 		router.Handle("/s3/{namespace}:{name}/{key:.*}", Instrument(state)(NewAuthHandler(NewS3Handler(state), state.userManager))).Methods("PUT")
 		// put file into other branch
 		router.Handle("/s3/{namespace}:{name}@{branch}/{key:.*}", Instrument(state)(NewAuthHandler(NewS3Handler(state), state.userManager))).Methods("PUT")
+
+		router.Handle("/activity", Instrument(state)(NewAuthHandler(NewActivityHandler(state), state.userManager))).Methods("GET")
 	}
 
 	router.HandleFunc("/check",
@@ -123,11 +127,19 @@ func (state *InMemoryState) runServer() {
 
 	router.Handle("/metrics", promhttp.Handler())
 
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%s", client.SERVER_PORT),
+		Handler:   router,
+		TLSConfig: state.config.TLSConfig,
+	}
 	if os.Getenv("PRINT_HTTP_LOGS") != "" {
-		loggingRouter := handlers.LoggingHandler(getLogfile("requests"), router)
-		err = http.ListenAndServe(fmt.Sprintf(":%s", client.SERVER_PORT), loggingRouter)
+		server.Handler = handlers.LoggingHandler(getLogfile("requests"), router)
+	}
+	if server.TLSConfig != nil {
+		// the certificates come from TLSConfig
+		err = server.ListenAndServeTLS("", "")
 	} else {
-		err = http.ListenAndServe(fmt.Sprintf(":%s", client.SERVER_PORT), router)
+		err = server.ListenAndServe()
 	}
 
 	if err != nil {
@@ -151,28 +163,36 @@ func (state *InMemoryState) runUnixDomainServer() {
 		log.Printf("[runUnixDomainServer] Error while registering services %s", err)
 	}
 
-	// UNIX socket for flexvolume driver to talk to us
-	FV_SOCKET := FLEXVOLUME_DIR + "/dm.sock"
+	unixSocketRouter := mux.NewRouter()
+	unixSocketRouter.Handle("/rpc", r)
+	handler := NewAdminHandler(unixSocketRouter)
+
+	// pre-authenticated-as-admin rpc server for clever unix socket clients
+	// only: the flexvolume driver, which finds it next to itself, and the
+	// CSI driver, which finds it in a directory only root can get into.
+	go serveUnixSocket(CSI_SOCKET_DIR, handler)
+	serveUnixSocket(FLEXVOLUME_DIR, handler)
+}
+
+func serveUnixSocket(dir string, handler http.Handler) {
+	socket := dir + "/dm.sock"
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		log.Fatalf("[runUnixDomainServer] Could not create %s: %v", dir, err)
+	}
 
 	// Unlink any old socket lingering there
-	if _, err := os.Stat(FV_SOCKET); err == nil {
-		if err = os.Remove(FV_SOCKET); err != nil {
-			log.Fatalf("[runUnixDomainServer] Could not clean up existing socket at %s: %v", FV_SOCKET, err)
+	if _, err := os.Stat(socket); err == nil {
+		if err = os.Remove(socket); err != nil {
+			log.Fatalf("[runUnixDomainServer] Could not clean up existing socket at %s: %v", socket, err)
 		}
 	}
 
-	listener, err := net.Listen("unix", FV_SOCKET)
+	listener, err := net.Listen("unix", socket)
 	if err != nil {
-		log.Fatalf("[runUnixDomainServer] Could not listen on %s: %v", FV_SOCKET, err)
+		log.Fatalf("[runUnixDomainServer] Could not listen on %s: %v", socket, err)
 	}
-
-	unixSocketRouter := mux.NewRouter()
-	unixSocketRouter.Handle("/rpc", r)
-
-	// pre-authenticated-as-admin rpc server for clever unix socket clients
-	// only. intended for use by the flexvolume driver, hence the location on
-	// disk.
-	http.Serve(listener, NewAdminHandler(unixSocketRouter))
+	http.Serve(listener, handler)
 }
 
 // handler which makes all requests appear as the admin user!
@@ -206,6 +226,13 @@ func (irw *instrResponseWriter) WriteHeader(code int) {
 	irw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush - passes flushes through for handlers that stream their responses
+func (irw *instrResponseWriter) Flush() {
+	if flusher, ok := irw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func Instrument(state *InMemoryState) MetricsMiddleware {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
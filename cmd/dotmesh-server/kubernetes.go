@@ -9,6 +9,10 @@ var FLEXVOLUME_DIR = "/system-flexvolume/dotmesh.io~dm"
 var FLEXVOLUME_BIN = "dm"
 var FLEXVOLUME_SOURCE = "/usr/local/bin/flexvolume"
 
+// where the CSI driver finds the server's unix socket, which unlike the
+// flexvolume directory is in the same place on every node
+var CSI_SOCKET_DIR = "/run/dotmesh"
+
 func installKubernetesPlugin() error {
 	// Just atomically install the flexvolume binary every time we start up.
 	// This way we'll always handle upgrades.
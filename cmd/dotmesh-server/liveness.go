@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/net/context"
@@ -50,8 +51,19 @@ func (state *InMemoryState) runLivenessServer() {
 			return
 		}
 
+		// Check our certificates haven't expired, which will stop us talking
+		// to etcd, and warn if they're about to
+		warnings, err := checkCertificates(time.Now())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Certificate error: %v\n", err), http.StatusInternalServerError)
+			return
+		}
+
 		// Nothing failed, so let's return OK with the default 200 status code.
 		fmt.Fprintf(w, "OK")
+		for _, warning := range warnings {
+			fmt.Fprintf(w, "\nWARNING: %s", warning)
+		}
 	},
 	)
 
@@ -15,13 +15,18 @@ import (
 	"sync"
 	"time"
 
+	"github.com/dotmesh-io/dotmesh/pkg/changerequests"
+	"github.com/dotmesh-io/dotmesh/pkg/hooks"
 	"github.com/dotmesh-io/dotmesh/pkg/kv"
 	"github.com/dotmesh-io/dotmesh/pkg/messaging/nats"
+	"github.com/dotmesh-io/dotmesh/pkg/notification/webhook"
+	"github.com/dotmesh-io/dotmesh/pkg/quota"
+	"github.com/dotmesh-io/dotmesh/pkg/tracing"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/user"
 
 	// registering metric counters
-	_ "github.com/dotmesh-io/dotmesh/pkg/metrics"
+	"github.com/dotmesh-io/dotmesh/pkg/metrics"
 
 	// notification provider
 	_ "github.com/dotmesh-io/dotmesh/pkg/notification/nats"
@@ -84,12 +89,35 @@ func main() {
 		os.Exit(1)
 	}
 
+	MASTER_FAILOVER_GRACE_PERIOD_STRING := os.Getenv("MASTER_FAILOVER_GRACE_PERIOD")
+
+	if len(MASTER_FAILOVER_GRACE_PERIOD_STRING) == 0 {
+		MASTER_FAILOVER_GRACE_PERIOD_STRING = "0"
+	}
+
+	MASTER_FAILOVER_GRACE_PERIOD_INT, err := strconv.ParseInt(MASTER_FAILOVER_GRACE_PERIOD_STRING, 10, 64)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	err = checkFailoverGracePeriod(time.Duration(MASTER_FAILOVER_GRACE_PERIOD_INT) * time.Second)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	// TODO: remove the different domains concept and have a proxy to services
 	config = Config{
 		FilesystemMetadataTimeout: FILESYSTEM_METADATA_TIMEOUT_INT,
+		MasterFailoverGracePeriod: time.Duration(MASTER_FAILOVER_GRACE_PERIOD_INT) * time.Second,
+		MasterFailoverForce:       os.Getenv("MASTER_FAILOVER_FORCE") != "",
 		NatsConfig:                nats.DefaultConfig(),
 	}
 
+	if os.Getenv("METRICS_PER_DOT") != "" {
+		metrics.EnablePerDot()
+	}
+
 	POOL = os.Getenv("POOL")
 	if POOL == "" {
 		fmt.Printf("Environment variable POOL must be set\n")
@@ -102,7 +130,15 @@ func main() {
 	}
 
 	traceAddr := os.Getenv("TRACE_ADDR")
-	if traceAddr != "" {
+	otlpEndpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if otlpEndpoint != "" {
+		tracer, err := tracing.NewTracer(tracing.NewOTLPRecorder(otlpEndpoint, "dotmesh"))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opentracing.InitGlobalTracer(tracer)
+	} else if traceAddr != "" {
 		collector, err := zipkin.NewHTTPCollector(
 			fmt.Sprintf("http://%s:9411/api/v1/spans", traceAddr),
 		)
@@ -110,10 +146,8 @@ func main() {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-		tracer, err := zipkin.NewTracer(
+		tracer, err := tracing.NewTracer(
 			zipkin.NewRecorder(collector, false, "127.0.0.1:0", "dotmesh"),
-			zipkin.ClientServerSameSpan(true),
-			zipkin.TraceID128Bit(true),
 		)
 		if err != nil {
 			fmt.Println(err)
@@ -156,12 +190,26 @@ func main() {
 	}
 	config.EtcdClient = etcdClient
 
+	config.TLSConfig, err = loadTLSConfig()
+	if err != nil {
+		log.Fatalf("Unable to set up TLS: '%s'", err)
+	}
+
 	config.ZFSExecPath = ZFS
 	config.ZPoolPath = ZPOOL
 	config.PoolName = POOL
 
 	kvClient := kv.New(etcdClient, ETCD_PREFIX)
 	config.UserManager = user.New(kvClient)
+	config.QuotaManager = quota.New(kvClient)
+	config.WebhookManager = webhook.New(kvClient)
+	config.WebhookAllowedNetworks, err = webhook.ParseNetworks(os.Getenv("WEBHOOK_ALLOWED_NETWORKS"))
+	if err != nil {
+		log.Fatalf("Invalid WEBHOOK_ALLOWED_NETWORKS: %s", err)
+	}
+	hooks.SetAllowedNetworks(config.WebhookAllowedNetworks)
+	config.HookManager = hooks.New(kvClient)
+	config.ChangeRequestManager = changerequests.New(kvClient)
 
 	s := NewInMemoryState(config)
 
@@ -204,6 +252,28 @@ func main() {
 	go runForever(s.zfs.ReportZpoolCapacity, "reportZPoolUsageReporter",
 		10*time.Minute, 10*time.Minute,
 	)
+	// kick off reporting when our certificates expire
+	go runForever(s.reportCertificateExpiry, "reportCertificateExpiry",
+		10*time.Minute, 1*time.Hour,
+	)
+	// kick off failing over the masters of dead nodes, and fencing this one
+	// off if it can't reach etcd
+	go runForever(s.failoverDeadMasters, "failoverDeadMasters",
+		FAILOVER_INTERVAL, FAILOVER_INTERVAL,
+	)
+	// kick off unmounting commits that nothing's using any more
+	err = s.loadCommitMounts()
+	if err != nil {
+		log.WithError(err).Error("Unable to load commit mounts from before restart")
+	}
+	go runForever(s.reapCommitMounts, "reapCommitMounts",
+		30*time.Second, 30*time.Second,
+	)
+	// kick off keeping the refquotas of our filesystems in step with their
+	// quotas
+	go runForever(s.enforceQuotas, "enforceQuotas",
+		10*time.Second, 10*time.Second,
+	)
 	// kick off watching etcd
 	go runForever(s.fetchAndWatchEtcd, "fetchAndWatchEtcd",
 		1*time.Second, 1*time.Second,
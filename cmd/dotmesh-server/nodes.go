@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/dotmesh-io/dotmesh/pkg/registry"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How long to wait for a handoff, which has to wait for the target to
+// replicate the master's latest commit
+const MOVE_BRANCH_MASTER_TIMEOUT = 5 * time.Minute
+
+// nodeStatus - the masters and running transfers on a node, so it can be
+// emptied out before being taken out of service
+func (s *InMemoryState) nodeStatus(node string) types.NodeStatus {
+	status := types.NodeStatus{
+		Id:               node,
+		Masters:          []string{},
+		RunningTransfers: []string{},
+		Cordoned:         s.isCordoned(node),
+	}
+	for fsId := range s.registry.ListMasterNodes(&registry.ListMasterNodesQuery{NodeID: node}) {
+		status.Masters = append(status.Masters, fsId)
+	}
+	sort.Strings(status.Masters)
+
+	s.interclusterTransfersLock.RLock()
+	defer s.interclusterTransfersLock.RUnlock()
+	for transferId, transfer := range s.interclusterTransfers {
+		if transfer.InitiatorNodeId != node {
+			continue
+		}
+		if transfer.Status == "finished" || transfer.Status == "error" {
+			continue
+		}
+		status.RunningTransfers = append(status.RunningTransfers, transferId)
+	}
+	sort.Strings(status.RunningTransfers)
+	return status
+}
+
+// liveNodes - the nodes that have told etcd their addresses recently, which
+// they do every 30 seconds while they're up
+func (s *InMemoryState) liveNodes() []string {
+	s.serverAddressesCacheLock.RLock()
+	defer s.serverAddressesCacheLock.RUnlock()
+	nodes := []string{}
+	for server, addresses := range s.serverAddressesCache {
+		if addresses != "" {
+			nodes = append(nodes, server)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func (s *InMemoryState) isCordoned(node string) bool {
+	s.cordonedNodesLock.RLock()
+	defer s.cordonedNodesLock.RUnlock()
+	_, ok := s.cordonedNodes[node]
+	return ok
+}
+
+// cordonNode - stops the node procuring dots, or having masters moved to
+// it, until it's uncordoned
+func (s *InMemoryState) cordonNode(node string) error {
+	_, err := s.etcdClient.Set(
+		context.Background(),
+		fmt.Sprintf("%s/servers/cordoned/%s", ETCD_PREFIX, node),
+		time.Now().UTC().Format(time.RFC3339),
+		&client.SetOptions{},
+	)
+	if err != nil {
+		return err
+	}
+	// don't wait for the watch, so anything after this sees it
+	s.cordonedNodesLock.Lock()
+	defer s.cordonedNodesLock.Unlock()
+	s.cordonedNodes[node] = time.Now().UTC().Format(time.RFC3339)
+	return nil
+}
+
+func (s *InMemoryState) uncordonNode(node string) error {
+	_, err := s.etcdClient.Delete(
+		context.Background(),
+		fmt.Sprintf("%s/servers/cordoned/%s", ETCD_PREFIX, node),
+		&client.DeleteOptions{},
+	)
+	if err != nil && !client.IsKeyNotFound(err) {
+		return err
+	}
+	s.cordonedNodesLock.Lock()
+	defer s.cordonedNodesLock.Unlock()
+	delete(s.cordonedNodes, node)
+	return nil
+}
+
+// mostUpToDateNode - whichever of the candidates is missing the fewest of the
+// filesystem's commits, and how many it's missing. Cordoned nodes aren't
+// considered, and nor are nodes that aren't up if no candidates are given.
+func (s *InMemoryState) mostUpToDateNode(filesystemId, exclude string, candidates []string) (string, int) {
+	if len(candidates) == 0 {
+		candidates = s.liveNodes()
+	}
+	return mostUpToDate(s.GetReplicationLatency(filesystemId), candidates, func(candidate string) bool {
+		return candidate == exclude || s.isCordoned(candidate)
+	})
+}
+
+// mostUpToDate - whichever of the candidates not skipped is missing the
+// fewest commits, given the commits each node is missing, and how many it's
+// missing
+func mostUpToDate(missing map[string][]string, candidates []string, skip func(string) bool) (string, int) {
+	best, bestMissing := "", 0
+	for _, candidate := range candidates {
+		if skip(candidate) {
+			continue
+		}
+		commits, ok := missing[candidate]
+		if !ok {
+			// no record of any snapshots there, so it doesn't have a copy
+			continue
+		}
+		if best == "" || len(commits) < bestMissing {
+			best, bestMissing = candidate, len(commits)
+		}
+	}
+	return best, bestMissing
+}
+
+// moveBranchMaster - hands the filesystem off to the most up to date of the
+// candidates with a "move" request to its current master. If force is set
+// and the handoff fails for any reason other than the branch being in use,
+// but the target already has every commit and there's nothing uncommitted on
+// the master, the master is forced over instead.
+func (s *InMemoryState) moveBranchMaster(ctx context.Context, filesystemId string, candidates []string, force bool) (string, error) {
+	master, err := s.registry.CurrentMasterNode(filesystemId)
+	if err != nil {
+		return "", err
+	}
+	target, missing := s.mostUpToDateNode(filesystemId, master, candidates)
+	if target == "" {
+		return "", fmt.Errorf("No node to move %s to has a copy of it", filesystemId)
+	}
+
+	responseChan, err := s.globalFsRequest(
+		ctx,
+		filesystemId,
+		&Event{
+			Name: "move",
+			Args: &EventArgs{"target": target},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	var moveErr error
+	select {
+	case <-time.After(MOVE_BRANCH_MASTER_TIMEOUT):
+		// something needs to read the response from the response chan
+		go func() { <-responseChan }()
+		moveErr = fmt.Errorf("timed out moving %s from %s to %s", filesystemId, master, target)
+	case e := <-responseChan:
+		if e.Name == "moved" {
+			log.Infof("[moveBranchMaster] moved %s from %s to %s", filesystemId, master, target)
+			return target, nil
+		}
+		moveErr = maybeError(e, "moved")
+		if e.Name == "cannot-move-while-containers-running" {
+			return "", moveErr
+		}
+	}
+	if !force {
+		return "", moveErr
+	}
+
+	s.globalDirtyCacheLock.RLock()
+	dirty := s.globalDirtyCache[filesystemId].DirtyBytes
+	s.globalDirtyCacheLock.RUnlock()
+	if missing == 0 && dirty == 0 {
+		log.Warnf("[moveBranchMaster] handoff of %s to %s failed (%s), but it has every commit, so forcing the master over", filesystemId, target, moveErr)
+		err = forceBranchMaster(filesystemId, target, master)
+		if err != nil {
+			return "", err
+		}
+		return target, nil
+	}
+	return "", moveErr
+}
+
+// forceBranchMaster - sets the master of a filesystem in etcd, without
+// moving any data. If prevMaster is set, only if the master hasn't changed
+// from it.
+func forceBranchMaster(filesystemId, newMaster, prevMaster string) error {
+	kapi, err := getEtcdKeysApi()
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/filesystems/masters/%s", ETCD_PREFIX, filesystemId)
+	log.Printf("[forceBranchMaster] setting %s to %s", key, newMaster)
+	_, err = kapi.Set(
+		context.Background(),
+		key,
+		newMaster,
+		&client.SetOptions{PrevValue: prevMaster},
+	)
+	return err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestMoveBranchMasterOnlyForcesWhenAsked(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	s := c.rpc.state
+	s.cordonedNodes = map[string]string{}
+	s.cordonedNodesLock = &sync.RWMutex{}
+	// node2 has every commit, node3 is missing one
+	s.filesystems[testDotId] = &fakeFSM{snapshots: map[string][]*types.Snapshot{
+		testNodeId: {{Id: "c1"}, {Id: "c2"}},
+		"node2":    {{Id: "c1"}, {Id: "c2"}},
+		"node3":    {{Id: "c1"}},
+	}}
+
+	// forcing a master over sets it in etcd
+	etcdConnectionOnce.Do(func() {})
+	defer func(kapi kv.Store) { etcdKeysAPI = kapi }(etcdKeysAPI)
+	etcdKeysAPI = c.etcdClient
+	masterKey := fmt.Sprintf("%s/filesystems/masters/%s", ETCD_PREFIX, testDotId)
+	master := func() string {
+		resp, err := c.etcdClient.Get(context.Background(), masterKey, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Node.Value
+	}
+
+	tests := []struct {
+		name       string
+		response   string
+		candidates []string
+		dirty      int64
+		force      bool
+		want       string
+	}{
+		{"handed off", "moved", []string{"node2"}, 0, false, "node2"},
+		{"handoff failed", "move-failed", []string{"node2"}, 0, false, ""},
+		{"handoff failed, forced", "move-failed", []string{"node2"}, 0, true, "node2"},
+		{"in use, forced", "cannot-move-while-containers-running", []string{"node2"}, 0, true, ""},
+		{"uncommitted changes, forced", "move-failed", []string{"node2"}, 10, true, ""},
+		{"missing commits, forced", "move-failed", []string{"node3"}, 0, true, ""},
+	}
+	for _, tt := range tests {
+		c.registry.SetMasterNode(testDotId, testNodeId)
+		_, err := c.etcdClient.Set(context.Background(), masterKey, testNodeId, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.globalDirtyCache[testDotId] = dirtyInfo{DirtyBytes: tt.dirty}
+		response := tt.response
+		s.messenger = newFakeMessenger(func(e *types.Event) *types.Event {
+			return &types.Event{Name: response, Args: &types.EventArgs{"err": fmt.Errorf("%s", response)}}
+		})
+
+		target, err := s.moveBranchMaster(context.Background(), testDotId, tt.candidates, tt.force)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got moved to %s", tt.name, target)
+			}
+			if master() != testNodeId {
+				t.Errorf("%s: expected the master not to be forced over, got %s", tt.name, master())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if target != tt.want {
+			t.Errorf("%s: expected to move to %s, got %s", tt.name, tt.want, target)
+		}
+		forced := tt.response != "moved"
+		if forced != (master() == tt.want) {
+			t.Errorf("%s: expected forced=%t, got master %s in etcd", tt.name, forced, master())
+		}
+	}
+}
+
+func TestCordonedNodes(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	s := c.rpc.state
+	s.etcdClient = c.etcdClient
+	s.cordonedNodes = map[string]string{}
+	s.cordonedNodesLock = &sync.RWMutex{}
+	s.interclusterTransfers = map[string]TransferPollResult{}
+	s.interclusterTransfersLock = &sync.RWMutex{}
+	s.filesystems[testDotId] = &fakeFSM{snapshots: map[string][]*types.Snapshot{
+		testNodeId: {{Id: "c1"}},
+		"node2":    {{Id: "c1"}},
+		"node3":    {},
+	}}
+
+	err := s.cordonNode("node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.nodeStatus("node2").Cordoned {
+		t.Error("expected node2 to be cordoned")
+	}
+	// masters aren't moved to cordoned nodes, even if they're the most up
+	// to date
+	target, missing := s.mostUpToDateNode(testDotId, testNodeId, []string{"node2", "node3"})
+	if target != "node3" || missing != 1 {
+		t.Errorf("expected node3 missing 1 commit, got %q missing %d", target, missing)
+	}
+
+	// and they don't procure dots
+	err = s.cordonNode(testNodeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.procureFilesystem(context.Background(), VolumeName{Namespace: "alice", Name: "db"})
+	if err == nil || !strings.Contains(err.Error(), "drained") {
+		t.Errorf("expected a cordoned node to refuse to procure a dot, got: %v", err)
+	}
+
+	err = s.uncordonNode("node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ = s.mostUpToDateNode(testDotId, testNodeId, []string{"node2", "node3"})
+	if target != "node2" {
+		t.Errorf("expected node2 once it's uncordoned, got %q", target)
+	}
+	// uncordoning a node that isn't cordoned is fine
+	err = s.uncordonNode("node2")
+	if err != nil {
+		t.Errorf("unexpected error uncordoning node2 again: %s", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// publishEvent - sends an event about a dot through the notification
+// publishers and to clients streaming activity, in the background
+func (s *InMemoryState) publishEvent(tlf types.TopLevelFilesystem, event *types.EventNotification) {
+	s.publishActivity(tlf, event)
+	go func() {
+		err := s.publisher.PublishEvent(event)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":         err,
+				"event":         event.Type,
+				"filesystem_id": event.FilesystemId,
+			}).Error("[publishEvent] failed to publish")
+		}
+	}()
+}
+
+// publishActivity - sends an event about a dot to clients streaming
+// activity, on whichever node they're connected to, filling in the dot's name
+// and who's allowed to see it from its registry entry
+func (s *InMemoryState) publishActivity(tlf types.TopLevelFilesystem, event *types.EventNotification) {
+	event.Namespace = tlf.MasterBranch.Name.Namespace
+	event.Name = tlf.MasterBranch.Name.Name
+	event.OwnerID = tlf.Owner.Id
+	event.CollaboratorIDs = make([]string, len(tlf.Collaborators))
+	for idx, u := range tlf.Collaborators {
+		event.CollaboratorIDs[idx] = u.Id
+	}
+	if s.messenger == nil {
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.WithError(err).Error("[publishActivity] failed to generate event ID")
+		return
+	}
+	err = s.messenger.Publish(&types.Event{
+		ID:           id.String(),
+		Name:         event.Type,
+		FilesystemID: tlf.MasterBranch.Id,
+		Type:         types.EventTypeActivity,
+		Args:         &types.EventArgs{"Event": event},
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":         err,
+			"event":         event.Type,
+			"filesystem_id": event.FilesystemId,
+		}).Error("[publishActivity] failed to publish")
+	}
+}
+
+// publishTransferUpdate - called as transfers are updated in etcd, streams
+// their progress and sends a notification when one finishes. Every node sees
+// the update, so only the node that initiated the transfer sends them.
+func (s *InMemoryState) publishTransferUpdate(previous *TransferPollResult, transfer *TransferPollResult) {
+	if transfer.InitiatorNodeId != s.NodeID() {
+		return
+	}
+	tlf, branch, err := s.registry.LookupFilesystemById(transfer.FilesystemId)
+	if err != nil {
+		// pulls of new dots are registered as they complete, so fall back
+		// to the names the transfer was asked for
+		tlf = types.TopLevelFilesystem{
+			MasterBranch: types.DotmeshVolume{
+				Id:   transfer.FilesystemId,
+				Name: types.VolumeName{Namespace: transfer.LocalNamespace, Name: transfer.LocalName},
+			},
+		}
+		branch = transfer.LocalBranchName
+	}
+	event := func(eventType string) *types.EventNotification {
+		return &types.EventNotification{
+			Type:         eventType,
+			FilesystemId: transfer.FilesystemId,
+			Branch:       branch,
+			CommitId:     transfer.TargetCommit,
+			Transfer: &types.TransferNotification{
+				TransferRequestId:  transfer.TransferRequestId,
+				Direction:          transfer.Direction,
+				Peer:               transfer.Peer,
+				RemoteNamespace:    transfer.RemoteNamespace,
+				RemoteName:         transfer.RemoteName,
+				RemoteBranchName:   transfer.RemoteBranchName,
+				TargetCommit:       transfer.TargetCommit,
+				Message:            transfer.Message,
+				Index:              transfer.Index,
+				Total:              transfer.Total,
+				Status:             transfer.Status,
+				NanosecondsElapsed: transfer.NanosecondsElapsed,
+				Size:               transfer.Size,
+				Sent:               transfer.Sent,
+			},
+		}
+	}
+
+	s.publishActivity(tlf, event(types.EventTypeTransferProgress))
+	if transfer.Status == "finished" && (previous == nil || previous.Status != "finished") {
+		s.publishEvent(tlf, event(types.EventTypeTransferFinished))
+	}
+}
+
+// publishStateChange - called as the state of filesystems on this node
+// changes, streams changes to the states of the dots mastered here
+func (s *InMemoryState) publishStateChange(filesystemId, state, status string) {
+	master, err := s.registry.CurrentMasterNode(filesystemId)
+	if err != nil || master != s.NodeID() {
+		return
+	}
+	tlf, branch, err := s.registry.LookupFilesystemById(filesystemId)
+	if err != nil {
+		return
+	}
+	s.publishActivity(tlf, &types.EventNotification{
+		Type:         types.EventTypeStateChanged,
+		FilesystemId: filesystemId,
+		Branch:       branch,
+		State:        state,
+		Status:       status,
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/dotmesh-io/dotmesh/pkg/metrics"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// How long before a certificate expires to start warning about it in /check
+const CERTIFICATE_EXPIRY_WARNING = 30 * 24 * time.Hour
+
+// the CA and server certificates we talk to etcd and serve TLS with, as
+// made by 'dm cluster init' and rotated by 'dm cluster rotate-certs', and
+// the certificate given with 'dm cluster init --tls-cert'
+var pkiCertificateFiles = []string{"ca.pem", "apiserver.pem", "tls.pem"}
+
+func getPkiPath() string {
+	pkiPath := os.Getenv("DOTMESH_PKI_PATH")
+	if pkiPath == "" {
+		pkiPath = "/pki"
+	}
+	return pkiPath
+}
+
+type certificateExpiry struct {
+	File     string
+	Subject  string
+	NotAfter time.Time
+}
+
+// certificateExpiries - when each certificate in the PKI directory expires,
+// or nothing if there isn't one (when etcd isn't spoken to over TLS)
+func certificateExpiries(pkiPath string) ([]certificateExpiry, error) {
+	result := []certificateExpiry{}
+	for _, file := range pkiCertificateFiles {
+		data, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", pkiPath, file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse %s: %s", file, err)
+			}
+			result = append(result, certificateExpiry{
+				File:     file,
+				Subject:  cert.Subject.CommonName,
+				NotAfter: cert.NotAfter,
+			})
+		}
+	}
+	return result, nil
+}
+
+// checkCertificates - an error if any certificate has expired, otherwise
+// warnings about those that will soon
+func checkCertificates(now time.Time) ([]string, error) {
+	expiries, err := certificateExpiries(getPkiPath())
+	if err != nil {
+		return nil, err
+	}
+	warnings := []string{}
+	for _, e := range expiries {
+		if now.After(e.NotAfter) {
+			return nil, fmt.Errorf(
+				"Certificate %s in %s expired at %s, run 'dm cluster rotate-certs'",
+				e.Subject, e.File, e.NotAfter.Format(time.RFC3339),
+			)
+		}
+		if e.NotAfter.Sub(now) < CERTIFICATE_EXPIRY_WARNING {
+			warnings = append(warnings, fmt.Sprintf(
+				"Certificate %s in %s expires at %s, run 'dm cluster rotate-certs'",
+				e.Subject, e.File, e.NotAfter.Format(time.RFC3339),
+			))
+		}
+	}
+	return warnings, nil
+}
+
+func (s *InMemoryState) reportCertificateExpiry() error {
+	expiries, err := certificateExpiries(getPkiPath())
+	if err != nil {
+		return err
+	}
+	metrics.CertificateExpiry.Reset()
+	for _, e := range expiries {
+		metrics.CertificateExpiry.WithLabelValues(e.File, e.Subject).Set(float64(e.NotAfter.Unix()))
+	}
+	return nil
+}
+
+// publishCA - stores a new CA, key and all, in etcd for the other nodes to
+// trust, until unpublishCA deletes it once the rotation has finished
+func (s *InMemoryState) publishCA(ca types.ClusterCA) error {
+	serialized, err := json.Marshal(ca)
+	if err != nil {
+		return err
+	}
+	_, err = s.etcdClient.Set(
+		context.Background(),
+		fmt.Sprintf("%s/pki/ca", ETCD_PREFIX),
+		string(serialized),
+		&client.SetOptions{},
+	)
+	return err
+}
+
+func (s *InMemoryState) publishedCA() (types.ClusterCA, error) {
+	var ca types.ClusterCA
+	resp, err := s.etcdClient.Get(
+		context.Background(),
+		fmt.Sprintf("%s/pki/ca", ETCD_PREFIX),
+		nil,
+	)
+	if client.IsKeyNotFound(err) {
+		return ca, fmt.Errorf("No new CA has been published, run 'dm cluster rotate-certs --new-ca' on one node first")
+	}
+	if err != nil {
+		return ca, err
+	}
+	err = json.Unmarshal([]byte(resp.Node.Value), &ca)
+	return ca, err
+}
+
+func (s *InMemoryState) unpublishCA() error {
+	_, err := s.etcdClient.Delete(
+		context.Background(),
+		fmt.Sprintf("%s/pki/ca", ETCD_PREFIX),
+		&client.DeleteOptions{},
+	)
+	if client.IsKeyNotFound(err) {
+		// another node finished the rotation first
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate - writes a self-signed certificate for subject, expiring
+// at notAfter, to file in pkiPath
+func writeCertificate(t *testing.T, pkiPath, file, subject string, notAfter time.Time) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: subject},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(
+		filepath.Join(pkiPath, file),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckCertificates(t *testing.T) {
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		notAfter     time.Time
+		wantWarnings int
+		wantErr      bool
+	}{
+		{"valid for a year", now.Add(365 * 24 * time.Hour), 0, false},
+		{"expires within 30 days", now.Add(10 * 24 * time.Hour), 1, false},
+		{"expired", now.Add(-time.Hour), 0, true},
+	}
+	for _, tt := range tests {
+		func() {
+			pkiPath, err := ioutil.TempDir("", "dotmesh-pki-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(pkiPath)
+			oldPkiPath := os.Getenv("DOTMESH_PKI_PATH")
+			os.Setenv("DOTMESH_PKI_PATH", pkiPath)
+			defer os.Setenv("DOTMESH_PKI_PATH", oldPkiPath)
+
+			writeCertificate(t, pkiPath, "apiserver.pem", "apiserver", tt.notAfter)
+			warnings, err := checkCertificates(now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("%s: expected error=%t, got: %v", tt.name, tt.wantErr, err)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("%s: expected %d warnings, got: %v", tt.name, tt.wantWarnings, warnings)
+			}
+		}()
+	}
+}
+
+func TestCertificateExpiriesWithoutPKI(t *testing.T) {
+	pkiPath, err := ioutil.TempDir("", "dotmesh-pki-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pkiPath)
+
+	// etcd isn't spoken to over TLS, so there are no certificates
+	expiries, err := certificateExpiries(pkiPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expiries) != 0 {
+		t.Errorf("expected no certificates, got: %v", expiries)
+	}
+}
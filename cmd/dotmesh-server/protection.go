@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// checkReceivedCommits - rejects a push before it's received if any of the
+// commits it brings are missing metadata the branch being pushed to requires.
+// The prelude lists all the commits up to the one being pushed, so only the
+// ones this node doesn't have yet are new.
+func (s *InMemoryState) checkReceivedCommits(filesystemId string, prelude types.Prelude) error {
+	tlf, branch, err := s.registry.LookupFilesystemById(filesystemId)
+	if err != nil {
+		// not registered (yet), e.g. the first push of a new dot, which has
+		// no protection rules
+		return nil
+	}
+	if len(tlf.Protection(branch).RequiredMetadata) == 0 {
+		return nil
+	}
+	existing, err := s.SnapshotsFor(s.NodeID(), filesystemId)
+	if err != nil {
+		return err
+	}
+	return tlf.CheckNewCommits(branch, existing, prelude.SnapshotProperties)
+}
+
+// checkFastForward - rejects a fast-forward of filesystemId up to commitId
+// (empty for the latest commit) of fromFilesystemId before it's made, if any
+// of the commits it would bring are missing metadata the branch requires
+func (s *InMemoryState) checkFastForward(filesystemId, fromFilesystemId, commitId string) error {
+	tlf, branch, err := s.registry.LookupFilesystemById(filesystemId)
+	if err != nil {
+		return err
+	}
+	if len(tlf.Protection(branch).RequiredMetadata) == 0 {
+		return nil
+	}
+	existing, err := s.SnapshotsForCurrentMaster(filesystemId)
+	if err != nil {
+		return err
+	}
+	from, err := s.SnapshotsForCurrentMaster(fromFilesystemId)
+	if err != nil {
+		return err
+	}
+	return tlf.CheckNewCommits(branch, existing, commitsUpTo(from, commitId))
+}
+
+// commitsUpTo - the commits up to and including commitId, or all of them if
+// it's empty or not among them
+func commitsUpTo(snapshots []types.Snapshot, commitId string) []*types.Snapshot {
+	result := []*types.Snapshot{}
+	for i := range snapshots {
+		result = append(result, &snapshots[i])
+		if snapshots[i].Id == commitId {
+			break
+		}
+	}
+	return result
+}
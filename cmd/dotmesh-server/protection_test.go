@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/dotmesh-io/dotmesh/pkg/user"
+)
+
+// requireTicket - has alice/db's master branch require the ticket metadata
+func requireTicket(t *testing.T, c *testCluster) {
+	err := c.registerDot(VolumeName{Namespace: "alice", Name: "db"}, types.RegistryFilesystem{
+		Id:              testDotId,
+		OwnerId:         c.alice.Id,
+		CollaboratorIds: []string{c.bob.Id},
+		Protections: map[string]types.BranchProtection{
+			"master": {RequiredMetadata: []string{"ticket"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to protect alice/db: %s", err)
+	}
+}
+
+func TestCheckReceivedCommits(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	requireTicket(t, c)
+	c.setCommits(testDotId, &types.Snapshot{Id: "c1", Metadata: types.Metadata{}})
+
+	tests := []struct {
+		name         string
+		filesystemId string
+		prelude      []*types.Snapshot
+		allowed      bool
+	}{
+		{"new commits with the metadata", testDotId, []*types.Snapshot{
+			{Id: "c1"}, {Id: "c2", Metadata: types.Metadata{"ticket": "DM-2"}},
+		}, true},
+		{"a new commit without it", testDotId, []*types.Snapshot{
+			{Id: "c1"}, {Id: "c2", Metadata: types.Metadata{"ticket": "DM-2"}}, {Id: "c3", Metadata: types.Metadata{}},
+		}, false},
+		{"the first push of a new dot", "new-id", []*types.Snapshot{{Id: "c1"}}, true},
+	}
+	for _, tt := range tests {
+		err := c.rpc.state.checkReceivedCommits(tt.filesystemId, types.Prelude{SnapshotProperties: tt.prelude})
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got error: %v", tt.name, tt.allowed, err)
+		}
+	}
+}
+
+func TestCheckFastForward(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	requireTicket(t, c)
+	c.setCommits(testDotId, &types.Snapshot{Id: "c1", Metadata: types.Metadata{}})
+	c.setCommits(testForkId,
+		&types.Snapshot{Id: "c1", Metadata: types.Metadata{}},
+		&types.Snapshot{Id: "c2", Metadata: types.Metadata{"ticket": "DM-2"}},
+		&types.Snapshot{Id: "c3", Metadata: types.Metadata{}},
+	)
+
+	tests := []struct {
+		name     string
+		commitId string
+		allowed  bool
+	}{
+		{"up to a commit with the metadata", "c2", true},
+		{"up to the latest commit, which hasn't got it", "", false},
+		{"up to that commit", "c3", false},
+	}
+	for _, tt := range tests {
+		err := c.rpc.state.checkFastForward(testDotId, testForkId, tt.commitId)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got error: %v", tt.name, tt.allowed, err)
+		}
+	}
+
+	// a fork's master branch has no rules of its own here
+	err := c.rpc.state.checkFastForward(testForkId, testDotId, "")
+	if err != nil {
+		t.Errorf("expected syncing the fork to be allowed, got: %v", err)
+	}
+}
+
+func TestSetBranchProtectionAuthorization(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+
+	tests := []struct {
+		name     string
+		as       *user.User
+		required []string
+		allowed  bool
+	}{
+		{"the dot's owner", c.alice, []string{"ticket"}, true},
+		{"the cluster's admin", &user.User{Id: ADMIN_USER_UUID, Name: "admin"}, []string{"ticket"}, true},
+		{"a collaborator", c.bob, []string{"ticket"}, false},
+		{"someone who can't see the dot", c.carol, []string{"ticket"}, false},
+		{"the owner, with a capitalised metadata key", c.alice, []string{"Ticket"}, false},
+	}
+	for _, tt := range tests {
+		args := &struct {
+			Namespace  string
+			Name       string
+			Branch     string
+			Protection types.BranchProtection
+		}{"alice", "db", "", types.BranchProtection{RequiredMetadata: tt.required}}
+		var ok bool
+		err := c.rpc.SetBranchProtection(requestAsUser(tt.as), args, &ok)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got error: %v", tt.name, tt.allowed, err)
+		}
+	}
+}
+
+func TestCommitsUpTo(t *testing.T) {
+	snapshots := []types.Snapshot{{Id: "c1"}, {Id: "c2"}, {Id: "c3"}}
+	tests := []struct {
+		commitId string
+		want     []string
+	}{
+		{"c2", []string{"c1", "c2"}},
+		{"c3", []string{"c1", "c2", "c3"}},
+		{"", []string{"c1", "c2", "c3"}},
+		{"elsewhere", []string{"c1", "c2", "c3"}},
+	}
+	for _, tt := range tests {
+		got := commitsUpTo(snapshots, tt.commitId)
+		ids := []string{}
+		for _, s := range got {
+			ids = append(ids, s.Id)
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.commitId, tt.want, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("%q: expected %v, got %v", tt.commitId, tt.want, ids)
+				break
+			}
+		}
+	}
+}
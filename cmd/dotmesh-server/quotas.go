@@ -0,0 +1,280 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/coreos/etcd/client"
+
+	"github.com/dotmesh-io/dotmesh/pkg/quota"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// usage figures come from the filesystems/dirty region of etcd, which every
+// master keeps up to date for its filesystems, so they're accurate to within
+// a polling interval on any node in the cluster.
+//
+// They're ZFS "used" figures, not "referenced" ones: a branch is a ZFS clone
+// of a commit on the branch it was made from, and only the data it's written
+// since (and its commits) counts towards its used; the data it shares with
+// that commit counts towards the branch that has the commit. So summing them
+// over a dot's branches counts each block once, however many branches share
+// it.
+func (s *InMemoryState) filesystemUsage(filesystemId string) int64 {
+	s.globalDirtyCacheLock.RLock()
+	defer s.globalDirtyCacheLock.RUnlock()
+	return s.globalDirtyCache[filesystemId].SizeBytes
+}
+
+// dotUsage - total space used by all the branches of a dot, each counting
+// only what it doesn't share with the branch it was made from
+func (s *InMemoryState) dotUsage(topLevelFilesystemId string) int64 {
+	total := s.filesystemUsage(topLevelFilesystemId)
+	for _, clone := range s.registry.ClonesFor(topLevelFilesystemId) {
+		total += s.filesystemUsage(clone.FilesystemId)
+	}
+	return total
+}
+
+// namespaceUsage - total size of all the dots in a namespace
+func (s *InMemoryState) namespaceUsage(namespace string) int64 {
+	var total int64
+	for _, tlf := range s.registry.DumpTopLevelFilesystems() {
+		if tlf.MasterBranch.Name.Namespace == namespace {
+			total += s.dotUsage(tlf.MasterBranch.Id)
+		}
+	}
+	return total
+}
+
+// checkNamespaceQuota - returns quota.ExceededError if adding
+// additionalBytes to the namespace would take it over its quota.
+func (s *InMemoryState) checkNamespaceQuota(namespace string, additionalBytes int64) error {
+	q, err := s.quotaManager.GetNamespaceQuota(namespace)
+	if err != nil {
+		return err
+	}
+	if q.Unlimited() {
+		return nil
+	}
+	used := s.namespaceUsage(namespace)
+	if q.Exceeded(used, additionalBytes) {
+		return quota.ExceededError{
+			Kind:       "namespace",
+			Name:       namespace,
+			LimitBytes: q.LimitBytes,
+			UsedBytes:  used,
+		}
+	}
+	return nil
+}
+
+// checkQuotas - returns quota.ExceededError if writing additionalBytes to
+// the given filesystem (which may be a branch) would take either its dot or
+// its namespace over quota. Pass zero additionalBytes when the size of the
+// write isn't known up front, which rejects writes only once the quota has
+// already been exceeded.
+func (s *InMemoryState) checkQuotas(filesystemId string, additionalBytes int64) error {
+	tlf, _, err := s.registry.LookupFilesystemById(filesystemId)
+	if err != nil {
+		// not registered (yet), e.g. the first push of a new dot, nothing
+		// to account it against
+		return nil
+	}
+	tlfId := tlf.MasterBranch.Id
+
+	q, err := s.quotaManager.GetFilesystemQuota(tlfId)
+	if err != nil {
+		return err
+	}
+	if !q.Unlimited() {
+		used := s.dotUsage(tlfId)
+		if q.Exceeded(used, additionalBytes) {
+			return quota.ExceededError{
+				Kind:       "dot",
+				Name:       tlf.MasterBranch.Name.String(),
+				LimitBytes: q.LimitBytes,
+				UsedBytes:  used,
+			}
+		}
+	}
+	return s.checkNamespaceQuota(tlf.MasterBranch.Name.Namespace, additionalBytes)
+}
+
+// CheckQuotas - checkQuotas, for the filesystem state machines to check
+// pulls with
+func (s *InMemoryState) CheckQuotas(filesystemId string, additionalBytes int64) error {
+	return s.checkQuotas(filesystemId, additionalBytes)
+}
+
+// applyFilesystemQuota - handles updates to the quotas/filesystems region of
+// etcd by enforcing the new quota on each branch of the dot that we hold a
+// copy of.
+func (s *InMemoryState) applyFilesystemQuota(node *client.Node) error {
+	// (0)/(1)dotmesh.io/(2)quotas/(3)filesystems/(4):filesystem = quota
+	pieces := strings.Split(node.Key, "/")
+	if len(pieces) < 5 {
+		return nil
+	}
+	topLevelFilesystemId := pieces[4]
+
+	filesystemIds := []string{topLevelFilesystemId}
+	for _, clone := range s.registry.ClonesFor(topLevelFilesystemId) {
+		filesystemIds = append(filesystemIds, clone.FilesystemId)
+	}
+	local := map[string]bool{}
+	for _, filesystemId := range s.localFilesystems() {
+		local[filesystemId] = true
+	}
+	for _, filesystemId := range filesystemIds {
+		// the filesystem may not exist on this node yet, in which case
+		// ensureFilesystemQuota enforces it once it does
+		if local[filesystemId] {
+			s.enforceQuota(filesystemId)
+		}
+	}
+	return nil
+}
+
+// ensureFilesystemQuota - enforces quotas on one of this node's filesystems,
+// if that hasn't been done since the server started. Called whenever the
+// node discovers its filesystems, so that ones that are created, cloned or
+// received here, and ones that were here before a restart (when the quota
+// may have changed), get it too.
+func (s *InMemoryState) ensureFilesystemQuota(filesystemId string) {
+	s.appliedQuotasLock.Lock()
+	_, ok := s.appliedQuotas[filesystemId]
+	s.appliedQuotasLock.Unlock()
+	if ok {
+		return
+	}
+	s.enforceQuota(filesystemId)
+}
+
+// enforceQuotas - keeps the refquotas of this node's filesystems in step with
+// how much of their dots' and namespaces' quotas are left, as data is written
+// to them and to the other branches and dots they share quotas with. Runs
+// forever.
+func (s *InMemoryState) enforceQuotas() error {
+	for _, filesystemId := range s.localFilesystems() {
+		s.enforceQuota(filesystemId)
+	}
+	return nil
+}
+
+// localFilesystems - the filesystems that exist on this node
+func (s *InMemoryState) localFilesystems() []string {
+	s.filesystemsLock.RLock()
+	defer s.filesystemsLock.RUnlock()
+	filesystemIds := []string{}
+	for filesystemId, fsm := range s.filesystems {
+		if _, ok := fsm.ListSnapshots()[s.NodeID()]; ok {
+			filesystemIds = append(filesystemIds, filesystemId)
+		}
+	}
+	return filesystemIds
+}
+
+// enforceQuota - sets the refquota of one of this node's filesystems to
+// refquotaFor it, if that's changed
+func (s *InMemoryState) enforceQuota(filesystemId string) {
+	limitBytes, err := s.refquotaFor(filesystemId)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":         err,
+			"filesystem_id": filesystemId,
+		}).Warn("quotas: failed to work out refquota")
+		return
+	}
+	s.appliedQuotasLock.Lock()
+	applied, ok := s.appliedQuotas[filesystemId]
+	s.appliedQuotasLock.Unlock()
+	if ok && applied == limitBytes {
+		return
+	}
+	s.setFilesystemQuota(filesystemId, limitBytes)
+}
+
+// refquotaFor - the ZFS refquota that stops writes to one of this node's
+// filesystems taking its dot or its namespace over quota: what it references
+// now plus whatever's left of the tighter of the two, so that a write that
+// would exceed them fails with EDQUOT.
+//
+// Only the master of a filesystem is written to, so that's the only copy
+// that's limited; replicas have no refquota (0), so that replication to
+// them never fails. Neither do filesystems with no quotas to enforce.
+func (s *InMemoryState) refquotaFor(filesystemId string) (int64, error) {
+	master, err := s.registry.CurrentMasterNode(filesystemId)
+	if err != nil || master != s.NodeID() {
+		return 0, nil
+	}
+	tlf, _, err := s.registry.LookupFilesystemById(filesystemId)
+	if err != nil {
+		// not registered yet, so there's no quota to enforce
+		return 0, nil
+	}
+	headroom, limited, err := s.quotaHeadroom(tlf)
+	if err != nil || !limited {
+		return 0, err
+	}
+	usage, _, err := s.zfs.SpaceUsage(filesystemId)
+	if err != nil {
+		return 0, err
+	}
+	return usage.Referenced + headroom, nil
+}
+
+// quotaHeadroom - how many more bytes can be written to a dot before it or
+// its namespace goes over quota, and whether either of them has one
+func (s *InMemoryState) quotaHeadroom(tlf types.TopLevelFilesystem) (int64, bool, error) {
+	var headroom int64
+	limited := false
+	q, err := s.quotaManager.GetFilesystemQuota(tlf.MasterBranch.Id)
+	if err != nil {
+		return 0, false, err
+	}
+	if !q.Unlimited() {
+		headroom = q.LimitBytes - s.dotUsage(tlf.MasterBranch.Id)
+		limited = true
+	}
+	namespace := tlf.MasterBranch.Name.Namespace
+	q, err = s.quotaManager.GetNamespaceQuota(namespace)
+	if err != nil {
+		return 0, false, err
+	}
+	if !q.Unlimited() {
+		left := q.LimitBytes - s.namespaceUsage(namespace)
+		if !limited || left < headroom {
+			headroom = left
+		}
+		limited = true
+	}
+	if headroom < 0 {
+		headroom = 0
+	}
+	return headroom, limited, nil
+}
+
+// setFilesystemQuota - sets a filesystem's refquota (0 for none), recording
+// it as set if that works. ZFS refuses a refquota below what the filesystem
+// references, which a write racing with us can cause, so failures are left
+// for enforceQuotas to retry.
+func (s *InMemoryState) setFilesystemQuota(filesystemId string, limitBytes int64) {
+	out, err := s.zfs.SetQuota(filesystemId, limitBytes)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":         err,
+			"output":        string(out),
+			"filesystem_id": filesystemId,
+			"limit_bytes":   limitBytes,
+		}).Warn("quotas: failed to set refquota")
+		s.appliedQuotasLock.Lock()
+		delete(s.appliedQuotas, filesystemId)
+		s.appliedQuotasLock.Unlock()
+		return
+	}
+	s.appliedQuotasLock.Lock()
+	s.appliedQuotas[filesystemId] = limitBytes
+	s.appliedQuotasLock.Unlock()
+}
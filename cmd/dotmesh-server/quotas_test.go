@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/quota"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestCheckQuotas(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	c.registry.UpdateCloneFromEtcd("feature", testDotId, types.Clone{FilesystemId: "feature-id"})
+	s := c.rpc.state
+	for filesystemId, size := range map[string]int64{testDotId: 60, "feature-id": 30, testForkId: 50} {
+		s.globalDirtyCache[filesystemId] = dirtyInfo{SizeBytes: size}
+	}
+	err := s.quotaManager.SetFilesystemQuota(testDotId, &quota.Quota{LimitBytes: 100})
+	if err != nil {
+		t.Fatalf("failed to set dot quota: %s", err)
+	}
+	err = s.quotaManager.SetNamespaceQuota("bob", &quota.Quota{LimitBytes: 40})
+	if err != nil {
+		t.Fatalf("failed to set namespace quota: %s", err)
+	}
+
+	tests := []struct {
+		name         string
+		filesystemId string
+		additional   int64
+		wantKind     string
+	}{
+		{"up to the dot's limit", testDotId, 10, ""},
+		{"over it, counting every branch", "feature-id", 11, "dot"},
+		{"a namespace already over its limit", testForkId, 0, "namespace"},
+		{"the first push of a new dot", "new-id", 1000, ""},
+	}
+	for _, tt := range tests {
+		err := s.checkQuotas(tt.filesystemId, tt.additional)
+		exceeded, ok := err.(quota.ExceededError)
+		if tt.wantKind == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got: %s", tt.name, err)
+			}
+			continue
+		}
+		if !ok || exceeded.Kind != tt.wantKind {
+			t.Errorf("%s: expected the %s quota to be exceeded, got: %v", tt.name, tt.wantKind, err)
+		}
+	}
+}
+
+// quotaZFS - a pool that records the refquotas set on it
+type quotaZFS struct {
+	fakeZFS
+	referenced map[string]int64
+	refquotas  map[string]int64
+	sets       int
+}
+
+func (z *quotaZFS) SpaceUsage(filesystemId string) (types.SpaceUsage, []types.CommitSpaceUsage, error) {
+	return types.SpaceUsage{Referenced: z.referenced[filesystemId]}, nil, nil
+}
+
+func (z *quotaZFS) SetQuota(filesystemId string, quotaBytes int64) ([]byte, error) {
+	z.refquotas[filesystemId] = quotaBytes
+	z.sets++
+	return nil, nil
+}
+
+func TestEnforceQuotas(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	c.registry.UpdateCloneFromEtcd("feature", testDotId, types.Clone{FilesystemId: "feature-id"})
+	s := c.rpc.state
+	s.appliedQuotas = map[string]int64{}
+	s.appliedQuotasLock = &sync.Mutex{}
+	pool := &quotaZFS{
+		fakeZFS:    fakeZFS{poolId: testNodeId},
+		referenced: map[string]int64{testDotId: 40, "feature-id": 20, testForkId: 50},
+		refquotas:  map[string]int64{},
+	}
+	s.zfs = pool
+	// every branch is here, but feature is mastered elsewhere
+	for filesystemId, master := range map[string]string{testDotId: testNodeId, "feature-id": "node2", testForkId: testNodeId} {
+		c.setCommits(filesystemId)
+		c.registry.SetMasterNode(filesystemId, master)
+	}
+	for filesystemId, size := range map[string]int64{testDotId: 60, "feature-id": 30, testForkId: 50} {
+		s.globalDirtyCache[filesystemId] = dirtyInfo{SizeBytes: size}
+	}
+	err := s.quotaManager.SetFilesystemQuota(testDotId, &quota.Quota{LimitBytes: 100})
+	if err != nil {
+		t.Fatalf("failed to set dot quota: %s", err)
+	}
+	err = s.quotaManager.SetNamespaceQuota("alice", &quota.Quota{LimitBytes: 95})
+	if err != nil {
+		t.Fatalf("failed to set namespace quota: %s", err)
+	}
+
+	check := func(name string, want map[string]int64) {
+		s.enforceQuotas()
+		for filesystemId, refquota := range want {
+			if got := pool.refquotas[filesystemId]; got != refquota {
+				t.Errorf("%s: expected %s's refquota to be %d, got %d", name, filesystemId, refquota, got)
+			}
+		}
+	}
+
+	// the master of the dot can write what's left of its namespace's quota,
+	// which is less than what's left of its own; replicas and dots without
+	// quotas aren't limited
+	check("under quota", map[string]int64{testDotId: 45, "feature-id": 0, testForkId: 0})
+
+	// nothing's changed, so nothing's set again
+	sets := pool.sets
+	s.enforceQuotas()
+	if pool.sets != sets {
+		t.Errorf("expected no refquotas to be set again, got %d", pool.sets-sets)
+	}
+
+	// writes to other branches use up the master's headroom
+	s.globalDirtyCache["feature-id"] = dirtyInfo{SizeBytes: 45}
+	check("over quota", map[string]int64{testDotId: 40})
+
+	// and once it's moved elsewhere, it's not limited here
+	c.registry.SetMasterNode(testDotId, "node2")
+	check("moved", map[string]int64{testDotId: 0})
+}
@@ -8,9 +8,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/quota"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/user"
 	"github.com/dotmesh-io/dotmesh/pkg/zfs"
 	"github.com/gorilla/mux"
@@ -90,7 +93,7 @@ func (z *ZFSSender) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		)
 
 		log.Printf("[ZFSSender:%s] Proxying pull from %s: %s", z.filesystem, masterNodeID, url)
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := dmclient.InternalHTTPClient().Do(req)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(fmt.Sprintf("Can't proxy pull from %s: %+v.\n", url, err)))
@@ -309,7 +312,7 @@ func (z *ZFSReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"admin",
 			admin.ApiKey,
 		)
-		postClient := new(http.Client)
+		postClient := dmclient.InternalHTTPClient()
 		log.Printf("[ZFSReceiver:%s] Proxying push to %s: %s", z.filesystem, masterNodeID, url)
 		resp, err := postClient.Do(req)
 		if err != nil {
@@ -340,6 +343,26 @@ func (z *ZFSReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// and is therefore blocking on us to tell it we've finished, one way or another, via
 	// z.state.notifyPushCompleted(z.filesystem, true/false) so we'd better do that in every path.
 
+	// pushes are streamed, so they say how big they'll be in a header
+	var pushSize int64
+	if r.ContentLength > 0 {
+		pushSize = r.ContentLength
+	} else if size, err := strconv.ParseInt(r.Header.Get(types.PushSizeHeader), 10, 64); err == nil && size > 0 {
+		pushSize = size
+	}
+	err = z.state.checkQuotas(z.filesystem, pushSize)
+	if err != nil {
+		log.Printf("[ZFSReceiver:%s] Rejecting push: %v", z.filesystem, err)
+		if _, ok := err.(quota.ExceededError); ok {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(fmt.Sprintf("%s\n", err)))
+		go z.state.notifyPushCompleted(z.filesystem, false)
+		return
+	}
+
 	zfs.LogZFSCommand(z.filesystem, fmt.Sprintf("%s recv %s", ZFS, zfs.FQ(z.state.config.PoolName, z.filesystem)))
 
 	cmd := exec.Command(ZFS, "recv", zfs.FQ(z.state.config.PoolName, z.filesystem))
@@ -388,6 +411,15 @@ func (z *ZFSReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("[ZFSReceiver:%s] Got prelude %v", z.filesystem, prelude)
 
+	err = z.state.checkReceivedCommits(z.filesystem, prelude)
+	if err != nil {
+		log.Printf("[ZFSReceiver:%s] Rejecting push: %v", z.filesystem, err)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(fmt.Sprintf("%s\n", err)))
+		go z.state.notifyPushCompleted(z.filesystem, false)
+		return
+	}
+
 	err = cmd.Run()
 	if err != nil {
 		log.Printf(
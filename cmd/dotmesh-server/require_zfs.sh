@@ -47,7 +47,7 @@ POOL=${USE_POOL_NAME:-pool}
 POOL=$(echo $POOL |sed s/\#HOSTNAME\#/$HOSTNAME/)
 DOTMESH_INNER_SERVER_NAME=${DOTMESH_INNER_SERVER_NAME:-dotmesh-server-inner}
 FLEXVOLUME_DRIVER_DIR=${FLEXVOLUME_DRIVER_DIR:-/usr/libexec/kubernetes/kubelet-plugins/volume/exec}
-INHERIT_ENVIRONMENT_NAMES=( "FILESYSTEM_METADATA_TIMEOUT" "DOTMESH_UPGRADES_URL" "DOTMESH_UPGRADES_INTERVAL_SECONDS" "NATS_URL" "NATS_USERNAME" "NATS_PASSWORD" "NATS_SUBJECT_PREFIX")
+INHERIT_ENVIRONMENT_NAMES=( "FILESYSTEM_METADATA_TIMEOUT" "MASTER_FAILOVER_GRACE_PERIOD" "MASTER_FAILOVER_FORCE" "METRICS_PER_DOT" "CONTAINER_RUNTIME" "CRI_RUNTIME_ENDPOINT" "DOTMESH_UPGRADES_URL" "DOTMESH_UPGRADES_INTERVAL_SECONDS" "NATS_URL" "NATS_USERNAME" "NATS_PASSWORD" "NATS_SUBJECT_PREFIX" "OTEL_EXPORTER_OTLP_ENDPOINT" "DOTMESH_TLS" "DOTMESH_TLS_CERT_FILE" "DOTMESH_TLS_KEY_FILE" "DOTMESH_TLS_ROLLOUT")
 
 if [ $POOL_SIZE = AUTO ]
 then
@@ -353,6 +353,14 @@ do
     INHERIT_ENVIRONMENT_ARGS="$INHERIT_ENVIRONMENT_ARGS -e $name=$(eval "echo \$$name")"
 done
 
+# when kubernetes is using containerd (or another CRI runtime) rather than
+# docker, containers are stopped and started through its socket instead
+if [ "$CONTAINER_RUNTIME" = "containerd" -o "$CONTAINER_RUNTIME" = "cri" ]; then
+    CRI_SOCKET=${CRI_RUNTIME_ENDPOINT:-unix:///run/containerd/containerd.sock}
+    CRI_SOCKET=${CRI_SOCKET#unix://}
+    EXTRA_VOLUMES="$EXTRA_VOLUMES -v $CRI_SOCKET:$CRI_SOCKET"
+fi
+
 # we need the logs from the inner server to be sent to the outer container
 # such that k8s will pick them up from the pod - the inner container is not
 # a pod but a container run from /var/run/docker.sock
@@ -374,6 +382,8 @@ set +e
 # 4. Be able to install a Kubernetes FlexVolume driver (we make symlinks
 #    where it tells us to).
 
+# 5. Share the API's unix socket with the CSI driver.
+
 # NOTE: The *source* path in the -v flags IS AS SEEN BY THE HOST,
 # *not* as seen by this container running require_zfs.sh, because
 # we're talking to the host docker. That's why we must map
@@ -384,6 +394,7 @@ docker run -i $rm_opt --pid=host --privileged --name=$DOTMESH_INNER_SERVER_NAME
     -v /run/docker/plugins:/run/docker/plugins \
     -v $OUTER_DIR:$OUTER_DIR:rshared \
     -v $FLEXVOLUME_DRIVER_DIR:/system-flexvolume \
+    -v /run/dotmesh:/run/dotmesh \
     $EXTRA_VOLUMES \
     $net \
     $link \
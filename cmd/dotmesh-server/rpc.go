@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
@@ -24,6 +25,8 @@ import (
 
 	"github.com/dotmesh-io/dotmesh/pkg/auth"
 	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/notification/webhook"
+	"github.com/dotmesh-io/dotmesh/pkg/quota"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/user"
 
@@ -100,7 +103,7 @@ func (d *DotmeshRPC) Procure(
 
 	ctx := r.Context()
 
-	vn := VolumeName{args.Namespace, args.Name}
+	vn := VolumeName{Namespace: args.Namespace, Name: args.Name}
 
 	err = requireValidVolumeNameWithBranch(vn)
 	if err != nil {
@@ -576,7 +579,7 @@ func (d *DotmeshRPC) SwitchContainers(
 	}
 
 	toFilesystemId, err := d.state.registry.MaybeCloneFilesystemId(
-		VolumeName{args.Namespace, args.Name},
+		VolumeName{Namespace: args.Namespace, Name: args.Name},
 		args.NewBranchName,
 	)
 	if err != nil {
@@ -587,9 +590,9 @@ func (d *DotmeshRPC) SwitchContainers(
 	d.state.containersLock.Lock()
 	defer d.state.containersLock.Unlock()
 
-	// TODO Maybe be a bit more selective about which containers we stop/start
-	// here (only ones which are using the given volume *and branch* name).
-	if err := d.state.containers.Stop(args.Name); err != nil {
+	// Only containers following the dot's current branch move; those pinned
+	// to a branch or commit are left running.
+	if err := d.state.containers.StopFollowing(args.Name); err != nil {
 		log.Printf("[SwitchContainers] Error stopping containers: %+v", err)
 		return err
 	}
@@ -602,6 +605,12 @@ func (d *DotmeshRPC) SwitchContainers(
 		log.Printf("[SwitchContainers] Error switching symlinks: %+v", err)
 		return err
 	}
+	// so that containers started from now on get the new branch too
+	err = switchContainerMountSymlink(VolumeName{Namespace: args.Namespace, Name: args.Name}, toFilesystemId)
+	if err != nil {
+		// the containers that were stopped still need starting
+		log.Printf("[SwitchContainers] Error switching symlink for new containers: %+v", err)
+	}
 
 	*result = true
 	err = d.state.containers.Start(args.Name)
@@ -626,7 +635,7 @@ func (d *DotmeshRPC) Containers(r *http.Request, args *struct{ Namespace, Name,
 	}
 
 	filesystemId, err := d.state.registry.MaybeCloneFilesystemId(
-		VolumeName{args.Namespace, args.Name},
+		VolumeName{Namespace: args.Namespace, Name: args.Name},
 		args.Branch,
 	)
 	if err != nil {
@@ -679,7 +688,7 @@ func (d *DotmeshRPC) Exists(
 		return err
 	}
 
-	fsId := d.state.registry.Exists(VolumeName{args.Namespace, args.Name}, args.Branch)
+	fsId := d.state.registry.Exists(VolumeName{Namespace: args.Namespace, Name: args.Name}, args.Branch)
 	deleted, err := isFilesystemDeletedInEtcd(fsId)
 	if err != nil {
 		return err
@@ -709,7 +718,7 @@ func (d *DotmeshRPC) Lookup(
 	}
 
 	filesystemId, err := d.state.registry.MaybeCloneFilesystemId(
-		VolumeName{args.Namespace, args.Name}, args.Branch,
+		VolumeName{Namespace: args.Namespace, Name: args.Name}, args.Branch,
 	)
 	if err != nil {
 		return err
@@ -745,7 +754,7 @@ func (d *DotmeshRPC) Commits(
 	}
 
 	filesystemId, err := d.state.registry.MaybeCloneFilesystemId(
-		VolumeName{args.Namespace, args.Name},
+		VolumeName{Namespace: args.Namespace, Name: args.Name},
 		args.Branch,
 	)
 	if err != nil {
@@ -759,6 +768,50 @@ func (d *DotmeshRPC) Commits(
 	return nil
 }
 
+// QueryCommits - like Commits, but only a page of the commits matching a
+// query, so that long histories can be searched and listed a page at a time
+func (d *DotmeshRPC) QueryCommits(
+	r *http.Request,
+	args *struct {
+		Namespace, Name, Branch string
+		Query                   types.CommitQuery
+	},
+	result *types.CommitPage,
+) error {
+	err := validator.IsValidVolume(args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+
+	err = validator.IsValidBranchName(args.Branch)
+	if err != nil {
+		return err
+	}
+
+	name := VolumeName{Namespace: args.Namespace, Name: args.Name}
+	tlf, err := d.state.registry.LookupFilesystem(name)
+	if err != nil {
+		return err
+	}
+	authorized, err := tlf.Authorize(r.Context())
+	if err != nil {
+		return err
+	}
+	if !authorized {
+		return PermissionDenied{}
+	}
+	filesystemId, err := d.state.registry.MaybeCloneFilesystemId(name, args.Branch)
+	if err != nil {
+		return err
+	}
+	page, err := d.state.QuerySnapshotsForCurrentMaster(filesystemId, args.Query)
+	if err != nil {
+		return err
+	}
+	*result = page
+	return nil
+}
+
 func (d *DotmeshRPC) CommitsById(
 	r *http.Request,
 	filesystemId *string,
@@ -778,6 +831,7 @@ func (d *DotmeshRPC) StashAfter(
 	newBranch *string,
 ) error {
 	responseChan, err := d.state.globalFsRequest(
+		r.Context(),
 		args.FilesystemId,
 		&Event{Name: "stash",
 			Args: &EventArgs{"snapshotId": args.SnapshotId}},
@@ -848,7 +902,7 @@ func (d *DotmeshRPC) Commit(
 	// wait for a response to be inserted into etcd as well, before firing with
 	// that.
 	filesystemId, err := d.state.registry.MaybeCloneFilesystemId(
-		VolumeName{args.Namespace, args.Name},
+		VolumeName{Namespace: args.Namespace, Name: args.Name},
 		args.Branch,
 	)
 	if err != nil {
@@ -867,7 +921,16 @@ func (d *DotmeshRPC) Commit(
 		meta[name] = value
 	}
 	log.WithField("meta", meta).Infoln("Finished collating metadata with msg and author")
+	tlf, err := d.state.registry.LookupFilesystem(VolumeName{Namespace: args.Namespace, Name: args.Name})
+	if err != nil {
+		return err
+	}
+	err = tlf.CheckMetadata(args.Branch, "", meta)
+	if err != nil {
+		return err
+	}
 	responseChan, err := d.state.globalFsRequest(
+		r.Context(),
 		filesystemId,
 		&Event{Name: "snapshot",
 			Args: &EventArgs{"metadata": meta}},
@@ -889,51 +952,88 @@ func (d *DotmeshRPC) Commit(
 	return nil
 }
 
+// MountCommit - mounts a commit read-only on its master for holder, returning
+// the path it's mounted at there. Only the admin user can mount commits, as
+// with ProcureCommit, as the path is only any use on the node.
 func (d *DotmeshRPC) MountCommit(
 	r *http.Request,
-	args *struct{ FilesystemId, CommitId string },
+	args *struct{ FilesystemId, CommitId, Holder string },
 	result *string,
 ) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
+	}
+	holder, err := rpcCommitHolder(args.Holder)
+	if err != nil {
+		return err
+	}
 
 	// check that a filesystem with that id exists
-	_, _, err := d.state.registry.LookupFilesystemById(args.FilesystemId)
+	_, _, err = d.state.registry.LookupFilesystemById(args.FilesystemId)
 
 	if err != nil {
 		return err
 	}
 
-	snapshots, err := d.state.SnapshotsForCurrentMaster(args.FilesystemId)
+	mountPath, err := d.state.mountCommit(r.Context(), args.FilesystemId, args.CommitId, holder)
 	if err != nil {
 		return err
 	}
+	log.Printf("snapshot mounted %s for filesystem %s", args.CommitId, args.FilesystemId)
+	*result = mountPath
+	return nil
+}
 
-	foundSnapshot := false
-	for _, snapshot := range snapshots {
-		if snapshot.Id == args.CommitId {
-			foundSnapshot = true
-		}
+// UnmountCommit - lets go of a commit mounted by MountCommit, unmounting it
+// if nothing else is using it. Only the admin user can, and not for docker or
+// symlink holders.
+func (d *DotmeshRPC) UnmountCommit(
+	r *http.Request,
+	args *struct{ FilesystemId, CommitId, Holder string },
+	result *bool,
+) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
 	}
-
-	if !foundSnapshot {
-		return fmt.Errorf("Cannot find commit with id %s for filesystem %s", args.CommitId, args.FilesystemId)
+	holder, err := rpcCommitHolder(args.Holder)
+	if err != nil {
+		return err
 	}
-
-	responseChan, err := d.state.globalFsRequest(
-		args.FilesystemId,
-		&Event{Name: "mount-snapshot",
-			Args: &EventArgs{"snapId": args.CommitId}},
-	)
+	err = d.state.unmountCommit(args.FilesystemId, args.CommitId, holder)
 	if err != nil {
 		return err
 	}
+	*result = true
+	return nil
+}
 
-	e := <-responseChan
-	if e.Name == "mounted" {
-		log.Printf("snapshot mounted %s for filesystem %s", args.CommitId, args.FilesystemId)
-		*result = (*e.Args)["mount-path"].(string)
-	} else {
-		return maybeError(e, "mounted")
+// ProcureCommit - like Procure, but for dot@commit or dot@branch@commit,
+// which is mounted read-only for holder rather than following a branch.
+func (d *DotmeshRPC) ProcureCommit(
+	r *http.Request,
+	args *struct{ Namespace, Name, Subdot, Holder string },
+	result *string,
+) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
+	}
+	err = validator.IsValidSubdotName(args.Subdot)
+	if err != nil {
+		return err
 	}
+	ctx := r.Context()
+	branch, commitId, ok := d.state.commitRef(VolumeName{Namespace: args.Namespace, Name: args.Name})
+	if !ok {
+		return fmt.Errorf("%s/%s is not a commit", args.Namespace, args.Name)
+	}
+	mountPath, err := d.state.procureCommit(ctx, branch, commitId, args.Holder)
+	if err != nil {
+		return err
+	}
+	*result = filepath.Join(mountPath, args.Subdot)
 	return nil
 }
 
@@ -959,17 +1059,27 @@ func (d *DotmeshRPC) Rollback(
 		return err
 	}
 
+	tlf, err := d.state.registry.LookupFilesystem(VolumeName{Namespace: args.Namespace, Name: args.Name})
+	if err != nil {
+		return err
+	}
+	err = tlf.CheckRollback(args.Branch)
+	if err != nil {
+		return err
+	}
+
 	// Insert a command into etcd for the current master to respond to, and
 	// wait for a response to be inserted into etcd as well, before firing with
 	// that.
 	filesystemId, err := d.state.registry.MaybeCloneFilesystemId(
-		VolumeName{args.Namespace, args.Name},
+		VolumeName{Namespace: args.Namespace, Name: args.Name},
 		args.Branch,
 	)
 	if err != nil {
 		return err
 	}
 	responseChan, err := d.state.globalFsRequest(
+		r.Context(),
 		filesystemId,
 		&Event{Name: "rollback",
 			Args: &EventArgs{"rollbackTo": args.SnapshotId}},
@@ -997,6 +1107,13 @@ func (d *DotmeshRPC) Rollback(
 }
 
 func maybeError(e *Event, expected string) error {
+	if e.Name == "snapshot-rejected" || e.Name == "push-rejected" || e.Name == "stash-rejected" {
+		// a policy hook or branch protection said no, which isn't
+		// unexpected, so just pass on what it said
+		if message, ok := (*e.Args)["message"].(string); ok {
+			return fmt.Errorf("%s", message)
+		}
+	}
 	log.Printf("Unexpected response '%s' (expected: '%s') - %#v", e.Name, expected, e.Args)
 	err, ok := (*e.Args)["err"]
 	if ok {
@@ -1063,56 +1180,11 @@ func (d *DotmeshRPC) Branch(
 		return err
 	}
 
-	tlf, err := d.state.registry.LookupFilesystem(VolumeName{args.Namespace, args.Name})
+	_, err = d.state.createBranch(r.Context(), args.Namespace, args.Name, args.SourceBranch, args.NewBranchName, args.SourceCommitId)
 	if err != nil {
 		return err
 	}
-	var originFilesystemId string
-
-	// find whether branch refers to top-level fs or a clone, by guessing based
-	// on name convention. XXX this shouldn't be dealing with "master" and
-	// branches
-	if args.SourceBranch == DEFAULT_BRANCH {
-		originFilesystemId = tlf.MasterBranch.Id
-	} else {
-		clone, err := d.state.registry.LookupClone(
-			tlf.MasterBranch.Id, args.SourceBranch,
-		)
-		originFilesystemId = clone.FilesystemId
-		if err != nil {
-			return err
-		}
-	}
-	// target node is responsible for creating registry entry (so that they're
-	// as close as possible to eachother), so give it all the info it needs to
-	// do that.
-	responseChan, err := d.state.globalFsRequest(
-		originFilesystemId,
-		&Event{Name: "clone",
-			Args: &EventArgs{
-				"topLevelFilesystemId": tlf.MasterBranch.Id,
-				"originFilesystemId":   originFilesystemId,
-				"originSnapshotId":     args.SourceCommitId,
-				"newBranchName":        args.NewBranchName,
-			},
-		},
-	)
-	if err != nil {
-		return err
-	}
-
-	// TODO this may never succeed, if the master for it never shows up. maybe
-	// this response should have a timeout associated with it.
-	e := <-responseChan
-	if e.Name == "cloned" {
-		log.Printf(
-			"Cloned %s:%s@%s (%s) to %s", args.Name,
-			args.SourceBranch, args.SourceCommitId, originFilesystemId, (*e.Args)["newFilesystemId"].(string),
-		)
-		*result = true
-	} else {
-		return maybeError(e, "cloned")
-	}
+	*result = true
 	return nil
 }
 
@@ -1141,6 +1213,13 @@ func (d *DotmeshRPC) registerFilesystemBecomeMaster(
 	log.Debugf("[registerFilesystemBecomeMaster] called: filesystemNamespace=%s, filesystemName=%s, cloneName=%s, filesystemId=%s path=%+v",
 		filesystemNamespace, filesystemName, cloneName, filesystemId, path)
 
+	// everything registered here is about to be received, so it's refused
+	// once the namespace is over quota, like creating a dot is
+	err := d.state.checkNamespaceQuota(filesystemNamespace, 0)
+	if err != nil {
+		return err
+	}
+
 	kapi, err := getEtcdKeysApi()
 	if err != nil {
 		return err
@@ -1429,6 +1508,7 @@ func (d *DotmeshRPC) S3Transfer(
 	// etcd.
 
 	responseChan, requestId, err := d.state.globalFsRequestId(
+		r.Context(),
 		localFilesystemId,
 		&Event{Name: "s3-transfer",
 			Args: &EventArgs{
@@ -1494,7 +1574,7 @@ func (d *DotmeshRPC) RegisterTransfer(
 	// tying a transfer to a filesystem id is probably wrong. except, the thing
 	// being updated is a specific branch (filesystem id), it's ok if it drags
 	// dependent snapshots along with it.
-	responseChan, err := d.state.globalFsRequest(args.FilesystemId, &Event{
+	responseChan, err := d.state.globalFsRequest(r.Context(), args.FilesystemId, &Event{
 		Name: "peer-transfer",
 		Args: &EventArgs{
 			"Transfer": args,
@@ -1586,6 +1666,18 @@ func (d *DotmeshRPC) Transfer(
 	if args.Direction == "pull" && !remoteExists {
 		return fmt.Errorf("Can't pull when remote doesn't exist")
 	}
+	if args.Direction == "pull" && localExists && args.StashDivergence {
+		// pushes with StashDivergence are checked by the remote, when it's
+		// asked to stash (StashAfter)
+		tlf, err := d.state.registry.LookupFilesystem(VolumeName{Namespace: args.LocalNamespace, Name: args.LocalName})
+		if err != nil {
+			return err
+		}
+		err = tlf.CheckForcePush(args.LocalBranchName)
+		if err != nil {
+			return err
+		}
+	}
 
 	var localPath, remotePath PathToTopLevelFilesystem
 	if args.Direction == "push" {
@@ -1711,6 +1803,7 @@ func (d *DotmeshRPC) Transfer(
 					user, _, _ := r.BasicAuth()
 					meta := Metadata{"message": "committing dirty data ready for stashing", "author": user}
 					responseChan, err := d.state.globalFsRequest(
+						r.Context(),
 						filesystemId,
 						&Event{Name: "snapshot",
 							Args: &EventArgs{"metadata": meta}},
@@ -1767,6 +1860,7 @@ func (d *DotmeshRPC) Transfer(
 	// etcd.
 
 	responseChan, requestId, err := d.state.globalFsRequestId(
+		r.Context(),
 		filesystemId,
 		&Event{Name: "transfer",
 			Args: &EventArgs{
@@ -1916,6 +2010,9 @@ func (d *DotmeshRPC) AllDotsAndBranches(
 	return nil
 }
 
+// Fork - copies a dot into another namespace, remembering where it came
+// from. Anyone who can see a dot can fork it, into a namespace they
+// administer.
 func (d *DotmeshRPC) Fork(
 	r *http.Request,
 	args *struct {
@@ -1925,19 +2022,31 @@ func (d *DotmeshRPC) Fork(
 	},
 	result *string,
 ) error {
-
-	// TODO #611: strip this out and replace it with a check to make sure they're in the collaborators list
-	err := ensureAdminUser(r)
+	tlf, _, err := d.state.registry.LookupFilesystemById(args.MasterBranchID)
 	if err != nil {
 		return err
 	}
-
-	_, _, err = d.state.registry.LookupFilesystemById(args.MasterBranchID)
+	authorized, err := tlf.Authorize(r.Context())
+	if err != nil {
+		return err
+	}
+	if !authorized {
+		return PermissionDenied{}
+	}
+	isAdmin, err := AuthenticatedUserIsNamespaceAdministrator(r.Context(), args.ForkNamespace)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return fmt.Errorf("You can only fork dots into your own namespace, not %s", args.ForkNamespace)
+	}
+	err = validator.IsValidVolume(args.ForkNamespace, args.ForkName)
 	if err != nil {
 		return err
 	}
 
 	responseChan, _, err := d.state.globalFsRequestId(
+		r.Context(),
 		args.MasterBranchID,
 		&Event{Name: "fork",
 			Args: &EventArgs{
@@ -1961,151 +2070,795 @@ func (d *DotmeshRPC) Fork(
 	return nil
 }
 
-func (d *DotmeshRPC) AddCollaborator(
+// SyncFork - brings a fork's master branch up to date with the dot it was
+// forked from, returning how many commits that took. The fork mustn't have
+// commits of its own since it was forked (or last synced).
+func (d *DotmeshRPC) SyncFork(
 	r *http.Request,
 	args *struct {
-		MasterBranchID string
-		Collaborator   string
+		Namespace string
+		Name      string
 	},
-	result *bool,
+	result *int,
 ) error {
-	// check authenticated user is owner of volume.
-	crappyTlf, clone, err := d.state.registry.LookupFilesystemById(args.MasterBranchID)
-	if err != nil {
-		return err
-	}
-	if clone != "" {
-		return fmt.Errorf(
-			"Please add collaborators to the master branch of the dot",
-		)
-	}
-	authorized, err := crappyTlf.AuthorizeOwner(r.Context())
-	if err != nil {
-		return err
-	}
-	if !authorized {
-		return fmt.Errorf(
-			"Not owner. Please ask the owner to add the collaborator.",
-		)
-	}
-	// add collaborator in registry, re-save.
-	potentialCollaborator, err := d.usersManager.Get(&user.Query{Ref: args.Collaborator})
+	fork, upstream, err := d.lookupFork(r, args.Namespace, args.Name)
 	if err != nil {
 		return err
 	}
-	newCollaborators := append(crappyTlf.Collaborators, potentialCollaborator.SafeUser())
-	err = d.state.registry.UpdateCollaborators(r.Context(), crappyTlf, newCollaborators)
+	commits, err := d.state.fastForward(r.Context(), fork.MasterBranch.Id, upstream.MasterBranch.Id, "")
 	if err != nil {
 		return err
 	}
-	*result = true
+	log.Printf("[SyncFork] %s/%s synced %d commits from %s", args.Namespace, args.Name, commits, upstream.MasterBranch.Name)
+	*result = commits
 	return nil
 }
 
-func (d *DotmeshRPC) RemoveCollaborator(
+// CreateChangeRequest - proposes merging the latest commit on a fork's
+// master branch into the dot it was forked from.
+func (d *DotmeshRPC) CreateChangeRequest(
 	r *http.Request,
 	args *struct {
-		MasterBranchID string
-		Collaborator   string
+		Namespace   string
+		Name        string
+		Title       string
+		Description string
 	},
-	result *bool,
+	result *types.ChangeRequest,
 ) error {
-	// check authenticated user is owner of volume.
-	crappyTlf, clone, err := d.state.registry.LookupFilesystemById(args.MasterBranchID)
+	fork, upstream, err := d.lookupFork(r, args.Namespace, args.Name)
 	if err != nil {
 		return err
 	}
-	if clone != "" {
-		return fmt.Errorf(
-			"Please remove collaborators from the master branch of the dot",
-		)
-	}
-	authorized, err := crappyTlf.AuthorizeOwner(r.Context())
+	author, err := changeRequestAuthor(r)
 	if err != nil {
 		return err
 	}
-	if !authorized {
-		return fmt.Errorf(
-			"Not owner. Please ask the owner to remove the collaborator.",
-		)
-	}
-
-	authenticatedUser := auth.GetUser(r)
-
-	if authenticatedUser == nil {
-		return fmt.Errorf("user not found in the request ctx")
-	}
-
-	if authenticatedUser.Name == args.Collaborator {
-		return fmt.Errorf(
-			"You cannot remove yourself as a collaborator from a dot.",
-		)
-	}
-
-	collaboratorIndex := -1
-
-	for i, collaborator := range crappyTlf.Collaborators {
-		if collaborator.Name == args.Collaborator {
-			collaboratorIndex = i
-		}
+	snapshots, err := d.state.SnapshotsForCurrentMaster(fork.MasterBranch.Id)
+	if err != nil {
+		return err
 	}
-
-	if collaboratorIndex == -1 {
-		return fmt.Errorf(
-			"%s is not a collaborator on this dot so cannot remove.",
-			args.Collaborator,
-		)
+	if len(snapshots) == 0 {
+		return fmt.Errorf("%s/%s has no commits", args.Namespace, args.Name)
 	}
-
-	// remove collaborator in registry, re-save.
-	newCollaborators := append(crappyTlf.Collaborators[:collaboratorIndex], crappyTlf.Collaborators[collaboratorIndex+1:]...)
-
-	err = d.state.registry.UpdateCollaborators(r.Context(), crappyTlf, newCollaborators)
+	cr, err := d.state.changeRequestManager.Create(&types.ChangeRequest{
+		FilesystemId: upstream.MasterBranch.Id,
+		ForkId:       fork.MasterBranch.Id,
+		CommitId:     snapshots[len(snapshots)-1].Id,
+		Title:        args.Title,
+		Description:  args.Description,
+		AuthorId:     author,
+	})
 	if err != nil {
 		return err
 	}
-	*result = true
+	*result = *cr
 	return nil
 }
 
-func (d *DotmeshRPC) DeducePathToTopLevelFilesystem(
+// ChangeRequests - lists the change requests proposed to a dot. Its
+// administrators see all of them, anyone else just their own.
+func (d *DotmeshRPC) ChangeRequests(
 	r *http.Request,
 	args *struct {
-		RemoteNamespace      string
-		RemoteFilesystemName string
-		RemoteCloneName      string
+		Namespace string
+		Name      string
 	},
-	result *PathToTopLevelFilesystem,
+	result *[]types.ChangeRequest,
 ) error {
-	log.Printf("[DeducePathToTopLevelFilesystem] called with args: %+v", args)
-
-	err := validator.IsValidVolume(args.RemoteNamespace, args.RemoteFilesystemName)
+	tlf, isAdmin, err := d.authorizeChangeRequests(r, args.Namespace, args.Name)
 	if err != nil {
 		return err
 	}
-	err = validator.IsValidBranchName(args.RemoteCloneName)
+	crs, err := d.state.changeRequestManager.List(tlf.MasterBranch.Id)
 	if err != nil {
 		return err
 	}
-
-	res, err := d.state.registry.DeducePathToTopLevelFilesystem(
-		VolumeName{args.RemoteNamespace, args.RemoteFilesystemName}, args.RemoteCloneName,
-	)
-	if err != nil {
-		return err
+	*result = []types.ChangeRequest{}
+	for _, cr := range crs {
+		if isAdmin || isChangeRequestAuthor(r, cr) {
+			*result = append(*result, *cr)
+		}
 	}
-	*result = res
-	log.Printf("[DeducePathToTopLevelFilesystem] succeeded: args %+v -> result %+v", args, res)
 	return nil
 }
 
-func (d *DotmeshRPC) PredictSize(
+// ReviewChangeRequest - approves of, or objects to, a change request. Its
+// dot's administrators and collaborators can review it.
+func (d *DotmeshRPC) ReviewChangeRequest(
 	r *http.Request,
 	args *struct {
-		FromFilesystemId string
-		FromSnapshotId   string
-		ToFilesystemId   string
-		ToSnapshotId     string
+		Namespace string
+		Name      string
+		Id        string
+		Approve   bool
+		Comment   string
+	},
+	result *types.ChangeRequest,
+) error {
+	tlf, isAdmin, err := d.authorizeChangeRequests(r, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		authorized, err := tlf.Authorize(r.Context())
+		if err != nil {
+			return err
+		}
+		if !authorized {
+			return PermissionDenied{}
+		}
+	}
+	reviewer, err := changeRequestAuthor(r)
+	if err != nil {
+		return err
+	}
+	cr, err := d.state.changeRequestManager.Review(tlf.MasterBranch.Id, args.Id, types.ChangeRequestReview{
+		ReviewerId: reviewer,
+		Approved:   args.Approve,
+		Comment:    args.Comment,
+	})
+	if err != nil {
+		return err
+	}
+	*result = *cr
+	return nil
+}
+
+// AcceptChangeRequest - brings a dot's master branch up to the commit on the
+// fork that a change request proposes. Only the dot's administrators can
+// accept change requests.
+func (d *DotmeshRPC) AcceptChangeRequest(
+	r *http.Request,
+	args *struct {
+		Namespace string
+		Name      string
+		Id        string
+	},
+	result *types.ChangeRequest,
+) error {
+	tlf, isAdmin, err := d.authorizeChangeRequests(r, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return PermissionDenied{}
+	}
+	cr, err := d.state.changeRequestManager.Get(tlf.MasterBranch.Id, args.Id)
+	if err != nil {
+		return err
+	}
+	if cr.State != types.ChangeRequestOpen {
+		return fmt.Errorf("Change request %s is already %s", cr.Id, cr.State)
+	}
+	commits, err := d.state.fastForward(r.Context(), tlf.MasterBranch.Id, cr.ForkId, cr.CommitId)
+	if err != nil {
+		return err
+	}
+	log.Printf("[AcceptChangeRequest] %s/%s took %d commits from %s", args.Namespace, args.Name, commits, cr.ForkId)
+	cr, err = d.state.changeRequestManager.SetState(tlf.MasterBranch.Id, cr.Id, types.ChangeRequestAccepted)
+	if err != nil {
+		return err
+	}
+	*result = *cr
+	return nil
+}
+
+// CloseChangeRequest - closes a change request without accepting it. The
+// dot's administrators and the change request's author can close it.
+func (d *DotmeshRPC) CloseChangeRequest(
+	r *http.Request,
+	args *struct {
+		Namespace string
+		Name      string
+		Id        string
+	},
+	result *types.ChangeRequest,
+) error {
+	tlf, isAdmin, err := d.authorizeChangeRequests(r, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	cr, err := d.state.changeRequestManager.Get(tlf.MasterBranch.Id, args.Id)
+	if err != nil {
+		return err
+	}
+	if !isAdmin && !isChangeRequestAuthor(r, cr) {
+		return PermissionDenied{}
+	}
+	cr, err = d.state.changeRequestManager.SetState(tlf.MasterBranch.Id, cr.Id, types.ChangeRequestClosed)
+	if err != nil {
+		return err
+	}
+	*result = *cr
+	return nil
+}
+
+func (d *DotmeshRPC) AddCollaborator(
+	r *http.Request,
+	args *struct {
+		MasterBranchID string
+		Collaborator   string
+	},
+	result *bool,
+) error {
+	// check authenticated user is owner of volume.
+	crappyTlf, clone, err := d.state.registry.LookupFilesystemById(args.MasterBranchID)
+	if err != nil {
+		return err
+	}
+	if clone != "" {
+		return fmt.Errorf(
+			"Please add collaborators to the master branch of the dot",
+		)
+	}
+	authorized, err := crappyTlf.AuthorizeOwner(r.Context())
+	if err != nil {
+		return err
+	}
+	if !authorized {
+		return fmt.Errorf(
+			"Not owner. Please ask the owner to add the collaborator.",
+		)
+	}
+	// add collaborator in registry, re-save.
+	potentialCollaborator, err := d.usersManager.Get(&user.Query{Ref: args.Collaborator})
+	if err != nil {
+		return err
+	}
+	newCollaborators := append(crappyTlf.Collaborators, potentialCollaborator.SafeUser())
+	err = d.state.registry.UpdateCollaborators(r.Context(), crappyTlf, newCollaborators)
+	if err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+func (d *DotmeshRPC) RemoveCollaborator(
+	r *http.Request,
+	args *struct {
+		MasterBranchID string
+		Collaborator   string
+	},
+	result *bool,
+) error {
+	// check authenticated user is owner of volume.
+	crappyTlf, clone, err := d.state.registry.LookupFilesystemById(args.MasterBranchID)
+	if err != nil {
+		return err
+	}
+	if clone != "" {
+		return fmt.Errorf(
+			"Please remove collaborators from the master branch of the dot",
+		)
+	}
+	authorized, err := crappyTlf.AuthorizeOwner(r.Context())
+	if err != nil {
+		return err
+	}
+	if !authorized {
+		return fmt.Errorf(
+			"Not owner. Please ask the owner to remove the collaborator.",
+		)
+	}
+
+	authenticatedUser := auth.GetUser(r)
+
+	if authenticatedUser == nil {
+		return fmt.Errorf("user not found in the request ctx")
+	}
+
+	if authenticatedUser.Name == args.Collaborator {
+		return fmt.Errorf(
+			"You cannot remove yourself as a collaborator from a dot.",
+		)
+	}
+
+	collaboratorIndex := -1
+
+	for i, collaborator := range crappyTlf.Collaborators {
+		if collaborator.Name == args.Collaborator {
+			collaboratorIndex = i
+		}
+	}
+
+	if collaboratorIndex == -1 {
+		return fmt.Errorf(
+			"%s is not a collaborator on this dot so cannot remove.",
+			args.Collaborator,
+		)
+	}
+
+	// remove collaborator in registry, re-save.
+	newCollaborators := append(crappyTlf.Collaborators[:collaboratorIndex], crappyTlf.Collaborators[collaboratorIndex+1:]...)
+
+	err = d.state.registry.UpdateCollaborators(r.Context(), crappyTlf, newCollaborators)
+	if err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+// SetQuota - sets the storage quota of a dot, or of a whole namespace if Name
+// is empty. A LimitBytes of zero removes the quota. Only the admin user can
+// set quotas.
+func (d *DotmeshRPC) SetQuota(
+	r *http.Request,
+	args *struct {
+		Namespace  string
+		Name       string
+		LimitBytes int64
+	},
+	result *bool,
+) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
+	}
+	if args.LimitBytes < 0 {
+		return fmt.Errorf("Quota must not be negative, got %d", args.LimitBytes)
+	}
+
+	q := &quota.Quota{LimitBytes: args.LimitBytes}
+	if args.Name == "" {
+		err = d.state.quotaManager.SetNamespaceQuota(args.Namespace, q)
+	} else {
+		err = validator.IsValidVolume(args.Namespace, args.Name)
+		if err != nil {
+			return err
+		}
+		var filesystemId string
+		filesystemId, err = d.state.registry.IdFromName(VolumeName{Namespace: args.Namespace, Name: args.Name})
+		if err != nil {
+			return err
+		}
+		err = d.state.quotaManager.SetFilesystemQuota(filesystemId, q)
+	}
+	if err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+// GetQuota - returns the storage quota and current usage of a dot, or of a
+// whole namespace if Name is empty.
+func (d *DotmeshRPC) GetQuota(
+	r *http.Request,
+	args *struct {
+		Namespace string
+		Name      string
+	},
+	result *quota.Usage,
+) error {
+	if args.Name == "" {
+		isAdmin, err := AuthenticatedUserIsNamespaceAdministrator(r.Context(), args.Namespace)
+		if err != nil {
+			return err
+		}
+		if !isAdmin {
+			return PermissionDenied{}
+		}
+		q, err := d.state.quotaManager.GetNamespaceQuota(args.Namespace)
+		if err != nil {
+			return err
+		}
+		*result = quota.Usage{
+			LimitBytes: q.LimitBytes,
+			UsedBytes:  d.state.namespaceUsage(args.Namespace),
+		}
+		return nil
+	}
+
+	tlf, err := d.state.registry.LookupFilesystem(VolumeName{Namespace: args.Namespace, Name: args.Name})
+	if err != nil {
+		return err
+	}
+	authorized, err := tlf.Authorize(r.Context())
+	if err != nil {
+		return err
+	}
+	if !authorized {
+		return PermissionDenied{}
+	}
+	q, err := d.state.quotaManager.GetFilesystemQuota(tlf.MasterBranch.Id)
+	if err != nil {
+		return err
+	}
+	*result = quota.Usage{
+		LimitBytes: q.LimitBytes,
+		UsedBytes:  d.state.dotUsage(tlf.MasterBranch.Id),
+	}
+	return nil
+}
+
+// authorizeWebhook - webhooks on a whole namespace can be managed by its
+// administrators, webhooks on a single dot by its owner too.
+func authorizeWebhook(r *http.Request, d *DotmeshRPC, namespace, name string) error {
+	isAdmin, err := AuthenticatedUserIsNamespaceAdministrator(r.Context(), namespace)
+	if err != nil {
+		return err
+	}
+	if isAdmin {
+		return nil
+	}
+	if name != "" {
+		tlf, err := d.state.registry.LookupFilesystem(VolumeName{Namespace: namespace, Name: name})
+		if err != nil {
+			return err
+		}
+		authorized, err := tlf.AuthorizeOwner(r.Context())
+		if err != nil {
+			return err
+		}
+		if authorized {
+			return nil
+		}
+	}
+	return PermissionDenied{}
+}
+
+// SetWebhook - creates a webhook when Id is empty, otherwise updates it,
+// which also re-enables it if it was disabled after failing. An empty Secret
+// keeps the existing one, or generates one for a new webhook, which is
+// returned only this once.
+func (d *DotmeshRPC) SetWebhook(
+	r *http.Request,
+	args *struct {
+		Id        string
+		Namespace string
+		Name      string
+		URL       string
+		Secret    string
+		Events    []string
+	},
+	result *types.Webhook,
+) error {
+	if args.Name != "" {
+		err := validator.IsValidVolume(args.Namespace, args.Name)
+		if err != nil {
+			return err
+		}
+	}
+	err := authorizeWebhook(r, d, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+
+	hook := &types.Webhook{
+		Id:        args.Id,
+		Namespace: args.Namespace,
+		Name:      args.Name,
+		URL:       args.URL,
+		Secret:    args.Secret,
+		Events:    args.Events,
+	}
+	if args.Id == "" {
+		hook, err = d.state.webhookManager.Create(hook)
+		if err != nil {
+			return err
+		}
+		*result = *hook
+		return nil
+	}
+
+	existing, err := d.state.webhookManager.Get(args.Id)
+	if err != nil {
+		return err
+	}
+	// moving a webhook needs permission on where it was as well as where
+	// it's going
+	err = authorizeWebhook(r, d, existing.Namespace, existing.Name)
+	if err != nil {
+		return err
+	}
+	err = webhook.Validate(hook)
+	if err != nil {
+		return err
+	}
+	if hook.Secret == "" {
+		hook.Secret = existing.Secret
+	}
+	err = d.state.webhookManager.Update(hook)
+	if err != nil {
+		return err
+	}
+	*result = *hook
+	result.Secret = ""
+	return nil
+}
+
+// Webhooks - lists the webhooks in a namespace, without their secrets.
+func (d *DotmeshRPC) Webhooks(
+	r *http.Request,
+	args *struct {
+		Namespace string
+	},
+	result *[]types.Webhook,
+) error {
+	isAdmin, err := AuthenticatedUserIsNamespaceAdministrator(r.Context(), args.Namespace)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return PermissionDenied{}
+	}
+	hooks, err := d.state.webhookManager.List()
+	if err != nil {
+		return err
+	}
+	*result = []types.Webhook{}
+	for _, hook := range hooks {
+		if hook.Namespace != args.Namespace {
+			continue
+		}
+		hook.Secret = ""
+		*result = append(*result, *hook)
+	}
+	return nil
+}
+
+func (d *DotmeshRPC) DeleteWebhook(
+	r *http.Request,
+	args *struct {
+		Id string
+	},
+	result *bool,
+) error {
+	hook, err := d.state.webhookManager.Get(args.Id)
+	if err != nil {
+		return err
+	}
+	err = authorizeWebhook(r, d, hook.Namespace, hook.Name)
+	if err != nil {
+		return err
+	}
+	err = d.state.webhookManager.Delete(args.Id)
+	if err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+// authorizeDotOwner - for things only the dot's owner or a namespace
+// administrator can do
+func authorizeDotOwner(r *http.Request, d *DotmeshRPC, namespace, name string) (TopLevelFilesystem, error) {
+	err := validator.IsValidVolume(namespace, name)
+	if err != nil {
+		return TopLevelFilesystem{}, err
+	}
+	tlf, err := d.state.registry.LookupFilesystem(VolumeName{Namespace: namespace, Name: name})
+	if err != nil {
+		return TopLevelFilesystem{}, err
+	}
+	isAdmin, err := AuthenticatedUserIsNamespaceAdministrator(r.Context(), namespace)
+	if err != nil {
+		return TopLevelFilesystem{}, err
+	}
+	if isAdmin {
+		return tlf, nil
+	}
+	authorized, err := tlf.AuthorizeOwner(r.Context())
+	if err != nil {
+		return TopLevelFilesystem{}, err
+	}
+	if !authorized {
+		return TopLevelFilesystem{}, PermissionDenied{}
+	}
+	return tlf, nil
+}
+
+// authorizeHook - hooks can be managed by the dot's owner or a namespace
+// administrator, returning the dot's top level filesystem ID
+func authorizeHook(r *http.Request, d *DotmeshRPC, namespace, name string) (string, error) {
+	tlf, err := authorizeDotOwner(r, d, namespace, name)
+	if err != nil {
+		return "", err
+	}
+	return tlf.MasterBranch.Id, nil
+}
+
+// AddHook - adds a pre-commit or pre-push policy hook to a dot. Hooks that
+// run a command run it as the dotmesh server, so only the admin user can add
+// those.
+func (d *DotmeshRPC) AddHook(
+	r *http.Request,
+	args *struct {
+		Namespace      string
+		Name           string
+		Stage          string
+		URL            string
+		Command        []string
+		TimeoutSeconds int
+	},
+	result *types.PolicyHook,
+) error {
+	filesystemId, err := authorizeHook(r, d, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	if len(args.Command) > 0 {
+		err = ensureAdminUser(r)
+		if err != nil {
+			return err
+		}
+	}
+	hook, err := d.state.hookManager.Create(&types.PolicyHook{
+		FilesystemId:   filesystemId,
+		Stage:          args.Stage,
+		URL:            args.URL,
+		Command:        args.Command,
+		TimeoutSeconds: args.TimeoutSeconds,
+	})
+	if err != nil {
+		return err
+	}
+	*result = *hook
+	return nil
+}
+
+// Hooks - lists the policy hooks of a dot.
+func (d *DotmeshRPC) Hooks(
+	r *http.Request,
+	args *struct {
+		Namespace string
+		Name      string
+	},
+	result *[]types.PolicyHook,
+) error {
+	filesystemId, err := authorizeHook(r, d, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	hooks, err := d.state.hookManager.List(filesystemId)
+	if err != nil {
+		return err
+	}
+	*result = []types.PolicyHook{}
+	for _, hook := range hooks {
+		*result = append(*result, *hook)
+	}
+	return nil
+}
+
+func (d *DotmeshRPC) DeleteHook(
+	r *http.Request,
+	args *struct {
+		Namespace string
+		Name      string
+		Id        string
+	},
+	result *bool,
+) error {
+	filesystemId, err := authorizeHook(r, d, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	err = d.state.hookManager.Delete(filesystemId, args.Id)
+	if err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+// SetBranchProtection - replaces the protection rules of one of a dot's
+// branches, which apply to everyone including admins, so that they have to
+// be removed before doing what they forbid. A zero protection removes them.
+func (d *DotmeshRPC) SetBranchProtection(
+	r *http.Request,
+	args *struct {
+		Namespace  string
+		Name       string
+		Branch     string
+		Protection types.BranchProtection
+	},
+	result *bool,
+) error {
+	tlf, err := authorizeDotOwner(r, d, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	err = validator.IsValidBranchName(args.Branch)
+	if err != nil {
+		return err
+	}
+	if args.Branch != "" && args.Branch != "master" {
+		_, err = d.state.registry.LookupClone(tlf.MasterBranch.Id, args.Branch)
+		if err != nil {
+			return err
+		}
+	}
+	// NB: metadata keys must always start lowercase, as in Commit
+	for _, key := range args.Protection.RequiredMetadata {
+		if key == "" || key[:1] == strings.ToUpper(key[:1]) {
+			return fmt.Errorf("Metadata field names must start with lowercase characters: %q", key)
+		}
+	}
+	err = d.state.registry.SetBranchProtection(r.Context(), tlf, args.Branch, args.Protection)
+	if err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+// BranchProtections - the protection rules of a dot's branches, by branch
+// name. Anyone who can write to the dot can see them.
+func (d *DotmeshRPC) BranchProtections(
+	r *http.Request,
+	args *VolumeName,
+	result *map[string]types.BranchProtection,
+) error {
+	err := validator.IsValidVolume(args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	tlf, err := d.state.registry.LookupFilesystem(*args)
+	if err != nil {
+		return err
+	}
+	authorized, err := tlf.Authorize(r.Context())
+	if err != nil {
+		return err
+	}
+	if !authorized {
+		isAdmin, err := AuthenticatedUserIsNamespaceAdministrator(r.Context(), args.Namespace)
+		if err != nil {
+			return err
+		}
+		if !isAdmin {
+			return PermissionDenied{}
+		}
+	}
+	*result = map[string]types.BranchProtection{}
+	for branch, p := range tlf.Protections {
+		(*result)[branch] = p
+	}
+	return nil
+}
+
+func (d *DotmeshRPC) DeducePathToTopLevelFilesystem(
+	r *http.Request,
+	args *struct {
+		RemoteNamespace      string
+		RemoteFilesystemName string
+		RemoteCloneName      string
+	},
+	result *PathToTopLevelFilesystem,
+) error {
+	log.Printf("[DeducePathToTopLevelFilesystem] called with args: %+v", args)
+
+	err := validator.IsValidVolume(args.RemoteNamespace, args.RemoteFilesystemName)
+	if err != nil {
+		return err
+	}
+	err = validator.IsValidBranchName(args.RemoteCloneName)
+	if err != nil {
+		return err
+	}
+
+	res, err := d.state.registry.DeducePathToTopLevelFilesystem(
+		VolumeName{args.RemoteNamespace, args.RemoteFilesystemName}, args.RemoteCloneName,
+	)
+	if err != nil {
+		return err
+	}
+	*result = res
+	log.Printf("[DeducePathToTopLevelFilesystem] succeeded: args %+v -> result %+v", args, res)
+	return nil
+}
+
+func (d *DotmeshRPC) PredictSize(
+	r *http.Request,
+	args *struct {
+		FromFilesystemId string
+		FromSnapshotId   string
+		ToFilesystemId   string
+		ToSnapshotId     string
 	},
 	result *int64,
 ) error {
@@ -2117,6 +2870,7 @@ func (d *DotmeshRPC) PredictSize(
 		args.ToSnapshotId,
 	)
 	responseChan, err := d.state.globalFsRequest(
+		r.Context(),
 		args.ToFilesystemId,
 		&Event{Name: "predictSize",
 			Args: &EventArgs{
@@ -2140,6 +2894,77 @@ func (d *DotmeshRPC) PredictSize(
 	return nil
 }
 
+// SpaceUsage - space used by each branch of a dot (or just the one named),
+// and by each of their commits
+func (d *DotmeshRPC) SpaceUsage(
+	r *http.Request,
+	args *struct{ Namespace, Name, Branch string },
+	result *[]types.BranchSpaceUsage,
+) error {
+	branches, err := d.authorizeSpaceUsage(r, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	if args.Branch != "" {
+		if _, ok := branches[args.Branch]; !ok {
+			return fmt.Errorf("No such branch %s", args.Branch)
+		}
+		names = append(names, args.Branch)
+	} else {
+		for name := range branches {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	usages := []types.BranchSpaceUsage{}
+	for _, name := range names {
+		usage, commits, err := d.state.branchSpaceUsage(r.Context(), branches[name])
+		if err != nil {
+			return err
+		}
+		usages = append(usages, types.BranchSpaceUsage{
+			Branch:       name,
+			FilesystemId: branches[name],
+			SpaceUsage:   usage,
+			Commits:      commits,
+		})
+	}
+	*result = usages
+	return nil
+}
+
+// ReclaimableSpace - bytes that would be freed by deleting the commits
+// FromCommitId to ToCommitId (or just FromCommitId) of a branch or, if no
+// commits are given, the branch and any branches made from it
+func (d *DotmeshRPC) ReclaimableSpace(
+	r *http.Request,
+	args *struct{ Namespace, Name, Branch, FromCommitId, ToCommitId string },
+	result *int64,
+) error {
+	branches, err := d.authorizeSpaceUsage(r, args.Namespace, args.Name)
+	if err != nil {
+		return err
+	}
+	if args.Branch == "" {
+		args.Branch = DEFAULT_BRANCH
+	}
+	filesystemId, ok := branches[args.Branch]
+	if !ok {
+		return fmt.Errorf("No such branch %s", args.Branch)
+	}
+	if args.FromCommitId == "" && args.ToCommitId != "" {
+		return fmt.Errorf("Please give the first commit of the range as well as the last")
+	}
+	reclaim, err := d.state.predictReclaim(r.Context(), filesystemId, args.FromCommitId, args.ToCommitId)
+	if err != nil {
+		return err
+	}
+	*result = reclaim
+	return nil
+}
+
 func checkNotInUse(d *DotmeshRPC, fsid string, origins map[string]string) error {
 	containersInUse := func() int {
 		d.state.globalContainerCacheLock.Lock()
@@ -2207,6 +3032,10 @@ func (d *DotmeshRPC) Delete(r *http.Request, args *VolumeName, result *bool) err
 		)
 
 	}
+	err = filesystem.CheckDelete()
+	if err != nil {
+		return err
+	}
 
 	// Find the list of all clones of the filesystem, as we need to delete each independently.
 	filesystems := d.state.registry.ClonesFor(filesystem.MasterBranch.Id)
@@ -2312,6 +3141,11 @@ func (d *DotmeshRPC) Delete(r *http.Request, args *VolumeName, result *bool) err
 		}
 	}
 
+	d.state.publishEvent(filesystem, &types.EventNotification{
+		Type:         types.EventTypeDotDeleted,
+		FilesystemId: filesystem.MasterBranch.Id,
+	})
+
 	*result = true
 	return nil
 }
@@ -2352,6 +3186,7 @@ func (d *DotmeshRPC) SetDebugFlag(
 	case "ForceStateMachineToDiscovering":
 		filesystemId := args.FlagValue
 		responseChan, err := d.state.globalFsRequest(
+			r.Context(),
 			filesystemId,
 			&Event{Name: "deliberately-unhandled-event-for-test-purposes"},
 		)
@@ -2592,6 +3427,17 @@ func (d *DotmeshRPC) DumpInternalState(
 		resultChan <- []string{"etcdWait.DONE", "yes"}
 	}()
 
+	go func() {
+		defer recoverFromPanic() // Don't kill the entire server if resultChan is closed because we took too long
+		resultChan <- []string{"commitMounts.STARTED", "yes"}
+		s.commitMountsLock.Lock()
+		defer s.commitMountsLock.Unlock()
+		for key, mount := range s.commitMounts {
+			resultChan <- []string{fmt.Sprintf("commitMounts.%s", key), toJsonString(mount.Holders)}
+		}
+		resultChan <- []string{"commitMounts.DONE", "yes"}
+	}()
+
 	resultChan <- []string{"myNodeId", s.zfs.GetPoolID()}
 	resultChan <- []string{"versionInfo", toJsonString(s.versionInfo)}
 
@@ -2887,38 +3733,179 @@ func (d *DotmeshRPC) ForceBranchMasterById(
 ) error {
 	log.Printf("[ForceBranchMasterById] being called with: %+v", args)
 
-	kapi, err := getEtcdKeysApi()
-	if err != nil {
-		return err
-	}
-
 	newMaster := args.Master
 	if newMaster == "" {
 		// Default is THIS node
 		newMaster = d.state.zfs.GetPoolID()
 	}
 
-	key := fmt.Sprintf(
-		"%s/filesystems/masters/%s", ETCD_PREFIX, args.FilesystemId,
-	)
+	err := forceBranchMaster(args.FilesystemId, newMaster, "")
+	if err != nil {
+		return err
+	}
 
-	log.Printf("[ForceBranchMasterById] settings %s to %s", key, newMaster)
+	*result = true
+	return nil
+}
 
-	_, err = kapi.Set(
-		context.Background(),
-		key,
-		newMaster,
-		&client.SetOptions{},
-	)
+// NodeStatus - the branch masters and running transfers on a node (the one
+// answering if none is given), for taking it out of service
+func (d *DotmeshRPC) NodeStatus(
+	r *http.Request,
+	args *struct{ Node string },
+	result *types.NodeStatus,
+) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
+	}
+	node := args.Node
+	if node == "" {
+		node = d.state.NodeID()
+	}
+	*result = d.state.nodeStatus(node)
+	return nil
+}
+
+// Cordon - stops a node procuring dots or having masters moved to it, the
+// first step in draining it
+func (d *DotmeshRPC) Cordon(
+	r *http.Request,
+	args *struct{ Node string },
+	result *bool,
+) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
+	}
+	if args.Node == "" {
+		return fmt.Errorf("No node given")
+	}
+	err = d.state.cordonNode(args.Node)
+	if err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+func (d *DotmeshRPC) Uncordon(
+	r *http.Request,
+	args *struct{ Node string },
+	result *bool,
+) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
+	}
+	if args.Node == "" {
+		return fmt.Errorf("No node given")
+	}
+	err = d.state.uncordonNode(args.Node)
+	if err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
+
+// ensureTLS - an error unless the request came over TLS, for RPCs carrying
+// a CA's private key
+func ensureTLS(r *http.Request) error {
+	if r.TLS == nil {
+		return fmt.Errorf(
+			"Refusing to send a CA's private key over plain HTTP, " +
+				"the cluster must serve TLS ('dm cluster init --tls') to rotate to a new CA",
+		)
+	}
+	return nil
+}
+
+// PublishCA - stores a CA the cluster is rotating to, for the other nodes
+// to fetch with PublishedCA
+func (d *DotmeshRPC) PublishCA(
+	r *http.Request,
+	args *types.ClusterCA,
+	result *bool,
+) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
+	}
+	err = ensureTLS(r)
+	if err != nil {
+		return err
+	}
+	if args.Cert == "" || args.Key == "" {
+		return fmt.Errorf("No CA certificate and key given")
+	}
+	err = d.state.publishCA(*args)
+	if err != nil {
+		return err
+	}
+	*result = true
+	return nil
+}
 
+func (d *DotmeshRPC) PublishedCA(
+	r *http.Request,
+	args *struct{},
+	result *types.ClusterCA,
+) error {
+	err := ensureAdminUser(r)
 	if err != nil {
 		return err
 	}
+	err = ensureTLS(r)
+	if err != nil {
+		return err
+	}
+	ca, err := d.state.publishedCA()
+	if err != nil {
+		return err
+	}
+	*result = ca
+	return nil
+}
 
+// UnpublishCA - deletes the CA published with PublishCA, once every node
+// has stopped trusting the old one, so its private key doesn't stay in etcd
+func (d *DotmeshRPC) UnpublishCA(
+	r *http.Request,
+	args *struct{},
+	result *bool,
+) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
+	}
+	err = d.state.unpublishCA()
+	if err != nil {
+		return err
+	}
 	*result = true
 	return nil
 }
 
+// MoveBranchMaster - hands a branch off from its master to another node,
+// returning the node it was moved to
+func (d *DotmeshRPC) MoveBranchMaster(
+	r *http.Request,
+	args *types.MoveBranchMasterRequest,
+	result *string,
+) error {
+	err := ensureAdminUser(r)
+	if err != nil {
+		return err
+	}
+	target, err := d.state.moveBranchMaster(r.Context(), args.FilesystemId, args.Candidates, args.Force)
+	if err != nil {
+		return err
+	}
+	*result = target
+	return nil
+}
+
 func (d *DotmeshRPC) CheckNameIsValid(
 	r *http.Request,
 	args *struct{ Namespace, Name, Branch string },
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/coreos/etcd/client"
+	"github.com/dotmesh-io/dotmesh/pkg/auth"
+	"github.com/dotmesh-io/dotmesh/pkg/changerequests"
+	"github.com/dotmesh-io/dotmesh/pkg/fsm"
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/quota"
+	"github.com/dotmesh-io/dotmesh/pkg/registry"
+	"github.com/dotmesh-io/dotmesh/pkg/testutil"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/dotmesh-io/dotmesh/pkg/user"
+	"github.com/dotmesh-io/dotmesh/pkg/zfs"
+)
+
+// requestAs - an RPC request authenticated with a password as the user with
+// id userId
+func requestAs(userId string) *http.Request {
+	return requestAsUser(&user.User{Id: userId, Name: userId})
+}
+
+func requestAsUser(u *user.User) *http.Request {
+	r := httptest.NewRequest("POST", "/rpc", nil)
+	return auth.SetAuthenticationDetails(r, u, user.AuthenticationTypePassword)
+}
+
+// testCluster - an RPC server whose registry, users and change requests are
+// kept in a throwaway etcd, with the dot alice/db, which bob collaborates
+// on and has forked to bob/db, and a user carol who has nothing to do with
+// either
+type testCluster struct {
+	rpc               *DotmeshRPC
+	registry          *registry.DefaultRegistry
+	alice, bob, carol *user.User
+	etcdClient        client.KeysAPI
+	prefix            string
+}
+
+const (
+	testDotId  = "db-id"
+	testForkId = "fork-id"
+)
+
+func newTestCluster(t *testing.T) (*testCluster, func()) {
+	etcdClient, teardown, err := testutil.GetEtcdClient()
+	if err != nil {
+		t.Fatalf("failed to get etcd client: %s", err)
+	}
+	prefix := testutil.GetTestPrefix()
+	kvClient := kv.New(etcdClient, prefix)
+	um := user.New(kvClient)
+	c := &testCluster{
+		registry:   registry.NewRegistry(um, etcdClient, prefix),
+		etcdClient: etcdClient,
+		prefix:     prefix,
+	}
+	newUser := func(name string) *user.User {
+		u, err := um.New(name, name+"@example.com", "verysecret")
+		if err != nil {
+			teardown()
+			t.Fatalf("failed to create user %s: %s", name, err)
+		}
+		return u
+	}
+	c.alice, c.bob, c.carol = newUser("alice"), newUser("bob"), newUser("carol")
+
+	dots := map[VolumeName]types.RegistryFilesystem{
+		{Namespace: "alice", Name: "db"}: {Id: testDotId, OwnerId: c.alice.Id, CollaboratorIds: []string{c.bob.Id}},
+		{Namespace: "bob", Name: "db"}:   {Id: testForkId, OwnerId: c.bob.Id, ForkParentId: testDotId},
+	}
+	for name, rf := range dots {
+		err = c.registerDot(name, rf)
+		if err != nil {
+			teardown()
+			t.Fatalf("failed to register %s: %s", name, err)
+		}
+	}
+	c.rpc = &DotmeshRPC{state: &InMemoryState{
+		registry:             c.registry,
+		changeRequestManager: changerequests.New(kvClient),
+		quotaManager:         quota.New(kvClient),
+		globalDirtyCache:     map[string]dirtyInfo{},
+		globalDirtyCacheLock: &sync.RWMutex{},
+		filesystems:          map[string]fsm.FSM{},
+		filesystemsLock:      &sync.RWMutex{},
+		zfs:                  fakeZFS{poolId: testNodeId},
+	}}
+	return c, teardown
+}
+
+// registerDot - stores the dot in etcd, as if it had been created, and in
+// the registry
+func (c *testCluster) registerDot(name VolumeName, rf types.RegistryFilesystem) error {
+	serialized, err := json.Marshal(rf)
+	if err != nil {
+		return err
+	}
+	_, err = c.etcdClient.Set(
+		context.Background(),
+		fmt.Sprintf("%s/registry/filesystems/%s/%s", c.prefix, name.Namespace, name.Name),
+		string(serialized),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	return c.registry.UpdateFilesystemFromEtcd(name, rf)
+}
+
+const testNodeId = "node1"
+
+// fakeZFS - just enough of a pool for the node to have an id
+type fakeZFS struct {
+	zfs.ZFS
+	poolId string
+}
+
+func (z fakeZFS) GetPoolID() string {
+	return z.poolId
+}
+
+// fakeFSM - just enough of a filesystem's state machine to know the commits
+// on each node
+type fakeFSM struct {
+	fsm.FSM
+	snapshots map[string][]*types.Snapshot
+}
+
+func (f *fakeFSM) GetSnapshots(nodeId string) []*types.Snapshot {
+	return f.snapshots[nodeId]
+}
+
+func (f *fakeFSM) ListSnapshots() map[string][]*types.Snapshot {
+	return f.snapshots
+}
+
+func (f *fakeFSM) QuerySnapshots(nodeId string, query types.CommitQuery) (types.CommitPage, error) {
+	page := types.CommitPage{Commits: []types.Snapshot{}}
+	for _, snapshot := range f.snapshots[nodeId] {
+		if query.Matches(snapshot) {
+			page.Commits = append(page.Commits, *snapshot)
+		}
+	}
+	return page, nil
+}
+
+// setCommits - puts the commits on a filesystem mastered by this node
+func (c *testCluster) setCommits(filesystemId string, snapshots ...*types.Snapshot) {
+	c.registry.SetMasterNode(filesystemId, testNodeId)
+	c.rpc.state.filesystems[filesystemId] = &fakeFSM{
+		snapshots: map[string][]*types.Snapshot{testNodeId: snapshots},
+	}
+}
+
+func TestCommitMountRPCsNeedAdmin(t *testing.T) {
+	d := &DotmeshRPC{}
+	args := &struct{ FilesystemId, CommitId, Holder string }{"fs1", "commit1", ""}
+
+	var path string
+	err := d.MountCommit(requestAs("bob"), args, &path)
+	if err == nil {
+		t.Error("expected MountCommit to be refused for a user who isn't admin")
+	}
+	var ok bool
+	err = d.UnmountCommit(requestAs("bob"), args, &ok)
+	if err == nil {
+		t.Error("expected UnmountCommit to be refused for a user who isn't admin")
+	}
+
+	// the admin can't let go of docker or symlink holders either
+	args.Holder = "docker:abc123"
+	err = d.UnmountCommit(requestAs(ADMIN_USER_UUID), args, &ok)
+	if err == nil {
+		t.Error("expected UnmountCommit to refuse a docker holder")
+	}
+}
+
+func TestCARPCsNeedAdminAndTLS(t *testing.T) {
+	d := &DotmeshRPC{}
+	overTLS := func(r *http.Request) *http.Request {
+		r.TLS = &tls.ConnectionState{}
+		return r
+	}
+	ca := &types.ClusterCA{Cert: "cert", Key: "key"}
+
+	tests := []struct {
+		name string
+		r    *http.Request
+	}{
+		{"admin over plain HTTP", requestAs(ADMIN_USER_UUID)},
+		{"another user over TLS", overTLS(requestAs("bob"))},
+	}
+	for _, tt := range tests {
+		var ok bool
+		if err := d.PublishCA(tt.r, ca, &ok); err == nil {
+			t.Errorf("%s: expected PublishCA to be refused", tt.name)
+		}
+		var published types.ClusterCA
+		if err := d.PublishedCA(tt.r, &struct{}{}, &published); err == nil {
+			t.Errorf("%s: expected PublishedCA to be refused", tt.name)
+		}
+	}
+
+	var ok bool
+	if err := d.UnpublishCA(requestAs("bob"), &struct{}{}, &ok); err == nil {
+		t.Error("expected UnpublishCA to be refused for a user who isn't admin")
+	}
+}
+
+func TestQueryCommitsAuthorization(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	c.setCommits(testDotId, &types.Snapshot{Id: "c1", Metadata: types.Metadata{"author": "alice"}})
+
+	tests := []struct {
+		name    string
+		as      *user.User
+		allowed bool
+	}{
+		{"the dot's owner", c.alice, true},
+		{"a collaborator", c.bob, true},
+		{"the cluster's admin", &user.User{Id: ADMIN_USER_UUID, Name: "admin"}, true},
+		{"someone who can't see the dot", c.carol, false},
+	}
+	for _, tt := range tests {
+		args := &struct {
+			Namespace, Name, Branch string
+			Query                   types.CommitQuery
+		}{Namespace: "alice", Name: "db"}
+		var page types.CommitPage
+		err := c.rpc.QueryCommits(requestAsUser(tt.as), args, &page)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got error: %v", tt.name, tt.allowed, err)
+			continue
+		}
+		if !tt.allowed && len(page.Commits) != 0 {
+			t.Errorf("%s: expected no commits, got: %v", tt.name, page.Commits)
+		}
+		if tt.allowed && len(page.Commits) != 1 {
+			t.Errorf("%s: expected the dot's commit, got: %v", tt.name, page.Commits)
+		}
+	}
+}
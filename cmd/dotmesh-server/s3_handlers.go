@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -12,6 +13,9 @@ import (
 
 	"github.com/dotmesh-io/dotmesh/pkg/auth"
 	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/fsm"
+	"github.com/dotmesh-io/dotmesh/pkg/metrics"
+	"github.com/dotmesh-io/dotmesh/pkg/quota"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/user"
 	"github.com/gorilla/mux"
@@ -30,6 +34,8 @@ func NewS3Handler(state *InMemoryState) http.Handler {
 	}
 
 	h.ReverseProxy.Director = h.Director
+	// requests are proxied to other nodes in the cluster
+	h.ReverseProxy.Transport = dmclient.InternalHTTPClient().Transport
 
 	return h
 }
@@ -162,19 +168,55 @@ func (s *S3Handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	if ok {
 		switch req.Method {
 		case "GET":
+			resp, req = countS3Bytes("GetObject", resp, req)
 			s.readFile(resp, req, localFilesystemId, snapshotId, key)
 		case "PUT":
+			resp, req = countS3Bytes("PutObject", resp, req)
 			s.putObject(resp, req, localFilesystemId, key)
 		}
 	} else {
 		switch req.Method {
 		case "GET":
+			resp, req = countS3Bytes("ListObjects", resp, req)
 			s.listBucket(resp, req, bucketName, localFilesystemId, snapshotId)
 		}
 	}
 }
 
-func (s *S3Handler) mountFilesystemSnapshot(filesystemId string, snapshotId string) *Event {
+// countS3Bytes - wraps a request's body and response so that the bytes read
+// from and written to them are counted towards operation's S3 traffic
+// metrics. It's only done on the master node, so that proxied requests
+// aren't counted twice.
+func countS3Bytes(operation string, resp http.ResponseWriter, req *http.Request) (http.ResponseWriter, *http.Request) {
+	if req.Body != nil {
+		req.Body = &countingReadCloser{ReadCloser: req.Body, operation: operation}
+	}
+	return &countingResponseWriter{ResponseWriter: resp, operation: operation}, req
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	operation string
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	metrics.S3Bytes.WithLabelValues(c.operation, "in").Add(float64(n))
+	return n, err
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	operation string
+}
+
+func (c *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	metrics.S3Bytes.WithLabelValues(c.operation, "out").Add(float64(n))
+	return n, err
+}
+
+func (s *S3Handler) mountFilesystemSnapshot(ctx context.Context, filesystemId string, snapshotId string) *Event {
 	snapshots, err := s.state.SnapshotsForCurrentMaster(filesystemId)
 	if err != nil {
 		return types.NewErrorEvent("snapshots-error", err)
@@ -188,6 +230,7 @@ func (s *S3Handler) mountFilesystemSnapshot(filesystemId string, snapshotId stri
 		mountSnapshotId = snapshotId
 	}
 	responseChan, err := s.state.globalFsRequest(
+		ctx,
 		filesystemId,
 		&Event{Name: "mount-snapshot",
 			Args: &EventArgs{"snapId": mountSnapshotId}},
@@ -215,7 +258,7 @@ func (s *S3Handler) readFile(resp http.ResponseWriter, req *http.Request, filesy
 
 	// we must first mount the given snapshot before we try to read a file within it
 	// if snapshotId is not given then the latest snapshot id will be used
-	e := s.mountFilesystemSnapshot(filesystemId, snapshotId)
+	e := s.mountFilesystemSnapshot(req.Context(), filesystemId, snapshotId)
 
 	// the snapshot has been mounted - pass the SnapshotMountPath via the
 	// OutputFile to the fileOutputIO channel to get handled
@@ -257,29 +300,72 @@ func (s *S3Handler) readFile(resp http.ResponseWriter, req *http.Request, filesy
 
 func (s *S3Handler) putObject(resp http.ResponseWriter, req *http.Request, filesystemId, filename string) {
 	user := auth.GetUserFromCtx(req.Context())
-	fsm, err := s.state.InitFilesystemMachine(filesystemId)
+
+	// ContentLength is -1 when unknown, in which case only reject uploads
+	// once the quota has already been used up
+	var uploadSize int64
+	if req.ContentLength > 0 {
+		uploadSize = req.ContentLength
+	}
+
+	// user-defined object metadata (x-amz-meta-<key> headers) becomes
+	// metadata of the commit, which the branch may require some of
+	meta := types.Metadata{}
+	for header, values := range req.Header {
+		if strings.HasPrefix(header, "X-Amz-Meta-") && len(values) > 0 {
+			meta[strings.ToLower(strings.TrimPrefix(header, "X-Amz-Meta-"))] = values[0]
+		}
+	}
+	upload := &types.InputFile{
+		Filename: filename,
+		Contents: req.Body,
+		User:     user.Name,
+		Metadata: meta,
+	}
+	tlf, branch, err := s.state.registry.LookupFilesystemById(filesystemId)
+	if err != nil {
+		http.Error(resp, "failed to find filesystem: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = tlf.CheckMetadata(branch, "", fsm.UploadMetadata(upload, uploadSize))
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	fsMachine, err := s.state.InitFilesystemMachine(filesystemId)
 	if err != nil {
 		http.Error(resp, "failed to initialize filesystem", http.StatusInternalServerError)
 		return
 	}
 
-	if fsm.GetCurrentState() != "active" {
+	if fsMachine.GetCurrentState() != "active" {
 		http.Error(resp, "please try again later", http.StatusServiceUnavailable)
 		return
 	}
 
 	defer req.Body.Close()
+
+	err = s.state.checkQuotas(filesystemId, uploadSize)
+	if err != nil {
+		if _, ok := err.(quota.ExceededError); ok {
+			http.Error(resp, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(resp, "failed to check quota: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	respCh := make(chan *Event)
-	fsm.WriteFile(&types.InputFile{
-		Filename: filename,
-		Contents: req.Body,
-		User:     user.Name,
-		Response: respCh,
-	})
+	upload.Response = respCh
+	fsMachine.WriteFile(upload)
 
 	result := <-respCh
 
 	switch result.Name {
+	case types.EventNameSaveRejected:
+		// a pre-commit hook turned the upload down
+		http.Error(resp, (*result.Args)["message"].(string), http.StatusForbidden)
 	case types.EventNameSaveFailed:
 		e, ok := (*result.Args)["err"].(string)
 		if ok {
@@ -308,7 +394,7 @@ type BucketObject struct {
 func (s *S3Handler) listBucket(resp http.ResponseWriter, req *http.Request, name string, filesystemId string, snapshotId string) {
 
 	start := time.Now()
-	e := s.mountFilesystemSnapshot(filesystemId, snapshotId)
+	e := s.mountFilesystemSnapshot(req.Context(), filesystemId, snapshotId)
 	if time.Since(start) > 2*time.Second {
 		log.WithFields(log.Fields{
 			"filesystem_id": filesystemId,
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/dotmesh-io/dotmesh/pkg/validator"
+)
+
+// Space accounting asks ZFS on each branch's master node, so unlike the
+// SizeBytes and DirtyBytes in the dirty cache it's exact, but costs a
+// request per branch.
+
+// branchSpaceUsage - space used by a branch and each of its commits
+func (s *InMemoryState) branchSpaceUsage(ctx context.Context, filesystemId string) (types.SpaceUsage, []types.CommitSpaceUsage, error) {
+	responseChan, err := s.globalFsRequest(ctx, filesystemId, &Event{Name: "space-usage"})
+	if err != nil {
+		return types.SpaceUsage{}, nil, err
+	}
+	e := <-responseChan
+	if e.Name != "space-usage" {
+		return types.SpaceUsage{}, nil, maybeError(e, "space-usage")
+	}
+	var usage types.SpaceUsage
+	var commits []types.CommitSpaceUsage
+	err = decodeEventArg((*e.Args)["Usage"], &usage)
+	if err != nil {
+		return types.SpaceUsage{}, nil, err
+	}
+	err = decodeEventArg((*e.Args)["Commits"], &commits)
+	if err != nil {
+		return types.SpaceUsage{}, nil, err
+	}
+	return usage, commits, nil
+}
+
+// predictReclaim - bytes freed by deleting the commits fromCommitId to
+// toCommitId of a branch or, if fromCommitId is empty, the whole branch and
+// the branches made from it
+func (s *InMemoryState) predictReclaim(ctx context.Context, filesystemId, fromCommitId, toCommitId string) (int64, error) {
+	responseChan, err := s.globalFsRequest(ctx, filesystemId, &Event{
+		Name: "predict-reclaim",
+		Args: &EventArgs{"FromSnapshotId": fromCommitId, "ToSnapshotId": toCommitId},
+	})
+	if err != nil {
+		return 0, err
+	}
+	e := <-responseChan
+	if e.Name != "predicted-reclaim" {
+		return 0, maybeError(e, "predicted-reclaim")
+	}
+	// events from other nodes have been through JSON
+	switch reclaim := (*e.Args)["Bytes"].(type) {
+	case int64:
+		return reclaim, nil
+	case float64:
+		return int64(reclaim), nil
+	}
+	return 0, fmt.Errorf("Unexpected reclaim estimate %#v", (*e.Args)["Bytes"])
+}
+
+// decodeEventArg - copies an event argument into out, whether it's the value
+// a local state machine put there or what JSON made of one from another node
+func decodeEventArg(in interface{}, out interface{}) error {
+	bts, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bts, out)
+}
+
+// authorizeSpaceUsage - the filesystem IDs of the branches of a dot the
+// authenticated user can see, by branch name
+func (d *DotmeshRPC) authorizeSpaceUsage(r *http.Request, namespace, name string) (map[string]string, error) {
+	err := validator.IsValidVolume(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	tlf, err := d.state.registry.LookupFilesystem(VolumeName{Namespace: namespace, Name: name})
+	if err != nil {
+		return nil, err
+	}
+	authorized, err := tlf.Authorize(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	if !authorized {
+		return nil, PermissionDenied{}
+	}
+	branches := map[string]string{DEFAULT_BRANCH: tlf.MasterBranch.Id}
+	for branch, clone := range d.state.registry.ClonesFor(tlf.MasterBranch.Id) {
+		branches[branch] = clone.FilesystemId
+	}
+	return branches, nil
+}
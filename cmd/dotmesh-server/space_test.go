@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/dotmesh-io/dotmesh/pkg/user"
+)
+
+func TestAuthorizeSpaceUsage(t *testing.T) {
+	c, teardown := newTestCluster(t)
+	defer teardown()
+	c.registry.UpdateCloneFromEtcd("feature", testDotId, types.Clone{FilesystemId: "feature-id"})
+
+	tests := []struct {
+		name    string
+		as      *user.User
+		allowed bool
+	}{
+		{"the dot's owner", c.alice, true},
+		{"a collaborator", c.bob, true},
+		{"the cluster's admin", &user.User{Id: ADMIN_USER_UUID, Name: "admin"}, true},
+		{"someone who can't see the dot", c.carol, false},
+	}
+	for _, tt := range tests {
+		branches, err := c.rpc.authorizeSpaceUsage(requestAsUser(tt.as), "alice", "db")
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got error: %v", tt.name, tt.allowed, err)
+			continue
+		}
+		if tt.allowed && (branches[DEFAULT_BRANCH] != testDotId || branches["feature"] != "feature-id" || len(branches) != 2) {
+			t.Errorf("%s: expected master and feature, got: %v", tt.name, branches)
+		}
+	}
+
+	_, err := c.rpc.authorizeSpaceUsage(requestAsUser(c.alice), "alice", "missing")
+	if err == nil {
+		t.Error("expected a dot that doesn't exist to be an error")
+	}
+}
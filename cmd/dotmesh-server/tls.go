@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// TLS
+//
+// With DOTMESH_TLS set, the API on SERVER_PORT (RPC, S3, replication and
+// /metrics) is served over TLS instead of plain HTTP. The certificate is the
+// one 'dm cluster init/join' issues each node from the cluster CA
+// (apiserver.pem, valid for the node's addresses), and other nodes are
+// trusted if their certificates were issued from it too. A certificate and
+// key can also be given with DOTMESH_TLS_CERT_FILE and DOTMESH_TLS_KEY_FILE,
+// e.g. for a public hostname: it's presented to clients asking for a name it
+// is valid for, and the cluster certificate (if any) to the rest, which
+// includes other nodes connecting by address.
+//
+// Nodes serving TLS only talk to each other over TLS. While it's being turned
+// on one node at a time, DOTMESH_TLS_ROLLOUT lets them fall back to plain
+// HTTP for nodes that haven't been seen serving TLS yet.
+
+// loadTLSConfig - how to serve the API, nil for plain HTTP. Also sets up
+// connections to other nodes to check their certificates
+func loadTLSConfig() (*tls.Config, error) {
+	certFile := os.Getenv("DOTMESH_TLS_CERT_FILE")
+	keyFile := os.Getenv("DOTMESH_TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("DOTMESH_TLS_CERT_FILE and DOTMESH_TLS_KEY_FILE must be set together")
+	}
+
+	pkiPath := getPkiPath()
+	internal := &types.RemoteTLS{}
+	ca, err := ioutil.ReadFile(fmt.Sprintf("%s/ca.pem", pkiPath))
+	if err == nil {
+		internal.CACert = string(ca)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	rollout := os.Getenv("DOTMESH_TLS_ROLLOUT") != ""
+	if os.Getenv("DOTMESH_TLS") == "" && certFile == "" {
+		if internal.CACert != "" && rollout {
+			// other nodes may serve TLS already
+			return nil, dmclient.SetInternalTLS(internal, false, true)
+		}
+		return nil, nil
+	}
+
+	var clusterCert, customCert *tls.Certificate
+	if internal.CACert != "" {
+		cert, err := tls.LoadX509KeyPair(
+			fmt.Sprintf("%s/apiserver.pem", pkiPath),
+			fmt.Sprintf("%s/apiserver-key.pem", pkiPath),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the cluster certificate: %s", err)
+		}
+		clusterCert = &cert
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the certificate in %s: %s", certFile, err)
+		}
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		customCert = &cert
+	}
+	if clusterCert == nil && customCert == nil {
+		return nil, fmt.Errorf(
+			"DOTMESH_TLS is set, but there's no cluster CA in %s and no "+
+				"DOTMESH_TLS_CERT_FILE", pkiPath,
+		)
+	}
+
+	err = dmclient.SetInternalTLS(internal, true, rollout)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"cluster_certificate": clusterCert != nil,
+		"certificate_file":    certFile,
+		"http_fallback":       rollout,
+	}).Info("Serving the API over TLS")
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if customCert == nil {
+				return clusterCert, nil
+			}
+			if clusterCert == nil ||
+				(hello.ServerName != "" && customCert.Leaf.VerifyHostname(hello.ServerName) == nil) {
+				return customCert, nil
+			}
+			return clusterCert, nil
+		},
+	}, nil
+}
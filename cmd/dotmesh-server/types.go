@@ -1,11 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/changerequests"
 	"github.com/dotmesh-io/dotmesh/pkg/container"
 	"github.com/dotmesh-io/dotmesh/pkg/messaging/nats"
 
-	"github.com/coreos/etcd/client"
-
+	"github.com/dotmesh-io/dotmesh/pkg/hooks"
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/notification/webhook"
+	"github.com/dotmesh-io/dotmesh/pkg/quota"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/user"
 )
@@ -15,7 +22,9 @@ const DEFAULT_BRANCH = "master"
 type dirtyInfo struct {
 	Server     string
 	DirtyBytes int64
-	SizeBytes  int64
+	// SizeBytes - the ZFS "used" of the filesystem, which includes its
+	// commits but not the data it shares with the commit it was cloned from
+	SizeBytes int64
 }
 
 type PermissionDenied struct {
@@ -89,8 +98,24 @@ type TransferPollResult = types.TransferPollResult
 
 type Config struct {
 	FilesystemMetadataTimeout int64
-	UserManager               user.UserManager
-	EtcdClient                client.KeysAPI
+	// How long a node has to have been gone before the branches it's the
+	// master of are failed over to other nodes, zero (the default) to never
+	// fail them over
+	MasterFailoverGracePeriod time.Duration
+	// Whether to fail branches over to nodes that are missing some of their
+	// commits, losing them, when no node has them all
+	MasterFailoverForce bool
+	UserManager         user.UserManager
+	QuotaManager        quota.Manager
+	WebhookManager      webhook.Manager
+	// loopback, link-local and private addresses webhooks may be delivered
+	// to, which they otherwise can't be
+	WebhookAllowedNetworks []*net.IPNet
+	HookManager            hooks.Manager
+	ChangeRequestManager   changerequests.Manager
+	EtcdClient             kv.Store
+	// how to serve the API, nil for plain HTTP
+	TLSConfig *tls.Config
 
 	// variables used to create fsm.FsMachine
 	ZFSExecPath string
@@ -378,7 +378,7 @@ func tryUntilSucceedsN(f func() error, desc string, retries int) error {
 // if the PRINT_QUIET_LOGS env is not empty
 func quietLogger(logMessage string) {
 	if os.Getenv("PRINT_QUIET_LOGS") != "" {
-		log.Printf(logMessage)
+		log.Print(logMessage)
 	}
 }
 
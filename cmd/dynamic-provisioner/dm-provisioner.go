@@ -93,6 +93,24 @@ func (p *dotmeshProvisioner) Provision(options controller.VolumeOptions) (*v1.Pe
 	if !ok {
 		subdot = ""
 	}
+
+	// Restoring from history: a claim with a dotmeshCommit annotation gets a
+	// new branch of the dot, cloned from that commit of dotmeshSourceBranch
+	// (master by default). The branch is named after the claim unless
+	// dotmeshBranch says otherwise, and is created by the flexvolume driver
+	// when the volume is first mounted. dotmeshBranch on its own mounts an
+	// existing branch.
+	branch := annotations["dotmeshBranch"]
+	commit := annotations["dotmeshCommit"]
+	sourceBranch := annotations["dotmeshSourceBranch"]
+	if commit != "" {
+		if branch == "" {
+			branch = options.PVC.ObjectMeta.Name
+		}
+		if sourceBranch == "" {
+			sourceBranch = "master"
+		}
+	}
 	/*
 		// Cover two cases: Creating a new volume, or connecting to an existing volume
 
@@ -138,6 +156,9 @@ func (p *dotmeshProvisioner) Provision(options controller.VolumeOptions) (*v1.Pe
 	*/
 
 	glog.Info(fmt.Sprintf("Creating PV %s in response to PVC %s: %s/%s.%s", options.PVName, options.PVC.ObjectMeta.Name, namespace, name, subdot))
+	if commit != "" {
+		glog.Info(fmt.Sprintf("PV %s restores commit %s of %s/%s@%s to branch %s", options.PVName, commit, namespace, name, sourceBranch, branch))
+	}
 
 	pv := &v1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
@@ -145,9 +166,12 @@ func (p *dotmeshProvisioner) Provision(options controller.VolumeOptions) (*v1.Pe
 			Annotations: map[string]string{
 				// Record some stuff we have, in case it's useful for debugging or anything
 				// But not the API key, obviously.
-				"dotmeshNamespace": namespace,
-				"dotmeshName":      name,
-				"dotmeshSubdot":    subdot,
+				"dotmeshNamespace":    namespace,
+				"dotmeshName":         name,
+				"dotmeshSubdot":       subdot,
+				"dotmeshBranch":       branch,
+				"dotmeshCommit":       commit,
+				"dotmeshSourceBranch": sourceBranch,
 			},
 		},
 		Spec: v1.PersistentVolumeSpec{
@@ -163,9 +187,12 @@ func (p *dotmeshProvisioner) Provision(options controller.VolumeOptions) (*v1.Pe
 					Driver: "dotmesh.io/dm",
 					FSType: "zfs",
 					Options: map[string]string{
-						"name":      name,
-						"namespace": namespace,
-						"subdot":    subdot,
+						"name":         name,
+						"namespace":    namespace,
+						"subdot":       subdot,
+						"branch":       branch,
+						"commit":       commit,
+						"sourceBranch": sourceBranch,
 					},
 				},
 			},
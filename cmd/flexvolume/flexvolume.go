@@ -95,6 +95,21 @@ func (d *FlexVolumeDriver) mount(targetMountDir, jsonOptions string) (map[string
 	binaryDir := filepath.Dir(os.Args[0])
 	fvSocket := binaryDir + "/dm.sock"
 
+	branch, _ := opts["branch"].(string)
+	if branch != "" {
+		commit, _ := opts["commit"].(string)
+		if commit != "" {
+			sourceBranch, _ := opts["sourceBranch"].(string)
+			err := restoreBranch(fvSocket, namespace, name, sourceBranch, branch, commit)
+			if err != nil {
+				logger.Printf("MOUNT: Restoring commit %s of %s/%s@%s to branch %s failed: %+v", commit, namespace, name, sourceBranch, branch, err)
+				return opts, err
+			}
+		}
+		// Procure takes the branch as part of the name
+		name = name + "@" + branch
+	}
+
 	err := doRPC(
 		fvSocket,
 		"DotmeshRPC.Procure",
@@ -143,6 +158,41 @@ func (d *FlexVolumeDriver) mount(targetMountDir, jsonOptions string) (map[string
 	return nil, nil
 }
 
+// restoreBranch - clones a new branch from a commit, unless a previous mount
+// already did
+func restoreBranch(fvSocket, namespace, name, sourceBranch, branch, commit string) error {
+	var fsId string
+	err := doRPC(
+		fvSocket,
+		"DotmeshRPC.Exists",
+		struct{ Namespace, Name, Branch string }{namespace, name, branch},
+		&fsId,
+	)
+	if err != nil {
+		return err
+	}
+	if fsId != "" {
+		return nil
+	}
+	if sourceBranch == "" {
+		sourceBranch = "master"
+	}
+	var result bool
+	err = doRPC(
+		fvSocket,
+		"DotmeshRPC.Branch",
+		struct{ Namespace, Name, SourceBranch, NewBranchName, SourceCommitId string }{
+			namespace, name, sourceBranch, branch, commit,
+		},
+		&result,
+	)
+	if err != nil {
+		return err
+	}
+	logger.Printf("MOUNT: Restored commit %s of %s/%s@%s to branch %s", commit, namespace, name, sourceBranch, branch)
+	return nil
+}
+
 // Invocation: <driver executable> unmount <mount dir>
 func (d *FlexVolumeDriver) unmount(targetMountDir string) (map[string]interface{}, error) {
 	// TODO
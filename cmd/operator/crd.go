@@ -0,0 +1,301 @@
+package main
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/rest"
+)
+
+// Custom resources for managing dots declaratively. The CRDs themselves are
+// in kubernetes/dotmesh-crds.yaml.
+
+const CRD_GROUP = "dotmesh.io"
+const CRD_VERSION = "v1alpha1"
+
+var crdGroupVersion = schema.GroupVersion{Group: CRD_GROUP, Version: CRD_VERSION}
+
+// Dot retention policies: what happens to the dot when its Dot resource is
+// deleted
+const RETENTION_RETAIN = "Retain"
+const RETENTION_DELETE = "Delete"
+
+// Finalizer holding on to Dots with the Delete retention policy until their
+// dot is gone
+const DOT_FINALIZER = "dotmesh.io/delete-dot"
+
+// Condition types and statuses reported on the resources
+const CONDITION_READY = "Ready"
+const CONDITION_SYNCED = "Synced"
+const CONDITION_TRUE = "True"
+const CONDITION_FALSE = "False"
+
+type Condition struct {
+	Type               string       `json:"type"`
+	Status             string       `json:"status"`
+	Reason             string       `json:"reason,omitempty"`
+	Message            string       `json:"message,omitempty"`
+	LastTransitionTime meta_v1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// Dot - a dot and its branches and collaborators
+type Dot struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DotSpec   `json:"spec"`
+	Status DotStatus `json:"status,omitempty"`
+}
+
+type DotSpec struct {
+	// Namespace and Name of the dot, defaulting to the namespace and name of
+	// the resource. Other dotmesh namespaces can only be used from the
+	// Kubernetes namespaces listed in dots.adminNamespaces in the operator's
+	// configuration.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Branches to create from the latest commit on master, if they don't
+	// already exist. Branches aren't deleted when they're removed from here.
+	Branches []string `json:"branches,omitempty"`
+	// Collaborators are usernames. Collaborators not listed are removed.
+	Collaborators []string `json:"collaborators,omitempty"`
+	// Retention is Retain (the default) to keep the dot when the resource
+	// is deleted, or Delete to delete it too
+	Retention string `json:"retention,omitempty"`
+}
+
+type DotStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// OwnerUID is the UID of the resource the dot was created for. The
+	// operator only manages dots it created, for the resource that asked for
+	// them, so a Dot naming an existing dot can't take it over.
+	OwnerUID     string      `json:"ownerUID,omitempty"`
+	FilesystemId string      `json:"filesystemId,omitempty"`
+	Branches     []string    `json:"branches,omitempty"`
+	Conditions   []Condition `json:"conditions,omitempty"`
+}
+
+type DotList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Dot `json:"items"`
+}
+
+// DotRemote - another dotmesh cluster or an S3 bucket that dots can be synced
+// with. Credentials come from a secret in the same namespace.
+type DotRemote struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DotRemoteSpec   `json:"spec"`
+	Status DotRemoteStatus `json:"status,omitempty"`
+}
+
+const REMOTE_TYPE_DOTMESH = "dotmesh"
+const REMOTE_TYPE_S3 = "s3"
+
+type DotRemoteSpec struct {
+	// Type is dotmesh (the default) or s3
+	Type string `json:"type,omitempty"`
+
+	// For dotmesh remotes
+	Hostname string `json:"hostname,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	User     string `json:"user,omitempty"`
+
+	// For S3 remotes, the endpoint defaults to AWS
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// SecretName is the secret holding apiKey for dotmesh remotes, or
+	// accessKeyId and secretAccessKey for S3 remotes
+	SecretName string `json:"secretName"`
+}
+
+type DotRemoteStatus struct {
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	Conditions         []Condition `json:"conditions,omitempty"`
+}
+
+type DotRemoteList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []DotRemote `json:"items"`
+}
+
+// DotSync - pushes a branch of a Dot to, or pulls it from, a DotRemote on a
+// schedule
+type DotSync struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DotSyncSpec   `json:"spec"`
+	Status DotSyncStatus `json:"status,omitempty"`
+}
+
+type DotSyncSpec struct {
+	// Dot and Remote are the names of resources in the same namespace
+	Dot    string `json:"dot"`
+	Remote string `json:"remote"`
+	// Direction is push (the default) or pull
+	Direction string `json:"direction,omitempty"`
+	// Branch defaults to master
+	Branch string `json:"branch,omitempty"`
+	// RemoteNamespace, RemoteName and RemoteBranch default to the remote
+	// user's namespace and the dot's name and branch
+	RemoteNamespace string `json:"remoteNamespace,omitempty"`
+	RemoteName      string `json:"remoteName,omitempty"`
+	RemoteBranch    string `json:"remoteBranch,omitempty"`
+	// IntervalSeconds between the end of one sync and the start of the
+	// next, defaulting to an hour
+	IntervalSeconds int64 `json:"intervalSeconds,omitempty"`
+}
+
+type DotSyncStatus struct {
+	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
+	TransferId         string       `json:"transferId,omitempty"`
+	LastSyncTime       meta_v1.Time `json:"lastSyncTime,omitempty"`
+	Conditions         []Condition  `json:"conditions,omitempty"`
+}
+
+type DotSyncList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []DotSync `json:"items"`
+}
+
+// newCRDClient - a REST client for the dotmesh.io resources
+func newCRDClient(config *rest.Config) (*rest.RESTClient, error) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(crdGroupVersion,
+		&Dot{}, &DotList{},
+		&DotRemote{}, &DotRemoteList{},
+		&DotSync{}, &DotSyncList{},
+	)
+	meta_v1.AddToGroupVersion(scheme, crdGroupVersion)
+
+	crdConfig := *config
+	crdConfig.GroupVersion = &crdGroupVersion
+	crdConfig.APIPath = "/apis"
+	crdConfig.ContentType = runtime.ContentTypeJSON
+	crdConfig.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+	return rest.RESTClientFor(&crdConfig)
+}
+
+// setCondition - adds or updates a condition, only moving its transition
+// time when its status changes
+func setCondition(conditions []Condition, c Condition) []Condition {
+	for i, existing := range conditions {
+		if existing.Type == c.Type {
+			if existing.Status == c.Status {
+				c.LastTransitionTime = existing.LastTransitionTime
+			} else {
+				c.LastTransitionTime = meta_v1.Now()
+			}
+			conditions[i] = c
+			return conditions
+		}
+	}
+	c.LastTransitionTime = meta_v1.Now()
+	return append(conditions, c)
+}
+
+// Deep copies, as required of runtime.Objects
+
+func copyConditions(conditions []Condition) []Condition {
+	if conditions == nil {
+		return nil
+	}
+	out := make([]Condition, len(conditions))
+	for i, c := range conditions {
+		out[i] = c
+		c.LastTransitionTime.DeepCopyInto(&out[i].LastTransitionTime)
+	}
+	return out
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func (d *Dot) DeepCopyInto(out *Dot) {
+	*out = *d
+	d.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec.Branches = copyStrings(d.Spec.Branches)
+	out.Spec.Collaborators = copyStrings(d.Spec.Collaborators)
+	out.Status.Branches = copyStrings(d.Status.Branches)
+	out.Status.Conditions = copyConditions(d.Status.Conditions)
+}
+
+func (d *Dot) DeepCopyObject() runtime.Object {
+	out := &Dot{}
+	d.DeepCopyInto(out)
+	return out
+}
+
+func (l *DotList) DeepCopyObject() runtime.Object {
+	out := &DotList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	if l.Items != nil {
+		out.Items = make([]Dot, len(l.Items))
+		for i := range l.Items {
+			l.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+func (r *DotRemote) DeepCopyInto(out *DotRemote) {
+	*out = *r
+	r.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Status.Conditions = copyConditions(r.Status.Conditions)
+}
+
+func (r *DotRemote) DeepCopyObject() runtime.Object {
+	out := &DotRemote{}
+	r.DeepCopyInto(out)
+	return out
+}
+
+func (l *DotRemoteList) DeepCopyObject() runtime.Object {
+	out := &DotRemoteList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	if l.Items != nil {
+		out.Items = make([]DotRemote, len(l.Items))
+		for i := range l.Items {
+			l.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+func (s *DotSync) DeepCopyInto(out *DotSync) {
+	*out = *s
+	s.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	s.Status.LastSyncTime.DeepCopyInto(&out.Status.LastSyncTime)
+	out.Status.Conditions = copyConditions(s.Status.Conditions)
+}
+
+func (s *DotSync) DeepCopyObject() runtime.Object {
+	out := &DotSync{}
+	s.DeepCopyInto(out)
+	return out
+}
+
+func (l *DotSyncList) DeepCopyObject() runtime.Object {
+	out := &DotSyncList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	if l.Items != nil {
+		out.Items = make([]DotSync, len(l.Items))
+		for i := range l.Items {
+			l.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/golang/glog"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// How often the dot resources are reconciled even when they haven't changed,
+// which is also the resolution of DotSync schedules
+const DOTS_RESYNC_PERIOD = 30 * time.Second
+const DEFAULT_SYNC_INTERVAL_SECONDS = 3600
+
+// dotsController - reconciles Dot, DotRemote and DotSync resources against
+// the dotmesh cluster, through the DotmeshRPC API as the admin user. Like
+// the rest of the operator it keeps no state of its own: everything it
+// needs to pick up where it left off is in the resources' status.
+type dotsController struct {
+	client    kubernetes.Interface
+	crdClient *rest.RESTClient
+	dotmesh   *client.JsonRpcClient
+
+	dotStore       cache.Store
+	remoteStore    cache.Store
+	syncStore      cache.Store
+	dotInformer    cache.Controller
+	remoteInformer cache.Controller
+	syncInformer   cache.Controller
+
+	// Kubernetes namespaces whose Dots may manage dots in any dotmesh
+	// namespace, rather than just the one named after their own
+	adminNamespaces []string
+
+	updatesNeeded     bool
+	updatesNeededLock *sync.Mutex
+}
+
+func newDotsController(client kubernetes.Interface, crdClient *rest.RESTClient, dotmesh *client.JsonRpcClient) *dotsController {
+	c := &dotsController{
+		client:            client,
+		crdClient:         crdClient,
+		dotmesh:           dotmesh,
+		updatesNeededLock: &sync.Mutex{},
+	}
+	config, err := client.Core().ConfigMaps(DOTMESH_NAMESPACE).Get(DOTMESH_CONFIG_MAP, meta_v1.GetOptions{})
+	if err != nil {
+		glog.Infof("Error fetching configmap %s/%s: %+v, Dots can only use their own namespace", DOTMESH_NAMESPACE, DOTMESH_CONFIG_MAP, err)
+	} else {
+		c.adminNamespaces = splitList(config.Data[CONFIG_DOTS_ADMIN_NAMESPACES])
+	}
+	handlers := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.scheduleUpdate() },
+		UpdateFunc: func(oldObj, newObj interface{}) { c.scheduleUpdate() },
+		DeleteFunc: func(obj interface{}) { c.scheduleUpdate() },
+	}
+	c.dotStore, c.dotInformer = c.newInformer("dots", &Dot{}, handlers)
+	c.remoteStore, c.remoteInformer = c.newInformer("dotremotes", &DotRemote{}, handlers)
+	c.syncStore, c.syncInformer = c.newInformer("dotsyncs", &DotSync{}, handlers)
+	return c
+}
+
+func (c *dotsController) newInformer(resource string, objType runtime.Object, handlers cache.ResourceEventHandler) (cache.Store, cache.Controller) {
+	lw := cache.NewListWatchFromClient(c.crdClient, resource, meta_v1.NamespaceAll, fields.Everything())
+	return cache.NewInformer(lw, objType, DOTS_RESYNC_PERIOD, handlers)
+}
+
+func (c *dotsController) scheduleUpdate() {
+	c.updatesNeededLock.Lock()
+	defer c.updatesNeededLock.Unlock()
+
+	c.updatesNeeded = true
+}
+
+func (c *dotsController) Run(stopCh chan struct{}) {
+	go c.dotInformer.Run(stopCh)
+	go c.remoteInformer.Run(stopCh)
+	go c.syncInformer.Run(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, c.dotInformer.HasSynced, c.remoteInformer.HasSynced, c.syncInformer.HasSynced) {
+		glog.Error(fmt.Errorf("Timed out waiting for dot resource caches to sync"))
+		return
+	}
+
+	wait.Until(c.runWorker, time.Second, stopCh)
+}
+
+func (c *dotsController) runWorker() {
+	needed :=
+		func() bool {
+			c.updatesNeededLock.Lock()
+			defer c.updatesNeededLock.Unlock()
+			needed := c.updatesNeeded
+			c.updatesNeeded = false
+			return needed
+		}()
+
+	if needed {
+		c.process()
+	}
+}
+
+func (c *dotsController) process() {
+	glog.V(1).Info("Reconciling dot resources...")
+	for _, obj := range c.dotStore.List() {
+		dot := obj.(*Dot).DeepCopyObject().(*Dot)
+		err := c.reconcileDot(dot)
+		if err != nil {
+			glog.Errorf("Error reconciling Dot %s/%s: %v", dot.Namespace, dot.Name, err)
+		}
+	}
+	for _, obj := range c.remoteStore.List() {
+		remote := obj.(*DotRemote).DeepCopyObject().(*DotRemote)
+		err := c.reconcileRemote(remote)
+		if err != nil {
+			glog.Errorf("Error reconciling DotRemote %s/%s: %v", remote.Namespace, remote.Name, err)
+		}
+	}
+	for _, obj := range c.syncStore.List() {
+		dotSync := obj.(*DotSync).DeepCopyObject().(*DotSync)
+		err := c.reconcileSync(dotSync)
+		if err != nil {
+			glog.Errorf("Error reconciling DotSync %s/%s: %v", dotSync.Namespace, dotSync.Name, err)
+		}
+	}
+}
+
+func (c *dotsController) call(method string, args interface{}, result interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), client.RPC_TIMEOUT)
+	defer cancel()
+	return c.dotmesh.CallRemote(ctx, method, args, result)
+}
+
+func dotVolumeName(dot *Dot) types.VolumeName {
+	name := types.VolumeName{Namespace: dot.Spec.Namespace, Name: dot.Spec.Name}
+	if name.Namespace == "" {
+		name.Namespace = dot.Namespace
+	}
+	if name.Name == "" {
+		name.Name = dot.Name
+	}
+	return name
+}
+
+// checkDotNamespace - Dots can only manage dots in the dotmesh namespace
+// named after their Kubernetes namespace, unless their Kubernetes namespace
+// is one of the admin namespaces
+func checkDotNamespace(dot *Dot, name types.VolumeName, adminNamespaces []string) error {
+	if name.Namespace == dot.Namespace || containsString(adminNamespaces, dot.Namespace) {
+		return nil
+	}
+	return fmt.Errorf(
+		"Dots in namespace %s can only manage dots in the %s dotmesh namespace, not %s; add %s to %s in the operator configuration to allow it",
+		dot.Namespace, dot.Namespace, name.Namespace, dot.Namespace, CONFIG_DOTS_ADMIN_NAMESPACES,
+	)
+}
+
+// ownsDot - whether the operator created the dot with filesystem id fsId for
+// this resource. Dots the operator didn't create, or created for another
+// resource, are never adopted: they may belong to someone else entirely.
+func ownsDot(dot *Dot, fsId string) bool {
+	return fsId != "" && dot.Status.OwnerUID != "" &&
+		dot.Status.OwnerUID == string(dot.UID) && dot.Status.FilesystemId == fsId
+}
+
+func splitList(list string) []string {
+	out := []string{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// DOTS
+
+func (c *dotsController) reconcileDot(dot *Dot) error {
+	name := dotVolumeName(dot)
+
+	if dot.DeletionTimestamp != nil {
+		if !hasFinalizer(dot.Finalizers, DOT_FINALIZER) {
+			return nil
+		}
+		err := c.deleteDot(dot, name)
+		if err != nil {
+			return err
+		}
+		dot.Finalizers = removeFinalizer(dot.Finalizers, DOT_FINALIZER)
+		return c.crdClient.Put().Namespace(dot.Namespace).Resource("dots").Name(dot.Name).Body(dot).Do().Error()
+	}
+
+	// Only hold on to the resource when there's something to do on deletion
+	wantFinalizer := dot.Spec.Retention == RETENTION_DELETE
+	if wantFinalizer != hasFinalizer(dot.Finalizers, DOT_FINALIZER) {
+		if wantFinalizer {
+			dot.Finalizers = append(dot.Finalizers, DOT_FINALIZER)
+		} else {
+			dot.Finalizers = removeFinalizer(dot.Finalizers, DOT_FINALIZER)
+		}
+		// the update comes back round through the informer
+		return c.crdClient.Put().Namespace(dot.Namespace).Resource("dots").Name(dot.Name).Body(dot).Do().Error()
+	}
+
+	status := dot.Status
+	status.Branches = copyStrings(status.Branches)
+	status.Conditions = copyConditions(status.Conditions)
+	status.ObservedGeneration = dot.Generation
+	reason := "ReconcileFailed"
+	err := checkDotNamespace(dot, name, c.adminNamespaces)
+	if err != nil {
+		reason = "NamespaceNotAllowed"
+	} else {
+		var fsId string
+		fsId, err = c.claimDot(dot, name)
+		if err != nil {
+			if _, ok := err.(notOwnedError); ok {
+				reason = "NotOwned"
+			}
+		} else {
+			// recorded before anything else is done to the dot, so that a
+			// failure part way through doesn't lose the dot
+			status.OwnerUID = string(dot.UID)
+			status.FilesystemId = fsId
+			var branches []string
+			branches, err = c.applyDot(dot, name, fsId)
+			if err == nil {
+				status.Branches = branches
+			}
+		}
+	}
+	if err != nil {
+		status.Conditions = setCondition(status.Conditions, Condition{
+			Type: CONDITION_READY, Status: CONDITION_FALSE, Reason: reason, Message: err.Error(),
+		})
+	} else {
+		status.Conditions = setCondition(status.Conditions, Condition{
+			Type: CONDITION_READY, Status: CONDITION_TRUE, Reason: "Reconciled",
+		})
+	}
+	if reflect.DeepEqual(status, dot.Status) {
+		return err
+	}
+	dot.Status = status
+	updateErr := c.crdClient.Put().Namespace(dot.Namespace).Resource("dots").Name(dot.Name).SubResource("status").Body(dot).Do().Error()
+	if updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+type notOwnedError struct {
+	name types.VolumeName
+}
+
+func (e notOwnedError) Error() string {
+	return fmt.Sprintf("Dot %s already exists and wasn't created for this resource, so it won't be managed", e.name)
+}
+
+// claimDot - creates the dot if it doesn't exist, returning its filesystem
+// id, or fails with notOwnedError if it exists but wasn't created for this
+// resource
+func (c *dotsController) claimDot(dot *Dot, name types.VolumeName) (string, error) {
+	var fsId string
+	err := c.call("DotmeshRPC.Exists", struct{ Namespace, Name, Branch string }{name.Namespace, name.Name, ""}, &fsId)
+	if err != nil {
+		return "", err
+	}
+	if fsId != "" {
+		if !ownsDot(dot, fsId) {
+			return "", notOwnedError{name}
+		}
+		return fsId, nil
+	}
+
+	var created bool
+	err = c.call("DotmeshRPC.Create", name, &created)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create dot %s: %s", name, err)
+	}
+	glog.Infof("Created dot %s for Dot %s/%s", name, dot.Namespace, dot.Name)
+	err = c.call("DotmeshRPC.Exists", struct{ Namespace, Name, Branch string }{name.Namespace, name.Name, ""}, &fsId)
+	if err != nil {
+		return "", err
+	}
+	if fsId == "" {
+		return "", fmt.Errorf("Dot %s wasn't found after creating it", name)
+	}
+	return fsId, nil
+}
+
+// applyDot - creates any missing branches of a dot the resource owns, and
+// brings its collaborators in line with the spec
+func (c *dotsController) applyDot(dot *Dot, name types.VolumeName, fsId string) ([]string, error) {
+	var branches []string
+	err := c.call("DotmeshRPC.Branches", name, &branches)
+	if err != nil {
+		return nil, err
+	}
+	for _, branch := range dot.Spec.Branches {
+		if branch == client.DEFAULT_BRANCH || containsString(branches, branch) {
+			continue
+		}
+		err = c.createBranch(name, branch)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create branch %s of %s: %s", branch, name, err)
+		}
+		glog.Infof("Created branch %s of dot %s for Dot %s/%s", branch, name, dot.Namespace, dot.Name)
+		branches = append(branches, branch)
+	}
+
+	err = c.applyCollaborators(fsId, name, dot.Spec.Collaborators)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{client.DEFAULT_BRANCH}, branches...), nil
+}
+
+// createBranch - branches from the latest commit on master, making an
+// initial commit if there isn't one yet, as there is when a dot has just
+// been created
+func (c *dotsController) createBranch(name types.VolumeName, branch string) error {
+	var commits []types.Snapshot
+	err := c.call("DotmeshRPC.Commits", struct{ Namespace, Name, Branch string }{name.Namespace, name.Name, client.DEFAULT_BRANCH}, &commits)
+	if err != nil {
+		return err
+	}
+	var commitId string
+	if len(commits) > 0 {
+		commitId = commits[len(commits)-1].Id
+	} else {
+		err = c.call("DotmeshRPC.Commit", struct {
+			Namespace, Name, Branch, Message string
+			Metadata                         map[string]string
+		}{name.Namespace, name.Name, client.DEFAULT_BRANCH, "Initial commit", nil}, &commitId)
+		if err != nil {
+			return err
+		}
+	}
+	var result bool
+	return c.call("DotmeshRPC.Branch", struct{ Namespace, Name, SourceBranch, NewBranchName, SourceCommitId string }{
+		name.Namespace, name.Name, client.DEFAULT_BRANCH, branch, commitId,
+	}, &result)
+}
+
+func (c *dotsController) applyCollaborators(fsId string, name types.VolumeName, want []string) error {
+	var all types.VolumesAndBranches
+	err := c.call("DotmeshRPC.AllDotsAndBranches", struct{}{}, &all)
+	if err != nil {
+		return err
+	}
+	current := []string{}
+	for _, tlf := range all.Dots {
+		if tlf.MasterBranch.Id == fsId {
+			for _, collaborator := range tlf.Collaborators {
+				current = append(current, collaborator.Name)
+			}
+		}
+	}
+
+	for _, collaborator := range want {
+		if containsString(current, collaborator) {
+			continue
+		}
+		var result bool
+		err = c.call("DotmeshRPC.AddCollaborator", struct{ MasterBranchID, Collaborator string }{fsId, collaborator}, &result)
+		if err != nil {
+			return fmt.Errorf("Unable to add collaborator %s to %s: %s", collaborator, name, err)
+		}
+		glog.Infof("Added collaborator %s to dot %s", collaborator, name)
+	}
+	for _, collaborator := range current {
+		if containsString(want, collaborator) {
+			continue
+		}
+		var result bool
+		err = c.call("DotmeshRPC.RemoveCollaborator", struct{ MasterBranchID, Collaborator string }{fsId, collaborator}, &result)
+		if err != nil {
+			return fmt.Errorf("Unable to remove collaborator %s from %s: %s", collaborator, name, err)
+		}
+		glog.Infof("Removed collaborator %s from dot %s", collaborator, name)
+	}
+	return nil
+}
+
+// deleteDot - deletes the dot if it was created for this resource, and leaves
+// it be otherwise
+func (c *dotsController) deleteDot(dot *Dot, name types.VolumeName) error {
+	var fsId string
+	err := c.call("DotmeshRPC.Exists", struct{ Namespace, Name, Branch string }{name.Namespace, name.Name, ""}, &fsId)
+	if err != nil {
+		return err
+	}
+	if fsId == "" {
+		return nil
+	}
+	if !ownsDot(dot, fsId) {
+		glog.Infof("Not deleting dot %s for deleted Dot resource %s/%s, as it wasn't created for it", name, dot.Namespace, dot.Name)
+		return nil
+	}
+	var result bool
+	err = c.call("DotmeshRPC.Delete", name, &result)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Deleted dot %s as its Dot resource %s/%s was deleted", name, dot.Namespace, dot.Name)
+	return nil
+}
+
+// REMOTES
+
+// remoteCredentials - the API key for a dotmesh remote, or the key ID and
+// secret key for an S3 remote
+func (c *dotsController) remoteCredentials(remote *DotRemote) (string, string, error) {
+	if remote.Spec.SecretName == "" {
+		return "", "", fmt.Errorf("No secretName given")
+	}
+	secret, err := c.client.CoreV1().Secrets(remote.Namespace).Get(remote.Spec.SecretName, meta_v1.GetOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	keys := []string{"apiKey"}
+	if remote.Spec.Type == REMOTE_TYPE_S3 {
+		keys = []string{"accessKeyId", "secretAccessKey"}
+	}
+	values := []string{}
+	for _, key := range keys {
+		value, ok := secret.Data[key]
+		if !ok {
+			return "", "", fmt.Errorf("Secret %s has no %s", remote.Spec.SecretName, key)
+		}
+		values = append(values, string(value))
+	}
+	values = append(values, "")
+	return values[0], values[1], nil
+}
+
+func remotePort(remote *DotRemote) int {
+	if remote.Spec.Port == 0 {
+		return 32607
+	}
+	return remote.Spec.Port
+}
+
+func remoteUser(remote *DotRemote) string {
+	if remote.Spec.User == "" {
+		return "admin"
+	}
+	return remote.Spec.User
+}
+
+// reconcileRemote - checks the remote's credentials, and that dotmesh
+// remotes can be reached with them
+func (c *dotsController) reconcileRemote(remote *DotRemote) error {
+	condition := Condition{Type: CONDITION_READY, Status: CONDITION_TRUE, Reason: "Reachable"}
+	apiKey, _, err := c.remoteCredentials(remote)
+	switch {
+	case err != nil:
+		condition = Condition{Type: CONDITION_READY, Status: CONDITION_FALSE, Reason: "InvalidCredentials", Message: err.Error()}
+	case remote.Spec.Type == REMOTE_TYPE_S3:
+		condition.Reason = "CredentialsFound"
+	case remote.Spec.Type == "" || remote.Spec.Type == REMOTE_TYPE_DOTMESH:
+		if remote.Spec.Hostname == "" {
+			condition = Condition{Type: CONDITION_READY, Status: CONDITION_FALSE, Reason: "InvalidSpec", Message: "No hostname given"}
+			break
+		}
+		_, err = client.NewJsonRpcClient(remoteUser(remote), remote.Spec.Hostname, apiKey, remotePort(remote)).Ping()
+		if err != nil {
+			condition = Condition{Type: CONDITION_READY, Status: CONDITION_FALSE, Reason: "Unreachable", Message: err.Error()}
+		}
+	default:
+		condition = Condition{Type: CONDITION_READY, Status: CONDITION_FALSE, Reason: "InvalidSpec", Message: fmt.Sprintf("Unknown remote type %q", remote.Spec.Type)}
+	}
+
+	status := remote.Status
+	status.Conditions = setCondition(copyConditions(status.Conditions), condition)
+	status.ObservedGeneration = remote.Generation
+	if reflect.DeepEqual(status, remote.Status) {
+		return nil
+	}
+	remote.Status = status
+	return c.crdClient.Put().Namespace(remote.Namespace).Resource("dotremotes").Name(remote.Name).SubResource("status").Body(remote).Do().Error()
+}
+
+// SYNCS
+
+// reconcileSync - follows the sync's current transfer to completion, and
+// starts the next one once the interval has passed since the last
+func (c *dotsController) reconcileSync(dotSync *DotSync) error {
+	status := dotSync.Status
+	status.Conditions = copyConditions(status.Conditions)
+
+	if status.TransferId != "" {
+		var transfer types.TransferPollResult
+		err := c.call("DotmeshRPC.GetTransfer", status.TransferId, &transfer)
+		if err != nil {
+			return err
+		}
+		switch {
+		case transfer.Status == "error":
+			status.TransferId = ""
+			status.LastSyncTime = meta_v1.Now()
+			status.Conditions = setCondition(status.Conditions, Condition{
+				Type: CONDITION_SYNCED, Status: CONDITION_FALSE, Reason: "TransferFailed", Message: transfer.Message,
+			})
+		case transfer.Status == "finished" && transfer.Index == transfer.Total:
+			status.TransferId = ""
+			status.LastSyncTime = meta_v1.Now()
+			status.Conditions = setCondition(status.Conditions, Condition{
+				Type: CONDITION_SYNCED, Status: CONDITION_TRUE, Reason: "TransferFinished",
+			})
+		default:
+			// still going
+			return nil
+		}
+	} else {
+		interval := dotSync.Spec.IntervalSeconds
+		if interval <= 0 {
+			interval = DEFAULT_SYNC_INTERVAL_SECONDS
+		}
+		due := status.LastSyncTime.Add(time.Duration(interval) * time.Second)
+		// a changed spec is synced straight away
+		if time.Now().Before(due) && status.ObservedGeneration == dotSync.Generation {
+			return nil
+		}
+		transferId, err := c.startTransfer(dotSync)
+		if err != nil {
+			status.LastSyncTime = meta_v1.Now()
+			status.Conditions = setCondition(status.Conditions, Condition{
+				Type: CONDITION_SYNCED, Status: CONDITION_FALSE, Reason: "TransferNotStarted", Message: err.Error(),
+			})
+		} else {
+			glog.Infof("Started transfer %s for DotSync %s/%s", transferId, dotSync.Namespace, dotSync.Name)
+			status.TransferId = transferId
+		}
+	}
+
+	status.ObservedGeneration = dotSync.Generation
+	if reflect.DeepEqual(status, dotSync.Status) {
+		return nil
+	}
+	dotSync.Status = status
+	return c.crdClient.Put().Namespace(dotSync.Namespace).Resource("dotsyncs").Name(dotSync.Name).SubResource("status").Body(dotSync).Do().Error()
+}
+
+func (c *dotsController) startTransfer(dotSync *DotSync) (string, error) {
+	obj, ok, err := c.dotStore.GetByKey(dotSync.Namespace + "/" + dotSync.Spec.Dot)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("No Dot called %s", dotSync.Spec.Dot)
+	}
+	dot := obj.(*Dot)
+	obj, ok, err = c.remoteStore.GetByKey(dotSync.Namespace + "/" + dotSync.Spec.Remote)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("No DotRemote called %s", dotSync.Spec.Remote)
+	}
+	remote := obj.(*DotRemote)
+	if dot.Status.FilesystemId == "" || dot.Status.OwnerUID != string(dot.UID) {
+		return "", fmt.Errorf("Dot %s hasn't been created yet", dot.Name)
+	}
+
+	direction := dotSync.Spec.Direction
+	if direction == "" {
+		direction = "push"
+	}
+	if direction != "push" && direction != "pull" {
+		return "", fmt.Errorf("Unknown direction %q, expected push or pull", direction)
+	}
+	name := dotVolumeName(dot)
+	remoteName := dotSync.Spec.RemoteName
+	if remoteName == "" {
+		remoteName = name.Name
+	}
+	branch := dotSync.Spec.Branch
+	if branch == client.DEFAULT_BRANCH {
+		branch = ""
+	}
+	remoteBranch := dotSync.Spec.RemoteBranch
+	if remoteBranch == "" {
+		remoteBranch = branch
+	} else if remoteBranch == client.DEFAULT_BRANCH {
+		remoteBranch = ""
+	}
+
+	key, secret, err := c.remoteCredentials(remote)
+	if err != nil {
+		return "", err
+	}
+	var transferId string
+	if remote.Spec.Type == REMOTE_TYPE_S3 {
+		err = c.call("DotmeshRPC.S3Transfer", types.S3TransferRequest{
+			KeyID:           key,
+			SecretKey:       secret,
+			Endpoint:        remote.Spec.Endpoint,
+			Direction:       direction,
+			LocalNamespace:  name.Namespace,
+			LocalName:       name.Name,
+			LocalBranchName: branch,
+			RemoteName:      remoteName,
+		}, &transferId)
+		return transferId, err
+	}
+
+	remoteNamespace := dotSync.Spec.RemoteNamespace
+	if remoteNamespace == "" {
+		remoteNamespace = remoteUser(remote)
+	}
+	err = c.call("DotmeshRPC.Transfer", types.TransferRequest{
+		Peer:             remote.Spec.Hostname,
+		User:             remoteUser(remote),
+		Port:             remotePort(remote),
+		ApiKey:           key,
+		Direction:        direction,
+		LocalNamespace:   name.Namespace,
+		LocalName:        name.Name,
+		LocalBranchName:  branch,
+		RemoteNamespace:  remoteNamespace,
+		RemoteName:       remoteName,
+		RemoteBranchName: remoteBranch,
+	}, &transferId)
+	return transferId, err
+}
+
+func hasFinalizer(finalizers []string, finalizer string) bool {
+	return containsString(finalizers, finalizer)
+}
+
+func removeFinalizer(finalizers []string, finalizer string) []string {
+	out := []string{}
+	for _, f := range finalizers {
+		if f != finalizer {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckDotNamespace(t *testing.T) {
+	tests := []struct {
+		name            string
+		spec            DotSpec
+		adminNamespaces []string
+		allowed         bool
+	}{
+		{"defaults to the resource's namespace", DotSpec{}, nil, true},
+		{"its own namespace", DotSpec{Namespace: "team-a"}, nil, true},
+		{"another namespace", DotSpec{Namespace: "admin"}, nil, false},
+		{"another namespace from a different admin namespace", DotSpec{Namespace: "admin"}, []string{"ops"}, false},
+		{"another namespace from an admin namespace", DotSpec{Namespace: "admin"}, []string{"ops", "team-a"}, true},
+	}
+	for _, tt := range tests {
+		dot := &Dot{ObjectMeta: meta_v1.ObjectMeta{Namespace: "team-a", Name: "orders"}, Spec: tt.spec}
+		err := checkDotNamespace(dot, dotVolumeName(dot), tt.adminNamespaces)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got error: %v", tt.name, tt.allowed, err)
+		}
+	}
+}
+
+func TestDotVolumeNameDefaults(t *testing.T) {
+	dot := &Dot{ObjectMeta: meta_v1.ObjectMeta{Namespace: "team-a", Name: "orders"}}
+	name := dotVolumeName(dot)
+	if name != (types.VolumeName{Namespace: "team-a", Name: "orders"}) {
+		t.Errorf("expected team-a/orders, got: %s", name)
+	}
+}
+
+func TestOwnsDot(t *testing.T) {
+	tests := []struct {
+		name   string
+		status DotStatus
+		fsId   string
+		owned  bool
+	}{
+		{"created for this resource", DotStatus{OwnerUID: "uid-1", FilesystemId: "fs-1"}, "fs-1", true},
+		{"no marker", DotStatus{FilesystemId: "fs-1"}, "fs-1", false},
+		{"created for another resource", DotStatus{OwnerUID: "uid-2", FilesystemId: "fs-1"}, "fs-1", false},
+		{"recreated by someone else", DotStatus{OwnerUID: "uid-1", FilesystemId: "fs-1"}, "fs-2", false},
+		{"doesn't exist", DotStatus{OwnerUID: "uid-1"}, "", false},
+	}
+	for _, tt := range tests {
+		dot := &Dot{ObjectMeta: meta_v1.ObjectMeta{UID: "uid-1"}, Status: tt.status}
+		if owned := ownsDot(dot, tt.fsId); owned != tt.owned {
+			t.Errorf("%s: expected owned=%t, got %t", tt.name, tt.owned, owned)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	got := splitList(" ops, ,team-a,")
+	if len(got) != 2 || got[0] != "ops" || got[1] != "team-a" {
+		t.Errorf("expected [ops team-a], got: %v", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 
+	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
 	"github.com/dotmesh-io/dotmesh/pkg/messaging/nats"
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
@@ -83,6 +85,10 @@ const CONFIG_LOG_ADDRESS = "logAddress"
 const CONFIG_KERNEL_ZFS_VERSION = "kernel.zfsVersion"
 const CONFIG_MODE = "storageMode"
 
+// Comma separated Kubernetes namespaces whose Dot resources may manage dots in
+// any dotmesh namespace, not just the one named after their own
+const CONFIG_DOTS_ADMIN_NAMESPACES = "dots.adminNamespaces"
+
 const CONFIG_MODE_LOCAL = "local" // Value for CONFIG_MODE
 const CONFIG_LOCAL_POOL_SIZE_PER_NODE = "local.poolSizePerNode"
 const CONFIG_LOCAL_POOL_LOCATION = "local.poolLocation"
@@ -117,6 +123,7 @@ func main() {
 	// However, allow the use of a local kubeconfig as this can make local
 	// development & testing easier.
 	kubeconfig := flag.String("kubeconfig", "", "Path to a kubeconfig file")
+	dotmeshHost := flag.String("dotmesh-host", "dotmesh.dotmesh", "Hostname of the dotmesh service, for managing Dot resources")
 
 	// We log to stderr because glog will default to logging to a file.
 	// By setting this debugging is easier via `kubectl logs`
@@ -137,7 +144,22 @@ func main() {
 	stopCh := make(chan struct{})
 	defer close(stopCh)
 
-	newDotmeshController(client).Run(stopCh)
+	// The Dot, DotRemote and DotSync resources are managed through the
+	// dotmesh API, as is draining nodes for upgrades, so need the admin API
+	// key
+	apiKey := os.Getenv("DOTMESH_API_KEY")
+	if apiKey != "" {
+		crdClient, err := newCRDClient(config)
+		if err != nil {
+			glog.Fatalf("Failed to create dotmesh.io resource client: %v", err)
+		}
+		dotmesh := dmclient.NewJsonRpcClient("admin", *dotmeshHost, apiKey, 32607)
+		go newDotsController(client, crdClient, dotmesh).Run(stopCh)
+	} else {
+		glog.Info("DOTMESH_API_KEY not set, not managing Dot resources")
+	}
+
+	newDotmeshController(client, apiKey).Run(stopCh)
 }
 
 type dotmeshController struct {
@@ -156,6 +178,14 @@ type dotmeshController struct {
 
 	config *v1.ConfigMap
 
+	// For talking to the servers to drain them, on their pods' IPs
+	apiKey     string
+	serverPort int
+
+	// Pods whose masters are being moved off in the background
+	draining     map[string]bool
+	drainingLock *sync.Mutex
+
 	nodesGauge           *prometheus.GaugeVec
 	dottedNodesGauge     *prometheus.GaugeVec
 	undottedNodesGauge   *prometheus.GaugeVec
@@ -163,6 +193,8 @@ type dotmeshController struct {
 	dotmeshesToKillGauge *prometheus.GaugeVec
 	suspendedNodesGauge  *prometheus.GaugeVec
 	targetMinPodsGauge   *prometheus.GaugeVec
+	outdatedPodsGauge    *prometheus.GaugeVec
+	upgradePausedGauge   *prometheus.GaugeVec
 }
 
 func provideDefault(m *map[string]string, key string, deflt string) {
@@ -175,11 +207,15 @@ func provideDefault(m *map[string]string, key string, deflt string) {
 	}
 }
 
-func newDotmeshController(client kubernetes.Interface) *dotmeshController {
+func newDotmeshController(client kubernetes.Interface, apiKey string) *dotmeshController {
 	rc := &dotmeshController{
 		client:            client,
+		apiKey:            apiKey,
+		serverPort:        32607,
 		updatesNeeded:     false,
 		updatesNeededLock: &sync.Mutex{},
+		draining:          map[string]bool{},
+		drainingLock:      &sync.Mutex{},
 
 		nodesGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "dm_operator_nodes",
@@ -210,6 +246,14 @@ func newDotmeshController(client kubernetes.Interface) *dotmeshController {
 			Name: "dm_operator_pods_low_water_mark",
 			Help: "Number of Dotmesh pods we won't go below if we can help it",
 		}, []string{}),
+		outdatedPodsGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dm_operator_pods_to_upgrade",
+			Help: "Number of running Dotmesh pods on an old image",
+		}, []string{}),
+		upgradePausedGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dm_operator_upgrade_paused",
+			Help: "1 if upgrading is paused because a node didn't come back healthy",
+		}, []string{}),
 	}
 
 	config, err := client.Core().ConfigMaps(DOTMESH_NAMESPACE).Get(DOTMESH_CONFIG_MAP, meta_v1.GetOptions{})
@@ -470,6 +514,8 @@ func (c *dotmeshController) Run(stopCh chan struct{}) {
 	prometheus.MustRegister(c.dotmeshesToKillGauge)
 	prometheus.MustRegister(c.suspendedNodesGauge)
 	prometheus.MustRegister(c.targetMinPodsGauge)
+	prometheus.MustRegister(c.outdatedPodsGauge)
+	prometheus.MustRegister(c.upgradePausedGauge)
 	go func() {
 		err := http.ListenAndServe(":32608", router)
 		glog.Fatal(err)
@@ -639,6 +685,7 @@ func (c *dotmeshController) process() error {
 	}
 
 	dotmeshesToKill := map[string]struct{}{} // Set of pod IDs of dotmesh pods that need to die
+	outdatedPods := map[string]*v1.Pod{}     // Running dotmesh pods that need upgrading
 	dotmeshIsRunning := map[string]bool{}    // Set of pod IDs that are in the "Running" state
 
 	runningPodCount := 0
@@ -694,7 +741,12 @@ func (c *dotmeshController) process() error {
 		//check version dotmesh-server image
 		if image != DOTMESH_IMAGE {
 			glog.V(2).Infof("Observing pod %s running wrong image %s (should be %s)", podName, image, DOTMESH_IMAGE)
-			dotmeshesToKill[podName] = struct{}{}
+			if status == v1.PodRunning && dotmesh.ObjectMeta.DeletionTimestamp == nil {
+				// Running pods are drained first, see rollingUpgrade
+				outdatedPods[podName] = dotmesh
+			} else {
+				dotmeshesToKill[podName] = struct{}{}
+			}
 			// But don't try starting any new dotmesh on the node it's SUPPOSED to be on until it's gone
 			suspendedNodes[boundNode] = struct{}{}
 			continue
@@ -828,6 +880,9 @@ func (c *dotmeshController) process() error {
 		}
 	}
 
+	// UPGRADE OUTDATED DOTMESH PODS, ONE AT A TIME
+	c.rollingUpgrade(nodes, dotmeshes, outdatedPods, undottedNodes)
+
 	// CREATE NEW DOTMESH PODS WHERE NEEDED
 	c.createDotmeshPods(undottedNodes, suspendedNodes, unusedPVCs, sentinels)
 
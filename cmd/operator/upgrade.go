@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ROLLING UPGRADES
+
+// Server pods running an old image are upgraded one node at a time: the
+// node's branch masters are moved to other nodes and its transfers are
+// allowed to finish, then the pod is deleted and recreated with the new
+// image, and the next node isn't started on until it's Ready (which means
+// the API's /check is answering, which it only does once the server has
+// caught up with etcd). If it doesn't come back in time, the node is
+// annotated with DOTMESH_UPGRADE_PAUSED_ANNOTATION, and no more nodes are
+// upgraded until someone removes it.
+
+// All the upgrade's state lives in annotations, so the operator can be
+// restarted at any point:
+
+// On the old pod being drained, when the drain started
+const DOTMESH_UPGRADE_STARTED_ANNOTATION = "dotmesh.io/upgrade-started"
+
+// On the old pod being drained, how the last attempt to move its masters off
+// went. The masters are moved in the background, so as not to hold up the
+// rest of the operator, and whether they've gone is checked on each resync.
+const DOTMESH_UPGRADE_DRAIN_PROGRESS_ANNOTATION = "dotmesh.io/upgrade-drain-progress"
+
+// On a node whose new pod didn't become healthy, why not
+const DOTMESH_UPGRADE_PAUSED_ANNOTATION = "dotmesh.io/upgrade-paused"
+
+// Masters still on a node after this long are left there; they're in use by
+// containers, and they'll come back when the pod does
+const UPGRADE_DRAIN_TIMEOUT = 10 * time.Minute
+
+// Transfers still running after this long are probably stuck
+const UPGRADE_TRANSFER_TIMEOUT = time.Hour
+
+// How long a new pod has to become Ready before the upgrade is paused
+const UPGRADE_HEALTH_TIMEOUT = 10 * time.Minute
+
+// How often to check on an upgrade, which nothing else will necessarily
+// trigger
+const UPGRADE_POLL_INTERVAL = 10 * time.Second
+
+const MOVE_BRANCH_MASTER_RPC_TIMEOUT = 6 * time.Minute
+
+func podIsReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// rollingUpgrade - takes the next step of upgrading the outdated pods, which
+// are running but on the wrong image
+func (c *dotmeshController) rollingUpgrade(nodes []*v1.Node, pods []*v1.Pod, outdatedPods map[string]*v1.Pod, undottedNodes map[string]struct{}) {
+	c.outdatedPodsGauge.WithLabelValues().Set(float64(len(outdatedPods)))
+	if len(outdatedPods) == 0 {
+		c.upgradePausedGauge.WithLabelValues().Set(0)
+		return
+	}
+
+	for _, node := range nodes {
+		reason, paused := node.ObjectMeta.Annotations[DOTMESH_UPGRADE_PAUSED_ANNOTATION]
+		if paused {
+			glog.V(1).Infof("Upgrade paused by node %s: %s. Remove its %s annotation to carry on.", node.ObjectMeta.Name, reason, DOTMESH_UPGRADE_PAUSED_ANNOTATION)
+			c.upgradePausedGauge.WithLabelValues().Set(1)
+			return
+		}
+	}
+	c.upgradePausedGauge.WithLabelValues().Set(0)
+
+	// Only carry on when every node has a healthy dotmesh
+	outdatedNodes := map[string]struct{}{}
+	for _, pod := range outdatedPods {
+		outdatedNodes[pod.Spec.NodeSelector[DOTMESH_NODE_LABEL]] = struct{}{}
+	}
+	for _, pod := range pods {
+		if pod.ObjectMeta.DeletionTimestamp != nil {
+			glog.V(1).Infof("Waiting for pod %s to go before upgrading another node", pod.ObjectMeta.Name)
+			c.scheduleUpgradeCheck()
+			return
+		}
+		if len(pod.Spec.Containers) != 1 || pod.Spec.Containers[0].Image != DOTMESH_IMAGE || podIsReady(pod) {
+			continue
+		}
+		node := pod.Spec.NodeSelector[DOTMESH_NODE_LABEL]
+		age := time.Since(pod.ObjectMeta.CreationTimestamp.Time)
+		if age > UPGRADE_HEALTH_TIMEOUT {
+			reason := fmt.Sprintf("pod %s not Ready %s after it was created", pod.ObjectMeta.Name, age.Round(time.Second))
+			glog.Errorf("Pausing upgrade: %s", reason)
+			c.pauseUpgrade(node, reason)
+			return
+		}
+		glog.V(1).Infof("Waiting for pod %s on node %s to become Ready before upgrading another node", pod.ObjectMeta.Name, node)
+		c.scheduleUpgradeCheck()
+		return
+	}
+	for node := range undottedNodes {
+		if _, outdated := outdatedNodes[node]; !outdated {
+			glog.V(1).Infof("Waiting for node %s to get a dotmesh before upgrading another node", node)
+			c.scheduleUpgradeCheck()
+			return
+		}
+	}
+
+	// Carry on with the pod already being drained, if there is one
+	names := []string{}
+	for name := range outdatedPods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pod := outdatedPods[names[0]]
+	for _, name := range names {
+		if _, ok := outdatedPods[name].ObjectMeta.Annotations[DOTMESH_UPGRADE_STARTED_ANNOTATION]; ok {
+			pod = outdatedPods[name]
+			break
+		}
+	}
+
+	started, err := c.startDrain(pod)
+	if err != nil {
+		glog.Errorf("Error starting to drain pod %s: %+v", pod.ObjectMeta.Name, err)
+		return
+	}
+
+	if !c.drain(pod, pods, started) {
+		c.scheduleUpgradeCheck()
+		return
+	}
+
+	glog.Infof("Upgrading pod %s on node %s", pod.ObjectMeta.Name, pod.Spec.NodeSelector[DOTMESH_NODE_LABEL])
+	dp := meta_v1.DeletePropagationBackground
+	err = c.client.Core().Pods(DOTMESH_NAMESPACE).Delete(pod.ObjectMeta.Name, &meta_v1.DeleteOptions{
+		PropagationPolicy: &dp,
+	})
+	if err != nil {
+		glog.Error(err)
+	}
+}
+
+func (c *dotmeshController) scheduleUpgradeCheck() {
+	time.AfterFunc(UPGRADE_POLL_INTERVAL, c.scheduleUpdate)
+}
+
+// startDrain - when the pod started being drained, marking it as being
+// drained now if it isn't already
+func (c *dotmeshController) startDrain(pod *v1.Pod) (time.Time, error) {
+	if value, ok := pod.ObjectMeta.Annotations[DOTMESH_UPGRADE_STARTED_ANNOTATION]; ok {
+		started, err := time.Parse(time.RFC3339, value)
+		if err == nil {
+			return started, nil
+		}
+		glog.Infof("Ignoring unparseable %s annotation %q on pod %s", DOTMESH_UPGRADE_STARTED_ANNOTATION, value, pod.ObjectMeta.Name)
+	}
+	started := time.Now()
+	p2 := pod.DeepCopy()
+	if p2.ObjectMeta.Annotations == nil {
+		p2.ObjectMeta.Annotations = map[string]string{}
+	}
+	p2.ObjectMeta.Annotations[DOTMESH_UPGRADE_STARTED_ANNOTATION] = started.UTC().Format(time.RFC3339)
+	glog.Infof("Draining pod %s on node %s to upgrade it", pod.ObjectMeta.Name, pod.Spec.NodeSelector[DOTMESH_NODE_LABEL])
+	_, err := c.client.Core().Pods(DOTMESH_NAMESPACE).Update(p2)
+	return started, err
+}
+
+func (c *dotmeshController) pauseUpgrade(nodeName, reason string) {
+	node, err := c.nodeLister.Get(nodeName)
+	if err != nil {
+		glog.Errorf("Error finding node %s to pause the upgrade: %+v", nodeName, err)
+		return
+	}
+	n2 := node.DeepCopy()
+	if n2.ObjectMeta.Annotations == nil {
+		n2.ObjectMeta.Annotations = map[string]string{}
+	}
+	n2.ObjectMeta.Annotations[DOTMESH_UPGRADE_PAUSED_ANNOTATION] = reason
+	_, err = c.client.Core().Nodes().Update(n2)
+	if err != nil {
+		glog.Errorf("Error pausing the upgrade on node %s: %+v", nodeName, err)
+	}
+}
+
+func (c *dotmeshController) serverClient(pod *v1.Pod) *dmclient.JsonRpcClient {
+	return dmclient.NewJsonRpcClient("admin", pod.Status.PodIP, c.apiKey, c.serverPort)
+}
+
+func (c *dotmeshController) nodeStatus(pod *v1.Pod) (types.NodeStatus, error) {
+	var status types.NodeStatus
+	ctx, cancel := context.WithTimeout(context.Background(), dmclient.RPC_TIMEOUT)
+	defer cancel()
+	err := c.serverClient(pod).CallRemote(ctx, "DotmeshRPC.NodeStatus", struct{}{}, &status)
+	return status, err
+}
+
+// drain - starts moving the pod's branch masters to the other healthy nodes,
+// and returns whether it's ready to be upgraded: once it has no masters left
+// (or they won't move) and no transfers running
+func (c *dotmeshController) drain(pod *v1.Pod, pods []*v1.Pod, started time.Time) bool {
+	if c.apiKey == "" {
+		glog.Infof("Not draining pod %s as DOTMESH_API_KEY isn't set", pod.ObjectMeta.Name)
+		return true
+	}
+	elapsed := time.Since(started)
+
+	status, err := c.nodeStatus(pod)
+	if err != nil {
+		if elapsed > UPGRADE_DRAIN_TIMEOUT {
+			glog.Infof("Giving up draining pod %s after %s, as it isn't answering: %+v", pod.ObjectMeta.Name, elapsed, err)
+			return true
+		}
+		glog.Errorf("Error getting the status of pod %s: %+v", pod.ObjectMeta.Name, err)
+		return false
+	}
+
+	if len(status.Masters) > 0 && elapsed < UPGRADE_DRAIN_TIMEOUT {
+		c.startMovingMasters(pod, pods, status.Masters)
+	}
+
+	if len(status.RunningTransfers) > 0 {
+		if elapsed < UPGRADE_TRANSFER_TIMEOUT {
+			glog.Infof("Waiting for %d transfers on pod %s to finish before upgrading it", len(status.RunningTransfers), pod.ObjectMeta.Name)
+			return false
+		}
+		glog.Infof("Upgrading pod %s with %d transfers still running after %s", pod.ObjectMeta.Name, len(status.RunningTransfers), elapsed)
+	}
+	if len(status.Masters) > 0 {
+		if elapsed < UPGRADE_DRAIN_TIMEOUT {
+			glog.Infof("Waiting for %d masters to move off pod %s before upgrading it", len(status.Masters), pod.ObjectMeta.Name)
+			return false
+		}
+		glog.Infof("Upgrading pod %s with %d masters that won't move (probably in use) after %s", pod.ObjectMeta.Name, len(status.Masters), elapsed)
+	}
+	return true
+}
+
+// startMovingMasters - moves the masters off the pod in the background,
+// unless that's already under way. Masters are only handed off, never forced
+// over, as the pod is still up to hand them off itself. Once it's done the
+// outcome is recorded on the pod and the pod's checked again, and any masters
+// still there are tried again on the next check.
+func (c *dotmeshController) startMovingMasters(pod *v1.Pod, pods []*v1.Pod, masters []string) {
+	podName := pod.ObjectMeta.Name
+	c.drainingLock.Lock()
+	defer c.drainingLock.Unlock()
+	if c.draining[podName] {
+		glog.V(1).Infof("Still moving masters off pod %s", podName)
+		return
+	}
+	c.draining[podName] = true
+
+	go func() {
+		defer func() {
+			c.drainingLock.Lock()
+			delete(c.draining, podName)
+			c.drainingLock.Unlock()
+			c.scheduleUpdate()
+		}()
+
+		candidates := []string{}
+		for _, other := range pods {
+			if other.ObjectMeta.Name == podName || !podIsReady(other) {
+				continue
+			}
+			otherStatus, err := c.nodeStatus(other)
+			if err != nil {
+				glog.Infof("Not moving masters to pod %s as it isn't answering: %+v", other.ObjectMeta.Name, err)
+				continue
+			}
+			candidates = append(candidates, otherStatus.Id)
+		}
+		if len(candidates) == 0 {
+			glog.Infof("No healthy nodes to move the masters on pod %s to", podName)
+			c.recordDrainProgress(podName, "no healthy nodes to move masters to")
+			return
+		}
+
+		moved := 0
+		for _, fsId := range masters {
+			var target string
+			ctx, cancel := context.WithTimeout(context.Background(), MOVE_BRANCH_MASTER_RPC_TIMEOUT)
+			err := c.serverClient(pod).CallRemote(ctx, "DotmeshRPC.MoveBranchMaster", types.MoveBranchMasterRequest{
+				FilesystemId: fsId,
+				Candidates:   candidates,
+			}, &target)
+			cancel()
+			if err != nil {
+				glog.Infof("Unable to move master of %s off pod %s: %+v", fsId, podName, err)
+			} else {
+				glog.Infof("Moved master of %s off pod %s to node %s", fsId, podName, target)
+				moved++
+			}
+		}
+		c.recordDrainProgress(podName, fmt.Sprintf("moved %d of %d masters", moved, len(masters)))
+	}()
+}
+
+func (c *dotmeshController) recordDrainProgress(podName, progress string) {
+	pod, err := c.client.Core().Pods(DOTMESH_NAMESPACE).Get(podName, meta_v1.GetOptions{})
+	if err != nil {
+		glog.Errorf("Error getting pod %s to record its drain progress: %+v", podName, err)
+		return
+	}
+	if pod.ObjectMeta.Annotations == nil {
+		pod.ObjectMeta.Annotations = map[string]string{}
+	}
+	pod.ObjectMeta.Annotations[DOTMESH_UPGRADE_DRAIN_PROGRESS_ANNOTATION] = fmt.Sprintf("%s at %s", progress, time.Now().UTC().Format(time.RFC3339))
+	_, err = c.client.Core().Pods(DOTMESH_NAMESPACE).Update(pod)
+	if err != nil {
+		glog.Errorf("Error recording the drain progress of pod %s: %+v", podName, err)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	core_v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	lister_v1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// fakeKube - the parts of the kubernetes API the upgrade uses, keeping pods
+// and nodes in memory
+type fakeKube struct {
+	kubernetes.Interface
+	core *fakeCore
+}
+
+func (k *fakeKube) Core() core_v1.CoreV1Interface {
+	return k.core
+}
+
+type fakeCore struct {
+	core_v1.CoreV1Interface
+	sync.Mutex
+	pods    map[string]*v1.Pod
+	nodes   map[string]*v1.Node
+	deleted []string
+}
+
+func (c *fakeCore) Pods(namespace string) core_v1.PodInterface {
+	return &fakePods{core: c}
+}
+
+func (c *fakeCore) Nodes() core_v1.NodeInterface {
+	return &fakeNodes{core: c}
+}
+
+func (c *fakeCore) pod(name string) *v1.Pod {
+	c.Lock()
+	defer c.Unlock()
+	return c.pods[name]
+}
+
+func (c *fakeCore) node(name string) *v1.Node {
+	c.Lock()
+	defer c.Unlock()
+	return c.nodes[name]
+}
+
+func (c *fakeCore) deletedPods() []string {
+	c.Lock()
+	defer c.Unlock()
+	return append([]string{}, c.deleted...)
+}
+
+type fakePods struct {
+	core_v1.PodInterface
+	core *fakeCore
+}
+
+func (p *fakePods) Get(name string, options meta_v1.GetOptions) (*v1.Pod, error) {
+	pod := p.core.pod(name)
+	if pod == nil {
+		return nil, fmt.Errorf("pod %s not found", name)
+	}
+	return pod.DeepCopy(), nil
+}
+
+func (p *fakePods) Update(pod *v1.Pod) (*v1.Pod, error) {
+	p.core.Lock()
+	defer p.core.Unlock()
+	p.core.pods[pod.ObjectMeta.Name] = pod.DeepCopy()
+	return pod, nil
+}
+
+func (p *fakePods) Delete(name string, options *meta_v1.DeleteOptions) error {
+	p.core.Lock()
+	defer p.core.Unlock()
+	p.core.deleted = append(p.core.deleted, name)
+	return nil
+}
+
+type fakeNodes struct {
+	core_v1.NodeInterface
+	core *fakeCore
+}
+
+func (n *fakeNodes) Update(node *v1.Node) (*v1.Node, error) {
+	n.core.Lock()
+	defer n.core.Unlock()
+	n.core.nodes[node.ObjectMeta.Name] = node.DeepCopy()
+	return node, nil
+}
+
+func newTestController(t *testing.T, nodes []*v1.Node, pods []*v1.Pod) (*dotmeshController, *fakeCore) {
+	core := &fakeCore{pods: map[string]*v1.Pod{}, nodes: map[string]*v1.Node{}}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		core.nodes[node.ObjectMeta.Name] = node
+		err := indexer.Add(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pod := range pods {
+		core.pods[pod.ObjectMeta.Name] = pod
+	}
+	gauge := func(name string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name}, []string{})
+	}
+	c := &dotmeshController{
+		client:             &fakeKube{core: core},
+		nodeLister:         lister_v1.NewNodeLister(indexer),
+		updatesNeededLock:  &sync.Mutex{},
+		draining:           map[string]bool{},
+		drainingLock:       &sync.Mutex{},
+		outdatedPodsGauge:  gauge("outdated"),
+		upgradePausedGauge: gauge("paused"),
+	}
+	return c, core
+}
+
+func testNode(name string) *v1.Node {
+	return &v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: name}}
+}
+
+func testPod(name, node, image string, ready bool, age time.Duration) *v1.Pod {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return &v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: meta_v1.NewTime(time.Now().Add(-age)),
+		},
+		Spec: v1.PodSpec{
+			NodeSelector: map[string]string{DOTMESH_NODE_LABEL: node},
+			Containers:   []v1.Container{{Image: image}},
+		},
+		Status: v1.PodStatus{
+			PodIP:      "127.0.0.1",
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}},
+		},
+	}
+}
+
+func outdated(pods ...*v1.Pod) map[string]*v1.Pod {
+	result := map[string]*v1.Pod{}
+	for _, pod := range pods {
+		result[pod.ObjectMeta.Name] = pod
+	}
+	return result
+}
+
+func TestRollingUpgradeOrder(t *testing.T) {
+	nodes := []*v1.Node{testNode("node-1"), testNode("node-2"), testNode("node-3")}
+	a := testPod("server-a", "node-1", "old", true, time.Hour)
+	b := testPod("server-b", "node-2", "old", true, time.Hour)
+	current := testPod("server-c", "node-3", DOTMESH_IMAGE, true, time.Hour)
+	pods := []*v1.Pod{b, current, a}
+
+	// one node at a time, in order
+	c, core := newTestController(t, nodes, pods)
+	c.rollingUpgrade(nodes, pods, outdated(b, a), map[string]struct{}{})
+	if deleted := core.deletedPods(); len(deleted) != 1 || deleted[0] != "server-a" {
+		t.Errorf("expected just server-a to be upgraded, got %v", deleted)
+	}
+	if _, ok := core.pod("server-a").ObjectMeta.Annotations[DOTMESH_UPGRADE_STARTED_ANNOTATION]; !ok {
+		t.Error("expected server-a to be marked as being drained")
+	}
+
+	// carrying on with the node already being drained
+	b.ObjectMeta.Annotations = map[string]string{
+		DOTMESH_UPGRADE_STARTED_ANNOTATION: time.Now().UTC().Format(time.RFC3339),
+	}
+	c, core = newTestController(t, nodes, pods)
+	c.rollingUpgrade(nodes, pods, outdated(b, a), map[string]struct{}{})
+	if deleted := core.deletedPods(); len(deleted) != 1 || deleted[0] != "server-b" {
+		t.Errorf("expected server-b, which was being drained, to be upgraded first, got %v", deleted)
+	}
+}
+
+func TestRollingUpgradeHealthGate(t *testing.T) {
+	nodes := []*v1.Node{testNode("node-1"), testNode("node-2")}
+	old := testPod("server-b", "node-2", "old", true, time.Hour)
+
+	tests := []struct {
+		name     string
+		upgraded *v1.Pod
+		paused   bool
+	}{
+		{"upgraded pod still starting", testPod("server-a", "node-1", DOTMESH_IMAGE, false, time.Minute), false},
+		{"upgraded pod never became healthy", testPod("server-a", "node-1", DOTMESH_IMAGE, false, UPGRADE_HEALTH_TIMEOUT+time.Minute), true},
+	}
+	for _, tt := range tests {
+		pods := []*v1.Pod{tt.upgraded, old}
+		c, core := newTestController(t, nodes, pods)
+		c.rollingUpgrade(nodes, pods, outdated(old), map[string]struct{}{})
+		if deleted := core.deletedPods(); len(deleted) != 0 {
+			t.Errorf("%s: expected no more pods to be upgraded, got %v", tt.name, deleted)
+		}
+		reason, paused := core.node("node-1").ObjectMeta.Annotations[DOTMESH_UPGRADE_PAUSED_ANNOTATION]
+		if paused != tt.paused {
+			t.Errorf("%s: expected paused=%t, got %t (%s)", tt.name, tt.paused, paused, reason)
+		}
+	}
+
+	// once paused, nothing's upgraded until the annotation's removed, even
+	// if everything's healthy again
+	paused := testNode("node-1")
+	paused.ObjectMeta.Annotations = map[string]string{DOTMESH_UPGRADE_PAUSED_ANNOTATION: "not Ready"}
+	nodes = []*v1.Node{paused, testNode("node-2")}
+	pods := []*v1.Pod{testPod("server-a", "node-1", DOTMESH_IMAGE, true, time.Hour), old}
+	c, core := newTestController(t, nodes, pods)
+	c.rollingUpgrade(nodes, pods, outdated(old), map[string]struct{}{})
+	if deleted := core.deletedPods(); len(deleted) != 0 {
+		t.Errorf("expected a paused upgrade not to carry on, got %v", deleted)
+	}
+
+	// nor while a node is still waiting for a dotmesh
+	nodes = []*v1.Node{testNode("node-1"), testNode("node-2"), testNode("node-3")}
+	c, core = newTestController(t, nodes, pods)
+	c.rollingUpgrade(nodes, pods, outdated(old), map[string]struct{}{"node-3": {}})
+	if deleted := core.deletedPods(); len(deleted) != 0 {
+		t.Errorf("expected the upgrade to wait for every node to have a dotmesh, got %v", deleted)
+	}
+}
+
+func TestDrainOnlyHandsMastersOff(t *testing.T) {
+	requests := make(chan types.MoveBranchMasterRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Id     uint64
+			Method string
+			Params json.RawMessage
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		var result interface{}
+		switch request.Method {
+		case "DotmeshRPC.NodeStatus":
+			result = types.NodeStatus{Id: "node-id", Masters: []string{"fs-1"}}
+		case "DotmeshRPC.MoveBranchMaster":
+			var args types.MoveBranchMasterRequest
+			json.Unmarshal(request.Params, &args)
+			requests <- args
+			result = "node-id"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id, "result": result})
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := testPod("server-a", "node-1", "old", true, time.Hour)
+	pods := []*v1.Pod{pod, testPod("server-b", "node-2", DOTMESH_IMAGE, true, time.Hour)}
+	c, core := newTestController(t, nil, pods)
+	c.apiKey = "secret"
+	c.serverPort, err = strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.drain(pod, pods, time.Now()) {
+		t.Error("expected the pod not to be ready to upgrade while it has masters")
+	}
+	select {
+	case request := <-requests:
+		if request.FilesystemId != "fs-1" || len(request.Candidates) != 1 || request.Candidates[0] != "node-id" {
+			t.Errorf("unexpected request to move a master: %+v", request)
+		}
+		if request.Force {
+			t.Error("expected the operator never to force masters over")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the masters to be moved off")
+	}
+
+	// the outcome's recorded on the pod
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		progress := core.pod("server-a").ObjectMeta.Annotations[DOTMESH_UPGRADE_DRAIN_PROGRESS_ANNOTATION]
+		if strings.HasPrefix(progress, "moved 1 of 1 masters") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the drain's progress to be recorded, got %q", progress)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// masters that won't move are left behind eventually
+	if !c.drain(pod, pods, time.Now().Add(-UPGRADE_DRAIN_TIMEOUT-time.Minute)) {
+		t.Error("expected the pod to be upgraded once it's had long enough to drain")
+	}
+}
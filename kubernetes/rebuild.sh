@@ -22,3 +22,7 @@ sed "s_ClusterIP_LoadBalancer_" < $OUT/dotmesh-k8s-1.8.yaml > $OUT/dotmesh-k8s-1
 cp configmap.yaml $OUT/configmap.yaml
 sed "s_/usr/libexec/kubernetes/kubelet-plugins/volume/exec_/home/kubernetes/flexvolume_" < configmap.yaml > $OUT/configmap.gke.yaml
 sed "s_/usr/libexec/kubernetes/kubelet-plugins/volume/exec_/etc/kubernetes/volumeplugins_" < configmap.yaml > $OUT/configmap.aks.yaml
+
+sed "s/DOCKER_TAG/$CI_DOCKER_TAG/" < dotmesh-csi.yaml > $OUT/dotmesh-csi.yaml
+
+cp dotmesh-crds.yaml $OUT/dotmesh-crds.yaml
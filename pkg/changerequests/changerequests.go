@@ -0,0 +1,173 @@
+package changerequests
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// ChangeRequestsPrefix - KV store prefix for change requests, keyed by the
+// top level filesystem ID of the dot they're proposed to and then by change
+// request ID
+const ChangeRequestsPrefix = "changerequests"
+
+type Manager interface {
+	// List - returns the change requests proposed to a dot, oldest first
+	List(filesystemId string) ([]*types.ChangeRequest, error)
+	Get(filesystemId, id string) (*types.ChangeRequest, error)
+	// Create - validates and stores a new open change request, assigning it
+	// an ID
+	Create(cr *types.ChangeRequest) (*types.ChangeRequest, error)
+	// Review - records a review of an open change request
+	Review(filesystemId, id string, review types.ChangeRequestReview) (*types.ChangeRequest, error)
+	// SetState - accepts or closes an open change request
+	SetState(filesystemId, id, state string) (*types.ChangeRequest, error)
+}
+
+type DefaultManager struct {
+	kv kv.KV
+}
+
+func New(kv kv.KV) *DefaultManager {
+	return &DefaultManager{
+		kv: kv,
+	}
+}
+
+func (m *DefaultManager) List(filesystemId string) ([]*types.ChangeRequest, error) {
+	nodes, err := m.kv.List(ChangeRequestsPrefix + "/" + filesystemId)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return []*types.ChangeRequest{}, nil
+		}
+		return nil, err
+	}
+	crs := make([]*types.ChangeRequest, 0, len(nodes))
+	for _, node := range nodes {
+		cr, err := decode(node.Value)
+		if err != nil {
+			return nil, err
+		}
+		crs = append(crs, cr)
+	}
+	sort.Slice(crs, func(i, j int) bool {
+		if crs[i].Created != crs[j].Created {
+			return crs[i].Created < crs[j].Created
+		}
+		return crs[i].Id < crs[j].Id
+	})
+	return crs, nil
+}
+
+func (m *DefaultManager) Get(filesystemId, id string) (*types.ChangeRequest, error) {
+	node, err := m.kv.Get(ChangeRequestsPrefix+"/"+filesystemId, id)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return nil, fmt.Errorf("No such change request %s", id)
+		}
+		return nil, err
+	}
+	return decode(node.Value)
+}
+
+func (m *DefaultManager) Create(cr *types.ChangeRequest) (*types.ChangeRequest, error) {
+	err := Validate(cr)
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	cr.Id = id.String()
+	cr.State = types.ChangeRequestOpen
+	cr.Reviews = nil
+	cr.Created = time.Now().Unix()
+	cr.Updated = cr.Created
+	err = m.save(cr)
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (m *DefaultManager) Review(filesystemId, id string, review types.ChangeRequestReview) (*types.ChangeRequest, error) {
+	cr, err := m.Get(filesystemId, id)
+	if err != nil {
+		return nil, err
+	}
+	if cr.State != types.ChangeRequestOpen {
+		return nil, fmt.Errorf("Change request %s is %s, only open change requests can be reviewed", id, cr.State)
+	}
+	review.Timestamp = time.Now().Unix()
+	cr.Reviews = append(cr.Reviews, review)
+	cr.Updated = review.Timestamp
+	err = m.save(cr)
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (m *DefaultManager) SetState(filesystemId, id, state string) (*types.ChangeRequest, error) {
+	if state != types.ChangeRequestAccepted && state != types.ChangeRequestClosed {
+		return nil, fmt.Errorf("Unknown change request state %q", state)
+	}
+	cr, err := m.Get(filesystemId, id)
+	if err != nil {
+		return nil, err
+	}
+	if cr.State != types.ChangeRequestOpen {
+		return nil, fmt.Errorf("Change request %s is already %s", id, cr.State)
+	}
+	cr.State = state
+	cr.Updated = time.Now().Unix()
+	err = m.save(cr)
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (m *DefaultManager) save(cr *types.ChangeRequest) error {
+	bts, err := json.Marshal(cr)
+	if err != nil {
+		return err
+	}
+	_, err = m.kv.Set(ChangeRequestsPrefix+"/"+cr.FilesystemId, cr.Id, string(bts))
+	return err
+}
+
+// Validate - checks that a change request says where the commits are going
+// from and to, and has a title
+func Validate(cr *types.ChangeRequest) error {
+	switch {
+	case cr.FilesystemId == "":
+		return fmt.Errorf("Change request filesystem ID not set")
+	case cr.ForkId == "":
+		return fmt.Errorf("Change request fork ID not set")
+	case cr.ForkId == cr.FilesystemId:
+		return fmt.Errorf("Change request can't be from a dot to itself")
+	case cr.CommitId == "":
+		return fmt.Errorf("Change request commit ID not set")
+	case cr.Title == "":
+		return fmt.Errorf("Change request needs a title")
+	}
+	return nil
+}
+
+func decode(val string) (*types.ChangeRequest, error) {
+	var cr types.ChangeRequest
+	err := json.Unmarshal([]byte(val), &cr)
+	if err != nil {
+		return nil, err
+	}
+	return &cr, nil
+}
@@ -0,0 +1,104 @@
+package changerequests
+
+import (
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/testutil"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestValidate(t *testing.T) {
+	valid := &types.ChangeRequest{FilesystemId: "parent", ForkId: "fork", CommitId: "commit", Title: "Fix the data"}
+	if err := Validate(valid); err != nil {
+		t.Errorf("expected change request %+v to be valid, got: %s", valid, err)
+	}
+
+	for _, cr := range []*types.ChangeRequest{
+		{ForkId: "fork", CommitId: "commit", Title: "Fix the data"},
+		{FilesystemId: "parent", CommitId: "commit", Title: "Fix the data"},
+		{FilesystemId: "parent", ForkId: "parent", CommitId: "commit", Title: "Fix the data"},
+		{FilesystemId: "parent", ForkId: "fork", Title: "Fix the data"},
+		{FilesystemId: "parent", ForkId: "fork", CommitId: "commit"},
+	} {
+		if err := Validate(cr); err == nil {
+			t.Errorf("expected change request %+v to be invalid", cr)
+		}
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	etcdClient, teardown, err := testutil.GetEtcdClient()
+	if err != nil {
+		t.Fatalf("failed to get etcd client: %s", err)
+	}
+	defer teardown()
+
+	m := New(kv.New(etcdClient, testutil.GetTestPrefix()))
+	parentId := "6b0f1c3e-6d0e-4c38-9d57-2a1f0e5b7c21"
+	forkId := "0c9d6a2b-51f4-4f0e-8e1a-7d3b9c4e2f10"
+
+	crs, err := m.List(parentId)
+	if err != nil {
+		t.Fatalf("failed to list change requests: %s", err)
+	}
+	if len(crs) != 0 {
+		t.Errorf("expected no change requests, got: %+v", crs)
+	}
+
+	created, err := m.Create(&types.ChangeRequest{
+		FilesystemId: parentId, ForkId: forkId, CommitId: "c1", Title: "Add the 2018 figures", AuthorId: "alice",
+	})
+	if err != nil {
+		t.Fatalf("failed to create change request: %s", err)
+	}
+	if created.Id == "" || created.State != types.ChangeRequestOpen {
+		t.Errorf("expected an open change request with an ID, got: %+v", created)
+	}
+	second, err := m.Create(&types.ChangeRequest{
+		FilesystemId: parentId, ForkId: forkId, CommitId: "c2", Title: "Add the 2019 figures", AuthorId: "alice",
+	})
+	if err != nil {
+		t.Fatalf("failed to create change request: %s", err)
+	}
+
+	reviewed, err := m.Review(parentId, created.Id, types.ChangeRequestReview{ReviewerId: "bob", Approved: true, Comment: "LGTM"})
+	if err != nil {
+		t.Fatalf("failed to review change request: %s", err)
+	}
+	if len(reviewed.Reviews) != 1 || !reviewed.Reviews[0].Approved || reviewed.Reviews[0].Timestamp == 0 {
+		t.Errorf("expected an approving review, got: %+v", reviewed.Reviews)
+	}
+
+	_, err = m.SetState(parentId, created.Id, types.ChangeRequestAccepted)
+	if err != nil {
+		t.Fatalf("failed to accept change request: %s", err)
+	}
+	_, err = m.SetState(parentId, created.Id, types.ChangeRequestClosed)
+	if err == nil {
+		t.Errorf("expected closing an accepted change request to fail")
+	}
+	_, err = m.Review(parentId, created.Id, types.ChangeRequestReview{ReviewerId: "bob"})
+	if err == nil {
+		t.Errorf("expected reviewing an accepted change request to fail")
+	}
+	_, err = m.SetState(parentId, second.Id, "merged")
+	if err == nil {
+		t.Errorf("expected an unknown state to be refused")
+	}
+
+	crs, err = m.List(parentId)
+	if err != nil {
+		t.Fatalf("failed to list change requests: %s", err)
+	}
+	if len(crs) != 2 {
+		t.Fatalf("expected 2 change requests, got: %+v", crs)
+	}
+	states := map[string]string{}
+	for _, cr := range crs {
+		states[cr.Id] = cr.State
+	}
+	if states[created.Id] != types.ChangeRequestAccepted || states[second.Id] != types.ChangeRequestOpen {
+		t.Errorf("unexpected states: %+v", states)
+	}
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// ActivityFilter - narrows down the activity streamed by WatchActivity,
+// empty fields match everything
+type ActivityFilter struct {
+	Namespace  string
+	Name       string
+	Branch     string
+	Types      []string
+	TransferId string
+}
+
+func (f ActivityFilter) query() string {
+	q := url.Values{}
+	if f.Namespace != "" {
+		q.Set("namespace", f.Namespace)
+	}
+	if f.Name != "" {
+		q.Set("name", f.Name)
+	}
+	if f.Branch != "" {
+		q.Set("branch", f.Branch)
+	}
+	if len(f.Types) > 0 {
+		q.Set("types", strings.Join(f.Types, ","))
+	}
+	if f.TransferId != "" {
+		q.Set("transfer", f.TransferId)
+	}
+	return q.Encode()
+}
+
+// ActivityStream - activity on dots, as streamed by the server's /activity
+// endpoint as Server-Sent Events
+type ActivityStream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+}
+
+// WatchActivity - connects to the server's activity stream, returning once
+// the server has subscribed to events so that nothing after that is missed.
+// Servers that predate the stream return an error, callers that can should
+// fall back to polling.
+func (j *JsonRpcClient) WatchActivity(ctx context.Context, filter ActivityFilter) (*ActivityStream, error) {
+	base, err := j.baseURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/activity?%s", base, filter.query()), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	req.SetBasicAuth(j.User, j.ApiKey)
+
+	httpClient, err := HTTPClient(j.TLS)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		if resp.StatusCode == 401 {
+			return nil, fmt.Errorf("Permission denied. Please check that your API key is still valid.")
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Unable to watch activity, status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return &ActivityStream{body: resp.Body, reader: bufio.NewReader(resp.Body)}, nil
+}
+
+// Next - blocks until the next event arrives, returning io.EOF if the server
+// closes the stream
+func (s *ActivityStream) Next() (*types.EventNotification, error) {
+	var data []string
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			// end of an event, or of a keep-alive
+			if len(data) == 0 {
+				continue
+			}
+			var event types.EventNotification
+			err = json.Unmarshal([]byte(strings.Join(data, "\n")), &event)
+			if err != nil {
+				return nil, err
+			}
+			return &event, nil
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		default:
+			// comments, ids and event names, the event name is in the data
+			// too
+		}
+	}
+}
+
+func (s *ActivityStream) Close() error {
+	return s.body.Close()
+}
+
+// WatchActivity - connects to the activity stream of the current remote
+func (dm *DotmeshAPI) WatchActivity(ctx context.Context, filter ActivityFilter) (*ActivityStream, error) {
+	err := dm.openClient()
+	if err != nil {
+		return nil, err
+	}
+	return dm.Client.WatchActivity(ctx, filter)
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestActivityFilterQuery(t *testing.T) {
+	tests := []struct {
+		filter ActivityFilter
+		want   string
+	}{
+		{ActivityFilter{}, ""},
+		{ActivityFilter{Namespace: "alice", Name: "db"}, "name=db&namespace=alice"},
+		{ActivityFilter{Branch: "feature", Types: []string{"commit", "branch.created"}}, "branch=feature&types=commit%2Cbranch.created"},
+		{ActivityFilter{TransferId: "t1"}, "transfer=t1"},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.query(); got != tt.want {
+			t.Errorf("%#v: expected %q, got %q", tt.filter, tt.want, got)
+		}
+	}
+}
+
+func TestActivityStreamNext(t *testing.T) {
+	body := ": keep-alive\n\n" +
+		"id: e1\nevent: commit\ndata: {\"Type\":\"commit\",\"Namespace\":\"alice\",\"Name\":\"db\",\"CommitId\":\"c1\"}\n\n" +
+		": keep-alive\n\n" +
+		// data split over lines, and CRLF line endings
+		"id: e2\r\nevent: transfer.progress\r\ndata: {\"Type\":\"transfer.progress\",\r\ndata:\"Transfer\":{\"Index\":1,\"Total\":2}}\r\n\r\n" +
+		"id: e3\nevent: commit\ndata: not json\n\n" +
+		// cut off part way through an event
+		"id: e4\nevent: commit\ndata: {}\n"
+	stream := &ActivityStream{body: ioutil.NopCloser(strings.NewReader(body))}
+	stream.reader = bufio.NewReader(stream.body)
+
+	event, err := stream.Next()
+	if err != nil {
+		t.Fatalf("expected the first event, got: %s", err)
+	}
+	if event.Type != "commit" || event.Namespace != "alice" || event.Name != "db" || event.CommitId != "c1" {
+		t.Errorf("unexpected first event: %#v", event)
+	}
+
+	event, err = stream.Next()
+	if err != nil {
+		t.Fatalf("expected the second event, got: %s", err)
+	}
+	if event.Type != "transfer.progress" || event.Transfer == nil || event.Transfer.Index != 1 || event.Transfer.Total != 2 {
+		t.Errorf("unexpected second event: %#v", event)
+	}
+
+	_, err = stream.Next()
+	if err == nil {
+		t.Error("expected an event that isn't JSON to be an error")
+	}
+
+	_, err = stream.Next()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF at the end of the stream, got: %v", err)
+	}
+}
+
+// testServer - a dotmesh server that answers GetTransfer with each of
+// transfers in turn, and streams activity if activity is set. Servers that
+// predate the activity stream 404 it.
+type testServer struct {
+	sync.Mutex
+	activity  []types.EventNotification
+	transfers []TransferPollResult
+	polls     int
+	query     string
+	user      string
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	switch r.URL.Path {
+	case "/activity":
+		if s.activity == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.query = r.URL.RawQuery
+		s.user, _, _ = r.BasicAuth()
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		for i, event := range s.activity {
+			data, _ := json.Marshal(&event)
+			fmt.Fprintf(w, "id: e%d\nevent: %s\ndata: %s\n\n", i, event.Type, data)
+		}
+	case "/rpc":
+		var request struct {
+			Id uint64 `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		result := s.transfers[len(s.transfers)-1]
+		if s.polls < len(s.transfers) {
+			result = s.transfers[s.polls]
+		}
+		s.polls++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0", "id": request.Id, "result": result,
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *testServer) start(t *testing.T) (*DotmeshAPI, func()) {
+	server := httptest.NewServer(s)
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm := &DotmeshAPI{Client: &JsonRpcClient{User: "alice", ApiKey: "secret", Hostname: host, Port: p}}
+	return dm, server.Close
+}
+
+func TestWatchActivity(t *testing.T) {
+	s := &testServer{activity: []types.EventNotification{
+		{Type: types.EventTypeCommit, Namespace: "alice", Name: "db", CommitId: "c1"},
+	}}
+	dm, stop := s.start(t)
+	defer stop()
+
+	stream, err := dm.WatchActivity(context.Background(), ActivityFilter{Namespace: "alice", Name: "db"})
+	if err != nil {
+		t.Fatalf("expected to watch activity, got: %s", err)
+	}
+	defer stream.Close()
+	s.Lock()
+	if s.query != "name=db&namespace=alice" || s.user != "alice" {
+		t.Errorf("unexpected request for %q by %q", s.query, s.user)
+	}
+	s.Unlock()
+	event, err := stream.Next()
+	if err != nil {
+		t.Fatalf("expected an event, got: %s", err)
+	}
+	if event.CommitId != "c1" {
+		t.Errorf("unexpected event: %#v", event)
+	}
+	_, err = stream.Next()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF when the server closes the stream, got: %v", err)
+	}
+
+	// servers that predate the stream
+	s.Lock()
+	s.activity = nil
+	s.Unlock()
+	_, err = dm.WatchActivity(context.Background(), ActivityFilter{})
+	if err == nil {
+		t.Error("expected an error from a server without the activity stream")
+	}
+}
+
+func TestPollTransferStreams(t *testing.T) {
+	s := &testServer{
+		activity: []types.EventNotification{
+			{Type: types.EventTypeTransferProgress, Transfer: &types.TransferNotification{
+				TransferRequestId: "t1", Index: 1, Total: 1, Status: "finished", Size: 10, Sent: 10,
+			}},
+		},
+		// what the transfer looked like before the stream started
+		transfers: []TransferPollResult{{TransferRequestId: "t1", Index: 1, Total: 1, Status: "pushing"}},
+	}
+	dm, stop := s.start(t)
+	defer stop()
+
+	out := &strings.Builder{}
+	err := dm.PollTransfer("t1", out)
+	if err != nil {
+		t.Errorf("expected the transfer to finish, got: %s", err)
+	}
+	if !strings.Contains(s.query, "transfer=t1") || !strings.Contains(s.query, "types=transfer.progress") {
+		t.Errorf("expected to watch the transfer's progress, got %q", s.query)
+	}
+	if s.polls != 1 {
+		t.Errorf("expected to catch up with the transfer once and then follow the stream, polled %d times", s.polls)
+	}
+}
+
+func TestPollTransferFallsBackToPolling(t *testing.T) {
+	tests := []struct {
+		name     string
+		activity []types.EventNotification
+	}{
+		{"server without the activity stream", nil},
+		// the stream drops before the transfer finishes
+		{"stream ends early", []types.EventNotification{
+			{Type: types.EventTypeTransferProgress, Transfer: &types.TransferNotification{
+				TransferRequestId: "t1", Index: 1, Total: 1, Status: "pushing",
+			}},
+		}},
+	}
+	for _, tt := range tests {
+		s := &testServer{
+			activity: tt.activity,
+			transfers: []TransferPollResult{
+				{TransferRequestId: "t1", Index: 1, Total: 1, Status: "pushing"},
+				{TransferRequestId: "t1", Index: 1, Total: 1, Status: "error", Message: "out of space"},
+			},
+		}
+		dm, stop := s.start(t)
+
+		out := &strings.Builder{}
+		err := dm.PollTransfer("t1", out)
+		stop()
+		if err == nil || err.Error() != "out of space" {
+			t.Errorf("%s: expected the transfer's error, got: %v", tt.name, err)
+		}
+		if s.polls < 2 {
+			t.Errorf("%s: expected to poll for the transfer, polled %d times", tt.name, s.polls)
+		}
+	}
+}
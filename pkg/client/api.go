@@ -44,6 +44,11 @@ type DotmeshVolume struct {
 	DirtyBytes     int64
 	CommitCount    int64
 	ServerStatuses map[string]string // serverId => status
+	QuotaBytes     int64
+	QuotaUsedBytes int64
+	// ForkParentId is the filesystem ID of the dot this one was forked from,
+	// if it's a fork
+	ForkParentId string
 }
 
 func CheckName(name string) bool {
@@ -180,6 +185,28 @@ func (dm *DotmeshAPI) ProcureVolume(volumeName string) (string, error) {
 	return response, nil
 }
 
+// ProcureCommit mounts dot@commit (or dot@branch@commit) read-only on the
+// server, for holder, and returns where
+func (dm *DotmeshAPI) ProcureCommit(volumeName, holder string) (string, error) {
+	var response string
+	namespace, name, err := ParseNamespacedVolume(volumeName)
+	if err != nil {
+		return "", err
+	}
+	err = dm.CallRemote(context.Background(), "DotmeshRPC.ProcureCommit", struct {
+		Namespace, Name, Subdot, Holder string
+	}{
+		Namespace: namespace,
+		Name:      name,
+		Subdot:    "__default__",
+		Holder:    holder,
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+	return response, nil
+}
+
 func (dm *DotmeshAPI) setCurrentVolume(volumeName string) error {
 	return dm.Configuration.SetCurrentVolume(volumeName)
 }
@@ -502,6 +529,367 @@ func (dm *DotmeshAPI) ForceBranchMaster(namespace, name, branch, newMaster strin
 	return err
 }
 
+// DotsAndBranches - every dot and branch on the cluster, along with the
+// cluster's servers and their addresses
+func (dm *DotmeshAPI) DotsAndBranches() (types.VolumesAndBranches, error) {
+	var result types.VolumesAndBranches
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.AllDotsAndBranches", struct{}{}, &result,
+	)
+	return result, err
+}
+
+// NodeStatus - the branch masters and running transfers on a node
+func (dm *DotmeshAPI) NodeStatus(node string) (types.NodeStatus, error) {
+	var result types.NodeStatus
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.NodeStatus", struct{ Node string }{
+			Node: node,
+		}, &result,
+	)
+	return result, err
+}
+
+func (dm *DotmeshAPI) Cordon(node string) error {
+	var result bool
+	return dm.CallRemote(
+		context.Background(), "DotmeshRPC.Cordon", struct{ Node string }{
+			Node: node,
+		}, &result,
+	)
+}
+
+func (dm *DotmeshAPI) Uncordon(node string) error {
+	var result bool
+	return dm.CallRemote(
+		context.Background(), "DotmeshRPC.Uncordon", struct{ Node string }{
+			Node: node,
+		}, &result,
+	)
+}
+
+// PublishCA - hands the cluster a new CA to rotate to, for the other nodes
+// to fetch with PublishedCA
+func (dm *DotmeshAPI) PublishCA(ca types.ClusterCA) error {
+	var result bool
+	return dm.CallRemote(context.Background(), "DotmeshRPC.PublishCA", ca, &result)
+}
+
+func (dm *DotmeshAPI) PublishedCA() (types.ClusterCA, error) {
+	var result types.ClusterCA
+	err := dm.CallRemote(context.Background(), "DotmeshRPC.PublishedCA", struct{}{}, &result)
+	return result, err
+}
+
+// UnpublishCA - deletes the CA handed to the cluster with PublishCA, once
+// the rotation to it has finished
+func (dm *DotmeshAPI) UnpublishCA() error {
+	var result bool
+	return dm.CallRemote(context.Background(), "DotmeshRPC.UnpublishCA", struct{}{}, &result)
+}
+
+// MoveBranchMaster - moves the master of a filesystem to whichever of the
+// candidate nodes is most up to date with it, returning the node it went to.
+// With force, the master is set over if the handoff fails and the target has
+// every commit.
+func (dm *DotmeshAPI) MoveBranchMaster(filesystemId string, candidates []string, force bool) (string, error) {
+	var result string
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.MoveBranchMaster", types.MoveBranchMasterRequest{
+			FilesystemId: filesystemId,
+			Candidates:   candidates,
+			Force:        force,
+		}, &result,
+	)
+	return result, err
+}
+
+// SetQuota - sets the storage quota for a dot, or for the whole namespace if
+// name is empty. A limit of zero removes the quota.
+func (dm *DotmeshAPI) SetQuota(namespace, name string, limitBytes int64) error {
+	var result bool
+	return dm.CallRemote(
+		context.Background(), "DotmeshRPC.SetQuota", struct {
+			Namespace  string
+			Name       string
+			LimitBytes int64
+		}{
+			Namespace:  namespace,
+			Name:       name,
+			LimitBytes: limitBytes,
+		}, &result,
+	)
+}
+
+// AddHook - adds a pre-commit or pre-push policy hook to a dot, which either
+// POSTs to url or, for the admin user only, runs command on the server
+func (dm *DotmeshAPI) AddHook(namespace, name, stage, url string, command []string, timeoutSeconds int) (*types.PolicyHook, error) {
+	var result types.PolicyHook
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.AddHook", struct {
+			Namespace      string
+			Name           string
+			Stage          string
+			URL            string
+			Command        []string
+			TimeoutSeconds int
+		}{
+			Namespace:      namespace,
+			Name:           name,
+			Stage:          stage,
+			URL:            url,
+			Command:        command,
+			TimeoutSeconds: timeoutSeconds,
+		}, &result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (dm *DotmeshAPI) Hooks(namespace, name string) ([]types.PolicyHook, error) {
+	var result []types.PolicyHook
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.Hooks", struct {
+			Namespace string
+			Name      string
+		}{
+			Namespace: namespace,
+			Name:      name,
+		}, &result,
+	)
+	return result, err
+}
+
+func (dm *DotmeshAPI) DeleteHook(namespace, name, id string) error {
+	var result bool
+	return dm.CallRemote(
+		context.Background(), "DotmeshRPC.DeleteHook", struct {
+			Namespace string
+			Name      string
+			Id        string
+		}{
+			Namespace: namespace,
+			Name:      name,
+			Id:        id,
+		}, &result,
+	)
+}
+
+// SetBranchProtection - replaces the protection rules of a branch of a dot,
+// a zero protection removing them
+func (dm *DotmeshAPI) SetBranchProtection(namespace, name, branch string, protection types.BranchProtection) error {
+	var result bool
+	return dm.CallRemote(
+		context.Background(), "DotmeshRPC.SetBranchProtection", struct {
+			Namespace  string
+			Name       string
+			Branch     string
+			Protection types.BranchProtection
+		}{
+			Namespace:  namespace,
+			Name:       name,
+			Branch:     branch,
+			Protection: protection,
+		}, &result,
+	)
+}
+
+// BranchProtections - the protection rules of a dot's branches, by branch
+// name
+func (dm *DotmeshAPI) BranchProtections(namespace, name string) (map[string]types.BranchProtection, error) {
+	var result map[string]types.BranchProtection
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.BranchProtections", VolumeName{namespace, name}, &result,
+	)
+	return result, err
+}
+
+// Fork - copies namespace/name into forkNamespace/forkName, returning the
+// fork's filesystem ID. The fork remembers the dot it was forked from.
+func (dm *DotmeshAPI) Fork(namespace, name, forkNamespace, forkName string) (string, error) {
+	fsId, err := dm.GetFsId(namespace, name, "")
+	if err != nil {
+		return "", err
+	}
+	var result string
+	err = dm.CallRemote(
+		context.Background(), "DotmeshRPC.Fork", struct {
+			MasterBranchID string
+			ForkNamespace  string
+			ForkName       string
+		}{
+			MasterBranchID: fsId,
+			ForkNamespace:  forkNamespace,
+			ForkName:       forkName,
+		}, &result,
+	)
+	return result, err
+}
+
+// ForkUpstream - the dot a fork was forked from
+func (dm *DotmeshAPI) ForkUpstream(namespace, name string) (VolumeName, error) {
+	fork, err := dm.BranchInfo(namespace, name, "")
+	if err != nil {
+		return VolumeName{}, err
+	}
+	if fork.ForkParentId == "" {
+		return VolumeName{}, fmt.Errorf("%s/%s isn't a fork", namespace, name)
+	}
+	var upstream DotmeshVolume
+	err = dm.CallRemote(
+		context.Background(), "DotmeshRPC.Get", fork.ForkParentId, &upstream,
+	)
+	if err != nil {
+		return VolumeName{}, err
+	}
+	return upstream.Name, nil
+}
+
+// SyncFork - brings a fork up to date with the dot it was forked from,
+// returning how many commits that took
+func (dm *DotmeshAPI) SyncFork(namespace, name string) (int, error) {
+	var result int
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.SyncFork", struct {
+			Namespace string
+			Name      string
+		}{
+			Namespace: namespace,
+			Name:      name,
+		}, &result,
+	)
+	return result, err
+}
+
+// CreateChangeRequest - proposes merging the latest commit on the fork
+// namespace/name into the dot it was forked from
+func (dm *DotmeshAPI) CreateChangeRequest(namespace, name, title, description string) (*types.ChangeRequest, error) {
+	var result types.ChangeRequest
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.CreateChangeRequest", struct {
+			Namespace   string
+			Name        string
+			Title       string
+			Description string
+		}{
+			Namespace:   namespace,
+			Name:        name,
+			Title:       title,
+			Description: description,
+		}, &result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ChangeRequests - the change requests proposed to namespace/name
+func (dm *DotmeshAPI) ChangeRequests(namespace, name string) ([]types.ChangeRequest, error) {
+	var result []types.ChangeRequest
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.ChangeRequests", struct {
+			Namespace string
+			Name      string
+		}{
+			Namespace: namespace,
+			Name:      name,
+		}, &result,
+	)
+	return result, err
+}
+
+func (dm *DotmeshAPI) ReviewChangeRequest(namespace, name, id string, approve bool, comment string) (*types.ChangeRequest, error) {
+	var result types.ChangeRequest
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.ReviewChangeRequest", struct {
+			Namespace string
+			Name      string
+			Id        string
+			Approve   bool
+			Comment   string
+		}{
+			Namespace: namespace,
+			Name:      name,
+			Id:        id,
+			Approve:   approve,
+			Comment:   comment,
+		}, &result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AcceptChangeRequest - accepts a change request, or closes it without
+// accepting it if accept is false
+func (dm *DotmeshAPI) AcceptChangeRequest(namespace, name, id string, accept bool) (*types.ChangeRequest, error) {
+	method := "DotmeshRPC.AcceptChangeRequest"
+	if !accept {
+		method = "DotmeshRPC.CloseChangeRequest"
+	}
+	var result types.ChangeRequest
+	err := dm.CallRemote(
+		context.Background(), method, struct {
+			Namespace string
+			Name      string
+			Id        string
+		}{
+			Namespace: namespace,
+			Name:      name,
+			Id:        id,
+		}, &result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SpaceUsage - space used by each branch of a dot (or just the given one, if
+// branch isn't empty) and each of their commits
+func (dm *DotmeshAPI) SpaceUsage(namespace, name, branch string) ([]types.BranchSpaceUsage, error) {
+	var result []types.BranchSpaceUsage
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.SpaceUsage", struct {
+			Namespace string
+			Name      string
+			Branch    string
+		}{
+			Namespace: namespace,
+			Name:      name,
+			Branch:    branch,
+		}, &result,
+	)
+	return result, err
+}
+
+// ReclaimableSpace - bytes that would be freed by deleting the commits
+// fromCommitId to toCommitId of a branch, or the whole branch if
+// fromCommitId is empty
+func (dm *DotmeshAPI) ReclaimableSpace(namespace, name, branch, fromCommitId, toCommitId string) (int64, error) {
+	var result int64
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.ReclaimableSpace", struct {
+			Namespace    string
+			Name         string
+			Branch       string
+			FromCommitId string
+			ToCommitId   string
+		}{
+			Namespace:    namespace,
+			Name:         name,
+			Branch:       branch,
+			FromCommitId: fromCommitId,
+			ToCommitId:   toCommitId,
+		}, &result,
+	)
+	return result, err
+}
+
 func (dm *DotmeshAPI) AllVolumes() ([]DotmeshVolume, error) {
 	filesystems := map[string]map[string]DotmeshVolume{}
 	result := []DotmeshVolume{}
@@ -586,6 +974,57 @@ type Snapshot struct {
 }
 
 func (dm *DotmeshAPI) ListCommits(activeVolumeName, activeBranch string) ([]Snapshot, error) {
+	return dm.ListCommitsMatching(activeVolumeName, activeBranch, types.CommitQuery{})
+}
+
+// QueryCommits - a page of the commits on a branch matching query
+func (dm *DotmeshAPI) QueryCommits(volumeName, branch string, query types.CommitQuery) (types.CommitPage, error) {
+	var result types.CommitPage
+
+	namespace, name, err := ParseNamespacedVolume(volumeName)
+	if err != nil {
+		return result, err
+	}
+
+	err = dm.CallRemote(
+		context.Background(),
+		"DotmeshRPC.QueryCommits",
+		struct {
+			Namespace, Name, Branch string
+			Query                   types.CommitQuery
+		}{namespace, name, deMasterify(branch), query},
+		&result,
+	)
+	return result, err
+}
+
+// ListCommitsMatching - all the commits on a branch matching query, oldest
+// first, fetched a page at a time. Servers too old to search commits send
+// them all, and they're filtered here.
+func (dm *DotmeshAPI) ListCommitsMatching(volumeName, branch string, query types.CommitQuery) ([]Snapshot, error) {
+	result := []Snapshot{}
+	query.Limit = types.MaxCommitPageSize
+	query.After = ""
+	for {
+		page, err := dm.QueryCommits(volumeName, branch, query)
+		if err != nil {
+			if strings.Contains(err.Error(), "can't find method") {
+				return dm.listAllCommitsMatching(volumeName, branch, query)
+			}
+			return []Snapshot{}, err
+		}
+		for _, commit := range page.Commits {
+			metadata := Metadata(commit.Metadata)
+			result = append(result, Snapshot{Id: commit.Id, Metadata: &metadata})
+		}
+		if page.Next == "" {
+			return result, nil
+		}
+		query.After = page.Next
+	}
+}
+
+func (dm *DotmeshAPI) listAllCommitsMatching(activeVolumeName, activeBranch string, query types.CommitQuery) ([]Snapshot, error) {
 	var result []Snapshot
 
 	activeNamespace, activeVolume, err := ParseNamespacedVolume(activeVolumeName)
@@ -609,7 +1048,18 @@ func (dm *DotmeshAPI) ListCommits(activeVolumeName, activeBranch string) ([]Snap
 	if err != nil {
 		return []Snapshot{}, err
 	}
-	return result, nil
+
+	matching := []Snapshot{}
+	for _, commit := range result {
+		s := types.Snapshot{Id: commit.Id}
+		if commit.Metadata != nil {
+			s.Metadata = types.Metadata(*commit.Metadata)
+		}
+		if query.Matches(&s) {
+			matching = append(matching, commit)
+		}
+	}
+	return matching, nil
 }
 
 func (dm *DotmeshAPI) findCommit(ref, volumeName, branchName string) (string, error) {
@@ -777,6 +1227,15 @@ func (transferPollResult TransferPollResult) String() string {
 	return toString
 }
 
+// GetTransfer - the progress of a transfer, or how it ended
+func (dm *DotmeshAPI) GetTransfer(transferId string) (TransferPollResult, error) {
+	var result TransferPollResult
+	err := dm.CallRemote(
+		context.Background(), "DotmeshRPC.GetTransfer", transferId, &result,
+	)
+	return result, err
+}
+
 type pollTransferInternalResult struct {
 	result TransferPollResult
 	err    error
@@ -786,8 +1245,13 @@ func (dm *DotmeshAPI) PollTransfer(transferId string, out io.Writer) error {
 
 	out.Write([]byte("Calculating...\n"))
 
-	var bar *pb.ProgressBar
-	started := false
+	progress := &transferProgress{out: out}
+
+	// follow the transfer as the server streams its progress, if it can
+	done, err := dm.streamTransfer(transferId, progress)
+	if done {
+		return err
+	}
 
 	debugMode := os.Getenv("DEBUG_MODE") != ""
 
@@ -850,72 +1314,137 @@ func (dm *DotmeshAPI) PollTransfer(transferId string, out io.Writer) error {
 			}
 		}
 
-		if !started {
-			bar = pb.New64(result.result.Size)
-			bar.ShowFinalTime = false
-			bar.SetMaxWidth(80)
-			bar.SetUnits(pb.U_BYTES)
-			bar.Start()
-			started = true
+		done, err := progress.update(result.result)
+		if done {
+			return err
 		}
+	}
+}
 
-		if result.result.Size != 0 {
-			bar.Total = result.result.Size
-		}
-		// Numbers reported by data transferred thru dotmesh versus size
-		// of stream reported by 'zfs send -nP' are off by a few kilobytes,
-		// fudge it (maybe no one will notice).
-		if result.result.Sent > result.result.Size {
-			bar.Set64(result.result.Size)
-		} else {
-			bar.Set64(result.result.Sent)
-		}
-		bar.Prefix(result.result.Status)
-		var speed string
-		if result.result.NanosecondsElapsed > 0 {
-			speed = fmt.Sprintf(" %.2f MiB/s",
-				// mib/sec
-				(float64(result.result.Sent)/(1024*1024))/
-					(float64(result.result.NanosecondsElapsed)/(1000*1000*1000)),
-			)
-		} else {
-			speed = " ? MiB/s"
-		}
-		quotient := fmt.Sprintf(" (%d/%d)", result.result.Index, result.result.Total)
-		bar.Postfix(speed + quotient)
+// streamTransfer - follows a transfer through the server's activity stream,
+// returning done as false if the stream isn't available or drops before the
+// transfer finishes, so that the caller can carry on by polling.
+func (dm *DotmeshAPI) streamTransfer(transferId string, progress *transferProgress) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := dm.WatchActivity(ctx, ActivityFilter{
+		Types:      []string{types.EventTypeTransferProgress},
+		TransferId: transferId,
+	})
+	if err != nil {
+		return false, nil
+	}
+	defer stream.Close()
 
-		if debugMode {
-			out.Write([]byte(fmt.Sprintf("DEBUG status %d / %d : %s\n", result.result.Index, result.result.Total, result.result.Status)))
+	// catch up with anything that happened before we were watching
+	var current TransferPollResult
+	rpcCtx, rpcCancel := context.WithTimeout(ctx, RPC_TIMEOUT)
+	err = dm.CallRemote(rpcCtx, "DotmeshRPC.GetTransfer", transferId, &current)
+	rpcCancel()
+	if err == nil {
+		done, err := progress.update(current)
+		if done {
+			return true, err
 		}
+	}
 
-		if result.result.Index == result.result.Total && result.result.Status == "finished" {
-			if started {
-				bar.FinishPrint("Done!")
-			}
-			// A terrible hack: many of the tests race the next 'dm log' or
-			// similar command against snapshots received by a push/pull/clone
-			// updating etcd which updates nodes' local caches of state. Give
-			// the etcd updates a 1 second head start, which might reduce the
-			// incidence of test flakes.
-			// TODO: In general, we need a better way to _request_ the current
-			// state of snapshots on a node, rather than always consulting
-			// potentially out-of-date global caches. This might help with
-			// scaling, too.
-			time.Sleep(time.Second)
-			return nil
+	for {
+		event, err := stream.Next()
+		if err != nil {
+			return false, nil
 		}
-		if result.result.Status == "error" {
-			if started {
-				bar.FinishPrint(fmt.Sprintf("error: %s", result.result.Message))
-			}
-			out.Write([]byte(result.result.Message + "\n"))
-			// A similarly terrible hack. See comment above.
-			time.Sleep(time.Second)
-			return fmt.Errorf(result.result.Message)
+		t := event.Transfer
+		done, err := progress.update(TransferPollResult{
+			TransferRequestId:  t.TransferRequestId,
+			Direction:          t.Direction,
+			Index:              t.Index,
+			Total:              t.Total,
+			Status:             t.Status,
+			NanosecondsElapsed: t.NanosecondsElapsed,
+			Size:               t.Size,
+			Sent:               t.Sent,
+			Message:            t.Message,
+		})
+		if done {
+			return true, err
 		}
 	}
 }
 
+// transferProgress - progress bar for a transfer
+type transferProgress struct {
+	out     io.Writer
+	bar     *pb.ProgressBar
+	started bool
+}
+
+// update - shows the latest state of the transfer, returning done when it's
+// finished or failed
+func (p *transferProgress) update(result TransferPollResult) (bool, error) {
+	if !p.started {
+		p.bar = pb.New64(result.Size)
+		p.bar.ShowFinalTime = false
+		p.bar.SetMaxWidth(80)
+		p.bar.SetUnits(pb.U_BYTES)
+		p.bar.Start()
+		p.started = true
+	}
+	bar := p.bar
+
+	if result.Size != 0 {
+		bar.Total = result.Size
+	}
+	// Numbers reported by data transferred thru dotmesh versus size
+	// of stream reported by 'zfs send -nP' are off by a few kilobytes,
+	// fudge it (maybe no one will notice).
+	if result.Sent > result.Size {
+		bar.Set64(result.Size)
+	} else {
+		bar.Set64(result.Sent)
+	}
+	bar.Prefix(result.Status)
+	var speed string
+	if result.NanosecondsElapsed > 0 {
+		speed = fmt.Sprintf(" %.2f MiB/s",
+			// mib/sec
+			(float64(result.Sent)/(1024*1024))/
+				(float64(result.NanosecondsElapsed)/(1000*1000*1000)),
+		)
+	} else {
+		speed = " ? MiB/s"
+	}
+	quotient := fmt.Sprintf(" (%d/%d)", result.Index, result.Total)
+	bar.Postfix(speed + quotient)
+
+	if os.Getenv("DEBUG_MODE") != "" {
+		p.out.Write([]byte(fmt.Sprintf("DEBUG status %d / %d : %s\n", result.Index, result.Total, result.Status)))
+	}
+
+	if result.Index == result.Total && result.Status == "finished" {
+		bar.FinishPrint("Done!")
+		// A terrible hack: many of the tests race the next 'dm log' or
+		// similar command against snapshots received by a push/pull/clone
+		// updating etcd which updates nodes' local caches of state. Give
+		// the etcd updates a 1 second head start, which might reduce the
+		// incidence of test flakes.
+		// TODO: In general, we need a better way to _request_ the current
+		// state of snapshots on a node, rather than always consulting
+		// potentially out-of-date global caches. This might help with
+		// scaling, too.
+		time.Sleep(time.Second)
+		return true, nil
+	}
+	if result.Status == "error" {
+		bar.FinishPrint(fmt.Sprintf("error: %s", result.Message))
+		p.out.Write([]byte(result.Message + "\n"))
+		// A similarly terrible hack. See comment above.
+		time.Sleep(time.Second)
+		return true, fmt.Errorf("%s", result.Message)
+	}
+	return false, nil
+}
+
 /*
 
 pull
@@ -1080,6 +1609,7 @@ func (dm *DotmeshAPI) RequestTransfer(
 			RemoteName:       remoteVolume,
 			RemoteBranchName: deMasterify(remoteBranchName),
 			StashDivergence:  stashDivergence,
+			TLS:              dmRemote.TLS,
 			// TODO add TargetSnapshot here, to support specifying "push to a given
 			// snapshot" rather than just "push all snapshots up to the latest"
 		}
@@ -15,6 +15,8 @@ import (
 	"github.com/opentracing/opentracing-go"
 	//	opentracinglog "github.com/opentracing/opentracing-go/log"
 	"github.com/openzipkin/zipkin-go-opentracing/examples/middleware"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
 )
 
 // RPC client for inter-cluster operation
@@ -29,6 +31,8 @@ type JsonRpcClient struct {
 	ApiKey   string
 	Port     int
 	Verbose  bool
+	// how to verify the server's certificate, nil if it doesn't serve TLS
+	TLS *types.RemoteTLS
 }
 
 func (jsonRpcClient JsonRpcClient) String() string {
@@ -59,21 +63,24 @@ func NewJsonRpcClient(user, hostname, apiKey string, port int) *JsonRpcClient {
 func (j *JsonRpcClient) CallRemote(
 	ctx context.Context, method string, args interface{}, result interface{},
 ) error {
-	// RPCs are always between clusters, so "external"
-	var url string
-	var err error
-	if j.Port == 0 {
-		url, err = DeduceUrl(ctx, []string{j.Hostname}, "external", j.User, j.ApiKey)
-		if err != nil {
-			return err
-		}
-	} else {
-		url = fmt.Sprintf("http://%s:%d", j.Hostname, j.Port)
+	url, err := j.baseURL(ctx)
+	if err != nil {
+		return err
 	}
 	url = fmt.Sprintf("%s/rpc", url)
 	return j.reallyCallRemote(ctx, method, args, result, url)
 }
 
+// baseURL - where to find the server, RPCs are always between clusters, so
+// "external"
+func (j *JsonRpcClient) baseURL(ctx context.Context) (string, error) {
+	url, ok := BaseURL(j.Hostname, j.Port, j.TLS)
+	if !ok {
+		return DeduceUrl(ctx, []string{j.Hostname}, "external", j.User, j.ApiKey)
+	}
+	return url, nil
+}
+
 func (j *JsonRpcClient) reallyCallRemote(
 	ctx context.Context, method string, args interface{}, result interface{},
 	urlToUse string,
@@ -110,7 +117,11 @@ func (j *JsonRpcClient) reallyCallRemote(
 	req.Header.Set("Content-Type", "application/json")
 	req.SetBasicAuth(j.User, j.ApiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	httpClient, err := HTTPClient(j.TLS)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -149,10 +160,12 @@ func DeduceUrl(ctx context.Context, hostnames []string, mode, user, apiKey strin
 	var errs []error
 	for _, hostname := range hostnames {
 		var urlsToTry []string
-		if mode == "external" && (strings.HasSuffix(hostname, "dothub.com") || strings.HasSuffix(hostname, "dotscience.net") || strings.HasSuffix(hostname, "dotscience.com")) {
+		if mode == "external" && isHubHostname(hostname) {
 			urlsToTry = []string{
 				fmt.Sprintf("https://%s:443", hostname),
 			}
+		} else if mode == "internal" {
+			urlsToTry = internalURLs(hostname)
 		} else {
 			urlsToTry = []string{
 				fmt.Sprintf("http://%s:%s", hostname, SERVER_PORT),
@@ -164,9 +177,15 @@ func DeduceUrl(ctx context.Context, hostnames []string, mode, user, apiKey strin
 			// hostname (2nd arg) doesn't matter because we're just calling
 			// reallyCallRemote which doesn't use it.
 			j := NewJsonRpcClient(user, "", apiKey, 0)
+			if mode == "internal" {
+				j.TLS = internalTLS
+			}
 			var result bool
 			err := j.reallyCallRemote(ctx, "DotmeshRPC.Ping", nil, &result, urlToTry+"/rpc")
 			if err == nil {
+				if mode == "internal" && strings.HasPrefix(urlToTry, "https://") {
+					servesInternalTLS(hostname)
+				}
 				return urlToTry, nil
 			} else {
 				errs = append(errs, err)
@@ -178,6 +197,12 @@ func DeduceUrl(ctx context.Context, hostnames []string, mode, user, apiKey strin
 
 }
 
+// isHubHostname - whether hostname is a hosted service, always served over
+// TLS on the standard port
+func isHubHostname(hostname string) bool {
+	return strings.HasSuffix(hostname, "dothub.com") || strings.HasSuffix(hostname, "dotscience.net") || strings.HasSuffix(hostname, "dotscience.com")
+}
+
 func (client *JsonRpcClient) Ping() (bool, error) {
 	var response bool
 	ctx, cancel := context.WithTimeout(context.Background(), RPC_TIMEOUT)
@@ -29,6 +29,8 @@ import (
 	"os"
 	"reflect"
 	"sync"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
 )
 
 type Remote interface {
@@ -52,6 +54,8 @@ type DMRemote struct {
 	CurrentVolume        string
 	CurrentBranches      map[string]string
 	DefaultRemoteVolumes map[string]map[string]VolumeName
+	// how to verify the cluster's certificate, nil if it doesn't serve TLS
+	TLS *types.RemoteTLS `json:",omitempty"`
 }
 
 func (remote DMRemote) DefaultNamespace() string {
@@ -427,7 +431,7 @@ func (c *Configuration) AddS3Remote(remote, keyID, secretKey, endpoint string) e
 	return c.save()
 }
 
-func (c *Configuration) AddRemote(remote, user, hostname string, port int, apiKey string) error {
+func (c *Configuration) AddRemote(remote, user, hostname string, port int, apiKey string, tls *types.RemoteTLS) error {
 	ok := c.RemoteExists(remote)
 	if ok {
 		return fmt.Errorf("Remote exists '%s'", remote)
@@ -437,7 +441,21 @@ func (c *Configuration) AddRemote(remote, user, hostname string, port int, apiKe
 		Hostname: hostname,
 		Port:     port,
 		ApiKey:   apiKey,
+		TLS:      tls,
+	}
+	return c.save()
+}
+
+// SetRemoteTLS - changes how the remote's certificate is verified, e.g. once
+// its CA has been rotated
+func (c *Configuration) SetRemoteTLS(remote string, tls *types.RemoteTLS) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	r, ok := c.DMRemotes[remote]
+	if !ok {
+		return fmt.Errorf("No such remote '%s'", remote)
 	}
+	r.TLS = tls
 	return c.save()
 }
 
@@ -472,6 +490,7 @@ func (c *Configuration) ClusterFromRemote(remote string, verbose bool) (*JsonRpc
 		Port:     remoteCreds.Port,
 		ApiKey:   remoteCreds.ApiKey,
 		Verbose:  verbose,
+		TLS:      remoteCreds.TLS,
 	}, nil
 }
 
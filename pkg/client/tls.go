@@ -0,0 +1,226 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// TLS
+//
+// A server started with DOTMESH_TLS serves TLS on SERVER_PORT instead of
+// plain HTTP. Clients say how to verify its certificate with a
+// types.RemoteTLS: remotes have one if they were added with an https://
+// host, a CA bundle or a pinned fingerprint, and the servers in a cluster
+// serving TLS talk to each other trusting the cluster CA (SetInternalTLS).
+
+var (
+	httpClientsLock sync.Mutex
+	// one client per way of verifying servers, so connections are reused
+	httpClients = map[types.RemoteTLS]*http.Client{}
+
+	internalHTTPClient   = http.DefaultClient
+	internalTLS          *types.RemoteTLS
+	internalTLSFirst     bool
+	internalHTTPFallback bool
+
+	// the nodes seen serving TLS, which are never tried over plain HTTP again
+	internalTLSHostsLock sync.Mutex
+	internalTLSHosts     = map[string]bool{}
+)
+
+// HTTPClient - a client verifying servers' certificates as t says, or
+// http.DefaultClient if t is nil
+func HTTPClient(t *types.RemoteTLS) (*http.Client, error) {
+	if t == nil {
+		return http.DefaultClient, nil
+	}
+	httpClientsLock.Lock()
+	defer httpClientsLock.Unlock()
+	c, ok := httpClients[*t]
+	if ok {
+		return c, nil
+	}
+	config, err := TLSConfig(t)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	c = &http.Client{Transport: transport}
+	httpClients[*t] = c
+	return c, nil
+}
+
+// TLSConfig - a tls.Config verifying servers' certificates as t says
+func TLSConfig(t *types.RemoteTLS) (*tls.Config, error) {
+	config := &tls.Config{}
+	if t.CACert != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM([]byte(t.CACert)) {
+			return nil, fmt.Errorf("No PEM encoded certificates found in the CA bundle")
+		}
+		config.RootCAs = roots
+	}
+	if t.Fingerprint != "" {
+		want := NormalizeFingerprint(t.Fingerprint)
+		if len(want) != 2*sha256.Size {
+			return nil, fmt.Errorf("%q isn't a SHA-256 fingerprint", t.Fingerprint)
+		}
+		// pinning the certificate replaces checking who signed it, so that
+		// self-signed certificates can be used
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return &FingerprintMismatchError{Want: want}
+			}
+			got := CertificateFingerprint(rawCerts[0])
+			if got != want {
+				return &FingerprintMismatchError{Want: want, Got: got}
+			}
+			return nil
+		}
+	}
+	return config, nil
+}
+
+// FingerprintMismatchError - the server's certificate isn't the pinned one
+type FingerprintMismatchError struct {
+	Want string
+	Got  string
+}
+
+func (e *FingerprintMismatchError) Error() string {
+	return fmt.Sprintf(
+		"Server certificate has fingerprint %s, expected %s", e.Got, e.Want,
+	)
+}
+
+// CertificateFingerprint - the SHA-256 fingerprint of a DER encoded
+// certificate, in lower case hex
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint - a fingerprint in the form CertificateFingerprint
+// returns, so that ones copied from openssl (upper case, with colons between
+// the bytes) can be used too
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+}
+
+// IsCertificateError - whether err is down to the server's certificate not
+// being trusted
+func IsCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var mismatch *FingerprintMismatchError
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &invalid) ||
+		errors.As(err, &mismatch)
+}
+
+// FetchCertificate - the certificate the server at address (host:port)
+// presents, without verifying it, so that users can be shown its
+// fingerprint to decide whether to trust it
+func FetchCertificate(address string) (*x509.Certificate, error) {
+	conn, err := tls.DialWithDialer(
+		&net.Dialer{Timeout: RPC_TIMEOUT}, "tcp", address,
+		&tls.Config{InsecureSkipVerify: true},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s presented no certificate", address)
+	}
+	return certs[0], nil
+}
+
+// BaseURL - where to find the server at hostname, if that's known without
+// trying addresses: servers serving TLS are on port, or the default port if
+// it's 0, and plain HTTP ones only if port is given
+func BaseURL(hostname string, port int, t *types.RemoteTLS) (string, bool) {
+	if t != nil {
+		if port != 0 {
+			return fmt.Sprintf("https://%s:%d", hostname, port), true
+		}
+		if isHubHostname(hostname) {
+			return fmt.Sprintf("https://%s:443", hostname), true
+		}
+		return fmt.Sprintf("https://%s:%s", hostname, SERVER_PORT), true
+	}
+	if port == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("http://%s:%d", hostname, port), true
+}
+
+// SetInternalTLS - has connections within the cluster (DeduceUrl's
+// "internal" mode, and InternalHTTPClient) use TLS verified as t says.
+// Called by servers at startup. Only with httpFallback set, while TLS is
+// being turned on one node at a time, are nodes also tried over plain HTTP,
+// TLS first if first is set, and then only until they're seen serving TLS
+func SetInternalTLS(t *types.RemoteTLS, first, httpFallback bool) error {
+	c, err := HTTPClient(t)
+	if err != nil {
+		return err
+	}
+	internalTLS = t
+	internalTLSFirst = first
+	internalHTTPFallback = httpFallback
+	internalHTTPClient = c
+	return nil
+}
+
+// internalURLs - the URLs to try to reach the server on hostname at from
+// within the cluster, in order
+func internalURLs(hostname string) []string {
+	httpURL := fmt.Sprintf("http://%s:%s", hostname, SERVER_PORT)
+	if internalTLS == nil {
+		return []string{httpURL, fmt.Sprintf("http://%s:%s", hostname, SERVER_PORT_OLD)}
+	}
+	httpsURL := fmt.Sprintf("https://%s:%s", hostname, SERVER_PORT)
+
+	internalTLSHostsLock.Lock()
+	servesTLS := internalTLSHosts[hostname]
+	internalTLSHostsLock.Unlock()
+	if !internalHTTPFallback || servesTLS {
+		return []string{httpsURL}
+	}
+	if internalTLSFirst {
+		return []string{httpsURL, httpURL}
+	}
+	return []string{httpURL, httpsURL}
+}
+
+// servesInternalTLS - records that the server on hostname was reached over
+// TLS, so it's not tried over plain HTTP again
+func servesInternalTLS(hostname string) {
+	internalTLSHostsLock.Lock()
+	defer internalTLSHostsLock.Unlock()
+	internalTLSHosts[hostname] = true
+}
+
+// InternalHTTPClient - the client for the URLs DeduceUrl finds in "internal"
+// mode
+func InternalHTTPClient() *http.Client {
+	return internalHTTPClient
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestInternalURLs(t *testing.T) {
+	const (
+		httpURL    = "http://10.0.0.2:32607"
+		oldHTTPURL = "http://10.0.0.2:6969"
+		httpsURL   = "https://10.0.0.2:32607"
+	)
+	tests := []struct {
+		name         string
+		tls          *types.RemoteTLS
+		first        bool
+		httpFallback bool
+		seenTLS      bool
+		want         []string
+	}{
+		{"cluster without TLS", nil, false, false, false, []string{httpURL, oldHTTPURL}},
+		{"serving TLS", &types.RemoteTLS{}, true, false, false, []string{httpsURL}},
+		{"serving TLS during rollout", &types.RemoteTLS{}, true, true, false, []string{httpsURL, httpURL}},
+		{"not serving TLS during rollout", &types.RemoteTLS{}, false, true, false, []string{httpURL, httpsURL}},
+		{"node seen serving TLS during rollout", &types.RemoteTLS{}, false, true, true, []string{httpsURL}},
+	}
+	defer func() {
+		internalTLS, internalTLSFirst, internalHTTPFallback = nil, false, false
+		internalTLSHosts = map[string]bool{}
+	}()
+	for _, tt := range tests {
+		internalTLS, internalTLSFirst, internalHTTPFallback = tt.tls, tt.first, tt.httpFallback
+		internalTLSHosts = map[string]bool{}
+		if tt.seenTLS {
+			servesInternalTLS("10.0.0.2")
+		}
+		if got := internalURLs("10.0.0.2"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package container
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// The subset of the CRI (runtime.v1) RuntimeService that the CRI client
+// needs, written out by hand rather than generated so as not to vendor all of
+// k8s.io/cri-api. Field numbers and names match api.proto; fields we don't
+// use are left out, and are skipped when decoding responses.
+
+const (
+	criMethodListContainers  = "/runtime.v1.RuntimeService/ListContainers"
+	criMethodContainerStatus = "/runtime.v1.RuntimeService/ContainerStatus"
+)
+
+// ContainerState
+const (
+	criContainerCreated int32 = 0
+	criContainerRunning int32 = 1
+	criContainerExited  int32 = 2
+	criContainerUnknown int32 = 3
+)
+
+// Labels kubelet puts on the containers it creates
+const (
+	criPodNamespaceLabel = "io.kubernetes.pod.namespace"
+	criPodNameLabel      = "io.kubernetes.pod.name"
+	criContainerLabel    = "io.kubernetes.container.name"
+)
+
+type criContainerStateValue struct {
+	State int32 `protobuf:"varint,1,opt,name=state,proto3" json:"state,omitempty"`
+}
+
+func (m *criContainerStateValue) Reset()         { *m = criContainerStateValue{} }
+func (m *criContainerStateValue) String() string { return proto.CompactTextString(m) }
+func (*criContainerStateValue) ProtoMessage()    {}
+
+type criContainerFilter struct {
+	Id           string                  `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	State        *criContainerStateValue `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"`
+	PodSandboxId string                  `protobuf:"bytes,3,opt,name=pod_sandbox_id,json=podSandboxId,proto3" json:"pod_sandbox_id,omitempty"`
+}
+
+func (m *criContainerFilter) Reset()         { *m = criContainerFilter{} }
+func (m *criContainerFilter) String() string { return proto.CompactTextString(m) }
+func (*criContainerFilter) ProtoMessage()    {}
+
+type criListContainersRequest struct {
+	Filter *criContainerFilter `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+}
+
+func (m *criListContainersRequest) Reset()         { *m = criListContainersRequest{} }
+func (m *criListContainersRequest) String() string { return proto.CompactTextString(m) }
+func (*criListContainersRequest) ProtoMessage()    {}
+
+type criContainerMetadata struct {
+	Name    string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Attempt uint32 `protobuf:"varint,2,opt,name=attempt,proto3" json:"attempt,omitempty"`
+}
+
+func (m *criContainerMetadata) Reset()         { *m = criContainerMetadata{} }
+func (m *criContainerMetadata) String() string { return proto.CompactTextString(m) }
+func (*criContainerMetadata) ProtoMessage()    {}
+
+type criContainer struct {
+	Id           string                `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	PodSandboxId string                `protobuf:"bytes,2,opt,name=pod_sandbox_id,json=podSandboxId,proto3" json:"pod_sandbox_id,omitempty"`
+	Metadata     *criContainerMetadata `protobuf:"bytes,3,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	State        int32                 `protobuf:"varint,6,opt,name=state,proto3" json:"state,omitempty"`
+	Labels       map[string]string     `protobuf:"bytes,8,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *criContainer) Reset()         { *m = criContainer{} }
+func (m *criContainer) String() string { return proto.CompactTextString(m) }
+func (*criContainer) ProtoMessage()    {}
+
+type criListContainersResponse struct {
+	Containers []*criContainer `protobuf:"bytes,1,rep,name=containers,proto3" json:"containers,omitempty"`
+}
+
+func (m *criListContainersResponse) Reset()         { *m = criListContainersResponse{} }
+func (m *criListContainersResponse) String() string { return proto.CompactTextString(m) }
+func (*criListContainersResponse) ProtoMessage()    {}
+
+type criContainerStatusRequest struct {
+	ContainerId string `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	Verbose     bool   `protobuf:"varint,2,opt,name=verbose,proto3" json:"verbose,omitempty"`
+}
+
+func (m *criContainerStatusRequest) Reset()         { *m = criContainerStatusRequest{} }
+func (m *criContainerStatusRequest) String() string { return proto.CompactTextString(m) }
+func (*criContainerStatusRequest) ProtoMessage()    {}
+
+type criMount struct {
+	ContainerPath string `protobuf:"bytes,1,opt,name=container_path,json=containerPath,proto3" json:"container_path,omitempty"`
+	HostPath      string `protobuf:"bytes,2,opt,name=host_path,json=hostPath,proto3" json:"host_path,omitempty"`
+	Readonly      bool   `protobuf:"varint,3,opt,name=readonly,proto3" json:"readonly,omitempty"`
+}
+
+func (m *criMount) Reset()         { *m = criMount{} }
+func (m *criMount) String() string { return proto.CompactTextString(m) }
+func (*criMount) ProtoMessage()    {}
+
+type criContainerStatus struct {
+	Id       string                `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Metadata *criContainerMetadata `protobuf:"bytes,2,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	State    int32                 `protobuf:"varint,3,opt,name=state,proto3" json:"state,omitempty"`
+	Labels   map[string]string     `protobuf:"bytes,12,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Mounts   []*criMount           `protobuf:"bytes,14,rep,name=mounts,proto3" json:"mounts,omitempty"`
+}
+
+func (m *criContainerStatus) Reset()         { *m = criContainerStatus{} }
+func (m *criContainerStatus) String() string { return proto.CompactTextString(m) }
+func (*criContainerStatus) ProtoMessage()    {}
+
+type criContainerStatusResponse struct {
+	Status *criContainerStatus `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+}
+
+func (m *criContainerStatusResponse) Reset()         { *m = criContainerStatusResponse{} }
+func (m *criContainerStatusResponse) String() string { return proto.CompactTextString(m) }
+func (*criContainerStatusResponse) ProtoMessage()    {}
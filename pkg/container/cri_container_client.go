@@ -0,0 +1,384 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// The dataset dotmesh filesystems live in, as types.RootFS (which can't be
+// imported here, as pkg/types imports this package)
+const ROOT_FS = "dmfs"
+
+const DEFAULT_CRI_ENDPOINT = "unix:///run/containerd/containerd.sock"
+
+// How long to wait for the container runtime to answer each request
+const CRI_REQUEST_TIMEOUT = 30 * time.Second
+
+// CRIClient tracks and stops containers through a CRI runtime (containerd,
+// or anything else kubelet can talk to), for nodes where kubernetes isn't
+// using docker. Containers there get dots through the flexvolume driver,
+// which symlinks the pod's volume directory to a container mount symlink, or
+// the CSI driver, which bind mounts the branch's filesystem. Only the former
+// can be switched to another filesystem; CSI volumes are pinned to a branch.
+//
+// Unlike docker, CRI runtimes won't restart a container once it's stopped,
+// and kubelet may replace a stopped container at any moment, so containers
+// can't be safely stopped and started again around a checkout or rollback.
+// Instead those are refused while a container is using the dot, and its pod
+// has to be scaled down (or deleted) first.
+type CRIClient struct {
+	containerMountPrefix  string
+	conn                  *grpc.ClientConn
+	containersStopped     map[string]map[string]string
+	containerMountDirLock *sync.Mutex
+	filesystemName        func(filesystemId string) (string, bool)
+	mountinfoPath         string
+}
+
+func NewCRI(options *Options) (*CRIClient, error) {
+	endpoint := options.CRIEndpoint
+	if endpoint == "" {
+		endpoint = DEFAULT_CRI_ENDPOINT
+	}
+	if !strings.HasPrefix(endpoint, "unix://") {
+		return nil, fmt.Errorf("Unsupported CRI endpoint %s, expected unix:///path/to/socket", endpoint)
+	}
+	// doesn't block, so the runtime doesn't need to be up yet
+	conn, err := grpc.Dial(
+		strings.TrimPrefix(endpoint, "unix://"),
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CRIClient{
+		containerMountPrefix:  options.ContainerMountPrefix,
+		conn:                  conn,
+		containersStopped:     make(map[string]map[string]string),
+		containerMountDirLock: options.ContainerMountDirLock,
+		filesystemName:        options.FilesystemName,
+		mountinfoPath:         "/proc/self/mountinfo",
+	}, nil
+}
+
+// A container mount that's a dot
+type criDotMount struct {
+	// namespace/name, without the admin namespace
+	name         string
+	filesystemId string
+	// the container mount symlink that points at the filesystem, if the dot
+	// can be switched to another one
+	symlink string
+	// whether it follows the dot's current branch
+	follows bool
+}
+
+type criContainerMounts struct {
+	container DockerContainer
+	running   bool
+	dots      []criDotMount
+}
+
+func (c *CRIClient) invoke(method string, request, response interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), CRI_REQUEST_TIMEOUT)
+	defer cancel()
+	return c.conn.Invoke(ctx, method, request, response)
+}
+
+// containers lists containers (just the running ones, unless all is set),
+// along with the dots each of them has mounted
+func (c *CRIClient) containers(all bool) ([]criContainerMounts, error) {
+	request := &criListContainersRequest{}
+	if !all {
+		request.Filter = &criContainerFilter{
+			State: &criContainerStateValue{State: criContainerRunning},
+		}
+	}
+	list := &criListContainersResponse{}
+	err := c.invoke(criMethodListContainers, request, list)
+	if err != nil {
+		return nil, err
+	}
+	mountinfo, err := c.zfsMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []criContainerMounts{}
+	for _, listed := range list.Containers {
+		status := &criContainerStatusResponse{}
+		err := c.invoke(criMethodContainerStatus, &criContainerStatusRequest{ContainerId: listed.Id}, status)
+		if err != nil {
+			// it may have gone away since it was listed
+			log.Printf("[CRIClient] Error getting status of container %s, skipping: %s", listed.Id, err)
+			continue
+		}
+		if status.Status == nil {
+			continue
+		}
+		cm := criContainerMounts{
+			container: DockerContainer{Id: listed.Id, Name: criContainerName(status.Status)},
+			running:   status.Status.State == criContainerRunning,
+		}
+		for _, mount := range status.Status.Mounts {
+			dot, ok := c.resolveMount(mount.HostPath, mountinfo)
+			if ok {
+				cm.dots = append(cm.dots, dot)
+			}
+		}
+		if len(cm.dots) > 0 {
+			result = append(result, cm)
+		}
+	}
+	return result, nil
+}
+
+// criContainerName - namespace/pod/container for containers created by
+// kubelet, otherwise just the name the container was created with
+func criContainerName(status *criContainerStatus) string {
+	pod := status.Labels[criPodNameLabel]
+	name := status.Labels[criContainerLabel]
+	if pod != "" && name != "" {
+		return fmt.Sprintf("%s/%s/%s", status.Labels[criPodNamespaceLabel], pod, name)
+	}
+	if status.Metadata != nil {
+		return status.Metadata.Name
+	}
+	return status.Id
+}
+
+// criVolumeName - the dot name as it'd be given by a user, without the admin
+// namespace, as Related is sometimes given it with and sometimes without
+func criVolumeName(volumeName string) string {
+	return baseDotName(strings.TrimPrefix(volumeName, "admin/"))
+}
+
+// resolveMount works out which dot, if any, a container's mount is: a path in
+// the container mount directory (or a read-only view in it), a symlink to
+// one, or a bind mount of one of our filesystems
+func (c *CRIClient) resolveMount(hostPath string, zfsMounts map[string]string) (criDotMount, bool) {
+	path := hostPath
+	if !strings.HasPrefix(path, c.containerMountPrefix+"/") {
+		if target, err := os.Readlink(path); err == nil && filepath.IsAbs(target) {
+			path = target
+		}
+	}
+
+	if strings.HasPrefix(path, c.containerMountPrefix+"/") {
+		if filesystemId, ok := readonlyMountFilesystem(c.containerMountPrefix, path); ok {
+			return c.namedMount(filesystemId)
+		}
+		root := findDotRoot(c.containerMountPrefix, path)
+		target, err := os.Readlink(root)
+		if err != nil {
+			log.Printf("[CRIClient] Error trying to read symlink '%s', skipping: %s", root, err)
+			return criDotMount{}, false
+		}
+		parts := strings.Split(strings.TrimPrefix(root, c.containerMountPrefix+"/"), "/")
+		if len(parts) != 2 {
+			return criDotMount{}, false
+		}
+		return criDotMount{
+			name:         criVolumeName(parts[0] + "/" + parts[1]),
+			filesystemId: filepath.Base(target),
+			symlink:      root,
+			follows:      followsCurrentBranch(c.containerMountPrefix, root),
+		}, true
+	}
+
+	if source, ok := zfsMounts[hostPath]; ok {
+		if filesystemId, ok := dotmeshFilesystem(source); ok {
+			return c.namedMount(filesystemId)
+		}
+	}
+	return criDotMount{}, false
+}
+
+func (c *CRIClient) namedMount(filesystemId string) (criDotMount, bool) {
+	name := ""
+	if c.filesystemName != nil {
+		name, _ = c.filesystemName(filesystemId)
+	}
+	return criDotMount{name: criVolumeName(name), filesystemId: filesystemId}, true
+}
+
+// dotmeshFilesystem - the filesystem id from a zfs dataset name like
+// pool/dmfs/:filesystemId or pool/dmfs/:filesystemId@:snapshotId
+func dotmeshFilesystem(dataset string) (string, bool) {
+	i := strings.Index(dataset, "/"+ROOT_FS+"/")
+	if i == -1 {
+		return "", false
+	}
+	filesystemId := dataset[i+len(ROOT_FS)+2:]
+	if at := strings.Index(filesystemId, "@"); at != -1 {
+		filesystemId = filesystemId[:at]
+	}
+	if filesystemId == "" || strings.Contains(filesystemId, "/") {
+		return "", false
+	}
+	return filesystemId, true
+}
+
+// zfsMounts - the zfs datasets mounted on this node, by mount point, which is
+// how the bind mounts the CSI driver makes are found
+func (c *CRIClient) zfsMounts() (map[string]string, error) {
+	result := map[string]string{}
+	f, err := os.Open(c.mountinfoPath)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /root /mount/point rw,noatime master:1 - zfs pool/dmfs/id rw
+		fields := strings.Fields(scanner.Text())
+		for i, field := range fields {
+			if field == "-" && i > 4 && len(fields) > i+2 {
+				if fields[i+1] == "zfs" {
+					result[fields[4]] = fields[i+2]
+				}
+				break
+			}
+		}
+	}
+	return result, scanner.Err()
+}
+
+func (c *CRIClient) AllRelated() (map[string][]DockerContainer, error) {
+	relatedContainers := map[string][]DockerContainer{}
+	containers, err := c.containers(false)
+	if err != nil {
+		return relatedContainers, err
+	}
+	for _, cm := range containers {
+		for _, dot := range cm.dots {
+			relatedContainers[dot.filesystemId] = append(relatedContainers[dot.filesystemId], cm.container)
+		}
+	}
+	return relatedContainers, nil
+}
+
+func (c *CRIClient) Related(volumeName string) ([]DockerContainer, error) {
+	return c.related(volumeName, false)
+}
+
+// related - the running containers using volumeName, or just those that
+// follow its current branch
+func (c *CRIClient) related(volumeName string, following bool) ([]DockerContainer, error) {
+	related := []DockerContainer{}
+	containers, err := c.containers(false)
+	if err != nil {
+		return related, err
+	}
+	name := criVolumeName(volumeName)
+	for _, cm := range containers {
+		for _, dot := range cm.dots {
+			if dot.name == name && (dot.follows || !following) {
+				related = append(related, cm.container)
+				break
+			}
+		}
+	}
+	log.Printf("[Related] Containers related to volume %+v: %+v", volumeName, related)
+	return related, nil
+}
+
+func (c *CRIClient) SwitchSymlinks(volumeName, toFilesystemIdPath string) error {
+	containers, err := c.containers(true)
+	if err != nil {
+		return err
+	}
+	name := criVolumeName(volumeName)
+	for _, cm := range containers {
+		for _, dot := range cm.dots {
+			if dot.name != name || !dot.follows {
+				continue
+			}
+			if cm.running {
+				return fmt.Errorf(
+					"Container %s was running when you asked me to switch its symlinks (%s => %s)",
+					cm.container.Id, dot.symlink, toFilesystemIdPath,
+				)
+			}
+			err = func() error {
+				c.containerMountDirLock.Lock()
+				defer c.containerMountDirLock.Unlock()
+
+				log.Printf("Switching %s to %s", dot.symlink, toFilesystemIdPath)
+				if err := os.Remove(dot.symlink); err != nil {
+					return err
+				}
+				return os.Symlink(toFilesystemIdPath, dot.symlink)
+			}()
+			if err != nil {
+				log.Printf("Error switching %s to %s: %#v", dot.symlink, toFilesystemIdPath, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Start - unlocks the volume. No containers were stopped by Stop, so there
+// are none to start.
+func (c *CRIClient) Start(volumeName string) error {
+	_, ok := c.containersStopped[volumeName]
+	if !ok {
+		return NotLocked{volumeName: volumeName}
+	}
+	delete(c.containersStopped, volumeName)
+	return nil
+}
+
+func (c *CRIClient) Stop(volumeName string) error {
+	return c.stop(volumeName, false)
+}
+
+func (c *CRIClient) StopFollowing(volumeName string) error {
+	return c.stop(volumeName, true)
+}
+
+// stop - locks the volume, as long as no running containers are using it. They
+// aren't stopped, as they couldn't be started again afterwards.
+func (c *CRIClient) stop(volumeName string, following bool) error {
+	_, ok := c.containersStopped[volumeName]
+	if ok {
+		return AlreadyLocked{volumeName: volumeName}
+	}
+	relatedContainers, err := c.related(volumeName, following)
+	if err != nil {
+		return err
+	}
+	if len(relatedContainers) > 0 {
+		names := []string{}
+		for _, container := range relatedContainers {
+			names = append(names, container.Name)
+		}
+		return fmt.Errorf(
+			"%s is in use by running containers (%s), which can't be safely stopped and restarted through CRI; scale down or delete their pods first",
+			volumeName, strings.Join(names, ", "),
+		)
+	}
+	c.containersStopped[volumeName] = map[string]string{}
+	return nil
+}
+
+// Pin - kubernetes volumes say which branch they want themselves, so there are
+// no labels to look at
+func (c *CRIClient) Pin(volumeName string) (Pin, error) {
+	return Pin{}, nil
+}
@@ -0,0 +1,236 @@
+package container
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// fakeRuntime - just enough of a CRI runtime service to test against
+type fakeRuntime struct {
+	sync.Mutex
+	containers map[string]*criContainerStatus
+}
+
+func (f *fakeRuntime) handler(method string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		f.Lock()
+		defer f.Unlock()
+		switch method {
+		case "ListContainers":
+			request := &criListContainersRequest{}
+			if err := dec(request); err != nil {
+				return nil, err
+			}
+			response := &criListContainersResponse{}
+			for id, status := range f.containers {
+				if request.Filter != nil && request.Filter.State != nil && request.Filter.State.State != status.State {
+					continue
+				}
+				response.Containers = append(response.Containers, &criContainer{Id: id, State: status.State})
+			}
+			return response, nil
+		case "ContainerStatus":
+			request := &criContainerStatusRequest{}
+			if err := dec(request); err != nil {
+				return nil, err
+			}
+			return &criContainerStatusResponse{Status: f.containers[request.ContainerId]}, nil
+		}
+		return nil, nil
+	}
+}
+
+func startFakeRuntime(t *testing.T, dir string, runtime *fakeRuntime) (string, func()) {
+	socket := filepath.Join(dir, "cri.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	desc := grpc.ServiceDesc{
+		ServiceName: "runtime.v1.RuntimeService",
+		HandlerType: (*interface{})(nil),
+	}
+	for _, method := range []string{"ListContainers", "ContainerStatus"} {
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{MethodName: method, Handler: runtime.handler(method)})
+	}
+	server.RegisterService(&desc, runtime)
+	go server.Serve(listener)
+	return "unix://" + socket, server.Stop
+}
+
+func TestCRIClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a container mount symlink, as made for the flexvolume driver, and a
+	// pod volume directory symlinked to it
+	prefix := filepath.Join(dir, "dotmesh")
+	mnt := filepath.Join(dir, "mnt", "dmfs")
+	for _, d := range []string{filepath.Join(prefix, "admin"), filepath.Join(mnt, "fs-1"), filepath.Join(mnt, "fs-2"), filepath.Join(dir, "pods")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(mnt, "fs-1"), filepath.Join(prefix, "admin", "apples")); err != nil {
+		t.Fatal(err)
+	}
+	podVolume := filepath.Join(dir, "pods", "apples")
+	if err := os.Symlink(filepath.Join(prefix, "admin", "apples"), podVolume); err != nil {
+		t.Fatal(err)
+	}
+
+	// and a bind mount of a filesystem, as made by the CSI driver
+	mountinfo := filepath.Join(dir, "mountinfo")
+	err = ioutil.WriteFile(mountinfo, []byte(
+		"36 35 98:0 / /var/lib/kubelet/pods/x/volumes/bananas rw,noatime shared:1 - zfs pool/dmfs/fs-2 rw,xattr\n"+
+			"37 35 98:0 / /other rw,noatime - ext4 /dev/sda1 rw\n",
+	), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := &fakeRuntime{containers: map[string]*criContainerStatus{
+		"c1": {
+			Id:    "c1",
+			State: criContainerRunning,
+			Labels: map[string]string{
+				criPodNamespaceLabel: "default",
+				criPodNameLabel:      "web",
+				criContainerLabel:    "app",
+			},
+			Mounts: []*criMount{{ContainerPath: "/data", HostPath: podVolume}},
+		},
+		"c2": {
+			Id:       "c2",
+			State:    criContainerRunning,
+			Metadata: &criContainerMetadata{Name: "db"},
+			Mounts:   []*criMount{{ContainerPath: "/data", HostPath: "/var/lib/kubelet/pods/x/volumes/bananas"}},
+		},
+		"c3": {
+			Id:     "c3",
+			State:  criContainerRunning,
+			Mounts: []*criMount{{ContainerPath: "/data", HostPath: "/other"}},
+		},
+	}}
+	endpoint, stop := startFakeRuntime(t, dir, runtime)
+	defer stop()
+
+	client, err := NewCRI(&Options{
+		ContainerMountPrefix:  prefix,
+		ContainerMountDirLock: &sync.Mutex{},
+		CRIEndpoint:           endpoint,
+		FilesystemName: func(filesystemId string) (string, bool) {
+			return map[string]string{"fs-1": "admin/apples", "fs-2": "bob/bananas"}[filesystemId], true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.mountinfoPath = mountinfo
+
+	all, err := client.AllRelated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || len(all["fs-1"]) != 1 || all["fs-1"][0].Name != "default/web/app" || len(all["fs-2"]) != 1 || all["fs-2"][0].Name != "db" {
+		t.Errorf("unexpected related containers %+v", all)
+	}
+
+	// with and without the admin namespace
+	for _, name := range []string{"apples", "admin/apples"} {
+		related, err := client.Related(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(related) != 1 || related[0].Id != "c1" {
+			t.Errorf("expected %s to be related to c1, got %+v", name, related)
+		}
+	}
+	related, err := client.Related("bob/bananas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(related) != 1 || related[0].Id != "c2" {
+		t.Errorf("expected bob/bananas to be related to c2, got %+v", related)
+	}
+
+	err = client.SwitchSymlinks("admin/apples", filepath.Join(mnt, "fs-2"))
+	if err == nil {
+		t.Error("expected switching the symlinks of a running container to fail")
+	}
+
+	// running containers can't be stopped and started again through CRI,
+	// so their pods have to be scaled down first
+	if err := client.Stop("admin/apples"); err == nil {
+		t.Error("expected stopping a dot in use by a running container to fail")
+	}
+	if err := client.Start("admin/apples"); err == nil {
+		t.Error("expected starting a dot that wasn't stopped to fail")
+	}
+	runtime.Lock()
+	runtime.containers["c1"].State = criContainerExited
+	runtime.Unlock()
+
+	if err := client.Stop("admin/apples"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Stop("admin/apples"); err == nil {
+		t.Error("expected stopping twice to fail")
+	}
+	if err := client.SwitchSymlinks("admin/apples", filepath.Join(mnt, "fs-2")); err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(filepath.Join(prefix, "admin", "apples"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != filepath.Join(mnt, "fs-2") {
+		t.Errorf("expected symlink to have been switched to fs-2, got %s", target)
+	}
+	if err := client.Start("admin/apples"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Start("admin/apples"); err == nil {
+		t.Error("expected starting twice to fail")
+	}
+
+	// CSI volumes are pinned to a branch, so don't follow checkouts, but
+	// still can't be rolled back under a running container
+	if err := client.StopFollowing("bob/bananas"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Start("bob/bananas"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Stop("bob/bananas"); err == nil {
+		t.Error("expected stopping a CSI dot in use by a running container to fail")
+	}
+}
+
+func TestDotmeshFilesystem(t *testing.T) {
+	for dataset, expected := range map[string]string{
+		"pool/dmfs/abc":       "abc",
+		"pool/dmfs/abc@snap":  "abc",
+		"some/pool/dmfs/abc":  "abc",
+		"pool/dmfs":           "",
+		"pool/other/abc":      "",
+		"pool/dmfs/abc/child": "",
+		"/dev/sda1":           "",
+	} {
+		filesystemId, ok := dotmeshFilesystem(dataset)
+		if filesystemId != expected || ok != (expected != "") {
+			t.Errorf("dotmeshFilesystem(%q) = %q, %v; expected %q", dataset, filesystemId, ok, expected)
+		}
+	}
+}
@@ -13,9 +13,45 @@ import (
 type Client interface {
 	AllRelated() (map[string][]DockerContainer, error)
 	Related(volumeName string) ([]DockerContainer, error)
+	// SwitchSymlinks switches the mounts of volumeName that follow its
+	// current branch, leaving those pinned to a branch or commit alone
 	SwitchSymlinks(volumeName, toFilesystemIdPath string) error
 	Start(volumeName string) error
 	Stop(volumeName string) error
+	// StopFollowing is Stop, but only for the containers whose mounts
+	// SwitchSymlinks would switch
+	StopFollowing(volumeName string) error
+	// Pin is the branch or commit that the container about to be started
+	// with volumeName asks for in its labels, if any
+	Pin(volumeName string) (Pin, error)
+}
+
+// READONLY_MOUNTS_DIR - where, under the container mount prefix, read-only
+// views of filesystems are bind mounted for docker volumes created with the
+// readonly option, at READONLY_MOUNTS_DIR/:volume/:filesystemId
+const READONLY_MOUNTS_DIR = ".readonly"
+
+// FOLLOWING_MOUNTS_DIR - where, under the container mount prefix, each docker
+// mount of a dot that follows its current branch gets its own symlink to the
+// branch's filesystem, at FOLLOWING_MOUNTS_DIR/:mountId
+const FOLLOWING_MOUNTS_DIR = ".mounts"
+
+// PINNED_MOUNTS_DIR - likewise, for mounts pinned to a branch, which are
+// never switched
+const PINNED_MOUNTS_DIR = ".pinned"
+
+// Container labels that pin a container's dm volumes to a branch, or to a
+// commit (read-only), rather than following the dot's current branch. They
+// can be suffixed with .:volume to pin just that volume.
+const (
+	PIN_BRANCH_LABEL = "io.dotmesh.branch"
+	PIN_COMMIT_LABEL = "io.dotmesh.commit"
+)
+
+// Pin - what a container's labels pin a volume to
+type Pin struct {
+	Branch string
+	Commit string
 }
 
 type DockerContainer struct {
@@ -26,6 +62,25 @@ type DockerContainer struct {
 type Options struct {
 	ContainerMountPrefix  string
 	ContainerMountDirLock *sync.Mutex
+	// Runtime - "docker" (the default), "containerd" or "cri" for any other
+	// CRI runtime, or "null" to not track containers at all
+	Runtime string
+	// CRIEndpoint - the CRI runtime's socket, as unix:///path
+	CRIEndpoint string
+	// FilesystemName - looks up the name of the dot a filesystem belongs to,
+	// for containers whose mounts only identify the filesystem
+	FilesystemName func(filesystemId string) (string, bool)
+}
+
+// NewClient returns a client for the container runtime in the options
+func NewClient(options *Options) (Client, error) {
+	switch options.Runtime {
+	case "containerd", "cri":
+		return NewCRI(options)
+	default:
+		// DockerClient.Related deals with the "null" runtime
+		return New(options)
+	}
 }
 
 func New(options *Options) (*DockerClient, error) {
@@ -114,6 +169,38 @@ func baseDotName(dotName string) string {
 	return dotName
 }
 
+// followsCurrentBranch - whether a dm container mount is switched along with
+// its dot's current branch, by where its symlink is
+func followsCurrentBranch(containerMountPrefix, path string) bool {
+	if !strings.HasPrefix(path, containerMountPrefix+"/") {
+		// a commit, mounted directly
+		return false
+	}
+	root := findDotRoot(containerMountPrefix, path)
+	parts := strings.Split(strings.TrimPrefix(root, containerMountPrefix+"/"), "/")
+	if len(parts) != 2 {
+		return false
+	}
+	switch parts[0] {
+	case FOLLOWING_MOUNTS_DIR:
+		return true
+	case PINNED_MOUNTS_DIR, READONLY_MOUNTS_DIR:
+		return false
+	}
+	// shared by every mount of the volume, from before mounts had their own
+	// symlinks, which follows unless the volume is dot@branch
+	return !strings.Contains(parts[1], "@")
+}
+
+func containerFollowing(containerMountPrefix, volumeName string, container *docker.Container) bool {
+	for _, m := range container.Mounts {
+		if m.Driver == "dm" && baseDotName(m.Name) == volumeName && followsCurrentBranch(containerMountPrefix, m.Source) {
+			return true
+		}
+	}
+	return false
+}
+
 func containerRelated(volumeName string, container *docker.Container) bool {
 	for _, m := range container.Mounts {
 		// split off "@" in mount name, in case of pinned branches. we want to
@@ -131,18 +218,18 @@ func containerRelated(volumeName string, container *docker.Container) bool {
 // Given a dm container mount path, find the path of the root dot
 // The path may already be the root path, or it may be some subdot bneeath it.
 // Paths look like CONTAINER_MOUNT_PREFIX/namespace/volume[/subdot]
-func (d *DockerClient) findDotRoot(path string) string {
-	if !strings.HasPrefix(path, d.containerMountPrefix+"/") {
-		log.Printf("[findDotRoot] Container mount path %v doesn't start with %v/", path, d.containerMountPrefix)
+func findDotRoot(containerMountPrefix, path string) string {
+	if !strings.HasPrefix(path, containerMountPrefix+"/") {
+		log.Printf("[findDotRoot] Container mount path %v doesn't start with %v/", path, containerMountPrefix)
 		return path
 	}
-	subpath := strings.TrimPrefix(path, d.containerMountPrefix+"/")
+	subpath := strings.TrimPrefix(path, containerMountPrefix+"/")
 	parts := strings.Split(subpath, "/")
 	switch len(parts) {
 	case 2: // no subdot
 		return path
 	case 3: // subdot
-		return d.containerMountPrefix + "/" + parts[0] + "/" + parts[1]
+		return containerMountPrefix + "/" + parts[0] + "/" + parts[1]
 	default:
 		log.Printf("[findDotRoot] Container mount path %v didn't have the usual number of parts: %+v %+v", path, subpath, parts)
 		return path
@@ -157,7 +244,11 @@ func (d *DockerClient) relatedFilesystems(container *docker.Container) ([]string
 		if mount.Driver != "dm" {
 			continue
 		}
-		target, err := os.Readlink(d.findDotRoot(mount.Source))
+		if filesystemId, ok := readonlyMountFilesystem(d.containerMountPrefix, mount.Source); ok {
+			result = append(result, filesystemId)
+			continue
+		}
+		target, err := os.Readlink(findDotRoot(d.containerMountPrefix, mount.Source))
 		if err != nil {
 			log.Printf("Error trying to read symlink '%s', skipping: %s", mount.Source, err)
 			continue
@@ -174,7 +265,27 @@ func (d *DockerClient) relatedFilesystems(container *docker.Container) ([]string
 	return result, nil
 }
 
+// Given a dm container mount path, the filesystem id of the read-only view it's
+// in, if it's in one
+func readonlyMountFilesystem(containerMountPrefix, path string) (string, bool) {
+	prefix := containerMountPrefix + "/" + READONLY_MOUNTS_DIR + "/"
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+	// :volume/:filesystemId[/subdot]
+	parts := strings.Split(strings.TrimPrefix(path, prefix), "/")
+	if len(parts) < 2 {
+		return "", false
+	}
+	return parts[1], true
+}
+
 func (d *DockerClient) Related(volumeName string) ([]DockerContainer, error) {
+	return d.related(volumeName, containerRelated)
+}
+
+// related - the running containers using volumeName that match
+func (d *DockerClient) related(volumeName string, matches func(string, *docker.Container) bool) ([]DockerContainer, error) {
 	related := []DockerContainer{}
 	// default to using docker runtime, but also allow specifying.
 	if os.Getenv("CONTAINER_RUNTIME") == "" || os.Getenv("CONTAINER_RUNTIME") == "docker" {
@@ -188,7 +299,7 @@ func (d *DockerClient) Related(volumeName string) ([]DockerContainer, error) {
 			if err != nil {
 				return related, err
 			}
-			if container.State.Running && containerRelated(volumeName, container) {
+			if container.State.Running && matches(volumeName, container) {
 				// Only append if it's not already done.
 				if _, ok := done[container.ID]; !ok {
 					related = append(
@@ -235,14 +346,14 @@ func (d *DockerClient) SwitchSymlinks(volumeName, toFilesystemIdPath string) err
 				// whether it's a symlink before trying os.Remove. maybe we can
 				// check whether it's a symlink with Stat instead.
 
-				mountPoint := d.findDotRoot(mount.Source)
+				mountPoint := findDotRoot(d.containerMountPrefix, mount.Source)
 
 				_, err := os.Readlink(mountPoint)
 				if err != nil {
 					log.Printf("Error trying to read symlink '%s', skipping: %s", mountPoint, err)
 					continue
 				}
-				if baseDotName(mount.Name) == volumeName {
+				if baseDotName(mount.Name) == volumeName && followsCurrentBranch(d.containerMountPrefix, mount.Source) {
 					// TODO could also check whether containerMnt(volumeName) == mount.Source. should we?
 					if container.State.Running {
 						return fmt.Errorf(
@@ -292,11 +403,21 @@ func (d *DockerClient) Start(volumeName string) error {
 }
 
 func (d *DockerClient) Stop(volumeName string) error {
+	return d.stop(volumeName, containerRelated)
+}
+
+func (d *DockerClient) StopFollowing(volumeName string) error {
+	return d.stop(volumeName, func(volumeName string, container *docker.Container) bool {
+		return containerFollowing(d.containerMountPrefix, volumeName, container)
+	})
+}
+
+func (d *DockerClient) stop(volumeName string, matches func(string, *docker.Container) bool) error {
 	_, ok := d.containersStopped[volumeName]
 	if ok {
 		return AlreadyLocked{volumeName: volumeName}
 	}
-	relatedContainers, err := d.Related(volumeName)
+	relatedContainers, err := d.related(volumeName, matches)
 	if err != nil {
 		return err
 	}
@@ -322,3 +443,53 @@ func (d *DockerClient) Stop(volumeName string) error {
 	}
 	return nil
 }
+
+// Pin looks at the labels of the containers using volumeName that are being
+// started for the first time, or restarted by docker. Docker doesn't say which
+// container a mount is for (the mount id it passes is its own), so if two of
+// them are being started at once, they have to agree. Exited containers
+// aren't looked at, as any number of them can be lying around with stale
+// labels; one that's started again with "docker start" follows the dot.
+func (d *DockerClient) Pin(volumeName string) (Pin, error) {
+	if os.Getenv("CONTAINER_RUNTIME") == "null" {
+		return Pin{}, nil
+	}
+	containers, err := d.client.ListContainers(docker.ListContainersOptions{
+		All: true,
+		Filters: map[string][]string{
+			"volume": []string{volumeName},
+			"status": []string{"created", "restarting"},
+		},
+	})
+	if err != nil {
+		return Pin{}, err
+	}
+	pins := map[Pin]bool{}
+	ids := []string{}
+	for _, c := range containers {
+		pins[labelPin(volumeName, c.Labels)] = true
+		ids = append(ids, c.ID)
+	}
+	if len(pins) > 1 {
+		return Pin{}, fmt.Errorf(
+			"Containers %s are being started with %s, but their %s and %s labels don't agree, start them one at a time",
+			strings.Join(ids, ", "), volumeName, PIN_BRANCH_LABEL, PIN_COMMIT_LABEL,
+		)
+	}
+	for pin := range pins {
+		return pin, nil
+	}
+	return Pin{}, nil
+}
+
+// labelPin - what a container's labels pin volumeName to, preferring labels
+// for just that volume
+func labelPin(volumeName string, labels map[string]string) Pin {
+	pin := Pin{Branch: labels[PIN_BRANCH_LABEL], Commit: labels[PIN_COMMIT_LABEL]}
+	branch, branchOk := labels[PIN_BRANCH_LABEL+"."+volumeName]
+	commit, commitOk := labels[PIN_COMMIT_LABEL+"."+volumeName]
+	if branchOk || commitOk {
+		pin = Pin{Branch: branch, Commit: commit}
+	}
+	return pin
+}
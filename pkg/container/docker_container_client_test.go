@@ -0,0 +1,40 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestFollowsCurrentBranch(t *testing.T) {
+	prefix := "/var/dotmesh"
+	for path, expected := range map[string]bool{
+		"/var/dotmesh/.mounts/abc":               true,
+		"/var/dotmesh/.mounts/abc/subdot":        true,
+		"/var/dotmesh/.pinned/abc":               false,
+		"/var/dotmesh/.readonly/apples/fs-1":     false,
+		"/var/dotmesh/admin/apples":              true,
+		"/var/dotmesh/admin/apples/subdot":       true,
+		"/var/dotmesh/admin/apples@branch":       false,
+		"/var/lib/dotmesh/mnt/dmfs/fs-1@commit":  false,
+		"/var/dotmesh/.mounts/abc/too/deep/here": false,
+	} {
+		if followsCurrentBranch(prefix, path) != expected {
+			t.Errorf("expected followsCurrentBranch(%q) to be %v", path, expected)
+		}
+	}
+}
+
+func TestLabelPin(t *testing.T) {
+	labels := map[string]string{
+		PIN_BRANCH_LABEL:              "feature",
+		PIN_COMMIT_LABEL + ".bananas": "abc",
+	}
+	if pin := labelPin("apples", labels); pin != (Pin{Branch: "feature"}) {
+		t.Errorf("expected apples to be pinned to feature, got %+v", pin)
+	}
+	if pin := labelPin("bananas", labels); pin != (Pin{Commit: "abc"}) {
+		t.Errorf("expected bananas to be pinned to commit abc, got %+v", pin)
+	}
+	if pin := labelPin("apples", nil); pin != (Pin{}) {
+		t.Errorf("expected no pin without labels, got %+v", pin)
+	}
+}
@@ -1,6 +1,7 @@
 package fsm
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -8,12 +9,13 @@ import (
 	"sync"
 	"time"
 
-	"github.com/coreos/etcd/client"
-
 	"github.com/dotmesh-io/dotmesh/pkg/container"
+	"github.com/dotmesh-io/dotmesh/pkg/hooks"
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
 	"github.com/dotmesh-io/dotmesh/pkg/metrics"
 	"github.com/dotmesh-io/dotmesh/pkg/observer"
 	"github.com/dotmesh-io/dotmesh/pkg/registry"
+	"github.com/dotmesh-io/dotmesh/pkg/tracing"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/user"
 	"github.com/dotmesh-io/dotmesh/pkg/zfs"
@@ -29,11 +31,12 @@ type FsConfig struct {
 	StateManager         StateManager
 	Registry             registry.Registry
 	UserManager          user.UserManager
-	EtcdClient           client.KeysAPI
+	EtcdClient           kv.Store
 	ContainerClient      container.Client
 	LocalReceiveProgress observer.Observer
 	NewSnapsOnMaster     observer.Observer
 	DeathObserver        observer.Observer
+	HookManager          hooks.Manager
 
 	FilesystemMetadataTimeout int64
 
@@ -73,6 +76,7 @@ type FSM interface {
 	GetSnapshots(nodeID string) []*types.Snapshot
 	ListSnapshots() map[string][]*types.Snapshot
 	SetSnapshots(nodeID string, snapshots []*types.Snapshot)
+	QuerySnapshots(nodeID string, query types.CommitQuery) (types.CommitPage, error)
 
 	// Local snapshots from ZFS
 	ListLocalSnapshots() []*types.Snapshot
@@ -108,6 +112,8 @@ func NewFilesystemMachine(cfg *FsConfig) *FsMachine {
 		filesystemId:            cfg.FilesystemID,
 		requests:                make(chan *types.Event),
 		innerRequests:           make(chan *types.Event),
+		requestCtx:              context.Background(),
+		requestCtxLock:          &sync.Mutex{},
 		innerResponses:          make(chan *types.Event),
 		fileInputIO:             make(chan *types.InputFile),
 		fileOutputIO:            make(chan *types.OutputFile),
@@ -119,6 +125,7 @@ func NewFilesystemMachine(cfg *FsConfig) *FsMachine {
 		state:                   cfg.StateManager,
 		userManager:             cfg.UserManager,
 		registry:                cfg.Registry,
+		hookManager:             cfg.HookManager,
 		newSnapsOnMaster:        cfg.NewSnapsOnMaster,
 		localReceiveProgress:    cfg.LocalReceiveProgress,
 		snapshotsLock:           &sync.Mutex{},
@@ -135,6 +142,7 @@ func NewFilesystemMachine(cfg *FsConfig) *FsMachine {
 
 		snapshotCache:   make(map[string][]*types.Snapshot),
 		snapshotCacheMu: &sync.RWMutex{},
+		snapshotIndexes: make(map[string]*snapshotIndex),
 		// In the case where we're receiving a push (pushPeerState), it's the
 		// POST handler on our http server which handles the receiving of the
 		// snapshot. We need to coordinate with it so that we know when to
@@ -147,7 +155,7 @@ func NewFilesystemMachine(cfg *FsConfig) *FsMachine {
 		transferUpdates: make(chan types.TransferUpdate),
 
 		filesystemMetadataTimeout: cfg.FilesystemMetadataTimeout,
-		zfs: zfsInter,
+		zfs:                       zfsInter,
 	}
 }
 
@@ -333,9 +341,15 @@ func (f *FsMachine) Run() {
 	for req := range f.requests {
 		log.Debugf("[run:%s] got req: %s", f.filesystemId, req)
 		log.Debugf("[run:%s] writing to internal requests", f.filesystemId)
+		span, ctx := tracing.StartSpanFromEvent(req, "fsm."+req.Name)
+		f.setRequestContext(ctx)
 		f.innerRequests <- req
 		log.Debugf("[run:%s] reading from internal responses", f.filesystemId)
 		resp, more := <-f.innerResponses
+		if more {
+			span.SetTag("response", resp.Name)
+		}
+		span.Finish()
 		if !more {
 			log.Debugf("[run:%s] statemachine is finished", f.filesystemId)
 			resp = &types.Event{
@@ -365,6 +379,20 @@ func (f *FsMachine) Run() {
 	}
 }
 
+func (f *FsMachine) setRequestContext(ctx context.Context) {
+	f.requestCtxLock.Lock()
+	defer f.requestCtxLock.Unlock()
+	f.requestCtx = ctx
+}
+
+// requestContext - the context of the request being handled, for passing to
+// calls made on its behalf
+func (f *FsMachine) requestContext() context.Context {
+	f.requestCtxLock.Lock()
+	defer f.requestCtxLock.Unlock()
+	return f.requestCtx
+}
+
 func (f *FsMachine) runWhileFilesystemLives(fn func() error, label string, filesystemId string, errorBackoff, successBackoff time.Duration) {
 	deathChan := make(chan interface{})
 	f.deathObserver.Subscribe(filesystemId, deathChan)
@@ -401,6 +429,7 @@ func (f *FsMachine) pollDirty() error {
 		if err != nil {
 			return err
 		}
+		metrics.SetDirtyBytes(f.filesystemId, dirtyDelta)
 		if f.dirtyDelta != dirtyDelta || f.sizeBytes != sizeBytes {
 			f.dirtyDelta = dirtyDelta
 			f.sizeBytes = sizeBytes
@@ -448,6 +477,9 @@ func (f *FsMachine) getCurrentPollResult() types.TransferPollResult {
 
 func (f *FsMachine) updateEtcdAboutTransfers() error {
 	pollResult := f.currentPollResult
+	// bytes sent so far by the current zfs send or receive, which reports
+	// its progress from zero
+	var progressSent int64
 
 	// wait until the state machine notifies us that it's changed the
 	// transfer state, but have an escape clause in case this filesystem
@@ -469,6 +501,7 @@ func (f *FsMachine) updateEtcdAboutTransfers() error {
 		switch update.Kind {
 		case types.TransferStart:
 			pollResult = update.Changes
+			progressSent = 0
 		case types.TransferGotIds:
 			pollResult.FilesystemId = update.Changes.FilesystemId
 			pollResult.StartingCommit = update.Changes.StartingCommit
@@ -481,10 +514,16 @@ func (f *FsMachine) updateEtcdAboutTransfers() error {
 			pollResult.Total = update.Changes.Total
 			pollResult.Size = update.Changes.Size
 		case types.TransferProgress:
+			if update.Changes.Sent < progressSent {
+				progressSent = 0
+			}
+			metrics.TransferBytes.WithLabelValues(pollResult.Direction).Add(float64(update.Changes.Sent - progressSent))
+			progressSent = update.Changes.Sent
 			pollResult.Sent = update.Changes.Sent
 			pollResult.NanosecondsElapsed = update.Changes.NanosecondsElapsed
 			pollResult.Status = update.Changes.Status
 		case types.TransferIncrementIndex:
+			progressSent = 0
 			if pollResult.Index < pollResult.Total {
 				pollResult.Index++
 			}
@@ -598,6 +637,7 @@ func (f *FsMachine) transitionedTo(state string, status string) {
 	)
 
 	metrics.TransitionCounter.WithLabelValues(f.currentState, state, status).Add(1)
+	metrics.FilesystemTransitioned(f.filesystemId, state)
 
 	f.currentState = state
 	f.status = status
@@ -701,7 +741,116 @@ func (f *FsMachine) fork(e *types.Event) (responseEvent *types.Event, nextState
 	return &types.Event{Name: "forked", Args: &types.EventArgs{"ForkId": forkId}}, activeState
 }
 
+// fastForward - brings this filesystem up to a commit of another one it shares
+// history with (a fork, or the dot it was forked from), as long as it hasn't
+// diverged from it. The other filesystem has to be on this node too.
+func (f *FsMachine) fastForward(e *types.Event) (responseEvent *types.Event, nextState StateFn) {
+	fromFilesystemId, _ := (*e.Args)["FromFilesystemId"].(string)
+	if fromFilesystemId == "" {
+		return &types.Event{Name: "cannot-fast-forward:filesystem-needed"}, activeState
+	}
+	// empty means the latest commit
+	toSnapshotId, _ := (*e.Args)["ToSnapshotId"].(string)
+
+	// like pulling, refuse to change the data under running containers
+	containers, err := f.containersRunning()
+	if err != nil {
+		return types.NewErrorEvent("cannot-fast-forward:error-listing-containers", err), activeState
+	}
+	if len(containers) > 0 {
+		return &types.Event{
+			Name: "cannot-fast-forward-while-containers-running",
+			Args: &types.EventArgs{"containers": containers},
+		}, activeState
+	}
+
+	from, err := f.zfs.DiscoverSystem(fromFilesystemId)
+	if err != nil {
+		return types.NewErrorEvent("cannot-fast-forward:error-discovering-source", err), activeState
+	}
+	if !from.Exists {
+		return types.NewErrorEvent(
+			"cannot-fast-forward:source-not-here",
+			fmt.Errorf("%s hasn't been replicated to %s yet", fromFilesystemId, f.state.NodeID()),
+		), activeState
+	}
+	fromSnaps, err := restrictSnapshots(from.Snapshots, toSnapshotId)
+	if err != nil {
+		return types.NewErrorEvent("cannot-fast-forward:no-such-commit", err), activeState
+	}
+
+	f.snapshotsLock.Lock()
+	toSnaps := append([]*types.Snapshot{}, f.filesystem.Snapshots...)
+	f.snapshotsLock.Unlock()
+
+	snapRange, err := canApply(fromSnaps, toSnaps)
+	if err != nil {
+		switch err.(type) {
+		case *ToSnapsUpToDate:
+			return &types.Event{Name: "fast-forwarded", Args: &types.EventArgs{"Commits": 0}}, activeState
+		case *ToSnapsAhead, *ToSnapsDiverged:
+			return types.NewErrorEvent("cannot-fast-forward:diverged", err), activeState
+		}
+		return types.NewErrorEvent("cannot-fast-forward:no-common-history", err), activeState
+	}
+	if snapRange.fromSnap == nil {
+		return &types.Event{Name: "cannot-fast-forward:no-common-history"}, activeState
+	}
+	commits := 0
+	for i := len(fromSnaps) - 1; i >= 0 && fromSnaps[i].Id != snapRange.fromSnap.Id; i-- {
+		commits++
+	}
+
+	log.WithFields(log.Fields{
+		"filesystem_id":      f.filesystemId,
+		"from_filesystem_id": fromFilesystemId,
+		"from_snapshot_id":   snapRange.fromSnap.Id,
+		"to_snapshot_id":     snapRange.toSnap.Id,
+	}).Info("[fastForward] receiving commits")
+
+	pipeReader, errch := f.zfs.Send(fromFilesystemId, snapRange.fromSnap.Id, fromFilesystemId, snapRange.toSnap.Id, []byte{})
+	stdErrBuffer := &bytes.Buffer{}
+	err = f.zfs.Recv(pipeReader, f.filesystemId, stdErrBuffer)
+	pipeReader.Close()
+	sendErr := <-errch
+	if err != nil {
+		if strings.Contains(stdErrBuffer.String(), "has been modified") {
+			return types.NewErrorEvent(
+				"cannot-fast-forward:uncommitted-changes",
+				fmt.Errorf("%s has uncommitted changes, commit or reset them first", f.filesystemId),
+			), activeState
+		}
+		return types.NewErrorEvent(
+			"cannot-fast-forward:error-receiving",
+			fmt.Errorf("%s: %s", err, stdErrBuffer.String()),
+		), backoffState
+	}
+	if sendErr != nil {
+		return types.NewErrorEvent("cannot-fast-forward:error-sending", sendErr), backoffState
+	}
+
+	// rediscover, so that the new commits are seen here and replicated to
+	// the other nodes
+	return &types.Event{
+		Name: "fast-forwarded",
+		Args: &types.EventArgs{"Commits": commits, "CommitId": snapRange.toSnap.Id},
+	}, discoveringState
+}
+
 func (f *FsMachine) snapshot(e *types.Event) (responseEvent *types.Event, nextState StateFn) {
+	return f.takeSnapshot(e, false)
+}
+
+// checkedSnapshot - a snapshot for a commit asked for by a user, which the
+// dot's pre-commit hooks check before it's recorded. They're run against the
+// snapshot rather than the live filesystem, which containers may still be
+// writing to, so that what they allow is exactly what's committed. It's
+// destroyed again if they reject it.
+func (f *FsMachine) checkedSnapshot(e *types.Event) (responseEvent *types.Event, nextState StateFn) {
+	return f.takeSnapshot(e, true)
+}
+
+func (f *FsMachine) takeSnapshot(e *types.Event, runHooks bool) (responseEvent *types.Event, nextState StateFn) {
 	var err error
 	var meta types.Metadata
 	if val, ok := (*e.Args)["metadata"]; ok {
@@ -744,6 +893,21 @@ func (f *FsMachine) snapshot(e *types.Event) (responseEvent *types.Event, nextSt
 			Args: &types.EventArgs{"err": fmt.Sprintf("%v", err), "combined-output": string(output)},
 		}, backoffState
 	}
+	if runHooks {
+		err = f.checkHooks(types.HookStagePreCommit, snapshotId, meta, "")
+		if err != nil {
+			out, destroyErr := f.zfs.DestroySnapshot(f.filesystemId, snapshotId)
+			if destroyErr != nil {
+				log.WithFields(log.Fields{
+					"filesystem_id": f.filesystemId,
+					"snapshot_id":   snapshotId,
+					"error":         destroyErr,
+					"output":        string(out),
+				}).Error("[snapshot] failed to destroy snapshot rejected by hooks")
+			}
+			return rejectedEvent("snapshot-rejected", err), activeState
+		}
+	}
 	list, err := f.zfs.List(f.filesystemId, snapshotId)
 	if err != nil {
 		return &types.Event{
@@ -770,6 +934,7 @@ func (f *FsMachine) snapshot(e *types.Event) (responseEvent *types.Event, nextSt
 			Args: &types.EventArgs{"err": fmt.Sprintf("%v", err)},
 		}, backoffState
 	}
+	metrics.Committed(f.filesystemId)
 	return &types.Event{Name: "snapshotted", Args: &types.EventArgs{"SnapshotId": snapshotId}}, activeState
 }
 
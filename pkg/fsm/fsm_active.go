@@ -52,6 +52,36 @@ func activeState(f *FsMachine) StateFn {
 				}
 			}
 			return activeState
+		} else if e.Name == "space-usage" {
+			usage, commits, err := f.zfs.SpaceUsage(f.filesystemId)
+			if err != nil {
+				f.innerResponses <- &types.Event{
+					Name: "error-space-usage",
+					Args: &types.EventArgs{"err": err},
+				}
+			} else {
+				f.innerResponses <- &types.Event{
+					Name: "space-usage",
+					Args: &types.EventArgs{"Usage": usage, "Commits": commits},
+				}
+			}
+			return activeState
+		} else if e.Name == "predict-reclaim" {
+			fromSnapshotId, _ := (*e.Args)["FromSnapshotId"].(string)
+			toSnapshotId, _ := (*e.Args)["ToSnapshotId"].(string)
+			reclaim, err := f.zfs.PredictReclaim(f.filesystemId, fromSnapshotId, toSnapshotId)
+			if err != nil {
+				f.innerResponses <- &types.Event{
+					Name: "error-predict-reclaim",
+					Args: &types.EventArgs{"err": err},
+				}
+			} else {
+				f.innerResponses <- &types.Event{
+					Name: "predicted-reclaim",
+					Args: &types.EventArgs{"Bytes": reclaim},
+				}
+			}
+			return activeState
 		} else if e.Name == "transfer" {
 
 			// TODO dedupe
@@ -163,8 +193,14 @@ func activeState(f *FsMachine) StateFn {
 			response, state := f.fork(e)
 			f.innerResponses <- response
 			return state
+		} else if e.Name == "fast-forward" {
+			response, state := f.fastForward(e)
+			f.innerResponses <- response
+			return state
 		} else if e.Name == "snapshot" {
-			response, state := f.snapshot(e)
+			// policy hooks only apply to commits asked for by users, not to
+			// the snapshots dotmesh takes itself (e.g. before handoffs)
+			response, state := f.checkedSnapshot(e)
 			f.innerResponses <- response
 			return state
 		} else if e.Name == "mount-snapshot" {
@@ -172,8 +208,34 @@ func activeState(f *FsMachine) StateFn {
 			response, state := f.mountSnap(snapId, true)
 			f.innerResponses <- response
 			return state
+		} else if e.Name == "unmount-snapshot" {
+			snapId := (*e.Args)["snapId"].(string)
+			if snapId == "" {
+				// that would be the filesystem itself
+				f.innerResponses <- &types.Event{
+					Name: "failed-unmount",
+					Args: &types.EventArgs{"err": "no snapshot to unmount"},
+				}
+				return activeState
+			}
+			response, state := f.unmountSnap(snapId)
+			f.innerResponses <- response
+			if response.Name == "unmounted" {
+				// the filesystem itself is still mounted
+				return activeState
+			}
+			return state
 		} else if e.Name == "stash" {
 			snapshotId := (*e.Args)["snapshotId"].(string)
+			// the branch's commits since snapshotId are about to be moved
+			// aside, which its protection rules may not allow
+			if tlf, branch, err := f.registry.LookupFilesystemById(f.filesystemId); err == nil {
+				err = tlf.CheckForcePush(branch)
+				if err != nil {
+					f.innerResponses <- rejectedEvent("stash-rejected", err)
+					return activeState
+				}
+			}
 			err := f.recoverFromDivergence(snapshotId)
 			if err != nil {
 				f.innerResponses <- &types.Event{
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/dotmesh-io/dotmesh/pkg/types"
@@ -17,6 +18,7 @@ func (f *FsMachine) saveFile(file *types.InputFile) StateFn {
 	destPath := fmt.Sprintf("%s/%s/%s", utils.Mnt(f.filesystemId), "__default__", file.Filename)
 	log.Printf("Saving file to %s", destPath)
 	directoryPath := destPath[:strings.LastIndex(destPath, "/")]
+	createdPath := firstMissingPath(directoryPath)
 	err := os.MkdirAll(directoryPath, 0775)
 	if err != nil {
 		file.Response <- &types.Event{
@@ -25,42 +27,37 @@ func (f *FsMachine) saveFile(file *types.InputFile) StateFn {
 		}
 		return backoffState
 	}
-	out, err := os.Create(destPath)
+
+	// pre-commit hooks may reject the commit of the upload, in which case
+	// the file it replaced is put back
+	undo, err := f.backupFile(destPath, createdPath)
 	if err != nil {
 		file.Response <- &types.Event{
 			Name: types.EventNameSaveFailed,
-			Args: &types.EventArgs{"err": fmt.Errorf("failed to create file, error: %s", err)},
+			Args: &types.EventArgs{"err": fmt.Errorf("failed to back up existing file, error: %s", err)},
 		}
 		return backoffState
 	}
 
-	defer func() {
-		err := out.Close()
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"file":  destPath,
-			}).Error("s3 saveFile: got error while closing output file")
-		}
-	}()
-
-	bytes, err := io.Copy(out, file.Contents)
+	bytes, err := writeFile(destPath, file.Contents)
 	if err != nil {
+		undo(true)
 		file.Response <- &types.Event{
 			Name: types.EventNameSaveFailed,
 			Args: &types.EventArgs{"err": fmt.Errorf("cannot to create a file, error: %s", err)},
 		}
 		return backoffState
 	}
-	response, _ := f.snapshot(&types.Event{Name: "snapshot",
-		Args: &types.EventArgs{"metadata": types.Metadata{
-			"message":      "Uploaded " + file.Filename + " (" + formatBytes(bytes) + ")",
-			"author":       file.User,
-			"type":         "upload",
-			"upload.type":  "S3",
-			"upload.file":  file.Filename,
-			"upload.bytes": fmt.Sprintf("%d", bytes),
-		}}})
+
+	meta := UploadMetadata(file, bytes)
+	response, _ := f.checkedSnapshot(&types.Event{Name: "snapshot",
+		Args: &types.EventArgs{"metadata": meta}})
+	if response.Name == "snapshot-rejected" {
+		undo(true)
+		file.Response <- &types.Event{Name: types.EventNameSaveRejected, Args: response.Args}
+		return activeState
+	}
+	undo(false)
 	if response.Name != "snapshotted" {
 		file.Response <- &types.Event{
 			Name: types.EventNameSaveFailed,
@@ -77,6 +74,147 @@ func (f *FsMachine) saveFile(file *types.InputFile) StateFn {
 	return activeState
 }
 
+// writeFile - (over)writes destPath with contents, returning how many bytes
+// were written
+func writeFile(destPath string, contents io.Reader) (int64, error) {
+	out, err := os.Create(destPath)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := out.Close()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"file":  destPath,
+			}).Error("s3 saveFile: got error while closing output file")
+		}
+	}()
+	return io.Copy(out, contents)
+}
+
+// firstMissingPath - the outermost directory MkdirAll will have to create
+// for path, "" if it exists already
+func firstMissingPath(path string) string {
+	missing := ""
+	for path != "" && path != "/" {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		missing = path
+		path = filepath.Dir(path)
+	}
+	return missing
+}
+
+// backupFile - moves any existing file at destPath aside, out of the
+// filesystem so it isn't in the commit hooks check, and returns a function
+// that either restores it (removing what was written in its place, and
+// createdPath, the directories made for it) or discards it
+func (f *FsMachine) backupFile(destPath, createdPath string) (func(restore bool), error) {
+	backupPath := utils.Mnt(f.filesystemId + "-upload-backup")
+	_, err := os.Lstat(destPath)
+	existed := err == nil
+	if existed {
+		err = moveFile(destPath, backupPath)
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return func(restore bool) {
+		var err error
+		switch {
+		case !restore && existed:
+			err = os.Remove(backupPath)
+		case restore && existed:
+			err = moveFile(backupPath, destPath)
+		case restore && createdPath != "":
+			err = os.RemoveAll(createdPath)
+		case restore:
+			err = os.Remove(destPath)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"file":    destPath,
+				"restore": restore,
+			}).Error("s3 saveFile: failed to clean up after upload")
+		}
+	}, nil
+}
+
+// moveFile - renames src to dst, replacing it, or copies it across if
+// they're on different filesystems
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	info, statErr := os.Lstat(src)
+	if statErr != nil {
+		return err
+	}
+	removeErr := os.Remove(dst)
+	if removeErr != nil && !os.IsNotExist(removeErr) {
+		return removeErr
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		err = os.Symlink(target, dst)
+		if err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		closeErr := out.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dst)
+			return err
+		}
+	default:
+		return err
+	}
+	return os.Remove(src)
+}
+
+// UploadMetadata - the metadata of the commit made by uploading bytes of
+// file, which can add to it but not override it
+func UploadMetadata(file *types.InputFile, bytes int64) types.Metadata {
+	meta := types.Metadata{}
+	for k, v := range file.Metadata {
+		meta[k] = v
+	}
+	meta["message"] = "Uploaded " + file.Filename + " (" + formatBytes(bytes) + ")"
+	meta["author"] = file.User
+	meta["type"] = "upload"
+	meta["upload.type"] = "S3"
+	meta["upload.file"] = file.Filename
+	meta["upload.bytes"] = fmt.Sprintf("%d", bytes)
+	return meta
+}
+
 func (f *FsMachine) readFile(file *types.OutputFile) StateFn {
 	// create the default paths
 	sourcePath := fmt.Sprintf("%s/%s/%s", file.SnapshotMountPath, "__default__", file.Filename)
@@ -0,0 +1,162 @@
+package fsm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/utils"
+)
+
+func newUploadTestMachine(t *testing.T) (*FsMachine, string, func()) {
+	prefix, err := ioutil.TempDir("", "dotmesh-upload-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	oldPrefix := os.Getenv("MOUNT_PREFIX")
+	os.Setenv("MOUNT_PREFIX", prefix)
+	f := &FsMachine{filesystemId: "fs1"}
+	dir := filepath.Join(utils.Mnt(f.filesystemId), "__default__")
+	err = os.MkdirAll(dir, 0775)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", dir, err)
+	}
+	return f, dir, func() {
+		os.Setenv("MOUNT_PREFIX", oldPrefix)
+		os.RemoveAll(prefix)
+	}
+}
+
+func TestUploadRollbackRestoresReplacedFile(t *testing.T) {
+	f, dir, teardown := newUploadTestMachine(t)
+	defer teardown()
+
+	dest := filepath.Join(dir, "data.csv")
+	err := ioutil.WriteFile(dest, []byte("old"), 0664)
+	if err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	undo, err := f.backupFile(dest, firstMissingPath(dir))
+	if err != nil {
+		t.Fatalf("failed to back up: %s", err)
+	}
+	// the commit the hooks check mustn't have the backup in it
+	entries, err := ioutil.ReadDir(utils.Mnt(f.filesystemId))
+	if err != nil {
+		t.Fatalf("failed to list filesystem: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only __default__ in the filesystem, got %d entries", len(entries))
+	}
+	_, err = writeFile(dest, strings.NewReader("new"))
+	if err != nil {
+		t.Fatalf("failed to write upload: %s", err)
+	}
+	undo(true)
+
+	contents, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("failed to read restored file: %s", err)
+	}
+	if string(contents) != "old" {
+		t.Errorf("expected the replaced file to be restored, got: %q", contents)
+	}
+}
+
+func TestUploadRollbackRemovesNewFileAndDirectories(t *testing.T) {
+	f, dir, teardown := newUploadTestMachine(t)
+	defer teardown()
+
+	newDir := filepath.Join(dir, "a", "b")
+	dest := filepath.Join(newDir, "data.csv")
+	created := firstMissingPath(newDir)
+	if created != filepath.Join(dir, "a") {
+		t.Fatalf("expected %s to be the first missing directory, got: %s", filepath.Join(dir, "a"), created)
+	}
+	err := os.MkdirAll(newDir, 0775)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", newDir, err)
+	}
+
+	undo, err := f.backupFile(dest, created)
+	if err != nil {
+		t.Fatalf("failed to back up: %s", err)
+	}
+	_, err = writeFile(dest, strings.NewReader("new"))
+	if err != nil {
+		t.Fatalf("failed to write upload: %s", err)
+	}
+	undo(true)
+
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got: %v", created, err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("expected %s to be kept, got: %s", dir, err)
+	}
+}
+
+func TestUploadAcceptedDiscardsBackup(t *testing.T) {
+	f, dir, teardown := newUploadTestMachine(t)
+	defer teardown()
+
+	dest := filepath.Join(dir, "data.csv")
+	err := ioutil.WriteFile(dest, []byte("old"), 0664)
+	if err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	undo, err := f.backupFile(dest, "")
+	if err != nil {
+		t.Fatalf("failed to back up: %s", err)
+	}
+	_, err = writeFile(dest, strings.NewReader("new"))
+	if err != nil {
+		t.Fatalf("failed to write upload: %s", err)
+	}
+	undo(false)
+
+	contents, err := ioutil.ReadFile(dest)
+	if err != nil || string(contents) != "new" {
+		t.Errorf("expected the upload to be kept, got: %q, %v", contents, err)
+	}
+	entries, err := ioutil.ReadDir(utils.Mnt(f.filesystemId))
+	if err != nil {
+		t.Fatalf("failed to list filesystem: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only __default__ to be left, got %d entries", len(entries))
+	}
+}
+
+func TestMoveFileReplaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dotmesh-move-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	err = os.Symlink("elsewhere", src)
+	if err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	err = ioutil.WriteFile(dst, []byte("rejected"), 0664)
+	if err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	err = moveFile(src, dst)
+	if err != nil {
+		t.Fatalf("failed to move: %s", err)
+	}
+	target, err := os.Readlink(dst)
+	if err != nil || target != "elsewhere" {
+		t.Errorf("expected the symlink to be moved, got: %q, %v", target, err)
+	}
+	if _, err := os.Lstat(src); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be gone, got: %v", src, err)
+	}
+}
@@ -85,8 +85,27 @@ func (f *FsMachine) ListLocalSnapshots() []*types.Snapshot {
 	return result
 }
 
+// QuerySnapshots - a page of the snapshots on a node matching a query, using
+// the index kept alongside the cache
+func (f *FsMachine) QuerySnapshots(nodeID string, query types.CommitQuery) (types.CommitPage, error) {
+	f.snapshotCacheMu.RLock()
+	defer f.snapshotCacheMu.RUnlock()
+
+	snaps, ok := f.snapshotCache[nodeID]
+	idx, indexed := f.snapshotIndexes[nodeID]
+	if !ok || !indexed {
+		idx = newSnapshotIndex(snaps)
+	}
+	return idx.query(snaps, query)
+}
+
 func (f *FsMachine) SetSnapshots(nodeID string, snapshots []*types.Snapshot) {
+	idx := newSnapshotIndex(snapshots)
 	f.snapshotCacheMu.Lock()
 	f.snapshotCache[nodeID] = snapshots
+	if f.snapshotIndexes == nil {
+		f.snapshotIndexes = make(map[string]*snapshotIndex)
+	}
+	f.snapshotIndexes[nodeID] = idx
 	f.snapshotCacheMu.Unlock()
 }
@@ -7,9 +7,8 @@ import (
 	"net/http"
 	"time"
 
-	"golang.org/x/net/context"
-
 	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/tracing"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/utils"
 )
@@ -50,11 +49,10 @@ func pullInitiatorState(f *FsMachine) StateFn {
 		transferRequest.ApiKey,
 		transferRequest.Port,
 	)
+	client.TLS = transferRequest.TLS
 
 	var path types.PathToTopLevelFilesystem
-	// XXX Not propagating context here; not needed for auth, but would be nice
-	// for inter-cluster opentracing.
-	err = client.CallRemote(context.Background(),
+	err = client.CallRemote(f.requestContext(),
 		"DotmeshRPC.DeducePathToTopLevelFilesystem", map[string]interface{}{
 			"RemoteNamespace":      transferRequest.RemoteNamespace,
 			"RemoteFilesystemName": transferRequest.RemoteName,
@@ -80,6 +78,7 @@ func pullInitiatorState(f *FsMachine) StateFn {
 	}
 
 	// iterate over the path, attempting to pull each clone in turn.
+	started := time.Now()
 	responseEvent, nextState := f.applyPath(path, func(f *FsMachine,
 		fromFilesystemId, fromSnapshotId, toFilesystemId, toSnapshotId string,
 		transferRequestId string,
@@ -88,6 +87,7 @@ func pullInitiatorState(f *FsMachine) StateFn {
 		return f.retryPull(fromFilesystemId, fromSnapshotId, toFilesystemId, toSnapshotId,
 			transferRequestId, client, transferRequest)
 	}, transferRequestId, client, &transferRequest)
+	observeTransfer("pull", started, responseEvent)
 
 	f.innerResponses <- responseEvent
 	return nextState
@@ -114,6 +114,7 @@ func (f *FsMachine) pull(
 	// TODO if we just created the filesystem, become the master for it. (or
 	// maybe this belongs in the metadata prenegotiation phase)
 	f.updateTransfer("calculating size", "")
+	ctx := f.requestContext()
 
 	// XXX This shouldn't be deduced here _and_ passed in as an argument (which
 	// is then thrown away), it just makes the code confusing.
@@ -124,7 +125,7 @@ func (f *FsMachine) pull(
 	// 1. Do an RPC to estimate the send size and update pollResult
 	// accordingly.
 	var size int64
-	err := client.CallRemote(context.Background(),
+	err := client.CallRemote(ctx,
 		"DotmeshRPC.PredictSize", map[string]interface{}{
 			"FromFilesystemId": fromFilesystemId,
 			"FromSnapshotId":   fromSnapshotId,
@@ -141,6 +142,14 @@ func (f *FsMachine) pull(
 	}
 	log.Printf("[pull] size: %d", size)
 
+	err = f.state.CheckQuotas(toFilesystemId, size)
+	if err != nil {
+		return &types.Event{
+			Name: "error-quota-exceeded",
+			Args: &types.EventArgs{"err": err},
+		}, backoffState
+	}
+
 	f.transferUpdates <- types.TransferUpdate{
 		Kind: types.TransferCalculatedSize,
 		Changes: types.TransferPollResult{
@@ -151,10 +160,10 @@ func (f *FsMachine) pull(
 
 	// 2. Perform GET, as receivingState does. Update as we go, similar to how
 	// push does it.
-	var url string
-	if transferRequest.Port == 0 {
+	url, ok := dmclient.BaseURL(transferRequest.Peer, transferRequest.Port, transferRequest.TLS)
+	if !ok {
 		url, err = dmclient.DeduceUrl(
-			context.Background(),
+			ctx,
 			[]string{transferRequest.Peer},
 			// pulls are between clusters, so use external address where
 			// appropriate
@@ -168,8 +177,14 @@ func (f *FsMachine) pull(
 				Args: &types.EventArgs{"err": err},
 			}, backoffState
 		}
-	} else {
-		url = fmt.Sprintf("http://%s:%d", transferRequest.Peer, transferRequest.Port)
+	}
+
+	getClient, err := dmclient.HTTPClient(transferRequest.TLS)
+	if err != nil {
+		return &types.Event{
+			Name: "pull-initiator-cant-verify-peer",
+			Args: &types.EventArgs{"err": err},
+		}, backoffState
 	}
 
 	url = fmt.Sprintf(
@@ -187,7 +202,12 @@ func (f *FsMachine) pull(
 		transferRequest.User,
 		transferRequest.ApiKey,
 	)
-	getClient := new(http.Client)
+	// carry the trace over to the cluster we're pulling from
+	err = tracing.InjectHTTP(ctx, req)
+	if err != nil {
+		log.Printf("Unable to carry the trace over to %s: %s", url, err)
+	}
+	req = req.WithContext(ctx)
 	resp, err := getClient.Do(req)
 	if err != nil {
 		log.Printf("Attempting to pull %s got %s", toFilesystemId, err)
@@ -311,7 +331,7 @@ func (f *FsMachine) retryPull(
 	// Let's go!
 	var remoteSnaps []*types.Snapshot
 	err := client.CallRemote(
-		context.Background(),
+		f.requestContext(),
 		"DotmeshRPC.CommitsById",
 		toFilesystemId,
 		&remoteSnaps,
@@ -7,11 +7,13 @@ import (
 
 	// "log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"golang.org/x/net/context"
 
 	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/tracing"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/utils"
 
@@ -51,6 +53,13 @@ func pushInitiatorState(f *FsMachine) StateFn {
 		),
 	}
 
+	err = f.checkPushHooks(transferRequest.Peer)
+	if err != nil {
+		f.updateUser(err.Error())
+		f.innerResponses <- rejectedEvent("push-rejected", err)
+		return activeState
+	}
+
 	// Also RPC to remote cluster to set up a similar record there.
 	// TODO retries
 	client := dmclient.NewJsonRpcClient(
@@ -59,6 +68,7 @@ func pushInitiatorState(f *FsMachine) StateFn {
 		transferRequest.ApiKey,
 		transferRequest.Port,
 	)
+	client.TLS = transferRequest.TLS
 
 	// TODO should we wait for the remote to ack that it's gone into the right state?
 
@@ -66,8 +76,9 @@ func pushInitiatorState(f *FsMachine) StateFn {
 	// for "up to latest")
 
 	// TODO tidy up argument passing here.
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	ctx, cancel := context.WithTimeout(f.requestContext(), 10*time.Minute)
 	defer cancel()
+	started := time.Now()
 	responseEvent, nextState := f.applyPath(path, func(f *FsMachine,
 		fromFilesystemId, fromSnapshotId, toFilesystemId, toSnapshotId string,
 		transferRequestId string,
@@ -78,6 +89,7 @@ func pushInitiatorState(f *FsMachine) StateFn {
 			transferRequestId, client, transferRequest, ctx,
 		)
 	}, transferRequestId, client, &transferRequest)
+	observeTransfer("push", started, responseEvent)
 
 	f.innerResponses <- responseEvent
 	if nextState == nil {
@@ -103,9 +115,9 @@ func (f *FsMachine) push(
 	defer postWriter.Close()
 	defer postReader.Close()
 
-	var url string
 	var err error
-	if transferRequest.Port == 0 {
+	url, ok := dmclient.BaseURL(transferRequest.Peer, transferRequest.Port, transferRequest.TLS)
+	if !ok {
 		url, err = dmclient.DeduceUrl(
 			ctx,
 			[]string{transferRequest.Peer},
@@ -121,8 +133,14 @@ func (f *FsMachine) push(
 				Args: &types.EventArgs{"err": err},
 			}, backoffState
 		}
-	} else {
-		url = fmt.Sprintf("http://%s:%d", transferRequest.Peer, transferRequest.Port)
+	}
+
+	postClient, err := dmclient.HTTPClient(transferRequest.TLS)
+	if err != nil {
+		return &types.Event{
+			Name: "push-initiator-cant-verify-peer",
+			Args: &types.EventArgs{"err": err},
+		}, backoffState
 	}
 	url = fmt.Sprintf(
 		"%s/filesystems/%s/%s/%s",
@@ -180,6 +198,7 @@ func (f *FsMachine) push(
 	}
 
 	log.Printf("[actualPush:%s] size: %d", filesystemId, size)
+	req.Header.Set(types.PushSizeHeader, strconv.FormatInt(size, 10))
 
 	f.transferUpdates <- types.TransferUpdate{
 		Kind: types.TransferCalculatedSize,
@@ -232,7 +251,6 @@ func (f *FsMachine) push(
 		transferRequest.User,
 		transferRequest.ApiKey,
 	)
-	postClient := new(http.Client)
 
 	log.Printf("[actualPush:%s] About to postClient.Do with req %+v", filesystemId, req)
 
@@ -244,6 +262,12 @@ func (f *FsMachine) push(
 
 	// proceed to do real send
 
+	// carry the trace over to the receiving cluster
+	err = tracing.InjectHTTP(ctx, req)
+	if err != nil {
+		log.Warnf("[actualPush:%s] unable to carry the trace over to the receiving cluster: %s", filesystemId, err)
+	}
+	req = req.WithContext(ctx)
 	resp, err := postClient.Do(req)
 	if err != nil {
 		log.Errorf("[actualPush:%s] error in postClient.Do: %s", filesystemId, err)
@@ -122,8 +122,7 @@ func receivingState(f *FsMachine) StateFn {
 		return backoffStateWithReason(fmt.Sprintf("receivingState: Attempting to pull %s got %+v", f.filesystemId, err))
 	}
 	req.SetBasicAuth("admin", admin.ApiKey)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := dmclient.InternalHTTPClient().Do(req)
 	if err != nil {
 		return backoffStateWithReason(fmt.Sprintf("receivingState: Attempting to pull %s got %+v", f.filesystemId, err))
 	}
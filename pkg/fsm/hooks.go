@@ -0,0 +1,109 @@
+package fsm
+
+import (
+	"fmt"
+
+	"github.com/dotmesh-io/dotmesh/pkg/hooks"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+	"github.com/dotmesh-io/dotmesh/pkg/utils"
+	"github.com/dotmesh-io/dotmesh/pkg/zfs"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// checkHooks - runs the dot's policy hooks for a stage against a read-only
+// mount of the commit being made or pushed. Returns a hooks.RejectedError if
+// any of them reject it.
+func (f *FsMachine) checkHooks(stage, snapshotId string, meta types.Metadata, peer string) error {
+	if f.hookManager == nil {
+		return nil
+	}
+	tlf, branch, err := f.registry.LookupFilesystemById(f.filesystemId)
+	if err != nil {
+		return err
+	}
+	all, err := f.hookManager.List(tlf.MasterBranch.Id)
+	if err != nil {
+		return err
+	}
+	staged := hooks.ForStage(all, stage)
+	if len(staged) == 0 {
+		return nil
+	}
+	if branch == "" {
+		branch = "master"
+	}
+
+	mountPath, cleanup, err := f.mountCandidate(snapshotId)
+	if err != nil {
+		return fmt.Errorf("Unable to mount %s for %s hooks: %s", zfs.FullIdWithSnapshot(f.filesystemId, snapshotId), stage, err)
+	}
+	defer cleanup()
+
+	return hooks.Check(staged, &types.HookRequest{
+		Stage:        stage,
+		Namespace:    tlf.MasterBranch.Name.Namespace,
+		Name:         tlf.MasterBranch.Name.Name,
+		Branch:       branch,
+		FilesystemId: f.filesystemId,
+		CommitId:     snapshotId,
+		Metadata:     meta,
+		MountPath:    mountPath,
+		Peer:         peer,
+	})
+}
+
+// checkPushHooks - runs pre-push hooks against the latest commit, which is
+// what the push brings the peer up to
+func (f *FsMachine) checkPushHooks(peer string) error {
+	var latest types.Snapshot
+	func() {
+		f.snapshotsLock.Lock()
+		defer f.snapshotsLock.Unlock()
+		if len(f.filesystem.Snapshots) > 0 {
+			latest = *f.filesystem.Snapshots[len(f.filesystem.Snapshots)-1]
+		}
+	}()
+	if latest.Id == "" {
+		return nil
+	}
+	return f.checkHooks(types.HookStagePrePush, latest.Id, latest.Metadata, peer)
+}
+
+// mountCandidate - mounts the commit read-only, if it isn't already
+func (f *FsMachine) mountCandidate(snapshotId string) (string, func(), error) {
+	fullId := zfs.FullIdWithSnapshot(f.filesystemId, snapshotId)
+	alreadyMounted, err := utils.IsFilesystemMounted(fullId)
+	if err != nil {
+		return "", nil, err
+	}
+	response, _ := f.mountSnap(snapshotId, true)
+	if response.Name != "mounted" {
+		return "", nil, fmt.Errorf("%s %v", response.Name, response.Args)
+	}
+	cleanup := func() {
+		if alreadyMounted {
+			// someone else is using it, leave it be
+			return
+		}
+		response, _ := f.unmountSnap(snapshotId)
+		if response.Name != "unmounted" {
+			log.WithFields(log.Fields{
+				"filesystem_id": f.filesystemId,
+				"snapshot_id":   snapshotId,
+				"response":      response,
+			}).Warn("[checkHooks] failed to unmount snapshot after running hooks")
+		}
+	}
+	return (*response.Args)["mount-path"].(string), cleanup, nil
+}
+
+// rejectedEvent - response for a commit or push rejected by a hook, or for a
+// stash refused by a branch protection rule, carrying the message as a string
+// so it survives being sent between nodes
+func rejectedEvent(name string, err error) *types.Event {
+	return &types.Event{
+		Name: name,
+		Args: &types.EventArgs{"err": err, "message": err.Error()},
+	}
+}
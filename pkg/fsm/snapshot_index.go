@@ -0,0 +1,121 @@
+package fsm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// snapshotIndex - looks up a node's snapshots of a filesystem by ID, time
+// and metadata, so that a page of a history of tens of thousands of commits
+// can be found without scanning all of it
+type snapshotIndex struct {
+	positions map[string]int
+	// timestamps in nanoseconds, and whether they're in order (they can be
+	// out of order when clocks disagree), so they can be binary searched
+	times  []int64
+	sorted bool
+	// positions of the snapshots with each metadata key and value, in order
+	metadata map[string]map[string][]int
+}
+
+func newSnapshotIndex(snapshots []*types.Snapshot) *snapshotIndex {
+	idx := &snapshotIndex{
+		positions: make(map[string]int, len(snapshots)),
+		times:     make([]int64, len(snapshots)),
+		sorted:    true,
+		metadata:  map[string]map[string][]int{},
+	}
+	for i, s := range snapshots {
+		idx.positions[s.Id] = i
+		t, _ := strconv.ParseInt(s.Metadata["timestamp"], 10, 64)
+		idx.times[i] = t
+		if i > 0 && t < idx.times[i-1] {
+			idx.sorted = false
+		}
+		for k, v := range s.Metadata {
+			values, ok := idx.metadata[k]
+			if !ok {
+				values = map[string][]int{}
+				idx.metadata[k] = values
+			}
+			values[v] = append(values[v], i)
+		}
+	}
+	return idx
+}
+
+// query - a page of the snapshots the index was built from matching q
+func (idx *snapshotIndex) query(snapshots []*types.Snapshot, q types.CommitQuery) (types.CommitPage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = types.DefaultCommitPageSize
+	}
+	if limit > types.MaxCommitPageSize {
+		limit = types.MaxCommitPageSize
+	}
+
+	start, end := 0, len(snapshots)
+	if q.After != "" {
+		p, ok := idx.positions[q.After]
+		if !ok {
+			return types.CommitPage{}, fmt.Errorf("No commit %s to list commits after", q.After)
+		}
+		start = p + 1
+	}
+	if idx.sorted {
+		if q.Since != 0 {
+			if lo := sort.Search(len(idx.times), func(i int) bool { return idx.times[i] >= q.Since }); lo > start {
+				start = lo
+			}
+		}
+		if q.Until != 0 {
+			if hi := sort.Search(len(idx.times), func(i int) bool { return idx.times[i] > q.Until }); hi < end {
+				end = hi
+			}
+		}
+	}
+
+	if start >= end {
+		return types.CommitPage{Commits: []types.Snapshot{}}, nil
+	}
+
+	// only look at the snapshots with the rarest metadata value asked for,
+	// if any
+	var candidates []int
+	for k, v := range q.Metadata {
+		positions := idx.metadata[k][v]
+		if candidates == nil || len(positions) < len(candidates) {
+			candidates = positions
+		}
+		if len(candidates) == 0 {
+			return types.CommitPage{Commits: []types.Snapshot{}}, nil
+		}
+	}
+	if candidates == nil {
+		candidates = make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			candidates = append(candidates, i)
+		}
+	} else {
+		candidates = candidates[sort.SearchInts(candidates, start):]
+	}
+
+	page := types.CommitPage{Commits: []types.Snapshot{}}
+	for _, i := range candidates {
+		if i >= end {
+			break
+		}
+		if !q.Matches(snapshots[i]) {
+			continue
+		}
+		if len(page.Commits) == limit {
+			page.Next = page.Commits[limit-1].Id
+			break
+		}
+		page.Commits = append(page.Commits, *snapshots[i].DeepCopy())
+	}
+	return page, nil
+}
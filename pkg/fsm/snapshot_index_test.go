@@ -0,0 +1,139 @@
+package fsm
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func testSnapshots(n int) []*types.Snapshot {
+	snaps := make([]*types.Snapshot, n)
+	for i := range snaps {
+		author := "alice"
+		if i%2 == 1 {
+			author = "bob"
+		}
+		snaps[i] = &types.Snapshot{
+			Id: fmt.Sprintf("c%d", i),
+			Metadata: map[string]string{
+				"author":    author,
+				"timestamp": fmt.Sprintf("%d", (i+1)*1000),
+				"tier":      fmt.Sprintf("%d", i%3),
+			},
+		}
+	}
+	return snaps
+}
+
+func ids(page types.CommitPage) []string {
+	result := []string{}
+	for _, s := range page.Commits {
+		result = append(result, s.Id)
+	}
+	return result
+}
+
+func TestQuerySnapshotsPages(t *testing.T) {
+	fsm := &FsMachine{
+		snapshotCache:   make(map[string][]*types.Snapshot),
+		snapshotCacheMu: &sync.RWMutex{},
+	}
+	fsm.SetSnapshots("123", testSnapshots(10))
+
+	var seen []string
+	query := types.CommitQuery{Limit: 3}
+	for pages := 0; ; pages++ {
+		if pages > 4 {
+			t.Fatalf("too many pages: %v", seen)
+		}
+		page, err := fsm.QuerySnapshots("123", query)
+		if err != nil {
+			t.Fatalf("failed to query snapshots: %s", err)
+		}
+		seen = append(seen, ids(page)...)
+		if page.Next == "" {
+			break
+		}
+		query.After = page.Next
+	}
+
+	if fmt.Sprint(seen) != "[c0 c1 c2 c3 c4 c5 c6 c7 c8 c9]" {
+		t.Errorf("unexpected commits: %v", seen)
+	}
+}
+
+func TestQuerySnapshotsFilters(t *testing.T) {
+	snaps := testSnapshots(10)
+	idx := newSnapshotIndex(snaps)
+
+	for _, tc := range []struct {
+		name     string
+		query    types.CommitQuery
+		expected string
+		next     string
+	}{
+		{"all", types.CommitQuery{}, "[c0 c1 c2 c3 c4 c5 c6 c7 c8 c9]", ""},
+		{"author", types.CommitQuery{Author: "bo"}, "[c1 c3 c5 c7 c9]", ""},
+		{"since", types.CommitQuery{Since: 8000}, "[c7 c8 c9]", ""},
+		{"until", types.CommitQuery{Until: 2000}, "[c0 c1]", ""},
+		{"range", types.CommitQuery{Since: 3000, Until: 5000}, "[c2 c3 c4]", ""},
+		{"empty range", types.CommitQuery{Since: 5000, Until: 3000}, "[]", ""},
+		{"metadata", types.CommitQuery{Metadata: types.Metadata{"tier": "0"}}, "[c0 c3 c6 c9]", ""},
+		{"metadata and author", types.CommitQuery{Author: "alice", Metadata: types.Metadata{"tier": "0"}}, "[c0 c6]", ""},
+		{"unknown metadata", types.CommitQuery{Metadata: types.Metadata{"tier": "7"}}, "[]", ""},
+		{"metadata after", types.CommitQuery{After: "c3", Metadata: types.Metadata{"tier": "0"}}, "[c6 c9]", ""},
+		{"limit", types.CommitQuery{Author: "alice", Limit: 2}, "[c0 c2]", "c2"},
+		{"limit after", types.CommitQuery{Author: "alice", Limit: 2, After: "c2"}, "[c4 c6]", "c6"},
+		{"last page", types.CommitQuery{Author: "alice", Limit: 2, After: "c6"}, "[c8]", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := idx.query(snaps, tc.query)
+			if err != nil {
+				t.Fatalf("failed to query snapshots: %s", err)
+			}
+			if fmt.Sprint(ids(page)) != tc.expected {
+				t.Errorf("expected %s, got %v", tc.expected, ids(page))
+			}
+			if page.Next != tc.next {
+				t.Errorf("expected next %q, got %q", tc.next, page.Next)
+			}
+		})
+	}
+}
+
+func TestQuerySnapshotsUnsortedTimes(t *testing.T) {
+	snaps := testSnapshots(4)
+	// a commit made on a node whose clock was behind
+	snaps[2].Metadata["timestamp"] = "500"
+	idx := newSnapshotIndex(snaps)
+
+	page, err := idx.query(snaps, types.CommitQuery{Since: 1000})
+	if err != nil {
+		t.Fatalf("failed to query snapshots: %s", err)
+	}
+	if fmt.Sprint(ids(page)) != "[c0 c1 c3]" {
+		t.Errorf("unexpected commits: %v", ids(page))
+	}
+}
+
+func TestQuerySnapshotsUnknownCursor(t *testing.T) {
+	snaps := testSnapshots(4)
+	_, err := newSnapshotIndex(snaps).query(snaps, types.CommitQuery{After: "nope"})
+	if err == nil {
+		t.Errorf("expected an error for an unknown cursor")
+	}
+}
+
+func TestQuerySnapshotsCopies(t *testing.T) {
+	snaps := testSnapshots(1)
+	page, err := newSnapshotIndex(snaps).query(snaps, types.CommitQuery{})
+	if err != nil {
+		t.Fatalf("failed to query snapshots: %s", err)
+	}
+	page.Commits[0].Metadata["author"] = "mallory"
+	if snaps[0].Metadata["author"] != "alice" {
+		t.Errorf("query returned the cached snapshot, not a copy")
+	}
+}
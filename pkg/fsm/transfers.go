@@ -2,8 +2,10 @@ package fsm
 
 import (
 	"fmt"
+	"time"
 
 	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
+	"github.com/dotmesh-io/dotmesh/pkg/metrics"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 
 	log "github.com/sirupsen/logrus"
@@ -251,6 +253,18 @@ func (f *FsMachine) applyPath(path types.PathToTopLevelFilesystem, transferFn tr
 	return responseEvent, nextState
 }
 
+// observeTransfer - records the outcome and duration of a transfer that
+// applyPath finished with responseEvent
+func observeTransfer(direction string, started time.Time, responseEvent *types.Event) {
+	status := "error"
+	if responseEvent.Name == "finished-push" ||
+		responseEvent.Name == "finished-pull" || responseEvent.Name == "peer-up-to-date" {
+		status = "finished"
+	}
+	metrics.TransferCounter.WithLabelValues(direction, status).Inc()
+	metrics.TransferDuration.WithLabelValues(direction, status).Observe(time.Since(started).Seconds())
+}
+
 func TransferPollResultFromTransferRequest(
 	transferRequestId string,
 	transferRequest types.TransferRequest,
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"sync"
 
-	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
 
 	dmclient "github.com/dotmesh-io/dotmesh/pkg/client"
 	"github.com/dotmesh-io/dotmesh/pkg/container"
+	"github.com/dotmesh-io/dotmesh/pkg/hooks"
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
 	"github.com/dotmesh-io/dotmesh/pkg/observer"
 	"github.com/dotmesh-io/dotmesh/pkg/registry"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
@@ -38,6 +40,10 @@ type StateManager interface {
 
 	RegisterNewFork(originFilesystemId, originSnapshotId, forkNamespace, forkName, forkFilesystemId string) error
 
+	// CheckQuotas - returns an error if writing additionalBytes to the
+	// filesystem would take its dot or its namespace over quota
+	CheckQuotas(filesystemId string, additionalBytes int64) error
+
 	// TODO: move under a separate interface for Etcd related things
 	MarkFilesystemAsLiveInEtcd(topLevelFilesystemId string) error
 }
@@ -70,10 +76,11 @@ type FsMachine struct {
 	// state *InMemoryState
 
 	containerClient container.Client
-	etcdClient      client.KeysAPI
+	etcdClient      kv.Store
 	state           StateManager
 	userManager     user.UserManager
 	registry        registry.Registry
+	hookManager     hooks.Manager
 
 	localReceiveProgress observer.Observer
 	newSnapsOnMaster     observer.Observer
@@ -85,6 +92,11 @@ type FsMachine struct {
 	snapshotsLock *sync.Mutex
 	// a place to store arguments to pass to the next state
 	handoffRequest *types.Event
+	// context of the request being handled, carrying the span that traces
+	// it; set by the request loop and read by the states, so only to be
+	// accessed through setRequestContext and requestContext
+	requestCtx     context.Context
+	requestCtxLock *sync.Mutex
 	// filesystem-sliced view of new snapshot events
 	newSnapsOnServers observer.Observer
 	// current state, status field for reporting/debugging and transition observer
@@ -114,6 +126,8 @@ type FsMachine struct {
 	// NodeID => snapshot metadata
 	snapshotCache   map[string][]*types.Snapshot
 	snapshotCacheMu *sync.RWMutex
+	// NodeID => index of snapshotCache, for QuerySnapshots
+	snapshotIndexes map[string]*snapshotIndex
 
 	filesystemMetadataTimeout int64
 
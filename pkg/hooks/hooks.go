@@ -0,0 +1,136 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/coreos/etcd/client"
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// HooksPrefix - KV store prefix for policy hooks, keyed by the dot's top
+// level filesystem ID and then by hook ID
+const HooksPrefix = "hooks"
+
+type Manager interface {
+	// List - returns the hooks configured for a dot, for all stages
+	List(filesystemId string) ([]*types.PolicyHook, error)
+	// Create - validates and stores a new hook, assigning it an ID
+	Create(hook *types.PolicyHook) (*types.PolicyHook, error)
+	Delete(filesystemId, id string) error
+}
+
+type DefaultManager struct {
+	kv kv.KV
+}
+
+func New(kv kv.KV) *DefaultManager {
+	return &DefaultManager{
+		kv: kv,
+	}
+}
+
+func (m *DefaultManager) List(filesystemId string) ([]*types.PolicyHook, error) {
+	nodes, err := m.kv.List(HooksPrefix + "/" + filesystemId)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return []*types.PolicyHook{}, nil
+		}
+		return nil, err
+	}
+	hooks := make([]*types.PolicyHook, 0, len(nodes))
+	for _, node := range nodes {
+		var hook types.PolicyHook
+		err = json.Unmarshal([]byte(node.Value), &hook)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, &hook)
+	}
+	return hooks, nil
+}
+
+func (m *DefaultManager) Create(hook *types.PolicyHook) (*types.PolicyHook, error) {
+	err := Validate(hook)
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	hook.Id = id.String()
+	bts, err := json.Marshal(hook)
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.kv.Set(HooksPrefix+"/"+hook.FilesystemId, hook.Id, string(bts))
+	if err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (m *DefaultManager) Delete(filesystemId, id string) error {
+	return m.kv.Delete(HooksPrefix+"/"+filesystemId, id, false)
+}
+
+// Validate - checks that a hook is for a known stage and has exactly one of
+// a URL or a command to run
+func Validate(hook *types.PolicyHook) error {
+	if hook.FilesystemId == "" {
+		return fmt.Errorf("Hook filesystem ID not set")
+	}
+	if !contains(types.HookStages, hook.Stage) {
+		return fmt.Errorf("Unknown hook stage %q, expected one of %v", hook.Stage, types.HookStages)
+	}
+	if hook.TimeoutSeconds < 0 {
+		return fmt.Errorf("Hook timeout can't be negative")
+	}
+	if hook.TimeoutSeconds > MaxTimeoutSeconds {
+		return fmt.Errorf("Hook timeout can't be more than %d seconds", MaxTimeoutSeconds)
+	}
+	switch {
+	case hook.URL != "" && len(hook.Command) > 0:
+		return fmt.Errorf("Hook can have a URL or a command, not both")
+	case hook.URL != "":
+		u, err := url.Parse(hook.URL)
+		if err != nil {
+			return fmt.Errorf("Invalid hook URL %q: %s", hook.URL, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid hook URL %q, expected an http or https URL", hook.URL)
+		}
+	case len(hook.Command) > 0:
+		if hook.Command[0] == "" {
+			return fmt.Errorf("Hook command can't be empty")
+		}
+	default:
+		return fmt.Errorf("Hook needs a URL or a command")
+	}
+	return nil
+}
+
+// ForStage - filters hooks down to the ones for a stage, in the order given
+func ForStage(hooks []*types.PolicyHook, stage string) []*types.PolicyHook {
+	matching := []*types.PolicyHook{}
+	for _, hook := range hooks {
+		if hook.Stage == stage {
+			matching = append(matching, hook)
+		}
+	}
+	return matching
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
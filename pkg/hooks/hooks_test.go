@@ -0,0 +1,90 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/testutil"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestValidate(t *testing.T) {
+	for _, hook := range []*types.PolicyHook{
+		{FilesystemId: "fs", Stage: types.HookStagePreCommit, URL: "https://policy.example.com/check"},
+		{FilesystemId: "fs", Stage: types.HookStagePrePush, Command: []string{"/usr/local/bin/check"}},
+		{FilesystemId: "fs", Stage: types.HookStagePrePush, Command: []string{"/usr/local/bin/check"}, TimeoutSeconds: MaxTimeoutSeconds},
+	} {
+		if err := Validate(hook); err != nil {
+			t.Errorf("expected hook %+v to be valid, got: %s", hook, err)
+		}
+	}
+
+	for _, hook := range []*types.PolicyHook{
+		{Stage: types.HookStagePreCommit, URL: "https://policy.example.com/check"},
+		{FilesystemId: "fs", Stage: "post-commit", URL: "https://policy.example.com/check"},
+		{FilesystemId: "fs", Stage: types.HookStagePreCommit},
+		{FilesystemId: "fs", Stage: types.HookStagePreCommit, URL: "ftp://policy.example.com/check"},
+		{FilesystemId: "fs", Stage: types.HookStagePreCommit, URL: "https://policy.example.com/check", Command: []string{"check"}},
+		{FilesystemId: "fs", Stage: types.HookStagePreCommit, URL: "https://policy.example.com/check", TimeoutSeconds: -1},
+		{FilesystemId: "fs", Stage: types.HookStagePreCommit, URL: "https://policy.example.com/check", TimeoutSeconds: MaxTimeoutSeconds + 1},
+	} {
+		if err := Validate(hook); err == nil {
+			t.Errorf("expected hook %+v to be invalid", hook)
+		}
+	}
+}
+
+func TestCreateListDelete(t *testing.T) {
+	etcdClient, teardown, err := testutil.GetEtcdClient()
+	if err != nil {
+		t.Fatalf("failed to get etcd client: %s", err)
+	}
+	defer teardown()
+
+	m := New(kv.New(etcdClient, testutil.GetTestPrefix()))
+	fsId := "3e7c7b1e-0d0a-4b55-9a8f-0e2f1f1e6a11"
+
+	hooks, err := m.List(fsId)
+	if err != nil {
+		t.Fatalf("failed to list hooks: %s", err)
+	}
+	if len(hooks) != 0 {
+		t.Errorf("expected no hooks, got: %+v", hooks)
+	}
+
+	created, err := m.Create(&types.PolicyHook{FilesystemId: fsId, Stage: types.HookStagePreCommit, Command: []string{"true"}})
+	if err != nil {
+		t.Fatalf("failed to create hook: %s", err)
+	}
+	if created.Id == "" {
+		t.Errorf("expected hook to be assigned an ID")
+	}
+	_, err = m.Create(&types.PolicyHook{FilesystemId: fsId, Stage: types.HookStagePrePush, Command: []string{"true"}})
+	if err != nil {
+		t.Fatalf("failed to create hook: %s", err)
+	}
+
+	hooks, err = m.List(fsId)
+	if err != nil {
+		t.Fatalf("failed to list hooks: %s", err)
+	}
+	if len(hooks) != 2 {
+		t.Fatalf("expected 2 hooks, got: %+v", hooks)
+	}
+	preCommit := ForStage(hooks, types.HookStagePreCommit)
+	if len(preCommit) != 1 || preCommit[0].Id != created.Id {
+		t.Errorf("expected only the pre-commit hook, got: %+v", preCommit)
+	}
+
+	err = m.Delete(fsId, created.Id)
+	if err != nil {
+		t.Fatalf("failed to delete hook: %s", err)
+	}
+	hooks, err = m.List(fsId)
+	if err != nil {
+		t.Fatalf("failed to list hooks: %s", err)
+	}
+	if len(hooks) != 1 || hooks[0].Stage != types.HookStagePrePush {
+		t.Errorf("expected only the pre-push hook to remain, got: %+v", hooks)
+	}
+}
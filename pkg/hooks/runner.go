@@ -0,0 +1,162 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/notification/webhook"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTimeout = 60 * time.Second
+	// MaxTimeoutSeconds - hooks run while the filesystem's state machine waits
+	// for them, so however long a hook asks for it can't hold it up for
+	// longer than this
+	MaxTimeoutSeconds = 120
+	// longest message we'll pass back to the user from a hook
+	maxMessageLength = 4096
+	// most of a URL hook's response we'll read, to find the message in
+	maxResponseLength = 64 * 1024
+)
+
+// urlClient - what URL hooks are called with. Like webhooks, and for the
+// same reason, they can't reach loopback, link-local or private addresses
+// unless an administrator allows them: any dot owner can add one, and its
+// response is passed back to them.
+var urlClient = &http.Client{Transport: webhook.NewTransport(nil)}
+
+// SetAllowedNetworks - the loopback, link-local and private addresses URL
+// hooks may call, as given in WEBHOOK_ALLOWED_NETWORKS
+func SetAllowedNetworks(allowed []*net.IPNet) {
+	urlClient = &http.Client{Transport: webhook.NewTransport(allowed)}
+}
+
+// RejectedError - returned when a hook rejects a request, or can't be run,
+// as hooks enforce policy an unreachable hook doesn't let anything through
+type RejectedError struct {
+	Stage   string
+	HookId  string
+	Message string
+}
+
+func (e RejectedError) Error() string {
+	return fmt.Sprintf("Rejected by %s hook %s: %s", e.Stage, e.HookId, e.Message)
+}
+
+// Check - runs each hook in turn, returning a RejectedError from the first
+// one that doesn't allow the request
+func Check(hooks []*types.PolicyHook, req *types.HookRequest) error {
+	for _, hook := range hooks {
+		message, allowed := Run(hook, req)
+		log.WithFields(log.Fields{
+			"hook_id":       hook.Id,
+			"stage":         req.Stage,
+			"filesystem_id": req.FilesystemId,
+			"allowed":       allowed,
+			"message":       message,
+		}).Info("[hooks] ran policy hook")
+		if !allowed {
+			return RejectedError{Stage: req.Stage, HookId: hook.Id, Message: message}
+		}
+	}
+	return nil
+}
+
+// Run - runs a single hook, returning whether it allowed the request and any
+// message it gave
+func Run(hook *types.PolicyHook, req *types.HookRequest) (string, bool) {
+	timeout := defaultTimeout
+	if hook.TimeoutSeconds > 0 {
+		timeout = time.Duration(hook.TimeoutSeconds) * time.Second
+	}
+	if timeout > MaxTimeoutSeconds*time.Second {
+		// hooks added before there was a limit
+		timeout = MaxTimeoutSeconds * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Sprintf("failed to encode request: %s", err), false
+	}
+	if hook.URL != "" {
+		return runURL(ctx, hook.URL, body)
+	}
+	return runCommand(ctx, hook.Command, req, body)
+}
+
+func runURL(ctx context.Context, url string, body []byte) (string, bool) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err.Error(), false
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := urlClient.Do(req)
+	if err != nil {
+		return fmt.Sprintf("failed to call hook: %s", err), false
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseLength))
+	if err != nil {
+		return fmt.Sprintf("failed to read hook response: %s", err), false
+	}
+
+	message := strings.TrimSpace(string(respBody))
+	var decoded types.HookResponse
+	if json.Unmarshal(respBody, &decoded) == nil {
+		message = decoded.Message
+	}
+	allowed := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !allowed && message == "" {
+		message = fmt.Sprintf("hook responded with status %d", resp.StatusCode)
+	}
+	return truncate(message), allowed
+}
+
+func runCommand(ctx context.Context, command []string, req *types.HookRequest, body []byte) (string, bool) {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"DOTMESH_HOOK_STAGE="+req.Stage,
+		"DOTMESH_NAMESPACE="+req.Namespace,
+		"DOTMESH_NAME="+req.Name,
+		"DOTMESH_BRANCH="+req.Branch,
+		"DOTMESH_COMMIT_ID="+req.CommitId,
+		"DOTMESH_MOUNT_PATH="+req.MountPath,
+	)
+	output, err := cmd.CombinedOutput()
+	message := strings.TrimSpace(string(output))
+	if err != nil {
+		if ctx.Err() != nil {
+			return "hook timed out", false
+		}
+		if message == "" {
+			message = err.Error()
+		}
+		return truncate(message), false
+	}
+	return truncate(message), true
+}
+
+func truncate(message string) string {
+	if len(message) > maxMessageLength {
+		return message[:maxMessageLength] + "..."
+	}
+	return message
+}
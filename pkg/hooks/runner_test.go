@@ -0,0 +1,124 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestRunURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req types.HookRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.Metadata["message"] == "wip" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(types.HookResponse{Message: "no WIP commits"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	SetAllowedNetworks(loopback)
+	defer SetAllowedNetworks(nil)
+
+	hook := &types.PolicyHook{Id: "url", Stage: types.HookStagePreCommit, URL: srv.URL}
+
+	_, allowed := Run(hook, &types.HookRequest{Stage: types.HookStagePreCommit, Metadata: types.Metadata{"message": "done"}})
+	if !allowed {
+		t.Errorf("expected request to be allowed")
+	}
+
+	message, allowed := Run(hook, &types.HookRequest{Stage: types.HookStagePreCommit, Metadata: types.Metadata{"message": "wip"}})
+	if allowed {
+		t.Errorf("expected request to be rejected")
+	}
+	if message != "no WIP commits" {
+		t.Errorf("unexpected message: %q", message)
+	}
+}
+
+var loopback = []*net.IPNet{{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(32, 32)}}
+
+func TestRunURLRefusesPrivateAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte("secret"))
+	}))
+	defer srv.Close()
+
+	hook := &types.PolicyHook{Id: "url", Stage: types.HookStagePreCommit, URL: srv.URL}
+	message, allowed := Run(hook, &types.HookRequest{Stage: types.HookStagePreCommit})
+	if allowed || called {
+		t.Errorf("expected a hook on a loopback address to be refused without calling it")
+	}
+	if strings.Contains(message, "secret") {
+		t.Errorf("expected none of the response in the message, got: %q", message)
+	}
+}
+
+func TestRunURLLimitsResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(bytes.Repeat([]byte("x"), 10*maxResponseLength))
+	}))
+	defer srv.Close()
+	SetAllowedNetworks(loopback)
+	defer SetAllowedNetworks(nil)
+
+	hook := &types.PolicyHook{Id: "url", Stage: types.HookStagePreCommit, URL: srv.URL}
+	message, allowed := Run(hook, &types.HookRequest{Stage: types.HookStagePreCommit})
+	if allowed {
+		t.Errorf("expected request to be rejected")
+	}
+	if len(message) != maxMessageLength+len("...") {
+		t.Errorf("expected the message to be truncated, got %d bytes", len(message))
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	hook := &types.PolicyHook{
+		Id:      "cmd",
+		Stage:   types.HookStagePrePush,
+		Command: []string{"sh", "-c", `if [ "$DOTMESH_BRANCH" = "master" ]; then echo "not on master" >&2; exit 1; fi`},
+	}
+
+	_, allowed := Run(hook, &types.HookRequest{Stage: types.HookStagePrePush, Branch: "feature"})
+	if !allowed {
+		t.Errorf("expected request to be allowed")
+	}
+
+	message, allowed := Run(hook, &types.HookRequest{Stage: types.HookStagePrePush, Branch: "master"})
+	if allowed {
+		t.Errorf("expected request to be rejected")
+	}
+	if message != "not on master" {
+		t.Errorf("unexpected message: %q", message)
+	}
+}
+
+func TestCheckStopsAtFirstRejection(t *testing.T) {
+	hooks := []*types.PolicyHook{
+		{Id: "allow", Stage: types.HookStagePreCommit, Command: []string{"true"}},
+		{Id: "reject", Stage: types.HookStagePreCommit, Command: []string{"sh", "-c", "echo missing schema.json; exit 1"}},
+		{Id: "unreachable", Stage: types.HookStagePreCommit, Command: []string{"false"}},
+	}
+	err := Check(hooks, &types.HookRequest{Stage: types.HookStagePreCommit})
+	rejected, ok := err.(RejectedError)
+	if !ok {
+		t.Fatalf("expected RejectedError, got: %v", err)
+	}
+	if rejected.HookId != "reject" || rejected.Message != "missing schema.json" {
+		t.Errorf("unexpected rejection: %+v", rejected)
+	}
+}
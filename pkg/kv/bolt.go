@@ -0,0 +1,493 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/coreos/etcd/client"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var boltBucket = []byte("kv")
+
+// how many events to keep around for watchers that fall behind, same as the
+// etcd v2 event history
+const boltWatchHistory = 1000
+
+// how often expired keys are swept up (and "expire" events emitted)
+const boltExpiryInterval = time.Second
+
+// BoltStore - embedded, single node Store backed by a bolt database file, for
+// small installs that don't want to run an etcd cluster and for running the
+// server in-process in tests. The index is the bucket's sequence number, and
+// watch history is kept in memory, so watchers can't resume across restarts
+// (which gives the same "history has been cleared" error as etcd).
+type BoltStore struct {
+	db *bolt.DB
+
+	// serialises writes with publishing their events, so that history is
+	// always in index order
+	writeLock *sync.Mutex
+
+	historyLock *sync.Mutex
+	history     []*client.Response
+	// index of the oldest event a watcher can still be given
+	firstIndex uint64
+	// closed and replaced whenever a new event is published
+	notify chan struct{}
+
+	closed chan struct{}
+}
+
+type boltRecord struct {
+	Value         string
+	CreatedIndex  uint64
+	ModifiedIndex uint64
+	Expiration    *time.Time `json:",omitempty"`
+}
+
+func (r *boltRecord) expired(now time.Time) bool {
+	return r.Expiration != nil && !now.Before(*r.Expiration)
+}
+
+func (r *boltRecord) node(key string, now time.Time) *client.Node {
+	n := &client.Node{
+		Key:           key,
+		Value:         r.Value,
+		CreatedIndex:  r.CreatedIndex,
+		ModifiedIndex: r.ModifiedIndex,
+	}
+	if r.Expiration != nil {
+		exp := *r.Expiration
+		n.Expiration = &exp
+		n.TTL = int64(exp.Sub(now)/time.Second) + 1
+	}
+	return n
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	var index uint64
+	err = db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(boltBucket)
+		if err != nil {
+			return err
+		}
+		index = bkt.Sequence()
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &BoltStore{
+		db:          db,
+		writeLock:   &sync.Mutex{},
+		historyLock: &sync.Mutex{},
+		firstIndex:  index + 1,
+		notify:      make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	go s.expireLoop()
+	return s, nil
+}
+
+func (s *BoltStore) Close() error {
+	close(s.closed)
+	return s.db.Close()
+}
+
+func getRecord(bkt *bolt.Bucket, key string, now time.Time) (*boltRecord, error) {
+	val := bkt.Get([]byte(key))
+	if val == nil {
+		return nil, nil
+	}
+	var r boltRecord
+	err := json.Unmarshal(val, &r)
+	if err != nil {
+		return nil, err
+	}
+	if r.expired(now) {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func putRecord(bkt *bolt.Bucket, key string, r *boltRecord) error {
+	bts, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return bkt.Put([]byte(key), bts)
+}
+
+// scanNodes - all the live nodes with keys starting with prefix
+func scanNodes(bkt *bolt.Bucket, prefix string, now time.Time) ([]*client.Node, error) {
+	nodes := []*client.Node{}
+	c := bkt.Cursor()
+	for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+		var r boltRecord
+		err := json.Unmarshal(v, &r)
+		if err != nil {
+			return nil, err
+		}
+		if r.expired(now) {
+			continue
+		}
+		nodes = append(nodes, r.node(string(k), now))
+	}
+	return nodes, nil
+}
+
+func (s *BoltStore) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	if opts == nil {
+		opts = &client.GetOptions{}
+	}
+	key = cleanKey(key)
+
+	var resp *client.Response
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(boltBucket)
+		index := bkt.Sequence()
+		now := time.Now()
+
+		r, err := getRecord(bkt, key, now)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			resp = &client.Response{Action: "get", Node: r.node(key, now), Index: index}
+			return nil
+		}
+
+		descendants, err := scanNodes(bkt, dirPrefix(key), now)
+		if err != nil {
+			return err
+		}
+		if len(descendants) == 0 && key != "/" {
+			return errKeyNotFound(key, index)
+		}
+		resp = &client.Response{Action: "get", Node: buildDir(key, descendants, opts.Recursive), Index: index}
+		return nil
+	})
+	return resp, err
+}
+
+func (s *BoltStore) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	if opts == nil {
+		opts = &client.SetOptions{}
+	}
+	if opts.Dir {
+		return nil, errDirectoriesUnsupported
+	}
+	key = cleanKey(key)
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	var resp *client.Response
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(boltBucket)
+		index := bkt.Sequence()
+		now := time.Now()
+		if key == "/" {
+			return errRootReadOnly(index)
+		}
+
+		r, err := getRecord(bkt, key, now)
+		if err != nil {
+			return err
+		}
+		var prev *client.Node
+		if r != nil {
+			prev = r.node(key, now)
+		} else {
+			children, err := scanNodes(bkt, dirPrefix(key), now)
+			if err != nil {
+				return err
+			}
+			if len(children) > 0 {
+				return errNotFile(key, index)
+			}
+		}
+		err = checkPrev(key, prev, opts.PrevExist, opts.PrevValue, opts.PrevIndex, index)
+		if err != nil {
+			return err
+		}
+
+		newIndex, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		newRecord := &boltRecord{Value: value, CreatedIndex: newIndex, ModifiedIndex: newIndex}
+		if prev != nil {
+			newRecord.CreatedIndex = prev.CreatedIndex
+		}
+		if opts.TTL > 0 {
+			exp := now.Add(opts.TTL)
+			newRecord.Expiration = &exp
+		}
+		err = putRecord(bkt, key, newRecord)
+		if err != nil {
+			return err
+		}
+		resp = &client.Response{
+			Action:   setAction(opts),
+			Node:     newRecord.node(key, now),
+			PrevNode: prev,
+			Index:    newIndex,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.publish(resp)
+	return resp, nil
+}
+
+func (s *BoltStore) Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error) {
+	if opts == nil {
+		opts = &client.DeleteOptions{}
+	}
+	key = cleanKey(key)
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	var resp *client.Response
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(boltBucket)
+		index := bkt.Sequence()
+		now := time.Now()
+		if key == "/" {
+			return errRootReadOnly(index)
+		}
+
+		r, err := getRecord(bkt, key, now)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			prev := r.node(key, now)
+			err = checkPrev(key, prev, client.PrevIgnore, opts.PrevValue, opts.PrevIndex, index)
+			if err != nil {
+				return err
+			}
+			newIndex, err := bkt.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = bkt.Delete([]byte(key))
+			if err != nil {
+				return err
+			}
+			resp = &client.Response{
+				Action:   deleteAction(opts),
+				Node:     &client.Node{Key: key, CreatedIndex: prev.CreatedIndex, ModifiedIndex: newIndex},
+				PrevNode: prev,
+				Index:    newIndex,
+			}
+			return nil
+		}
+
+		// deleting a directory, which only exists by virtue of its contents
+		descendants, err := scanNodes(bkt, dirPrefix(key), now)
+		if err != nil {
+			return err
+		}
+		if len(descendants) == 0 {
+			return errKeyNotFound(key, index)
+		}
+		if opts.PrevValue != "" || opts.PrevIndex != 0 || (!opts.Recursive && !opts.Dir) {
+			return errNotFile(key, index)
+		}
+		if !opts.Recursive {
+			return errDirNotEmpty(key, index)
+		}
+		newIndex, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		for _, n := range descendants {
+			err = bkt.Delete([]byte(n.Key))
+			if err != nil {
+				return err
+			}
+		}
+		resp = &client.Response{
+			Action:   "delete",
+			Node:     &client.Node{Key: key, Dir: true, ModifiedIndex: newIndex},
+			PrevNode: &client.Node{Key: key, Dir: true},
+			Index:    newIndex,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.publish(resp)
+	return resp, nil
+}
+
+// expireLoop - sweeps up keys whose TTL has passed, so that watchers find out
+// about them the way they would with etcd
+func (s *BoltStore) expireLoop() {
+	ticker := time.NewTicker(boltExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+		err := s.expire()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("kv: failed to expire keys")
+		}
+	}
+}
+
+func (s *BoltStore) expire() error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	events := []*client.Response{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(boltBucket)
+		now := time.Now()
+
+		expired := map[string]*boltRecord{}
+		err := bkt.ForEach(func(k, v []byte) error {
+			var r boltRecord
+			err := json.Unmarshal(v, &r)
+			if err != nil {
+				return err
+			}
+			if r.expired(now) {
+				expired[string(k)] = &r
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for key, r := range expired {
+			newIndex, err := bkt.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = bkt.Delete([]byte(key))
+			if err != nil {
+				return err
+			}
+			prev := r.node(key, now)
+			prev.TTL = 0
+			events = append(events, &client.Response{
+				Action:   "expire",
+				Node:     &client.Node{Key: key, CreatedIndex: r.CreatedIndex, ModifiedIndex: newIndex},
+				PrevNode: prev,
+				Index:    newIndex,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		s.publish(e)
+	}
+	return nil
+}
+
+func (s *BoltStore) publish(resp *client.Response) {
+	s.historyLock.Lock()
+	defer s.historyLock.Unlock()
+
+	s.history = append(s.history, resp)
+	if len(s.history) > boltWatchHistory {
+		s.history = s.history[len(s.history)-boltWatchHistory:]
+		s.firstIndex = s.history[0].Index
+	}
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+func (s *BoltStore) currentIndex() uint64 {
+	var index uint64
+	s.db.View(func(tx *bolt.Tx) error {
+		index = tx.Bucket(boltBucket).Sequence()
+		return nil
+	})
+	return index
+}
+
+func (s *BoltStore) Watcher(key string, opts *client.WatcherOptions) client.Watcher {
+	if opts == nil {
+		opts = &client.WatcherOptions{}
+	}
+	w := &boltWatcher{
+		store:     s,
+		key:       cleanKey(key),
+		recursive: opts.Recursive,
+		after:     opts.AfterIndex,
+	}
+	if w.after == 0 {
+		w.after = s.currentIndex()
+	}
+	return w
+}
+
+type boltWatcher struct {
+	store     *BoltStore
+	key       string
+	recursive bool
+	// index of the last event looked at
+	after uint64
+}
+
+func (w *boltWatcher) Next(ctx context.Context) (*client.Response, error) {
+	for {
+		w.store.historyLock.Lock()
+		if w.after+1 < w.store.firstIndex {
+			first := w.store.firstIndex
+			w.store.historyLock.Unlock()
+			return nil, errIndexCleared(w.after, first, w.store.currentIndex())
+		}
+		for _, e := range w.store.history {
+			if e.Index <= w.after {
+				continue
+			}
+			w.after = e.Index
+			if watchMatches(w.key, w.recursive, e.Node.Key) {
+				w.store.historyLock.Unlock()
+				return e, nil
+			}
+		}
+		notify := w.store.notify
+		w.store.historyLock.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-w.store.closed:
+			return nil, fmt.Errorf("kv: store closed while watching %s", w.key)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package kv
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+// EtcdV3Store - Store backed by the etcd v3 API. Keys are stored as they are
+// in v2 and directories are implicit, so a recursive get or delete of a
+// directory is a range operation over everything beneath it. Indexes are
+// etcd revisions and TTLs are implemented with leases.
+type EtcdV3Store struct {
+	client *clientv3.Client
+
+	// the lease each key was last set with a TTL on. Keys are kept alive by
+	// setting them again before they expire, which refreshes their lease
+	// rather than granting them a new one each time.
+	leases     map[string]keyLease
+	leasesLock *sync.Mutex
+}
+
+type keyLease struct {
+	id  clientv3.LeaseID
+	ttl int64
+}
+
+func NewEtcdV3Store(client *clientv3.Client) *EtcdV3Store {
+	return &EtcdV3Store{
+		client:     client,
+		leases:     map[string]keyLease{},
+		leasesLock: &sync.Mutex{},
+	}
+}
+
+func nodeFromKV(kv *mvccpb.KeyValue) *client.Node {
+	return &client.Node{
+		Key:           string(kv.Key),
+		Value:         string(kv.Value),
+		CreatedIndex:  uint64(kv.CreateRevision),
+		ModifiedIndex: uint64(kv.ModRevision),
+	}
+}
+
+func (s *EtcdV3Store) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	if opts == nil {
+		opts = &client.GetOptions{}
+	}
+	key = cleanKey(key)
+
+	resp, err := s.client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) > 0 {
+		return &client.Response{Action: "get", Node: nodeFromKV(resp.Kvs[0]), Index: uint64(resp.Header.Revision)}, nil
+	}
+
+	resp, err = s.client.Get(ctx, dirPrefix(key), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	index := uint64(resp.Header.Revision)
+	if len(resp.Kvs) == 0 && key != "/" {
+		return nil, errKeyNotFound(key, index)
+	}
+	descendants := make([]*client.Node, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		descendants = append(descendants, nodeFromKV(kv))
+	}
+	return &client.Response{Action: "get", Node: buildDir(key, descendants, opts.Recursive), Index: index}, nil
+}
+
+func (s *EtcdV3Store) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	if opts == nil {
+		opts = &client.SetOptions{}
+	}
+	if opts.Dir {
+		return nil, errDirectoriesUnsupported
+	}
+	key = cleanKey(key)
+	if key == "/" {
+		return nil, errRootReadOnly(0)
+	}
+
+	cmps := []clientv3.Cmp{}
+	switch opts.PrevExist {
+	case client.PrevNoExist:
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+	case client.PrevExist:
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), ">", 0))
+	}
+	if opts.PrevValue != "" {
+		cmps = append(cmps, clientv3.Compare(clientv3.Value(key), "=", opts.PrevValue))
+	}
+	if opts.PrevIndex != 0 {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", int64(opts.PrevIndex)))
+	}
+
+	putOpts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	var lease keyLease
+	if opts.TTL > 0 {
+		var err error
+		lease, err = s.leaseFor(ctx, key, int64(math.Ceil(opts.TTL.Seconds())))
+		if err != nil {
+			return nil, err
+		}
+		putOpts = append(putOpts, clientv3.WithLease(lease.id))
+	}
+
+	txnResp, err := s.client.Txn(ctx).
+		If(cmps...).
+		Then(clientv3.OpPut(key, value, putOpts...)).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return nil, err
+	}
+	index := uint64(txnResp.Header.Revision)
+
+	if !txnResp.Succeeded {
+		var prev *client.Node
+		kvs := txnResp.Responses[0].GetResponseRange().Kvs
+		if len(kvs) > 0 {
+			prev = nodeFromKV(kvs[0])
+		}
+		err = checkPrev(key, prev, opts.PrevExist, opts.PrevValue, opts.PrevIndex, index)
+		if err == nil {
+			// lost a race between the compare and re-reading the key
+			err = errTestFailed(key, index)
+		}
+		return nil, err
+	}
+	s.setLease(ctx, key, lease)
+
+	node := &client.Node{Key: key, Value: value, CreatedIndex: index, ModifiedIndex: index}
+	resp := &client.Response{Action: setAction(opts), Node: node, Index: index}
+	if prevKV := txnResp.Responses[0].GetResponsePut().PrevKv; prevKV != nil {
+		resp.PrevNode = nodeFromKV(prevKV)
+		node.CreatedIndex = resp.PrevNode.CreatedIndex
+	}
+	if opts.TTL > 0 {
+		exp := time.Now().Add(opts.TTL)
+		node.Expiration = &exp
+		node.TTL = int64(math.Ceil(opts.TTL.Seconds()))
+	}
+	return resp, nil
+}
+
+// leaseFor - the lease to set key with the given TTL on: the one it already
+// has, refreshed, if that was granted the same TTL and hasn't expired, or a
+// new one
+func (s *EtcdV3Store) leaseFor(ctx context.Context, key string, ttl int64) (keyLease, error) {
+	s.leasesLock.Lock()
+	lease, ok := s.leases[key]
+	s.leasesLock.Unlock()
+	if ok && lease.ttl == ttl {
+		_, err := s.client.KeepAliveOnce(ctx, lease.id)
+		if err == nil {
+			return lease, nil
+		}
+	}
+	granted, err := s.client.Grant(ctx, ttl)
+	if err != nil {
+		return keyLease{}, err
+	}
+	return keyLease{id: granted.ID, ttl: ttl}, nil
+}
+
+// setLease - records the lease key has been set with (none if its ID is
+// zero), revoking the one it had before, which nothing else is using
+func (s *EtcdV3Store) setLease(ctx context.Context, key string, lease keyLease) {
+	s.leasesLock.Lock()
+	prev, ok := s.leases[key]
+	if lease.id == 0 {
+		delete(s.leases, key)
+	} else {
+		s.leases[key] = lease
+	}
+	s.leasesLock.Unlock()
+	if ok && prev.id != lease.id {
+		// it expires anyway, this just saves waiting for it
+		s.client.Revoke(ctx, prev.id)
+	}
+}
+
+// forgetLeases - deleted keys don't need their leases refreshing, which are
+// left to expire
+func (s *EtcdV3Store) forgetLeases(key string, recursive bool) {
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+	delete(s.leases, key)
+	if recursive {
+		for k := range s.leases {
+			if strings.HasPrefix(k, dirPrefix(key)) {
+				delete(s.leases, k)
+			}
+		}
+	}
+}
+
+func (s *EtcdV3Store) Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error) {
+	if opts == nil {
+		opts = &client.DeleteOptions{}
+	}
+	key = cleanKey(key)
+	if key == "/" {
+		return nil, errRootReadOnly(0)
+	}
+
+	if opts.PrevValue != "" || opts.PrevIndex != 0 {
+		return s.compareAndDelete(ctx, key, opts)
+	}
+
+	ops := []clientv3.Op{clientv3.OpDelete(key, clientv3.WithPrevKV())}
+	if opts.Recursive {
+		ops = append(ops, clientv3.OpDelete(dirPrefix(key), clientv3.WithPrefix()))
+	}
+	txnResp, err := s.client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	index := uint64(txnResp.Header.Revision)
+	s.forgetLeases(key, opts.Recursive)
+
+	deleted := txnResp.Responses[0].GetResponseDeleteRange()
+	if deleted.Deleted > 0 {
+		prev := nodeFromKV(deleted.PrevKvs[0])
+		return &client.Response{
+			Action:   "delete",
+			Node:     &client.Node{Key: key, CreatedIndex: prev.CreatedIndex, ModifiedIndex: index},
+			PrevNode: prev,
+			Index:    index,
+		}, nil
+	}
+	if opts.Recursive && txnResp.Responses[1].GetResponseDeleteRange().Deleted > 0 {
+		return &client.Response{
+			Action:   "delete",
+			Node:     &client.Node{Key: key, Dir: true, ModifiedIndex: index},
+			PrevNode: &client.Node{Key: key, Dir: true},
+			Index:    index,
+		}, nil
+	}
+
+	// nothing deleted, work out why
+	children, err := s.client.Get(ctx, dirPrefix(key), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case children.Count == 0:
+		return nil, errKeyNotFound(key, index)
+	case opts.Dir:
+		return nil, errDirNotEmpty(key, index)
+	default:
+		return nil, errNotFile(key, index)
+	}
+}
+
+func (s *EtcdV3Store) compareAndDelete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error) {
+	cmps := []clientv3.Cmp{}
+	if opts.PrevValue != "" {
+		cmps = append(cmps, clientv3.Compare(clientv3.Value(key), "=", opts.PrevValue))
+	}
+	if opts.PrevIndex != 0 {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", int64(opts.PrevIndex)))
+	}
+	txnResp, err := s.client.Txn(ctx).
+		If(cmps...).
+		Then(clientv3.OpDelete(key, clientv3.WithPrevKV())).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return nil, err
+	}
+	index := uint64(txnResp.Header.Revision)
+
+	if !txnResp.Succeeded {
+		var prev *client.Node
+		kvs := txnResp.Responses[0].GetResponseRange().Kvs
+		if len(kvs) > 0 {
+			prev = nodeFromKV(kvs[0])
+		}
+		err = checkPrev(key, prev, client.PrevIgnore, opts.PrevValue, opts.PrevIndex, index)
+		if err == nil {
+			err = errTestFailed(key, index)
+		}
+		return nil, err
+	}
+	s.forgetLeases(key, false)
+	deleted := txnResp.Responses[0].GetResponseDeleteRange()
+	if deleted.Deleted == 0 {
+		// the comparisons pass against a missing key when comparing
+		// against its zero value
+		return nil, errKeyNotFound(key, index)
+	}
+	prev := nodeFromKV(deleted.PrevKvs[0])
+	return &client.Response{
+		Action:   deleteAction(opts),
+		Node:     &client.Node{Key: key, CreatedIndex: prev.CreatedIndex, ModifiedIndex: index},
+		PrevNode: prev,
+		Index:    index,
+	}, nil
+}
+
+func (s *EtcdV3Store) Watcher(key string, opts *client.WatcherOptions) client.Watcher {
+	if opts == nil {
+		opts = &client.WatcherOptions{}
+	}
+	return &etcdV3Watcher{
+		client:    s.client,
+		key:       cleanKey(key),
+		recursive: opts.Recursive,
+		after:     opts.AfterIndex,
+	}
+}
+
+type etcdV3Watcher struct {
+	client    *clientv3.Client
+	key       string
+	recursive bool
+	// revision of the last event looked at, zero until the first event if
+	// the watch started from the current revision
+	after uint64
+
+	watchChan clientv3.WatchChan
+	cancel    context.CancelFunc
+	pending   []*client.Response
+}
+
+func responseFromEvent(ev *clientv3.Event) *client.Response {
+	index := uint64(ev.Kv.ModRevision)
+	resp := &client.Response{Index: index}
+	if ev.PrevKv != nil {
+		resp.PrevNode = nodeFromKV(ev.PrevKv)
+	}
+	switch ev.Type {
+	case clientv3.EventTypePut:
+		resp.Node = nodeFromKV(ev.Kv)
+		if ev.IsCreate() {
+			resp.Action = "create"
+		} else {
+			resp.Action = "set"
+		}
+	default:
+		// v3 doesn't distinguish lease expiry from deletion
+		resp.Action = "delete"
+		resp.Node = &client.Node{Key: string(ev.Kv.Key), ModifiedIndex: index}
+		if resp.PrevNode != nil {
+			resp.Node.CreatedIndex = resp.PrevNode.CreatedIndex
+		}
+	}
+	return resp
+}
+
+func (w *etcdV3Watcher) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	watchOpts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if w.recursive {
+		// prefix matches siblings sharing the prefix too, those are filtered
+		// out in Next
+		watchOpts = append(watchOpts, clientv3.WithPrefix())
+	}
+	if w.after > 0 {
+		watchOpts = append(watchOpts, clientv3.WithRev(int64(w.after+1)))
+	}
+	w.watchChan = w.client.Watch(ctx, w.key, watchOpts...)
+}
+
+func (w *etcdV3Watcher) stop() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.watchChan = nil
+}
+
+func (w *etcdV3Watcher) Next(ctx context.Context) (*client.Response, error) {
+	for len(w.pending) == 0 {
+		if w.watchChan == nil {
+			w.start()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case watchResp, ok := <-w.watchChan:
+			if !ok {
+				// resume from where we got to
+				w.stop()
+				continue
+			}
+			if watchResp.CompactRevision != 0 {
+				w.stop()
+				return nil, errIndexCleared(w.after, uint64(watchResp.CompactRevision), uint64(watchResp.Header.Revision))
+			}
+			if err := watchResp.Err(); err != nil {
+				w.stop()
+				return nil, err
+			}
+			for _, ev := range watchResp.Events {
+				resp := responseFromEvent(ev)
+				w.after = resp.Index
+				if watchMatches(w.key, w.recursive, resp.Node.Key) {
+					w.pending = append(w.pending, resp)
+				}
+			}
+		}
+	}
+	resp := w.pending[0]
+	w.pending = w.pending[1:]
+	return resp, nil
+}
@@ -14,7 +14,7 @@ type NameIndex struct {
 	ID     string `json:"id"`
 }
 
-func (k *EtcdKV) idxFindID(prefix, name string) (id string, err error) {
+func (k *DefaultKV) idxFindID(prefix, name string) (id string, err error) {
 	resp, err := k.get(k.prefix+" /"+NameIndexAPIPrefix, name)
 	if err != nil {
 		return
@@ -29,7 +29,7 @@ func (k *EtcdKV) idxFindID(prefix, name string) (id string, err error) {
 	return i.ID, nil
 }
 
-func (k *EtcdKV) idxAdd(prefix, name, id string) error {
+func (k *DefaultKV) idxAdd(prefix, name, id string) error {
 	val := NameIndex{
 		Prefix: prefix,
 		Name:   name,
@@ -46,6 +46,6 @@ func (k *EtcdKV) idxAdd(prefix, name, id string) error {
 	return err
 }
 
-func (k *EtcdKV) idxDelete(prefix, name string) error {
+func (k *DefaultKV) idxDelete(prefix, name string) error {
 	return k.Delete(k.prefix+" /"+NameIndexAPIPrefix, name, false)
 }
@@ -21,19 +21,21 @@ type KV interface {
 	Delete(prefix, id string, recursive bool) error
 }
 
-type EtcdKV struct {
-	client client.KeysAPI
+type DefaultKV struct {
+	client Store
 	prefix string
 }
 
-func New(client client.KeysAPI, prefix string) *EtcdKV {
-	return &EtcdKV{
+// New - creates a KV on top of any Store, an etcd v2 client.KeysAPI can be
+// passed straight in
+func New(client Store, prefix string) *DefaultKV {
+	return &DefaultKV{
 		client: client,
 		prefix: prefix,
 	}
 }
 
-func (k *EtcdKV) List(prefix string) ([]*client.Node, error) {
+func (k *DefaultKV) List(prefix string) ([]*client.Node, error) {
 	resp, err := k.client.Get(context.Background(), k.prefix+"/"+prefix, &client.GetOptions{Recursive: true})
 	if err != nil {
 		return nil, err
@@ -42,7 +44,7 @@ func (k *EtcdKV) List(prefix string) ([]*client.Node, error) {
 	return resp.Node.Nodes, nil
 }
 
-func (k *EtcdKV) CreateWithIndex(prefix, id, name string, val string) (*client.Node, error) {
+func (k *DefaultKV) CreateWithIndex(prefix, id, name string, val string) (*client.Node, error) {
 	resp, err := k.client.Set(context.Background(), k.prefix+"/"+prefix+"/"+id, val, nil)
 	if err != nil {
 		return nil, err
@@ -59,15 +61,15 @@ func (k *EtcdKV) CreateWithIndex(prefix, id, name string, val string) (*client.N
 	return resp.Node, nil
 }
 
-func (k *EtcdKV) AddToIndex(prefix, name, id string) error {
+func (k *DefaultKV) AddToIndex(prefix, name, id string) error {
 	return k.idxAdd(prefix, name, id)
 }
 
-func (k *EtcdKV) DeleteFromIndex(prefix, name string) error {
+func (k *DefaultKV) DeleteFromIndex(prefix, name string) error {
 	return k.idxDelete(prefix, name)
 }
 
-func (k *EtcdKV) Set(prefix, id, val string) (*client.Node, error) {
+func (k *DefaultKV) Set(prefix, id, val string) (*client.Node, error) {
 	resp, err := k.client.Set(context.Background(), k.prefix+"/"+prefix+"/"+id, val, nil)
 	if err != nil {
 		return nil, err
@@ -76,7 +78,7 @@ func (k *EtcdKV) Set(prefix, id, val string) (*client.Node, error) {
 	return resp.Node, nil
 }
 
-func (k *EtcdKV) Get(prefix, ref string) (*client.Node, error) {
+func (k *DefaultKV) Get(prefix, ref string) (*client.Node, error) {
 	if validator.IsUUID(ref) {
 		return k.get(prefix, ref)
 	}
@@ -93,7 +95,7 @@ func (k *EtcdKV) Get(prefix, ref string) (*client.Node, error) {
 	return k.get(prefix, id)
 }
 
-func (k *EtcdKV) get(prefix, id string) (*client.Node, error) {
+func (k *DefaultKV) get(prefix, id string) (*client.Node, error) {
 	resp, err := k.client.Get(context.Background(), k.prefix+"/"+prefix+"/"+id, &client.GetOptions{Recursive: false})
 	if err != nil {
 		return nil, err
@@ -102,7 +104,7 @@ func (k *EtcdKV) get(prefix, id string) (*client.Node, error) {
 	return resp.Node, nil
 }
 
-func (k *EtcdKV) Delete(prefix, id string, recursive bool) error {
+func (k *DefaultKV) Delete(prefix, id string, recursive bool) error {
 	_, err := k.client.Delete(context.Background(), k.prefix+"/"+prefix+"/"+id, &client.DeleteOptions{Recursive: recursive})
 	return err
 }
@@ -0,0 +1,169 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/coreos/etcd/client"
+)
+
+// Store - the storage and watch layer that the server, registry and state
+// machines talk to. Its shape follows the etcd v2 keys API that all of that
+// code was written against (hierarchical keys, per-key modified indexes, TTLs,
+// compare-and-swap and recursive watches), so an etcd v2 client.KeysAPI
+// satisfies it as-is, while the etcd v3 and embedded bolt backends in this
+// package emulate those semantics on top of a flat key space.
+type Store interface {
+	Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error)
+	Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error)
+	Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error)
+	Watcher(key string, opts *client.WatcherOptions) client.Watcher
+}
+
+// errors returned by the emulated backends carry the same codes and messages
+// as the ones etcd v2 returns, so that client.IsKeyNotFound and friends keep
+// working.
+
+func errKeyNotFound(key string, index uint64) error {
+	return client.Error{Code: client.ErrorCodeKeyNotFound, Message: "Key not found", Cause: key, Index: index}
+}
+
+func errTestFailed(cause string, index uint64) error {
+	return client.Error{Code: client.ErrorCodeTestFailed, Message: "Compare failed", Cause: cause, Index: index}
+}
+
+func errNodeExist(key string, index uint64) error {
+	return client.Error{Code: client.ErrorCodeNodeExist, Message: "Key already exists", Cause: key, Index: index}
+}
+
+func errNotFile(key string, index uint64) error {
+	return client.Error{Code: client.ErrorCodeNotFile, Message: "Not a file", Cause: key, Index: index}
+}
+
+func errDirNotEmpty(key string, index uint64) error {
+	return client.Error{Code: client.ErrorCodeDirNotEmpty, Message: "Directory not empty", Cause: key, Index: index}
+}
+
+func errIndexCleared(afterIndex, firstIndex, index uint64) error {
+	return client.Error{
+		Code:    client.ErrorCodeEventIndexCleared,
+		Message: "The event in requested index is outdated and cleared",
+		Cause:   fmt.Sprintf("the requested history has been cleared [%d/%d]", firstIndex, afterIndex+1),
+		Index:   index,
+	}
+}
+
+func errRootReadOnly(index uint64) error {
+	return client.Error{Code: client.ErrorCodeRootROnly, Message: "Cannot modify the root", Cause: "/", Index: index}
+}
+
+var errDirectoriesUnsupported = fmt.Errorf("kv: directories are implicit, setting them explicitly is not supported")
+
+// checkPrev - checks the preconditions of a Set or Delete against the node
+// currently at key, which is nil if there isn't one
+func checkPrev(key string, prev *client.Node, prevExist client.PrevExistType, prevValue string, prevIndex uint64, index uint64) error {
+	switch prevExist {
+	case client.PrevNoExist:
+		if prev != nil {
+			return errNodeExist(key, index)
+		}
+	case client.PrevExist:
+		if prev == nil {
+			return errKeyNotFound(key, index)
+		}
+	}
+	if prevValue == "" && prevIndex == 0 {
+		return nil
+	}
+	if prev == nil {
+		return errKeyNotFound(key, index)
+	}
+	if prevValue != "" && prev.Value != prevValue {
+		return errTestFailed(fmt.Sprintf("[%s != %s]", prevValue, prev.Value), index)
+	}
+	if prevIndex != 0 && prev.ModifiedIndex != prevIndex {
+		return errTestFailed(fmt.Sprintf("[%d != %d]", prevIndex, prev.ModifiedIndex), index)
+	}
+	return nil
+}
+
+func setAction(opts *client.SetOptions) string {
+	switch {
+	case opts.PrevValue != "" || opts.PrevIndex != 0:
+		return "compareAndSwap"
+	case opts.PrevExist == client.PrevNoExist:
+		return "create"
+	case opts.PrevExist == client.PrevExist:
+		return "update"
+	}
+	return "set"
+}
+
+func deleteAction(opts *client.DeleteOptions) string {
+	if opts.PrevValue != "" || opts.PrevIndex != 0 {
+		return "compareAndDelete"
+	}
+	return "delete"
+}
+
+// cleanKey - etcd v2 keys always start with a slash and never end with one
+func cleanKey(key string) string {
+	return path.Clean("/" + key)
+}
+
+// dirPrefix - the prefix shared by every key underneath key
+func dirPrefix(key string) string {
+	if key == "/" {
+		return "/"
+	}
+	return key + "/"
+}
+
+// watchMatches - whether a change to changedKey should be reported to a
+// watcher on key
+func watchMatches(key string, recursive bool, changedKey string) bool {
+	if changedKey == key {
+		return true
+	}
+	return recursive && strings.HasPrefix(changedKey, dirPrefix(key))
+}
+
+// buildDir - assembles the directory node for key out of the flat list of
+// nodes beneath it, mimicking an etcd v2 directory listing: without recursive
+// only the immediate children are returned, and sub-directories are returned
+// without their contents.
+func buildDir(key string, descendants []*client.Node, recursive bool) *client.Node {
+	root := &client.Node{Key: key, Dir: true}
+	dirs := map[string]*client.Node{key: root}
+
+	var ensureDir func(dirKey string) *client.Node
+	ensureDir = func(dirKey string) *client.Node {
+		if d, ok := dirs[dirKey]; ok {
+			return d
+		}
+		d := &client.Node{Key: dirKey, Dir: true}
+		dirs[dirKey] = d
+		parent := ensureDir(path.Dir(dirKey))
+		if recursive || parent == root {
+			parent.Nodes = append(parent.Nodes, d)
+		}
+		return d
+	}
+
+	for _, n := range descendants {
+		parent := ensureDir(path.Dir(n.Key))
+		if recursive || parent == root {
+			parent.Nodes = append(parent.Nodes, n)
+		}
+		if n.ModifiedIndex > root.ModifiedIndex {
+			root.ModifiedIndex = n.ModifiedIndex
+		}
+	}
+	for _, d := range dirs {
+		sort.Sort(d.Nodes)
+	}
+	return root
+}
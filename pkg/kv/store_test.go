@@ -0,0 +1,340 @@
+package kv
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+
+	"github.com/dotmesh-io/dotmesh/pkg/testutil"
+)
+
+// every backend is run through the same checks, with etcd v2 itself as the
+// reference for how the emulated ones should behave
+
+func getStores(t *testing.T) (map[string]Store, func()) {
+	v2, v2Teardown, err := testutil.GetEtcdClient()
+	if err != nil {
+		t.Fatalf("failed to get etcd client: %s", err)
+	}
+	v3, v3Teardown, err := testutil.GetEtcdV3Client()
+	if err != nil {
+		v2Teardown()
+		t.Fatalf("failed to get etcd v3 client: %s", err)
+	}
+	dir, err := ioutil.TempDir(os.TempDir(), "dotmesh-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	bolt, err := NewBoltStore(filepath.Join(dir, "kv.db"))
+	if err != nil {
+		t.Fatalf("failed to open bolt store: %s", err)
+	}
+
+	stores := map[string]Store{
+		"etcd":  v2,
+		"etcd3": NewEtcdV3Store(v3),
+		"bolt":  bolt,
+	}
+	return stores, func() {
+		v2Teardown()
+		v3Teardown()
+		bolt.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestStoreSetGetDelete(t *testing.T) {
+	stores, teardown := getStores(t)
+	defer teardown()
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			key := "/" + testutil.GetTestPrefix() + "/foo"
+
+			_, err := s.Get(ctx, key, nil)
+			if !client.IsKeyNotFound(err) {
+				t.Fatalf("expected key not found, got: %v", err)
+			}
+
+			_, err = s.Set(ctx, key, "bar", nil)
+			if err != nil {
+				t.Fatalf("failed to set: %s", err)
+			}
+
+			resp, err := s.Set(ctx, key, "baz", nil)
+			if err != nil {
+				t.Fatalf("failed to set: %s", err)
+			}
+			if resp.PrevNode == nil || resp.PrevNode.Value != "bar" {
+				t.Errorf("expected previous value 'bar', got: %+v", resp.PrevNode)
+			}
+
+			resp, err = s.Get(ctx, key, nil)
+			if err != nil {
+				t.Fatalf("failed to get: %s", err)
+			}
+			if resp.Node.Value != "baz" {
+				t.Errorf("expected 'baz', got: '%s'", resp.Node.Value)
+			}
+
+			_, err = s.Delete(ctx, key, nil)
+			if err != nil {
+				t.Fatalf("failed to delete: %s", err)
+			}
+			_, err = s.Get(ctx, key, nil)
+			if !client.IsKeyNotFound(err) {
+				t.Errorf("expected key not found after delete, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreCompareAndSwap(t *testing.T) {
+	stores, teardown := getStores(t)
+	defer teardown()
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			key := "/" + testutil.GetTestPrefix() + "/foo"
+
+			resp, err := s.Set(ctx, key, "bar", &client.SetOptions{PrevExist: client.PrevNoExist})
+			if err != nil {
+				t.Fatalf("failed to create: %s", err)
+			}
+			_, err = s.Set(ctx, key, "bar", &client.SetOptions{PrevExist: client.PrevNoExist})
+			if e, ok := err.(client.Error); !ok || e.Code != client.ErrorCodeNodeExist {
+				t.Errorf("expected node exists error, got: %v", err)
+			}
+
+			_, err = s.Set(ctx, key, "baz", &client.SetOptions{PrevIndex: resp.Node.ModifiedIndex + 1000})
+			if e, ok := err.(client.Error); !ok || e.Code != client.ErrorCodeTestFailed {
+				t.Errorf("expected compare failed error, got: %v", err)
+			}
+			_, err = s.Set(ctx, key, "baz", &client.SetOptions{PrevIndex: resp.Node.ModifiedIndex})
+			if err != nil {
+				t.Errorf("failed to compare and swap: %s", err)
+			}
+
+			_, err = s.Delete(ctx, key, &client.DeleteOptions{PrevValue: "bar"})
+			if e, ok := err.(client.Error); !ok || e.Code != client.ErrorCodeTestFailed {
+				t.Errorf("expected compare failed error, got: %v", err)
+			}
+			_, err = s.Delete(ctx, key, &client.DeleteOptions{PrevValue: "baz"})
+			if err != nil {
+				t.Errorf("failed to compare and delete: %s", err)
+			}
+		})
+	}
+}
+
+func TestStoreDirectories(t *testing.T) {
+	stores, teardown := getStores(t)
+	defer teardown()
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := "/" + testutil.GetTestPrefix()
+
+			for _, key := range []string{"a", "b", "sub/c", "sub/d"} {
+				_, err := s.Set(ctx, dir+"/"+key, key, nil)
+				if err != nil {
+					t.Fatalf("failed to set %s: %s", key, err)
+				}
+			}
+
+			resp, err := s.Get(ctx, dir, &client.GetOptions{Sort: true})
+			if err != nil {
+				t.Fatalf("failed to get dir: %s", err)
+			}
+			if !resp.Node.Dir || len(resp.Node.Nodes) != 3 {
+				t.Fatalf("expected directory with 3 children, got: %+v", resp.Node)
+			}
+			sub := resp.Node.Nodes[2]
+			if sub.Key != dir+"/sub" || !sub.Dir || len(sub.Nodes) != 0 {
+				t.Errorf("expected empty listing of sub directory without recursive, got: %+v", sub)
+			}
+
+			resp, err = s.Get(ctx, dir, &client.GetOptions{Recursive: true, Sort: true})
+			if err != nil {
+				t.Fatalf("failed to get dir: %s", err)
+			}
+			sub = resp.Node.Nodes[2]
+			if len(sub.Nodes) != 2 || sub.Nodes[0].Value != "sub/c" {
+				t.Errorf("expected recursive listing of sub directory, got: %+v", sub)
+			}
+
+			_, err = s.Delete(ctx, dir+"/sub", nil)
+			if e, ok := err.(client.Error); !ok || e.Code != client.ErrorCodeNotFile {
+				t.Errorf("expected not a file error, got: %v", err)
+			}
+			_, err = s.Delete(ctx, dir+"/sub", &client.DeleteOptions{Recursive: true, Dir: true})
+			if err != nil {
+				t.Fatalf("failed to delete dir: %s", err)
+			}
+			_, err = s.Get(ctx, dir+"/sub/c", nil)
+			if !client.IsKeyNotFound(err) {
+				t.Errorf("expected key not found after deleting dir, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	stores, teardown := getStores(t)
+	defer teardown()
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			dir := "/" + testutil.GetTestPrefix()
+
+			resp, err := s.Set(context.Background(), dir+"/zero", "0", nil)
+			if err != nil {
+				t.Fatalf("failed to set: %s", err)
+			}
+			_, err = s.Set(context.Background(), dir+"/first", "1", nil)
+			if err != nil {
+				t.Fatalf("failed to set: %s", err)
+			}
+			_, err = s.Set(context.Background(), dir+"-sibling", "x", nil)
+			if err != nil {
+				t.Fatalf("failed to set: %s", err)
+			}
+			_, err = s.Set(context.Background(), dir+"/second", "2", nil)
+			if err != nil {
+				t.Fatalf("failed to set: %s", err)
+			}
+
+			// resuming from an index replays what happened since, and
+			// doesn't include keys that merely share the prefix
+			watcher := s.Watcher(dir, &client.WatcherOptions{AfterIndex: resp.Index, Recursive: true})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			for _, expected := range []string{"1", "2"} {
+				event, err := watcher.Next(ctx)
+				if err != nil {
+					t.Fatalf("failed to watch: %s", err)
+				}
+				if event.Node.Value != expected {
+					t.Errorf("expected value '%s', got: %+v", expected, event.Node)
+				}
+			}
+
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				s.Delete(context.Background(), dir+"/first", nil)
+			}()
+			event, err := watcher.Next(ctx)
+			if err != nil {
+				t.Fatalf("failed to watch: %s", err)
+			}
+			if event.Action != "delete" || event.Node.Key != dir+"/first" || event.PrevNode.Value != "1" {
+				t.Errorf("expected delete of first, got: %+v", event)
+			}
+		})
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	stores, teardown := getStores(t)
+	defer teardown()
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			key := "/" + testutil.GetTestPrefix() + "/foo"
+
+			_, err := s.Set(ctx, key, "bar", &client.SetOptions{TTL: time.Second})
+			if err != nil {
+				t.Fatalf("failed to set: %s", err)
+			}
+			resp, err := s.Get(ctx, key, nil)
+			if err != nil {
+				t.Fatalf("failed to get: %s", err)
+			}
+			if resp.Node.Value != "bar" {
+				t.Errorf("expected 'bar', got: '%s'", resp.Node.Value)
+			}
+
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				_, err = s.Get(ctx, key, nil)
+				if client.IsKeyNotFound(err) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("expected key to expire, got: %v", err)
+				}
+				time.Sleep(200 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestEtcdV3LeaseReuse(t *testing.T) {
+	v3, teardown, err := testutil.GetEtcdV3Client()
+	if err != nil {
+		t.Fatalf("failed to get etcd v3 client: %s", err)
+	}
+	defer teardown()
+	s := NewEtcdV3Store(v3)
+	ctx := context.Background()
+	key := "/" + testutil.GetTestPrefix() + "/foo"
+
+	set := func(ttl time.Duration) clientv3.LeaseID {
+		_, err := s.Set(ctx, key, "bar", &client.SetOptions{TTL: ttl})
+		if err != nil {
+			t.Fatalf("failed to set: %s", err)
+		}
+		resp, err := v3.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get: %s", err)
+		}
+		return clientv3.LeaseID(resp.Kvs[0].Lease)
+	}
+	leases := func() int {
+		resp, err := v3.Leases(ctx)
+		if err != nil {
+			t.Fatalf("failed to list leases: %s", err)
+		}
+		return len(resp.Leases)
+	}
+
+	// keeping a key alive refreshes its lease
+	first := set(time.Minute)
+	for i := 0; i < 3; i++ {
+		if lease := set(time.Minute); lease != first {
+			t.Errorf("expected lease %x to be refreshed, got %x", first, lease)
+		}
+	}
+	if leases() != 1 {
+		t.Errorf("expected one lease, got %d", leases())
+	}
+
+	// a different TTL gets a new one, and the old one's revoked
+	second := set(time.Second)
+	if second == first {
+		t.Errorf("expected a new lease for a new TTL")
+	}
+	if leases() != 1 {
+		t.Errorf("expected one lease, got %d", leases())
+	}
+
+	// as it is when the key's set without a TTL
+	_, err = s.Set(ctx, key, "bar", nil)
+	if err != nil {
+		t.Fatalf("failed to set: %s", err)
+	}
+	if leases() != 0 {
+		t.Errorf("expected no leases, got %d", leases())
+	}
+}
@@ -16,6 +16,7 @@ import (
 var (
 	RequestsSubjectTemplate = "dotmesh.events.requests.%s.%s"
 	ResponseSubjectTemplate = "dotmesh.events.responses.%s.%s"
+	ActivitySubjectTemplate = "dotmesh.events.activity.%s.%s"
 )
 
 var _ messaging.Messenger = (*NatsMessenger)(nil)
@@ -137,6 +138,8 @@ func (m *NatsMessenger) Subscribe(ctx context.Context, q *types.SubscribeQuery)
 		template = RequestsSubjectTemplate
 	case types.EventTypeResponse:
 		template = ResponseSubjectTemplate
+	case types.EventTypeActivity:
+		template = ActivitySubjectTemplate
 	default:
 		return nil, fmt.Errorf("unknown event type: %v", q.Type)
 	}
@@ -177,6 +180,8 @@ func getSubject(event *types.Event) (string, error) {
 		subject = fmt.Sprintf(RequestsSubjectTemplate, event.FilesystemID, event.ID)
 	case types.EventTypeResponse:
 		subject = fmt.Sprintf(ResponseSubjectTemplate, event.FilesystemID, event.ID)
+	case types.EventTypeActivity:
+		subject = fmt.Sprintf(ActivitySubjectTemplate, event.FilesystemID, event.ID)
 	default:
 		return "", fmt.Errorf("unknown event type: %d", event.Type)
 	}
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -11,6 +13,15 @@ func init() {
 		TransitionCounter,
 		RPCRequestDuration,
 		ZPoolCapacity,
+		TransferBytes,
+		TransferCounter,
+		TransferDuration,
+		FilesystemState,
+		DirtyBytes,
+		CommitCounter,
+		S3Bytes,
+		EtcdWatchLag,
+		CertificateExpiry,
 	)
 }
 
@@ -45,4 +56,158 @@ var (
 		Name: "dm_zpool_usage_percentage",
 		Help: "Percentage of zpool capacity used.",
 	}, []string{"node_name", "pool_name"})
+
+	// Transfers are counted on the node that initiated them; bytes are
+	// sent for pushes and received for pulls.
+	TransferBytes *prometheus.CounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dm_transfer_bytes_total",
+			Help: "Bytes replicated by transfers this node initiated, partitioned by direction (push or pull).",
+		},
+		[]string{"direction"},
+	)
+
+	TransferCounter *prometheus.CounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dm_transfer_total",
+			Help: "How many transfers this node initiated, partitioned by direction (push or pull) and outcome (finished or error).",
+		},
+		[]string{"direction", "status"},
+	)
+
+	TransferDuration *prometheus.SummaryVec = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name: "dm_transfer_duration_seconds",
+		Help: "Duration of transfers this node initiated by direction (push or pull) and outcome (finished or error).",
+	}, []string{"direction", "status"})
+
+	// Filesystem metrics are aggregated over the node, so there's a bounded
+	// number of series however many dots there are; see EnablePerDot for
+	// per-filesystem series.
+	FilesystemState *prometheus.GaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dm_filesystems",
+		Help: "How many filesystems' state machines on this node are in each state.",
+	}, []string{"state"})
+
+	DirtyBytes prometheus.Gauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dm_dirty_bytes",
+		Help: "Uncommitted bytes in all the filesystems mounted on this node.",
+	})
+
+	CommitCounter prometheus.Counter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dm_commits_total",
+		Help: "How many commits this node made.",
+	})
+
+	DotFilesystemState *prometheus.GaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dm_filesystem_state",
+		Help: "Current state of each filesystem's state machine on this node.",
+	}, []string{"filesystem_id", "state"})
+
+	DotDirtyBytes *prometheus.GaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dm_filesystem_dirty_bytes",
+		Help: "Uncommitted bytes in each filesystem mounted on this node.",
+	}, []string{"filesystem_id"})
+
+	DotCommitCounter *prometheus.CounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dm_filesystem_commits_total",
+			Help: "How many commits this node made, partitioned by filesystem.",
+		},
+		[]string{"filesystem_id"},
+	)
+
+	S3Bytes *prometheus.CounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dm_s3_bytes_total",
+			Help: "Bytes through the S3 gateway, partitioned by operation and direction (in or out).",
+		},
+		[]string{"operation", "direction"},
+	)
+
+	EtcdWatchLag prometheus.Gauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dm_etcd_watch_lag_indexes",
+		Help: "How many etcd indexes behind the cluster the last change seen by this node's watch was.",
+	})
+
+	CertificateExpiry *prometheus.GaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dm_certificate_expiry_timestamp_seconds",
+		Help: "When each certificate this node talks to etcd with expires, by file and subject, in seconds since the epoch.",
+	}, []string{"file", "subject"})
+)
+
+var (
+	perDot bool
+
+	// the state and last dirty byte count reported for each filesystem, so
+	// FilesystemState and DirtyBytes can be kept as their totals
+	filesystemStates = map[string]string{}
+	dirtyBytes       = map[string]int64{}
+	filesystemsLock  sync.Mutex
 )
+
+// EnablePerDot registers series labelled by filesystem id as well as the
+// per-node aggregates. There's a series per dot on the node, so it's opt-in
+// for clusters with few enough dots for that to be affordable.
+func EnablePerDot() {
+	perDot = true
+	prometheus.MustRegister(
+		DotFilesystemState,
+		DotDirtyBytes,
+		DotCommitCounter,
+	)
+}
+
+// FilesystemTransitioned records a filesystem's state machine moving to a new
+// state. Filesystems that have gone are forgotten.
+func FilesystemTransitioned(filesystemId, state string) {
+	filesystemsLock.Lock()
+	defer filesystemsLock.Unlock()
+
+	previous, counted := filesystemStates[filesystemId]
+	if counted {
+		FilesystemState.WithLabelValues(previous).Dec()
+		if perDot {
+			DotFilesystemState.DeleteLabelValues(filesystemId, previous)
+		}
+	}
+	if state == "gone" {
+		DirtyBytes.Sub(float64(dirtyBytes[filesystemId]))
+		delete(filesystemStates, filesystemId)
+		delete(dirtyBytes, filesystemId)
+		if perDot {
+			// don't leave series behind for filesystems that have gone
+			DotDirtyBytes.DeleteLabelValues(filesystemId)
+			DotCommitCounter.DeleteLabelValues(filesystemId)
+		}
+		return
+	}
+	filesystemStates[filesystemId] = state
+	FilesystemState.WithLabelValues(state).Inc()
+	if perDot {
+		DotFilesystemState.WithLabelValues(filesystemId, state).Set(1)
+	}
+}
+
+// SetDirtyBytes records how many uncommitted bytes a filesystem has.
+func SetDirtyBytes(filesystemId string, bytes int64) {
+	filesystemsLock.Lock()
+	defer filesystemsLock.Unlock()
+
+	if _, ok := filesystemStates[filesystemId]; !ok {
+		// it's gone, or hasn't started yet
+		return
+	}
+	DirtyBytes.Add(float64(bytes - dirtyBytes[filesystemId]))
+	dirtyBytes[filesystemId] = bytes
+	if perDot {
+		DotDirtyBytes.WithLabelValues(filesystemId).Set(float64(bytes))
+	}
+}
+
+// Committed counts a commit made on this node.
+func Committed(filesystemId string) {
+	CommitCounter.Inc()
+	if perDot {
+		DotCommitCounter.WithLabelValues(filesystemId).Inc()
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	err := g.Write(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestFilesystemsAggregatePerNode(t *testing.T) {
+	FilesystemTransitioned("fs1", "discovering")
+	FilesystemTransitioned("fs2", "discovering")
+	FilesystemTransitioned("fs1", "active")
+	FilesystemTransitioned("fs2", "active")
+	FilesystemTransitioned("fs2", "pushInitiatorState")
+	SetDirtyBytes("fs1", 100)
+	SetDirtyBytes("fs2", 50)
+	SetDirtyBytes("fs1", 30)
+
+	tests := []struct {
+		state string
+		want  float64
+	}{
+		{"discovering", 0},
+		{"active", 1},
+		{"pushInitiatorState", 1},
+	}
+	for _, tt := range tests {
+		if got := gaugeValue(t, FilesystemState.WithLabelValues(tt.state)); got != tt.want {
+			t.Errorf("expected %v filesystems %s, got %v", tt.want, tt.state, got)
+		}
+	}
+	if got := gaugeValue(t, DirtyBytes); got != 80 {
+		t.Errorf("expected 80 dirty bytes, got %v", got)
+	}
+
+	// a filesystem that's gone isn't counted, and nor are its dirty bytes,
+	// even if they're reported after it went
+	FilesystemTransitioned("fs1", "gone")
+	SetDirtyBytes("fs1", 10)
+	if got := gaugeValue(t, FilesystemState.WithLabelValues("active")); got != 0 {
+		t.Errorf("expected no active filesystems, got %v", got)
+	}
+	if got := gaugeValue(t, DirtyBytes); got != 50 {
+		t.Errorf("expected 50 dirty bytes, got %v", got)
+	}
+}
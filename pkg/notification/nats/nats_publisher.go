@@ -28,6 +28,7 @@ type publisher struct {
 	client        *nats.Conn
 	encodedClient *nats.EncodedConn
 	subject       string
+	eventsSubject string
 	initialized   bool
 }
 
@@ -88,8 +89,10 @@ func (p *publisher) Configure(c *notification.Config) (bool, error) {
 
 	if nastConfig.prefix != "" {
 		p.subject = nastConfig.prefix + "." + types.NATSPublishCommitsSubject
+		p.eventsSubject = nastConfig.prefix + "." + types.NATSPublishEventsSubject
 	} else {
 		p.subject = types.NATSPublishCommitsSubject
+		p.eventsSubject = types.NATSPublishEventsSubject
 	}
 
 	p.initialized = true
@@ -135,3 +138,11 @@ func (p *publisher) PublishCommit(event *types.CommitNotification) error {
 	}
 	return nil
 }
+
+// PublishEvent - publish event to NATS
+func (p *publisher) PublishEvent(event *types.EventNotification) error {
+	if p.initialized {
+		return p.encodedClient.Publish(p.eventsSubject, event)
+	}
+	return nil
+}
@@ -95,3 +95,52 @@ func TestPublishCommit(t *testing.T) {
 		return
 	}
 }
+
+func TestPublishEvent(t *testing.T) {
+	os.Setenv(EnvNatsURL, fmt.Sprintf("nats://127.0.0.1:%d", defaultNatsTestOptions.Port))
+
+	p := &publisher{}
+
+	configured, err := p.Configure(&notification.Config{})
+	if err != nil {
+		t.Fatalf("failed to configure: %s", err)
+	}
+
+	defer p.client.Close()
+
+	if !configured {
+		t.Fatalf("expected addon to be configured")
+	}
+
+	foundCh := make(chan bool, 1)
+
+	sub, err := p.encodedClient.Subscribe(p.eventsSubject, func(notification *types.EventNotification) {
+		if notification.Type == types.EventTypeBranchCreated && notification.Branch == "feature" {
+			foundCh <- true
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to create subscription for incoming tasks: %s", err)
+	}
+	defer sub.Unsubscribe()
+
+	err = p.PublishEvent(&types.EventNotification{
+		Type:   types.EventTypeBranchCreated,
+		Branch: "feature",
+	})
+
+	if err != nil {
+		t.Errorf("failed to publish event: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		t.Errorf("didn't get the event notification, deadline exceeded")
+	case <-foundCh:
+		// ok
+		return
+	}
+}
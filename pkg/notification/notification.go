@@ -40,6 +40,10 @@ type Publisher interface {
 
 	// PublishCommit informs the existence of the specified notification.
 	PublishCommit(event *types.CommitNotification) error
+
+	// PublishEvent informs about other changes to dots, and commits again
+	// for publishers that prefer a single kind of notification.
+	PublishEvent(event *types.EventNotification) error
 }
 
 // RegisterPublisher makes a Sender available by the provided name.
@@ -114,55 +118,72 @@ func (p *DefaultNotificationPublisher) Publishers() map[string]Publisher {
 	return ret
 }
 
-// Send - send notifications through all configured publishers
+// PublishCommit - send commit notifications through all configured publishers
 func (p *DefaultNotificationPublisher) PublishCommit(event *types.CommitNotification) error {
+	return p.publish(event.Name, func(publisher Publisher) error {
+		return publisher.PublishCommit(event)
+	})
+}
 
-	publishersM.RLock()
-	defer publishersM.RUnlock()
+// PublishEvent - send event notifications through all configured publishers
+func (p *DefaultNotificationPublisher) PublishEvent(event *types.EventNotification) error {
+	return p.publish(event.Name, func(publisher Publisher) error {
+		return publisher.PublishEvent(event)
+	})
+}
 
+func (p *DefaultNotificationPublisher) publish(name string, send func(Publisher) error) error {
 	for publisherName, publisher := range p.Publishers() {
 		// TODO: move this into goroutine if we have enough publishers
-		var attempts int
-		var backOff time.Duration
-		for {
-			// Max attempts exceeded.
-			if attempts >= p.config.Attempts {
-				log.WithFields(log.Fields{
-					logNotiName:      event.Name,
-					logPublisherName: publisherName,
-					"max attempts":   p.config.Attempts,
-				}).Info("giving up on publishing notification : max attempts exceeded")
-				return fmt.Errorf("failed to publish notification, max attempts (%d) reached", p.config.Attempts)
-			}
+		err := Retry(p.stopper, p.config.Attempts, log.Fields{
+			logNotiName:      name,
+			logPublisherName: publisherName,
+		}, func() error {
+			return send(publisher)
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-			// Backoff
-			if backOff > 0 {
-				log.WithFields(log.Fields{
-					"duration":       backOff,
-					logNotiName:      event.Name,
-					logPublisherName: publisherName,
-					"attempts":       attempts + 1,
-					"max attempts":   p.config.Attempts,
-				}).Info("waiting before retrying to publish notification")
-				if !p.stopper.Sleep(backOff) {
-					return nil
-				}
-			}
+	return nil
+}
+
+// Retry - calls send until it succeeds, backing off exponentially (up to
+// notifierMaxBackOff) between attempts. Gives up with an error after the
+// given number of attempts, or quietly if the stopper is stopped.
+func Retry(s *stopper.Stopper, maxAttempts int, fields log.Fields, send func() error) error {
+	var attempts int
+	var backOff time.Duration
+	for {
+		// Max attempts exceeded.
+		if attempts >= maxAttempts {
+			log.WithFields(fields).WithField("max attempts", maxAttempts).Info("giving up on publishing notification : max attempts exceeded")
+			return fmt.Errorf("failed to publish notification, max attempts (%d) reached", maxAttempts)
+		}
 
-			if err := publisher.PublishCommit(event); err != nil {
-				// Send failed; increase attempts/backoff and retry.
-				log.WithError(err).WithFields(log.Fields{logPublisherName: publisherName, logNotiName: event.Name}).Error("could not publish notification via notifier")
-				backOff = timeutil.ExpBackoff(backOff, notifierMaxBackOff)
-				attempts++
-				continue
+		// Backoff
+		if backOff > 0 {
+			log.WithFields(fields).WithFields(log.Fields{
+				"duration":     backOff,
+				"attempts":     attempts + 1,
+				"max attempts": maxAttempts,
+			}).Info("waiting before retrying to publish notification")
+			if !s.Sleep(backOff) {
+				return nil
 			}
+		}
 
-			// Publish has been successful. Go to the next notifier.
-			break
+		if err := send(); err != nil {
+			// Send failed; increase attempts/backoff and retry.
+			log.WithError(err).WithFields(fields).Error("could not publish notification via notifier")
+			backOff = timeutil.ExpBackoff(backOff, notifierMaxBackOff)
+			attempts++
+			continue
 		}
-	}
 
-	return nil
+		return nil
+	}
 }
 
 // UnregisterPublisher removes a publisher with a particular name from the list.
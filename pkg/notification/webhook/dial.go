@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// blockedNetworks - addresses webhooks can't be delivered to unless an
+// administrator allows them, so that users who can create webhooks can't use
+// the server to reach things only it can: itself, the cluster's private
+// network and cloud metadata services
+var blockedNetworks = mustParseNetworks(
+	"0.0.0.0/8",      // "this" network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, including cloud metadata services
+	"172.16.0.0/12",  // private
+	"192.168.0.0/16", // private
+	"224.0.0.0/4",    // multicast
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	networks, err := ParseNetworks(strings.Join(cidrs, ","))
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// ParseNetworks - parses a comma separated list of CIDRs or single IP
+// addresses, as given in WEBHOOK_ALLOWED_NETWORKS
+func ParseNetworks(list string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid network %q, expected a CIDR or an IP address", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network %q: %s", s, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddress - whether a webhook may be delivered to ip
+func checkAddress(ip net.IP, allowed []*net.IPNet) error {
+	if containsIP(blockedNetworks, ip) && !containsIP(allowed, ip) {
+		return fmt.Errorf(
+			"webhook address %s is loopback, link-local or private, "+
+				"add it to WEBHOOK_ALLOWED_NETWORKS to allow it", ip,
+		)
+	}
+	return nil
+}
+
+// NewTransport - an HTTP transport that refuses to connect to blocked
+// addresses, for webhooks and anything else that calls URLs users give it.
+// The check is made on the address actually being dialed, after the host
+// name has been resolved and for every redirect, so it can't be got round
+// with DNS or redirects.
+func NewTransport(allowed []*net.IPNet) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   requestTimeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("webhook address %q isn't an IP address", host)
+			}
+			return checkAddress(ip, allowed)
+		},
+	}
+	return &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: requestTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
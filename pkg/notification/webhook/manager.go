@@ -0,0 +1,158 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/coreos/etcd/client"
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// WebhooksPrefix - KV store prefix for webhooks, keyed by webhook ID
+const WebhooksPrefix = "webhooks"
+
+type Manager interface {
+	// List - returns every webhook, use Matching to find the ones that want
+	// a particular event
+	List() ([]*types.Webhook, error)
+	Get(id string) (*types.Webhook, error)
+	// Create - validates and stores a new webhook, assigning it an ID and,
+	// if it doesn't have one, a random secret
+	Create(hook *types.Webhook) (*types.Webhook, error)
+	Update(hook *types.Webhook) error
+	Delete(id string) error
+}
+
+type DefaultManager struct {
+	kv kv.KV
+}
+
+func New(kv kv.KV) *DefaultManager {
+	return &DefaultManager{
+		kv: kv,
+	}
+}
+
+func (m *DefaultManager) List() ([]*types.Webhook, error) {
+	nodes, err := m.kv.List(WebhooksPrefix)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return []*types.Webhook{}, nil
+		}
+		return nil, err
+	}
+	hooks := make([]*types.Webhook, 0, len(nodes))
+	for _, node := range nodes {
+		var hook types.Webhook
+		err = json.Unmarshal([]byte(node.Value), &hook)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, &hook)
+	}
+	return hooks, nil
+}
+
+func (m *DefaultManager) Get(id string) (*types.Webhook, error) {
+	node, err := m.kv.Get(WebhooksPrefix, id)
+	if err != nil {
+		return nil, err
+	}
+	var hook types.Webhook
+	err = json.Unmarshal([]byte(node.Value), &hook)
+	if err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+func (m *DefaultManager) Create(hook *types.Webhook) (*types.Webhook, error) {
+	err := Validate(hook)
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	hook.Id = id.String()
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+	return hook, m.Update(hook)
+}
+
+func (m *DefaultManager) Update(hook *types.Webhook) error {
+	if hook.Id == "" {
+		return fmt.Errorf("Webhook ID not set")
+	}
+	bts, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+	_, err = m.kv.Set(WebhooksPrefix, hook.Id, string(bts))
+	return err
+}
+
+func (m *DefaultManager) Delete(id string) error {
+	return m.kv.Delete(WebhooksPrefix, id, false)
+}
+
+// Validate - checks that a webhook has somewhere to send to and only asks for
+// events that exist
+func Validate(hook *types.Webhook) error {
+	if hook.Namespace == "" {
+		return fmt.Errorf("Webhook namespace not set")
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil {
+		return fmt.Errorf("Invalid webhook URL %q: %s", hook.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid webhook URL %q, expected an http or https URL", hook.URL)
+	}
+	for _, e := range hook.Events {
+		if !contains(types.EventTypes, e) {
+			return fmt.Errorf("Unknown webhook event %q, expected one of %v", e, types.EventTypes)
+		}
+	}
+	return nil
+}
+
+// Matching - filters hooks down to the enabled ones that want the event
+func Matching(hooks []*types.Webhook, event *types.EventNotification) []*types.Webhook {
+	matching := []*types.Webhook{}
+	for _, hook := range hooks {
+		if hook.Disabled || hook.Namespace != event.Namespace {
+			continue
+		}
+		if hook.Name != "" && hook.Name != event.Name {
+			continue
+		}
+		if len(hook.Events) > 0 && !contains(hook.Events, event.Type) {
+			continue
+		}
+		matching = append(matching, hook)
+	}
+	return matching
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+
+	"github.com/dotmesh-io/dotmesh/pkg/notification"
+	"github.com/dotmesh-io/dotmesh/pkg/stopper"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SignatureHeader - hex encoded HMAC-SHA256 of the request body, keyed
+	// with the webhook's secret and prefixed with "sha256="
+	SignatureHeader = "X-Dotmesh-Signature"
+	// EventHeader - the event type, also found in the body
+	EventHeader = "X-Dotmesh-Event"
+	// DeliveryHeader - unique for each event sent to each webhook, retries
+	// of a delivery reuse it
+	DeliveryHeader = "X-Dotmesh-Delivery"
+
+	// MaxFailures - webhooks are disabled after this many deliveries in a
+	// row have failed, each after exhausting its retries
+	MaxFailures = 5
+
+	requestTimeout = 10 * time.Second
+)
+
+// Publisher - notification.Publisher that POSTs events as JSON to the
+// webhooks configured for their namespace or dot. Each webhook is delivered
+// to in its own goroutine, so a slow or broken endpoint doesn't hold up
+// anything else.
+type Publisher struct {
+	manager  Manager
+	client   *http.Client
+	stopper  *stopper.Stopper
+	attempts int
+
+	// recording a delivery's result reads and then updates its webhook, so
+	// deliveries to the same webhook take turns at it
+	resultLocks     map[string]*sync.Mutex
+	resultLocksLock *sync.Mutex
+}
+
+// NewPublisher - allowedNetworks are the loopback, link-local and private
+// addresses webhooks may be delivered to, which they otherwise can't be
+func NewPublisher(manager Manager, allowedNetworks []*net.IPNet) *Publisher {
+	return &Publisher{
+		manager: manager,
+		client: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: NewTransport(allowedNetworks),
+		},
+		stopper:         stopper.NewStopper(context.Background()),
+		attempts:        5,
+		resultLocks:     map[string]*sync.Mutex{},
+		resultLocksLock: &sync.Mutex{},
+	}
+}
+
+// Configure - webhooks are configured through the API rather than at
+// startup, so this only picks up how many attempts to make
+func (p *Publisher) Configure(c *notification.Config) (bool, error) {
+	if c.Attempts > 0 {
+		p.attempts = c.Attempts
+	}
+	return true, nil
+}
+
+// PublishCommit - commits are also published as events of type "commit",
+// which is what gets delivered, so there's nothing to do here
+func (p *Publisher) PublishCommit(event *types.CommitNotification) error {
+	return nil
+}
+
+// PublishEvent - starts delivering the event to each matching webhook and
+// returns without waiting for them, failures are retried and counted against
+// the webhook rather than reported to the caller
+func (p *Publisher) PublishEvent(event *types.EventNotification) error {
+	hooks, err := p.manager.List()
+	if err != nil {
+		return err
+	}
+	hooks = Matching(hooks, event)
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		go p.deliver(hook, event.Type, body)
+	}
+	return nil
+}
+
+func (p *Publisher) deliver(hook *types.Webhook, eventType string, body []byte) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.WithError(err).Error("webhook: failed to generate delivery ID")
+		return
+	}
+	deliveryId := id.String()
+
+	err = notification.Retry(p.stopper, p.attempts, log.Fields{
+		"webhook_id": hook.Id,
+		"event":      eventType,
+		"delivery":   deliveryId,
+	}, func() error {
+		return p.send(hook, eventType, deliveryId, body)
+	})
+	p.recordResult(hook.Id, err)
+}
+
+func (p *Publisher) send(hook *types.Webhook, eventType, deliveryId string, body []byte) error {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	req.Header.Set(DeliveryHeader, deliveryId)
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with status %d", hook.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// recordResult - keeps count of consecutive failed deliveries, disabling the
+// webhook once there have been too many. The webhook is re-read as it may
+// have been changed or deleted while we were retrying.
+func (p *Publisher) recordResult(hookId string, deliveryErr error) {
+	lock := p.resultLock(hookId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	hook, err := p.manager.Get(hookId)
+	if err != nil {
+		return
+	}
+	if deliveryErr == nil {
+		if hook.Failures == 0 {
+			return
+		}
+		hook.Failures = 0
+	} else {
+		hook.Failures++
+		if hook.Failures >= MaxFailures {
+			hook.Disabled = true
+			log.WithFields(log.Fields{
+				"webhook_id": hook.Id,
+				"url":        hook.URL,
+				"failures":   hook.Failures,
+			}).Warn("webhook: disabling after repeated delivery failures")
+		}
+	}
+	err = p.manager.Update(hook)
+	if err != nil {
+		log.WithError(err).WithField("webhook_id", hookId).Error("webhook: failed to record delivery result")
+	}
+}
+
+func (p *Publisher) resultLock(hookId string) *sync.Mutex {
+	p.resultLocksLock.Lock()
+	defer p.resultLocksLock.Unlock()
+	lock, ok := p.resultLocks[hookId]
+	if !ok {
+		lock = &sync.Mutex{}
+		p.resultLocks[hookId] = lock
+	}
+	return lock
+}
+
+// Sign - the value of the signature header for a body, receivers should
+// compute the same and compare it using a constant time comparison
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,254 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/notification"
+	"github.com/dotmesh-io/dotmesh/pkg/testutil"
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func newTestManager(t *testing.T) (*DefaultManager, func()) {
+	client, teardown, err := testutil.GetEtcdClient()
+	if err != nil {
+		t.Fatalf("failed to get etcd client: %s", err)
+	}
+	return New(kv.New(client, testutil.GetTestPrefix())), teardown
+}
+
+func TestValidate(t *testing.T) {
+	valid := &types.Webhook{Namespace: "admin", URL: "https://ci.example.com/hook", Events: []string{types.EventTypeCommit}}
+	if err := Validate(valid); err != nil {
+		t.Errorf("expected webhook to be valid, got: %s", err)
+	}
+
+	for _, hook := range []*types.Webhook{
+		{URL: "https://ci.example.com/hook"},
+		{Namespace: "admin", URL: "ftp://ci.example.com/hook"},
+		{Namespace: "admin", URL: "https://ci.example.com/hook", Events: []string{"bogus"}},
+	} {
+		if err := Validate(hook); err == nil {
+			t.Errorf("expected webhook %+v to be invalid", hook)
+		}
+	}
+}
+
+func TestMatching(t *testing.T) {
+	hooks := []*types.Webhook{
+		{Id: "namespace", Namespace: "admin"},
+		{Id: "dot", Namespace: "admin", Name: "db"},
+		{Id: "other-dot", Namespace: "admin", Name: "web"},
+		{Id: "other-namespace", Namespace: "bob"},
+		{Id: "branches-only", Namespace: "admin", Events: []string{types.EventTypeBranchCreated}},
+		{Id: "disabled", Namespace: "admin", Disabled: true},
+	}
+
+	matching := Matching(hooks, &types.EventNotification{Type: types.EventTypeCommit, Namespace: "admin", Name: "db"})
+	if len(matching) != 2 || matching[0].Id != "namespace" || matching[1].Id != "dot" {
+		t.Errorf("expected namespace and dot webhooks to match, got: %+v", matching)
+	}
+}
+
+// the test servers listen on loopback, which has to be allowed
+var loopback = mustParseNetworks("127.0.0.1")
+
+func TestBlockedAddresses(t *testing.T) {
+	allowed := mustParseNetworks("10.1.2.0/24", "fd00::1")
+	for _, tc := range []struct {
+		ip      string
+		blocked bool
+	}{
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"169.254.169.254", true},
+		{"10.0.0.1", true},
+		{"172.20.0.1", true},
+		{"192.168.1.1", true},
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"0.0.0.0", true},
+		// allowed by the administrator
+		{"10.1.2.3", false},
+		{"fd00::1", false},
+		{"fd00::2", true},
+	} {
+		err := checkAddress(net.ParseIP(tc.ip), allowed)
+		if (err != nil) != tc.blocked {
+			t.Errorf("%s: expected blocked=%v, got: %v", tc.ip, tc.blocked, err)
+		}
+	}
+}
+
+func TestParseNetworksInvalid(t *testing.T) {
+	for _, s := range []string{"nonsense", "10.0.0.0/33", "10.0.0.1,bad"} {
+		_, err := ParseNetworks(s)
+		if err == nil {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
+
+func TestPublishToBlockedAddress(t *testing.T) {
+	m, teardown := newTestManager(t)
+	defer teardown()
+
+	called := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- true
+	}))
+	defer srv.Close()
+
+	hook, err := m.Create(&types.Webhook{Namespace: "admin", URL: srv.URL})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %s", err)
+	}
+
+	// loopback isn't allowed
+	p := NewPublisher(m, nil)
+	p.Configure(&notification.Config{Attempts: 1})
+	p.deliver(hook, types.EventTypeCommit, []byte("{}"))
+
+	select {
+	case <-called:
+		t.Errorf("webhook on loopback was called")
+	default:
+	}
+	stored, err := m.Get(hook.Id)
+	if err != nil {
+		t.Fatalf("failed to get webhook: %s", err)
+	}
+	if stored.Failures != 1 {
+		t.Errorf("expected the delivery to fail, got: %+v", stored)
+	}
+}
+
+func TestPublishSigned(t *testing.T) {
+	m, teardown := newTestManager(t)
+	defer teardown()
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	_, err := m.Create(&types.Webhook{Namespace: "admin", URL: srv.URL, Secret: "s3cret"})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %s", err)
+	}
+
+	p := NewPublisher(m, loopback)
+	err = p.PublishEvent(&types.EventNotification{Type: types.EventTypeCommit, Namespace: "admin", Name: "db", Branch: "master", CommitId: "1234"})
+	if err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		if r.Header.Get(EventHeader) != types.EventTypeCommit {
+			t.Errorf("expected commit event header, got: %s", r.Header.Get(EventHeader))
+		}
+		if r.Header.Get(SignatureHeader) != Sign("s3cret", body) {
+			t.Errorf("signature %s doesn't match body", r.Header.Get(SignatureHeader))
+		}
+		var event types.EventNotification
+		err = json.Unmarshal(body, &event)
+		if err != nil {
+			t.Fatalf("failed to decode body: %s", err)
+		}
+		if event.CommitId != "1234" {
+			t.Errorf("expected commit 1234, got: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook wasn't called")
+	}
+}
+
+func TestDisabledAfterRepeatedFailures(t *testing.T) {
+	m, teardown := newTestManager(t)
+	defer teardown()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	hook, err := m.Create(&types.Webhook{Namespace: "admin", URL: srv.URL})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %s", err)
+	}
+
+	p := NewPublisher(m, loopback)
+	p.Configure(&notification.Config{Attempts: 1})
+
+	event := &types.EventNotification{Type: types.EventTypeDotDeleted, Namespace: "admin", Name: "db"}
+	for i := 0; i < MaxFailures; i++ {
+		// deliver synchronously so failures are counted one at a time
+		hooks, err := m.List()
+		if err != nil {
+			t.Fatalf("failed to list webhooks: %s", err)
+		}
+		for _, h := range Matching(hooks, event) {
+			p.deliver(h, event.Type, []byte("{}"))
+		}
+	}
+
+	stored, err := m.Get(hook.Id)
+	if err != nil {
+		t.Fatalf("failed to get webhook: %s", err)
+	}
+	if !stored.Disabled || stored.Failures != MaxFailures {
+		t.Errorf("expected webhook to be disabled after %d failures, got: %+v", MaxFailures, stored)
+	}
+}
+
+func TestConcurrentFailuresAllCounted(t *testing.T) {
+	m, teardown := newTestManager(t)
+	defer teardown()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	hook, err := m.Create(&types.Webhook{Namespace: "admin", URL: srv.URL})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %s", err)
+	}
+
+	p := NewPublisher(m, loopback)
+	p.Configure(&notification.Config{Attempts: 1})
+
+	// as when several events are published at once
+	var wg sync.WaitGroup
+	for i := 0; i < MaxFailures-1; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.deliver(hook, types.EventTypeDotDeleted, []byte("{}"))
+		}()
+	}
+	wg.Wait()
+
+	stored, err := m.Get(hook.Id)
+	if err != nil {
+		t.Fatalf("failed to get webhook: %s", err)
+	}
+	if stored.Failures != MaxFailures-1 || stored.Disabled {
+		t.Errorf("expected %d failures to be counted, got: %+v", MaxFailures-1, stored)
+	}
+}
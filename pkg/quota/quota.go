@@ -0,0 +1,149 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd/client"
+
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+)
+
+// QuotasPrefix - KV store prefix for quotas
+const QuotasPrefix = "quotas"
+
+// FilesystemQuotasPrefix - KV store prefix for per-dot quotas, keyed by
+// the top level filesystem ID
+const FilesystemQuotasPrefix = QuotasPrefix + "/filesystems"
+
+// NamespaceQuotasPrefix - KV store prefix for per-namespace quotas, keyed
+// by namespace name
+const NamespaceQuotasPrefix = QuotasPrefix + "/namespaces"
+
+// Quota - storage limit, a zero LimitBytes means unlimited
+type Quota struct {
+	LimitBytes int64
+}
+
+// Unlimited - returns true if quota doesn't limit usage
+func (q *Quota) Unlimited() bool {
+	return q == nil || q.LimitBytes <= 0
+}
+
+// Exceeded - returns true if adding additionalBytes to usedBytes would
+// take usage over the limit
+func (q *Quota) Exceeded(usedBytes, additionalBytes int64) bool {
+	if q.Unlimited() {
+		return false
+	}
+	return usedBytes+additionalBytes > q.LimitBytes
+}
+
+// Usage - quota together with the usage it's measured against
+type Usage struct {
+	LimitBytes int64
+	UsedBytes  int64
+}
+
+// ExceededError - returned when an operation would take a dot or a namespace
+// over its quota
+type ExceededError struct {
+	// Kind is either "dot" or "namespace"
+	Kind       string
+	Name       string
+	LimitBytes int64
+	UsedBytes  int64
+}
+
+func (e ExceededError) Error() string {
+	return fmt.Sprintf(
+		"Quota exceeded for %s %s: %d bytes used of %d bytes allowed.",
+		e.Kind, e.Name, e.UsedBytes, e.LimitBytes,
+	)
+}
+
+type Manager interface {
+	GetFilesystemQuota(filesystemId string) (*Quota, error)
+	// SetFilesystemQuota - sets quota for a dot, unlimited quota removes it
+	SetFilesystemQuota(filesystemId string, quota *Quota) error
+
+	GetNamespaceQuota(namespace string) (*Quota, error)
+	// SetNamespaceQuota - sets quota for a namespace, unlimited quota removes it
+	SetNamespaceQuota(namespace string, quota *Quota) error
+}
+
+type DefaultManager struct {
+	kv kv.KV
+}
+
+func New(kv kv.KV) *DefaultManager {
+	return &DefaultManager{
+		kv: kv,
+	}
+}
+
+func (m *DefaultManager) GetFilesystemQuota(filesystemId string) (*Quota, error) {
+	node, err := m.kv.Get(FilesystemQuotasPrefix, filesystemId)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return &Quota{}, nil
+		}
+		return nil, err
+	}
+	return decode(node.Value)
+}
+
+func (m *DefaultManager) SetFilesystemQuota(filesystemId string, quota *Quota) error {
+	return m.set(FilesystemQuotasPrefix, filesystemId, quota)
+}
+
+// GetNamespaceQuota - namespaces aren't UUIDs, so rather than going through
+// the name index we list the (small) set of namespace quotas
+func (m *DefaultManager) GetNamespaceQuota(namespace string) (*Quota, error) {
+	nodes, err := m.kv.List(NamespaceQuotasPrefix)
+	if err != nil {
+		if client.IsKeyNotFound(err) {
+			return &Quota{}, nil
+		}
+		return nil, err
+	}
+	for _, node := range nodes {
+		if strings.HasSuffix(node.Key, "/"+namespace) {
+			return decode(node.Value)
+		}
+	}
+	return &Quota{}, nil
+}
+
+func (m *DefaultManager) SetNamespaceQuota(namespace string, quota *Quota) error {
+	if namespace == "" || strings.Contains(namespace, "/") {
+		return fmt.Errorf("Invalid namespace %q", namespace)
+	}
+	return m.set(NamespaceQuotasPrefix, namespace, quota)
+}
+
+func (m *DefaultManager) set(prefix, id string, quota *Quota) error {
+	if quota.Unlimited() {
+		err := m.kv.Delete(prefix, id, false)
+		if err != nil && !client.IsKeyNotFound(err) {
+			return err
+		}
+		return nil
+	}
+	bts, err := json.Marshal(quota)
+	if err != nil {
+		return err
+	}
+	_, err = m.kv.Set(prefix, id, string(bts))
+	return err
+}
+
+func decode(val string) (*Quota, error) {
+	var q Quota
+	err := json.Unmarshal([]byte(val), &q)
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
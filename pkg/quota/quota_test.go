@@ -0,0 +1,106 @@
+package quota
+
+import (
+	"testing"
+
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
+	"github.com/dotmesh-io/dotmesh/pkg/testutil"
+)
+
+func TestFilesystemQuota(t *testing.T) {
+	etcdClient, teardown, err := testutil.GetEtcdClient()
+	if err != nil {
+		t.Fatalf("failed to get etcd client: %s", err)
+	}
+	defer teardown()
+
+	m := New(kv.New(etcdClient, "quotatests"))
+	id := "d2a8c37c-0290-446a-8d2e-3c904cb8b0f8"
+
+	q, err := m.GetFilesystemQuota(id)
+	if err != nil {
+		t.Fatalf("failed to get quota: %s", err)
+	}
+	if !q.Unlimited() {
+		t.Errorf("expected no quota, got: %d", q.LimitBytes)
+	}
+
+	err = m.SetFilesystemQuota(id, &Quota{LimitBytes: 1024})
+	if err != nil {
+		t.Fatalf("failed to set quota: %s", err)
+	}
+
+	q, err = m.GetFilesystemQuota(id)
+	if err != nil {
+		t.Fatalf("failed to get quota: %s", err)
+	}
+	if q.LimitBytes != 1024 {
+		t.Errorf("unexpected limit: %d", q.LimitBytes)
+	}
+
+	err = m.SetFilesystemQuota(id, &Quota{})
+	if err != nil {
+		t.Fatalf("failed to remove quota: %s", err)
+	}
+
+	q, err = m.GetFilesystemQuota(id)
+	if err != nil {
+		t.Fatalf("failed to get quota: %s", err)
+	}
+	if !q.Unlimited() {
+		t.Errorf("expected quota to be removed, got: %d", q.LimitBytes)
+	}
+}
+
+func TestNamespaceQuota(t *testing.T) {
+	etcdClient, teardown, err := testutil.GetEtcdClient()
+	if err != nil {
+		t.Fatalf("failed to get etcd client: %s", err)
+	}
+	defer teardown()
+
+	m := New(kv.New(etcdClient, "quotatests"))
+
+	q, err := m.GetNamespaceQuota("alice")
+	if err != nil {
+		t.Fatalf("failed to get quota: %s", err)
+	}
+	if !q.Unlimited() {
+		t.Errorf("expected no quota, got: %d", q.LimitBytes)
+	}
+
+	err = m.SetNamespaceQuota("alice", &Quota{LimitBytes: 2048})
+	if err != nil {
+		t.Fatalf("failed to set quota: %s", err)
+	}
+	err = m.SetNamespaceQuota("bob", &Quota{LimitBytes: 4096})
+	if err != nil {
+		t.Fatalf("failed to set quota: %s", err)
+	}
+
+	q, err = m.GetNamespaceQuota("alice")
+	if err != nil {
+		t.Fatalf("failed to get quota: %s", err)
+	}
+	if q.LimitBytes != 2048 {
+		t.Errorf("unexpected limit: %d", q.LimitBytes)
+	}
+
+	err = m.SetNamespaceQuota("a/b", &Quota{LimitBytes: 1})
+	if err == nil {
+		t.Errorf("expected invalid namespace to be rejected")
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	q := &Quota{LimitBytes: 100}
+	if q.Exceeded(50, 50) {
+		t.Errorf("usage at the limit should not exceed quota")
+	}
+	if !q.Exceeded(50, 51) {
+		t.Errorf("usage over the limit should exceed quota")
+	}
+	if (&Quota{}).Exceeded(1<<40, 1) {
+		t.Errorf("unlimited quota should never be exceeded")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/coreos/etcd/client"
 
 	"github.com/dotmesh-io/dotmesh/pkg/auth"
+	"github.com/dotmesh-io/dotmesh/pkg/kv"
 	"github.com/dotmesh-io/dotmesh/pkg/types"
 	"github.com/dotmesh-io/dotmesh/pkg/user"
 
@@ -40,6 +41,7 @@ type Registry interface {
 	UnregisterFilesystem(name types.VolumeName) error
 
 	UpdateCollaborators(ctx context.Context, tlf types.TopLevelFilesystem, newCollaborators []user.SafeUser) error
+	SetBranchProtection(ctx context.Context, tlf types.TopLevelFilesystem, branch string, protection types.BranchProtection) error
 	RegisterClone(name string, topLevelFilesystemId string, clone types.Clone) error
 	RegisterFork(originFilesystemId string, originSnapshotId string, forkName types.VolumeName, forkFilesystemId string) error
 
@@ -90,11 +92,11 @@ type DefaultRegistry struct {
 	serverAddressesCache     map[string]string
 	serverAddressesCacheLock *sync.RWMutex
 
-	etcdClient client.KeysAPI
+	etcdClient kv.Store
 	prefix     string
 }
 
-func NewRegistry(um user.UserManager, etcdClient client.KeysAPI, prefix string) *DefaultRegistry {
+func NewRegistry(um user.UserManager, etcdClient kv.Store, prefix string) *DefaultRegistry {
 	return &DefaultRegistry{
 		topLevelFilesystems:     map[types.VolumeName]types.TopLevelFilesystem{},
 		clones:                  map[string]map[string]types.Clone{},
@@ -377,13 +379,55 @@ func (r *DefaultRegistry) UpdateCollaborators(ctx context.Context, tlf types.Top
 	for _, u := range newCollaborators {
 		collaboratorIds = append(collaboratorIds, u.Id)
 	}
-	rf := types.RegistryFilesystem{
+	rf := registryFilesystemFor(tlf)
+	rf.CollaboratorIds = collaboratorIds
+
+	log.Infof("updating after adding collab: %v", newCollaborators)
+	return r.updateRegistryFilesystem(tlf.MasterBranch.Name, rf)
+}
+
+// SetBranchProtection - replaces the protection rules of one of a dot's
+// branches ("" or "master" for the master branch), a zero protection
+// removing them
+func (r *DefaultRegistry) SetBranchProtection(ctx context.Context, tlf types.TopLevelFilesystem, branch string, protection types.BranchProtection) error {
+	rf := registryFilesystemFor(tlf)
+	protections := map[string]types.BranchProtection{}
+	for b, p := range tlf.Protections {
+		protections[b] = p
+	}
+	branch = types.ProtectionBranchName(branch)
+	if protection.IsZero() {
+		delete(protections, branch)
+	} else {
+		protections[branch] = protection
+	}
+	rf.Protections = protections
+	if len(protections) == 0 {
+		rf.Protections = nil
+	}
+	return r.updateRegistryFilesystem(tlf.MasterBranch.Name, rf)
+}
+
+// registryFilesystemFor - what's stored in etcd for an existing dot, to be
+// updated
+func registryFilesystemFor(tlf types.TopLevelFilesystem) types.RegistryFilesystem {
+	collaboratorIds := []string{}
+	for _, u := range tlf.Collaborators {
+		collaboratorIds = append(collaboratorIds, u.Id)
+	}
+	return types.RegistryFilesystem{
 		Id: tlf.MasterBranch.Id,
 		// Owner is, for now, always the authenticated user at the time of
 		// creation
-		OwnerId:         tlf.Owner.Id,
-		CollaboratorIds: collaboratorIds,
+		OwnerId:              tlf.Owner.Id,
+		ForkParentId:         tlf.ForkParentId,
+		ForkParentSnapshotId: tlf.ForkParentSnapshotId,
+		CollaboratorIds:      collaboratorIds,
+		Protections:          tlf.Protections,
 	}
+}
+
+func (r *DefaultRegistry) updateRegistryFilesystem(name types.VolumeName, rf types.RegistryFilesystem) error {
 	serialized, err := json.Marshal(rf)
 	if err != nil {
 		return err
@@ -393,23 +437,21 @@ func (r *DefaultRegistry) UpdateCollaborators(ctx context.Context, tlf types.Top
 		context.Background(),
 		// (0)/(1)dotmesh.io/(2)registry/(3)filesystems/(4)<namespace>/(5)<name> =>
 		//     {"Uuid": "<fs-uuid>"}
-		fmt.Sprintf("%s/registry/filesystems/%s/%s", r.prefix, tlf.MasterBranch.Name.Namespace, tlf.MasterBranch.Name.Name),
+		fmt.Sprintf("%s/registry/filesystems/%s/%s", r.prefix, name.Namespace, name.Name),
 		string(serialized),
 		// allow (and require) update over existing.
 		&client.SetOptions{PrevExist: client.PrevExist},
 	)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"path:": fmt.Sprintf("%s/registry/filesystems/%s/%s", r.prefix, tlf.MasterBranch.Name.Namespace, tlf.MasterBranch.Name.Name),
+			"path:": fmt.Sprintf("%s/registry/filesystems/%s/%s", r.prefix, name.Namespace, name.Name),
 			"error": err,
 		}).Error("failed to update registry filesystems")
 		return err
 	}
 	// Only update our local belief system once the write to etcd has been
 	// successful!
-
-	log.Infof("updating after adding collab: %v", newCollaborators)
-	return r.UpdateFilesystemFromEtcd(tlf.MasterBranch.Name, rf)
+	return r.UpdateFilesystemFromEtcd(name, rf)
 }
 
 // update a clone, including updating our local record and etcd
@@ -497,6 +539,7 @@ func (r *DefaultRegistry) UpdateFilesystemFromEtcd(name types.VolumeName, rf typ
 			Collaborators:        collaborators,
 			ForkParentId:         rf.ForkParentId,
 			ForkParentSnapshotId: rf.ForkParentSnapshotId,
+			Protections:          rf.Protections,
 		}
 	}
 	return nil
@@ -130,6 +130,83 @@ func TestUpdateCollaborators(t *testing.T) {
 	}
 }
 
+func TestSetBranchProtection(t *testing.T) {
+	etcdClient, teardown, err := testutil.GetEtcdClient()
+	if err != nil {
+		t.Fatalf("failed to get etcd client: %s", err)
+	}
+	defer teardown()
+	kvClient := kv.New(etcdClient, TestPrefix)
+	um := user.New(kvClient)
+	registry := NewRegistry(um, etcdClient, TestPrefix)
+
+	userA, err := um.New("foo", "foo@bar.pub", "verysecret")
+	if err != nil {
+		t.Fatalf("failed to create new user: %s", err)
+	}
+	userCollaborator, err := um.New("coo", "coo@bar.pub", "verysecret")
+	if err != nil {
+		t.Fatalf("failed to create new user: %s", err)
+	}
+
+	ctx := auth.SetAuthenticationDetailsCtx(context.Background(), userA, user.AuthenticationTypePassword)
+	name := types.VolumeName{Namespace: "def", Name: "n"}
+
+	err = registry.RegisterFilesystem(ctx, name, "id-1")
+	if err != nil {
+		t.Fatalf("failed to register filesystem: %s", err)
+	}
+	tlf, err := registry.GetByName(name)
+	if err != nil {
+		t.Fatalf("failed to get tlf by name: %s", err)
+	}
+
+	protection := types.BranchProtection{NoRollback: true, RequiredMetadata: []string{"ticket"}}
+	err = registry.SetBranchProtection(ctx, tlf, "", protection)
+	if err != nil {
+		t.Fatalf("failed to set branch protection: %s", err)
+	}
+	tlf, err = registry.GetByName(name)
+	if err != nil {
+		t.Fatalf("failed to get tlf by name: %s", err)
+	}
+	if !tlf.Protection("master").NoRollback || len(tlf.Protection("").RequiredMetadata) != 1 {
+		t.Errorf("expected master to be protected, got: %+v", tlf.Protections)
+	}
+
+	// protections survive collaborators being changed
+	err = registry.UpdateCollaborators(ctx, tlf, []user.SafeUser{userCollaborator.SafeUser()})
+	if err != nil {
+		t.Fatalf("failed to add collaborator to tlf: %s", err)
+	}
+	tlf, err = registry.GetByName(name)
+	if err != nil {
+		t.Fatalf("failed to get tlf by name: %s", err)
+	}
+	if !tlf.Protection("master").NoRollback {
+		t.Errorf("expected master to still be protected after adding a collaborator, got: %+v", tlf.Protections)
+	}
+	if len(tlf.Collaborators) != 1 {
+		t.Errorf("expected to find 1 collaborator, got: %+v", tlf.Collaborators)
+	}
+
+	// and a zero protection removes them
+	err = registry.SetBranchProtection(ctx, tlf, "master", types.BranchProtection{})
+	if err != nil {
+		t.Fatalf("failed to remove branch protection: %s", err)
+	}
+	tlf, err = registry.GetByName(name)
+	if err != nil {
+		t.Fatalf("failed to get tlf by name: %s", err)
+	}
+	if len(tlf.Protections) != 0 {
+		t.Errorf("expected no protections, got: %+v", tlf.Protections)
+	}
+	if len(tlf.Collaborators) != 1 {
+		t.Errorf("expected to still find 1 collaborator, got: %+v", tlf.Collaborators)
+	}
+}
+
 func TestDumpInternalState(t *testing.T) {
 	etcdClient, teardown, err := testutil.GetEtcdClient()
 	if err != nil {
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/coreos/etcd/embed"
 	"github.com/nu7hatch/gouuid"
 )
@@ -101,6 +102,28 @@ func GetEtcdClient() (client.KeysAPI, func(), error) {
 
 }
 
+// GetEtcdV3Client - same as GetEtcdClient but returns a client for the etcd v3 API
+func GetEtcdV3Client() (*clientv3.Client, func(), error) {
+
+	port, teardown, err := newTestServer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{fmt.Sprintf("http://localhost:%d", port)},
+		DialTimeout: time.Second * 10,
+	})
+	if err != nil {
+		teardown()
+		return nil, nil, err
+	}
+	return etcdClient, func() {
+		etcdClient.Close()
+		teardown()
+	}, nil
+}
+
 func GetTestPrefix() string {
 	id, err := uuid.NewV4()
 	if err != nil {
@@ -0,0 +1,255 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	zipkin "github.com/openzipkin/zipkin-go-opentracing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// OTLPBatchSize - how many spans the OTLP recorder sends at once, at most
+	OTLPBatchSize = 512
+	// OTLPFlushInterval - how often the OTLP recorder sends the spans it has
+	OTLPFlushInterval = 5 * time.Second
+)
+
+// OTLPRecorder - records spans by exporting them to an OpenTelemetry
+// collector with OTLP over HTTP, using the JSON encoding, in batches.
+type OTLPRecorder struct {
+	url         string
+	serviceName string
+	client      *http.Client
+
+	mu    sync.Mutex
+	spans []zipkin.RawSpan
+	flush chan struct{}
+}
+
+// NewOTLPRecorder - a recorder exporting spans to the collector at endpoint
+// (e.g. http://collector:4318), and a background loop sending them that
+// runs until the process exits
+func NewOTLPRecorder(endpoint, serviceName string) *OTLPRecorder {
+	r := &OTLPRecorder{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		flush:       make(chan struct{}, 1),
+	}
+	go r.run()
+	return r
+}
+
+// RecordSpan implements zipkin.SpanRecorder
+func (r *OTLPRecorder) RecordSpan(span zipkin.RawSpan) {
+	if !span.Context.Sampled {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// drop spans rather than grow without bound if the collector is down
+	if len(r.spans) >= 8*OTLPBatchSize {
+		return
+	}
+	r.spans = append(r.spans, span)
+	if len(r.spans) >= OTLPBatchSize {
+		select {
+		case r.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *OTLPRecorder) run() {
+	ticker := time.NewTicker(OTLPFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.flush:
+		}
+		err := r.Flush()
+		if err != nil {
+			log.Warnf("[OTLPRecorder] failed to export spans: %s", err)
+		}
+	}
+}
+
+// Flush - sends the spans recorded so far
+func (r *OTLPRecorder) Flush() error {
+	for {
+		r.mu.Lock()
+		batch := r.spans
+		if len(batch) > OTLPBatchSize {
+			batch = batch[:OTLPBatchSize]
+		}
+		r.spans = r.spans[len(batch):]
+		r.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+
+		body, err := encodeOTLP(r.serviceName, batch)
+		if err != nil {
+			return err
+		}
+		resp, err := r.client.Post(r.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s responded with %s", r.url, resp.Status)
+		}
+	}
+}
+
+// The OTLP JSON encoding of an ExportTraceServiceRequest, as much of it as
+// we use. IDs are hex, and 64-bit integers are strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// OTLP span kinds and status codes
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpKindClient   = 3
+
+	otlpStatusError = 2
+)
+
+func encodeOTLP(serviceName string, spans []zipkin.RawSpan) ([]byte, error) {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceId:           fmt.Sprintf("%016x%016x", span.Context.TraceID.High, span.Context.TraceID.Low),
+			SpanId:            fmt.Sprintf("%016x", span.Context.SpanID),
+			Name:              span.Operation,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.Start.Add(span.Duration).UnixNano(), 10),
+		}
+		if span.Context.ParentSpanID != nil {
+			s.ParentSpanId = fmt.Sprintf("%016x", *span.Context.ParentSpanID)
+		}
+		for key, value := range span.Tags {
+			switch {
+			case key == "span.kind" && fmt.Sprint(value) == "server":
+				s.Kind = otlpKindServer
+			case key == "span.kind" && fmt.Sprint(value) == "client":
+				s.Kind = otlpKindClient
+			case key == "error":
+				s.Status = otlpStatus{Code: otlpStatusError}
+				if message, ok := value.(string); ok {
+					s.Status.Message = message
+				}
+			default:
+				s.Attributes = append(s.Attributes, otlpAttr(key, value))
+			}
+		}
+		for _, record := range span.Logs {
+			event := otlpEvent{
+				TimeUnixNano: strconv.FormatInt(record.Timestamp.UnixNano(), 10),
+				Name:         "log",
+			}
+			for _, field := range record.Fields {
+				if field.Key() == "event" {
+					event.Name = fmt.Sprint(field.Value())
+					continue
+				}
+				event.Attributes = append(event.Attributes, otlpAttr(field.Key(), field.Value()))
+			}
+			s.Events = append(s.Events, event)
+		}
+		encoded = append(encoded, s)
+	}
+	return json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: []otlpAttribute{otlpAttr("service.name", serviceName)}},
+			ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "dotmesh"}, Spans: encoded}},
+		}},
+	})
+}
+
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch value := value.(type) {
+	case bool:
+		v.BoolValue = &value
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		i := fmt.Sprint(value)
+		v.IntValue = &i
+	case float32:
+		f := float64(value)
+		v.DoubleValue = &f
+	case float64:
+		v.DoubleValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	zipkin "github.com/openzipkin/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go-opentracing/types"
+)
+
+func TestOTLPRecorderFlush(t *testing.T) {
+	received := make(chan otlpRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("expected a POST to /v1/traces, got %s", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var req otlpRequest
+		err := json.Unmarshal(body, &req)
+		if err != nil {
+			t.Errorf("failed to decode %s: %s", body, err)
+		}
+		received <- req
+	}))
+	defer server.Close()
+
+	recorder := NewOTLPRecorder(server.URL, "dotmesh-test")
+	parent := uint64(0x1234)
+	recorder.RecordSpan(zipkin.RawSpan{
+		Context: zipkin.SpanContext{
+			TraceID:      types.TraceID{High: 1, Low: 2},
+			SpanID:       0xabcd,
+			ParentSpanID: &parent,
+			Sampled:      true,
+		},
+		Operation: "fsm.transfer",
+		Start:     time.Unix(1, 0),
+		Duration:  time.Second,
+		Tags:      map[string]interface{}{"span.kind": "server", "event": "transfer"},
+	})
+	recorder.RecordSpan(zipkin.RawSpan{
+		Context:   zipkin.SpanContext{TraceID: types.TraceID{Low: 3}, SpanID: 1},
+		Operation: "unsampled",
+	})
+	err := recorder.Flush()
+	if err != nil {
+		t.Fatalf("failed to flush: %s", err)
+	}
+
+	req := <-received
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	attrs := req.ResourceSpans[0].Resource.Attributes
+	if len(attrs) != 1 || attrs[0].Key != "service.name" || *attrs[0].Value.StringValue != "dotmesh-test" {
+		t.Errorf("unexpected resource attributes %+v", attrs)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatalf("expected just the sampled span, got %+v", spans)
+	}
+	s := spans[0]
+	if s.TraceId != "00000000000000010000000000000002" {
+		t.Errorf("unexpected trace id %s", s.TraceId)
+	}
+	if s.SpanId != "000000000000abcd" || s.ParentSpanId != "0000000000001234" {
+		t.Errorf("unexpected span ids %s, %s", s.SpanId, s.ParentSpanId)
+	}
+	if s.Kind != otlpKindServer {
+		t.Errorf("expected a server span, got kind %d", s.Kind)
+	}
+	if s.StartTimeUnixNano != "1000000000" || s.EndTimeUnixNano != "2000000000" {
+		t.Errorf("unexpected times %s, %s", s.StartTimeUnixNano, s.EndTimeUnixNano)
+	}
+}
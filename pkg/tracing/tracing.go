@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"net/http"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	zipkin "github.com/openzipkin/zipkin-go-opentracing"
+	"golang.org/x/net/context"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+// NewTracer - an OpenTracing tracer recording spans with recorder, set up
+// the same way on every node so that trace IDs carry across clusters
+func NewTracer(recorder zipkin.SpanRecorder) (opentracing.Tracer, error) {
+	return zipkin.NewTracer(
+		recorder,
+		zipkin.ClientServerSameSpan(true),
+		zipkin.TraceID128Bit(true),
+	)
+}
+
+// NewInMemoryTracer - a tracer that keeps the spans it finishes in memory,
+// for tests
+func NewInMemoryTracer() (opentracing.Tracer, *zipkin.InMemorySpanRecorder, error) {
+	recorder := zipkin.NewInMemoryRecorder()
+	tracer, err := NewTracer(recorder)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tracer, recorder, nil
+}
+
+// InjectEvent - records the trace context of the span in ctx, if there is
+// one, on an event, so that it survives being serialised to etcd or NATS and
+// handled by a state machine on another node
+func InjectEvent(ctx context.Context, e *types.Event) error {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return nil
+	}
+	carrier := opentracing.TextMapCarrier{}
+	err := span.Tracer().Inject(span.Context(), opentracing.TextMap, carrier)
+	if err != nil {
+		return err
+	}
+	e.Trace = map[string]string(carrier)
+	return nil
+}
+
+// InjectHTTP - records the trace context of the span in ctx, if there is one,
+// in the headers of a request to another node or cluster, so that the span
+// handling it there carries on the same trace
+func InjectHTTP(ctx context.Context, req *http.Request) error {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return nil
+	}
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	return span.Tracer().Inject(
+		span.Context(),
+		opentracing.HTTPHeaders,
+		opentracing.HTTPHeadersCarrier(req.Header),
+	)
+}
+
+// StartSpanFromEvent - starts a span for handling an event, as a child of
+// the span that submitted it if InjectEvent recorded one, and returns it
+// along with a context holding it
+func StartSpanFromEvent(e *types.Event, operationName string) (opentracing.Span, context.Context) {
+	tracer := opentracing.GlobalTracer()
+	var opts []opentracing.StartSpanOption
+	if len(e.Trace) > 0 {
+		parent, err := tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier(e.Trace))
+		if err == nil {
+			opts = append(opts, opentracing.ChildOf(parent))
+		}
+	}
+	span := tracer.StartSpan(operationName, opts...)
+	span.SetTag("event", e.Name)
+	if e.FilesystemID != "" {
+		span.SetTag("filesystemId", e.FilesystemID)
+	}
+	return span, opentracing.ContextWithSpan(context.Background(), span)
+}
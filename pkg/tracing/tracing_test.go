@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"golang.org/x/net/context"
+
+	"github.com/dotmesh-io/dotmesh/pkg/types"
+)
+
+func TestEventCarriesTraceAcrossJSON(t *testing.T) {
+	tracer, recorder, err := NewInMemoryTracer()
+	if err != nil {
+		t.Fatalf("failed to create tracer: %s", err)
+	}
+	opentracing.InitGlobalTracer(tracer)
+	defer opentracing.InitGlobalTracer(opentracing.NoopTracer{})
+
+	parent, ctx := opentracing.StartSpanFromContext(context.Background(), "rpc")
+	e := &types.Event{Name: "transfer", FilesystemID: "fs1"}
+	err = InjectEvent(ctx, e)
+	if err != nil {
+		t.Fatalf("failed to inject trace: %s", err)
+	}
+	parent.Finish()
+
+	// as if the event were sent to another node
+	bts, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received types.Event
+	err = json.Unmarshal(bts, &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, _ := StartSpanFromEvent(&received, "fsm.transfer")
+	child.Finish()
+
+	spans := recorder.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	p, c := spans[0], spans[1]
+	if c.Context.TraceID != p.Context.TraceID {
+		t.Errorf("expected trace %v, got %v", p.Context.TraceID, c.Context.TraceID)
+	}
+	if c.Context.ParentSpanID == nil || *c.Context.ParentSpanID != p.Context.SpanID {
+		t.Errorf("expected parent span %v, got %v", p.Context.SpanID, c.Context.ParentSpanID)
+	}
+	if c.Tags["filesystemId"] != "fs1" {
+		t.Errorf("expected filesystemId tag fs1, got %v", c.Tags["filesystemId"])
+	}
+}
+
+func TestInjectEventWithoutSpan(t *testing.T) {
+	e := &types.Event{Name: "transfer"}
+	err := InjectEvent(context.Background(), e)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if e.Trace != nil {
+		t.Errorf("expected no trace, got %v", e.Trace)
+	}
+}
+
+func TestRequestCarriesTraceAcrossHTTP(t *testing.T) {
+	tracer, recorder, err := NewInMemoryTracer()
+	if err != nil {
+		t.Fatalf("failed to create tracer: %s", err)
+	}
+
+	// as the receiving cluster would, carry on the trace in the headers
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+		if err != nil {
+			t.Errorf("failed to extract trace: %s", err)
+			return
+		}
+		tracer.StartSpan("zfs-receiver", opentracing.ChildOf(parent)).Finish()
+	}))
+	defer server.Close()
+
+	parent := tracer.StartSpan("fsm.transfer")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	req, err := http.NewRequest("POST", server.URL+"/filesystems/fs1/START/s1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InjectHTTP(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to inject trace: %s", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	parent.Finish()
+
+	spans := recorder.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c.Context.TraceID != p.Context.TraceID {
+		t.Errorf("expected trace %v, got %v", p.Context.TraceID, c.Context.TraceID)
+	}
+	if c.Context.ParentSpanID == nil || *c.Context.ParentSpanID != p.Context.SpanID {
+		t.Errorf("expected parent span %v, got %v", p.Context.SpanID, c.Context.ParentSpanID)
+	}
+	if p.Tags["http.method"] != "POST" {
+		t.Errorf("expected http.method tag POST, got %v", p.Tags["http.method"])
+	}
+}
+
+func TestInjectHTTPWithoutSpan(t *testing.T) {
+	req := httptest.NewRequest("GET", "/filesystems/fs1/START/s1", nil)
+	err := InjectHTTP(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(req.Header) != 0 {
+		t.Errorf("expected no headers, got %v", req.Header)
+	}
+}
@@ -0,0 +1,40 @@
+package types
+
+const (
+	// ChangeRequestOpen - waiting to be reviewed and accepted or closed
+	ChangeRequestOpen = "open"
+	// ChangeRequestAccepted - the fork's commits have been merged into the
+	// dot it was forked from
+	ChangeRequestAccepted = "accepted"
+	// ChangeRequestClosed - closed without being accepted
+	ChangeRequestClosed = "closed"
+)
+
+// ChangeRequest - a proposal to merge the commits on a fork back into the
+// dot it was forked from
+type ChangeRequest struct {
+	Id string
+	// FilesystemId - the top level filesystem ID of the dot the change is
+	// proposed to
+	FilesystemId string
+	// ForkId - the top level filesystem ID of the fork the commits are on
+	ForkId string
+	// CommitId - the commit on the fork the dot would be brought up to
+	CommitId    string
+	Title       string
+	Description string `json:",omitempty"`
+	AuthorId    string
+	State       string
+	Reviews     []ChangeRequestReview `json:",omitempty"`
+	// Created and Updated are unix timestamps
+	Created int64
+	Updated int64
+}
+
+// ChangeRequestReview - a reviewer's verdict on a change request
+type ChangeRequestReview struct {
+	ReviewerId string
+	Approved   bool
+	Comment    string `json:",omitempty"`
+	Timestamp  int64
+}
@@ -22,6 +22,9 @@ type EventType int
 const (
 	EventTypeRequest EventType = iota
 	EventTypeResponse
+	// EventTypeActivity - an EventNotification about a dot, for clients
+	// streaming activity
+	EventTypeActivity
 )
 
 type Event struct {
@@ -30,6 +33,9 @@ type Event struct {
 	FilesystemID string
 	Type         EventType
 	Args         *EventArgs
+	// Trace - the OpenTracing context of the request that submitted the
+	// event, if it was traced
+	Trace map[string]string `json:",omitempty"`
 }
 
 func (e Event) String() string {
@@ -9,6 +9,8 @@ type InputFile struct {
 	Filename string
 	Contents io.Reader
 	User     string
+	// Metadata - extra metadata for the commit the upload makes
+	Metadata Metadata
 	Response chan *Event
 }
 
@@ -0,0 +1,55 @@
+package types
+
+const (
+	// HookStagePreCommit - hooks run before a commit is taken, the candidate
+	// state is the dot's current data
+	HookStagePreCommit = "pre-commit"
+	// HookStagePrePush - hooks run before a push leaves this cluster, the
+	// candidate state is the commit being pushed
+	HookStagePrePush = "pre-push"
+)
+
+// HookStages - stages policy hooks can be configured for
+var HookStages = []string{HookStagePreCommit, HookStagePrePush}
+
+// PolicyHook - a check run on the master node of a dot before a commit or
+// push, which can reject it. Exactly one of URL or Command is set.
+type PolicyHook struct {
+	Id string
+	// FilesystemId - the dot's top level filesystem ID, hooks apply to all of
+	// its branches
+	FilesystemId string
+	Stage        string
+	// URL - the request is POSTed here, any 2xx response allows it
+	URL string `json:",omitempty"`
+	// Command - executable and arguments, run on the node with the request
+	// on stdin, a zero exit status allows it
+	Command []string `json:",omitempty"`
+	// TimeoutSeconds - after which the hook is considered to have rejected
+	// the request, defaults to 60 and can be at most 120
+	TimeoutSeconds int `json:",omitempty"`
+}
+
+// HookRequest - what a policy hook is asked to check
+type HookRequest struct {
+	Stage        string
+	Namespace    string
+	Name         string
+	Branch       string
+	FilesystemId string
+	// CommitId - the commit being pushed, or for pre-commit hooks the one
+	// that's made if they allow it
+	CommitId string `json:",omitempty"`
+	Metadata Metadata
+	// MountPath - read-only mount of the candidate state, only meaningful to
+	// hooks running on the same node, i.e. executables
+	MountPath string
+	// Peer - the cluster being pushed to, for pre-push hooks
+	Peer string `json:",omitempty"`
+}
+
+// HookResponse - optional JSON body of a URL hook's response, a plain text
+// body is used as the message as-is
+type HookResponse struct {
+	Message string
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // CommitNotification - is used by dotmesh server to send notifications
 // about new commits
 type CommitNotification struct {
@@ -17,3 +19,108 @@ type CommitNotification struct {
 // NATSPublishCommitsSubject - default NATS subject when sending commit
 // notifications
 const NATSPublishCommitsSubject = "dotmesh.commits"
+
+// NATSPublishEventsSubject - default NATS subject when sending notifications
+// about other events
+const NATSPublishEventsSubject = "dotmesh.events"
+
+// types of EventNotification
+const (
+	EventTypeCommit           = "commit"
+	EventTypeBranchCreated    = "branch.created"
+	EventTypeDotDeleted       = "dot.deleted"
+	EventTypeTransferFinished = "transfer.finished"
+	EventTypeBranchFailover   = "branch.failover"
+)
+
+// types of EventNotification that are only streamed to clients watching
+// activity, as they're too frequent to send through the publishers
+const (
+	EventTypeTransferProgress = "transfer.progress"
+	EventTypeStateChanged     = "state.changed"
+)
+
+// EventTypes - the types of EventNotification sent through the
+// publishers, in the order they're documented
+var EventTypes = []string{
+	EventTypeCommit,
+	EventTypeBranchCreated,
+	EventTypeDotDeleted,
+	EventTypeTransferFinished,
+	EventTypeBranchFailover,
+}
+
+// EventNotification - is used by dotmesh server to send notifications about
+// changes to dots, commits are sent as both a CommitNotification and an
+// EventNotification of type EventTypeCommit
+type EventNotification struct {
+	Type         string
+	FilesystemId string
+	Namespace    string
+	Name         string
+	Branch       string
+	CommitId     string            `json:",omitempty"`
+	Metadata     map[string]string `json:",omitempty"`
+
+	// only set for EventTypeTransferFinished and EventTypeTransferProgress
+	Transfer *TransferNotification `json:",omitempty"`
+	// only set for EventTypeBranchFailover
+	Failover *Failover `json:",omitempty"`
+	// only set for EventTypeStateChanged, the state of the dot's master
+	// node's state machine and its status within that state
+	State  string `json:",omitempty"`
+	Status string `json:",omitempty"`
+
+	OwnerID         string
+	CollaboratorIDs []string
+}
+
+// TransferNotification - the parts of a finished transfer that are safe to
+// send to third parties
+type TransferNotification struct {
+	TransferRequestId string
+	Direction         string
+	Peer              string
+	RemoteNamespace   string
+	RemoteName        string
+	RemoteBranchName  string
+	TargetCommit      string
+	Message           string
+
+	Index              int
+	Total              int
+	Status             string
+	NanosecondsElapsed int64
+	Size               int64
+	Sent               int64
+}
+
+// Failover - a branch's master being moved off a node that died, as recorded
+// in etcd and notified
+type Failover struct {
+	FilesystemId string
+	From         string
+	To           string
+	At           time.Time
+	// commits on the dead node that the new master didn't have, which are
+	// lost unless the dead node comes back
+	MissingCommits []string
+}
+
+// Webhook - an HTTP endpoint that event notifications about a namespace, or a
+// single dot in it, are POSTed to
+type Webhook struct {
+	Id        string
+	Namespace string
+	// Name of the dot, empty for every dot in the namespace
+	Name string
+	URL  string
+	// Secret used to sign payloads, only returned when the webhook is created
+	Secret string `json:",omitempty"`
+	// Events to send, empty for all of them
+	Events []string
+	// Disabled is set after too many failed deliveries in a row, saving the
+	// webhook again re-enables it
+	Disabled bool
+	Failures int
+}
@@ -0,0 +1,162 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Branch protection rules, as named in errors
+const (
+	ProtectionNoRollback       = "no-rollback"
+	ProtectionNoDelete         = "no-delete"
+	ProtectionNoForcePush      = "no-force-push"
+	ProtectionRequiredMetadata = "required-metadata"
+)
+
+// BranchProtection - what can't be done to a branch, even by users who can
+// write to it. Kept in the registry with the dot, by branch name ("master"
+// for the master branch).
+type BranchProtection struct {
+	// NoRollback - commits can't be discarded with 'dm reset --hard'
+	NoRollback bool `json:",omitempty"`
+	// NoDelete - the branch can't be deleted, which means the dot can't be
+	// either, as branches are deleted with it
+	NoDelete bool `json:",omitempty"`
+	// NoForcePush - pushes and pulls can't discard commits the branch has
+	// diverged with (StashDivergence)
+	NoForcePush bool `json:",omitempty"`
+	// RequiredMetadata - keys new commits must have non-empty metadata for,
+	// whether they're made with 'dm commit', pushed or uploaded over S3
+	RequiredMetadata []string `json:",omitempty"`
+}
+
+// IsZero - whether p doesn't protect anything
+func (p BranchProtection) IsZero() bool {
+	return !p.NoRollback && !p.NoDelete && !p.NoForcePush && len(p.RequiredMetadata) == 0
+}
+
+// MissingMetadata - the required metadata keys meta has no value for
+func (p BranchProtection) MissingMetadata(meta Metadata) []string {
+	missing := []string{}
+	for _, key := range p.RequiredMetadata {
+		if meta[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// ProtectionBranchName - the name branch protections are kept under, which
+// is "master" for the master branch however it's referred to
+func ProtectionBranchName(branch string) string {
+	if branch == "" {
+		return "master"
+	}
+	return branch
+}
+
+// ProtectedBranchError - something was refused because of one of a branch's
+// protection rules
+type ProtectedBranchError struct {
+	Dot    VolumeName
+	Branch string
+	Rule   string
+	Reason string
+}
+
+func (e ProtectedBranchError) Error() string {
+	return fmt.Sprintf(
+		"Branch %s@%s is protected by the %s rule: %s",
+		e.Dot, e.Branch, e.Rule, e.Reason,
+	)
+}
+
+// Protection - the protection rules of one of the dot's branches, "" being
+// the master branch
+func (t TopLevelFilesystem) Protection(branch string) BranchProtection {
+	return t.Protections[ProtectionBranchName(branch)]
+}
+
+func (t TopLevelFilesystem) protectedError(branch, rule, reason string) error {
+	return ProtectedBranchError{
+		Dot:    t.MasterBranch.Name,
+		Branch: ProtectionBranchName(branch),
+		Rule:   rule,
+		Reason: reason,
+	}
+}
+
+// CheckRollback - whether commits on the branch may be rolled back
+func (t TopLevelFilesystem) CheckRollback(branch string) error {
+	if t.Protection(branch).NoRollback {
+		return t.protectedError(branch, ProtectionNoRollback, "its commits can't be rolled back")
+	}
+	return nil
+}
+
+// CheckDelete - whether the dot may be deleted, which it can't be if any of
+// its branches are protected from deletion
+func (t TopLevelFilesystem) CheckDelete() error {
+	branches := []string{}
+	for branch, p := range t.Protections {
+		if p.NoDelete {
+			branches = append(branches, branch)
+		}
+	}
+	if len(branches) == 0 {
+		return nil
+	}
+	sort.Strings(branches)
+	return t.protectedError(branches[0], ProtectionNoDelete, "it can't be deleted, and neither can its dot")
+}
+
+// CheckForcePush - whether commits on the branch may be stashed to make way
+// for diverged ones being pushed or pulled
+func (t TopLevelFilesystem) CheckForcePush(branch string) error {
+	if t.Protection(branch).NoForcePush {
+		return t.protectedError(branch, ProtectionNoForcePush, "diverged commits on it can't be stashed by a push or pull")
+	}
+	return nil
+}
+
+// CheckMetadata - whether a new commit on the branch has the metadata it
+// requires. commitId is empty for commits that haven't been made yet.
+func (t TopLevelFilesystem) CheckMetadata(branch, commitId string, meta Metadata) error {
+	missing := t.Protection(branch).MissingMetadata(meta)
+	if len(missing) == 0 {
+		return nil
+	}
+	commit := "commits"
+	if commitId != "" {
+		commit = "commit " + commitId
+	}
+	return t.protectedError(
+		branch, ProtectionRequiredMetadata,
+		fmt.Sprintf("%s must have metadata for %s", commit, strings.Join(missing, ", ")),
+	)
+}
+
+// CheckNewCommits - whether the commits a push, pull or fast-forward would
+// bring to the branch have the metadata it requires. incoming lists every
+// commit up to the one being brought, and existing the branch's commits, so
+// only those it hasn't got yet are checked.
+func (t TopLevelFilesystem) CheckNewCommits(branch string, existing []Snapshot, incoming []*Snapshot) error {
+	if len(t.Protection(branch).RequiredMetadata) == 0 {
+		return nil
+	}
+	has := map[string]bool{}
+	for _, snapshot := range existing {
+		has[snapshot.Id] = true
+	}
+	for _, snapshot := range incoming {
+		if has[snapshot.Id] {
+			continue
+		}
+		err := t.CheckMetadata(branch, snapshot.Id, snapshot.Metadata)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
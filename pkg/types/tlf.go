@@ -19,6 +19,8 @@ type TopLevelFilesystem struct {
 	Collaborators        []user.SafeUser
 	ForkParentId         string
 	ForkParentSnapshotId string
+	// Protections - branch protection rules, by branch name
+	Protections map[string]BranchProtection `json:",omitempty"`
 }
 
 func (t TopLevelFilesystem) AuthorizeOwner(ctx context.Context) (bool, error) {
@@ -3,6 +3,8 @@ package types
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/dotmesh-io/dotmesh/pkg/user"
 )
@@ -32,6 +34,36 @@ type Server struct {
 
 type ByAddress []Server
 
+// NodeStatus - what needs to be dealt with before a node can be taken out of
+// service: the branches it's the master of, and the transfers it's running
+type NodeStatus struct {
+	Id               string
+	Masters          []string // filesystem ids
+	RunningTransfers []string // transfer ids
+	// Cordoned nodes don't procure dots or have masters moved to them
+	Cordoned bool
+}
+
+// ClusterCA - a CA being rotated to, published by the node that made it so
+// the others can trust it too, PEM encoded
+type ClusterCA struct {
+	Cert string
+	Key  string
+}
+
+type MoveBranchMasterRequest struct {
+	FilesystemId string
+	// Candidates are the nodes the master may be moved to, the one with
+	// the most of the branch's commits is picked. Any node but the current
+	// master if empty.
+	Candidates []string
+	// Force sets the master in etcd when the handoff fails, as long as the
+	// target has every commit and nothing is uncommitted on the current
+	// master. Anything written since the last commit is lost if that's
+	// out of date, so it's only for masters that can't hand off.
+	Force bool
+}
+
 type DotmeshVolumeAndContainers struct {
 	Volume     DotmeshVolume
 	Containers []DockerContainer
@@ -70,6 +102,59 @@ type Snapshot struct {
 	// Filesystem *Filesystem
 }
 
+// CommitQuery - which of a branch's commits to list, oldest first, a page
+// at a time. Every predicate given has to match.
+type CommitQuery struct {
+	// After is the cursor: the Next of the previous page, or "" to start
+	// from the first commit
+	After string
+	// Limit is the most commits to return, DefaultCommitPageSize if 0
+	Limit int
+	// Since and Until bound the commits' timestamps, in nanoseconds since
+	// the epoch, inclusively; 0 leaves them unbounded
+	Since int64
+	Until int64
+	// Author matches commits whose author contains it
+	Author string
+	// Metadata matches commits with all of these metadata values
+	Metadata Metadata
+}
+
+// CommitPage - a page of commits matching a CommitQuery
+type CommitPage struct {
+	Commits []Snapshot
+	// Next is the cursor for the next page, or "" if this is the last
+	Next string
+}
+
+const (
+	DefaultCommitPageSize = 100
+	MaxCommitPageSize     = 1000
+)
+
+// Matches - whether a commit matches all of the query's predicates (After
+// and Limit aren't predicates)
+func (q CommitQuery) Matches(s *Snapshot) bool {
+	if q.Since != 0 || q.Until != 0 {
+		t, _ := strconv.ParseInt(s.Metadata["timestamp"], 10, 64)
+		if q.Since != 0 && t < q.Since {
+			return false
+		}
+		if q.Until != 0 && t > q.Until {
+			return false
+		}
+	}
+	if q.Author != "" && !strings.Contains(s.Metadata["author"], q.Author) {
+		return false
+	}
+	for k, v := range q.Metadata {
+		if s.Metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *Snapshot) DeepCopy() *Snapshot {
 	c := new(Snapshot)
 	*c = *s
@@ -139,6 +224,22 @@ type TransferRequest struct {
 	// TODO could also include SourceSnapshot here
 	TargetCommit    string // optional, "" means "latest"
 	StashDivergence bool
+	// how to check the peer's certificate, nil if it doesn't serve TLS
+	TLS *RemoteTLS `json:",omitempty"`
+}
+
+// RemoteTLS - how to verify the certificate of a server serving TLS. With
+// neither field set, it must be signed by a CA the system trusts
+type RemoteTLS struct {
+	// PEM encoded CAs to trust as well as the system's
+	CACert string `json:",omitempty"`
+	// if set, the SHA-256 fingerprint the server's certificate must have,
+	// whoever signed it, and CACert isn't used
+	Fingerprint string `json:",omitempty"`
+}
+
+func (t RemoteTLS) String() string {
+	return fmt.Sprintf("{CACert=%t Fingerprint=%s}", t.CACert != "", t.Fingerprint)
 }
 
 func (transferRequest TransferRequest) String() string {
@@ -179,12 +280,17 @@ type RegistryFilesystem struct {
 	ForkParentId         string `json:",omitempty"`
 	ForkParentSnapshotId string `json:",omitempty"`
 	CollaboratorIds      []string
+	Protections          map[string]BranchProtection `json:",omitempty"`
 }
 
 const EtcdPrefix = "/dotmesh.io"
 
 const RootFS = "dmfs"
 
+// PushSizeHeader - the header a push sends its predicted size in, so that the
+// receiving end can check it against quotas before it starts
+const PushSizeHeader = "X-Dotmesh-Push-Size"
+
 const MetaKeyPrefix = "io.dotmesh:meta-"
 
 // BufLength - every 128kb of data transferred through a replication, etcd is updated with the
@@ -200,8 +306,9 @@ type Prelude struct {
 }
 
 const (
-	EventNameSaveFailed  = "save-failed"
-	EventNameSaveSuccess = "save-success"
-	EventNameReadFailed  = "read-failed"
-	EventNameReadSuccess = "read-success"
+	EventNameSaveFailed   = "save-failed"
+	EventNameSaveRejected = "save-rejected"
+	EventNameSaveSuccess  = "save-success"
+	EventNameReadFailed   = "read-failed"
+	EventNameReadSuccess  = "read-success"
 )
@@ -19,3 +19,108 @@ func TestSnapshotCopy(t *testing.T) {
 		t.Errorf("snapshot deepcopy failed")
 	}
 }
+
+func TestCommitQueryMatches(t *testing.T) {
+	s := &Snapshot{
+		Id: "123",
+		Metadata: Metadata{
+			"author":    "alice@example.com",
+			"timestamp": "2000",
+			"env":       "staging",
+		},
+	}
+
+	for _, tc := range []struct {
+		query    CommitQuery
+		expected bool
+	}{
+		{CommitQuery{}, true},
+		{CommitQuery{Author: "alice"}, true},
+		{CommitQuery{Author: "bob"}, false},
+		{CommitQuery{Since: 2000, Until: 2000}, true},
+		{CommitQuery{Since: 2001}, false},
+		{CommitQuery{Until: 1999}, false},
+		{CommitQuery{Metadata: Metadata{"env": "staging"}}, true},
+		{CommitQuery{Metadata: Metadata{"env": "production"}}, false},
+		{CommitQuery{Metadata: Metadata{"env": "staging", "tier": "1"}}, false},
+	} {
+		if tc.query.Matches(s) != tc.expected {
+			t.Errorf("expected %#v matching to be %v", tc.query, tc.expected)
+		}
+	}
+}
+
+func TestBranchProtectionChecks(t *testing.T) {
+	tlf := TopLevelFilesystem{
+		MasterBranch: DotmeshVolume{Name: VolumeName{Namespace: "admin", Name: "db"}},
+		Protections: map[string]BranchProtection{
+			"master":  {NoRollback: true, NoForcePush: true, RequiredMetadata: []string{"ticket", "reviewer"}},
+			"release": {NoDelete: true},
+		},
+	}
+
+	err := tlf.CheckRollback("")
+	if e, ok := err.(ProtectedBranchError); !ok || e.Rule != ProtectionNoRollback || e.Branch != "master" {
+		t.Errorf("expected rollback of master to be refused, got %v", err)
+	}
+	if err := tlf.CheckRollback("release"); err != nil {
+		t.Errorf("expected rollback of release to be allowed, got %v", err)
+	}
+	if err := tlf.CheckForcePush("master"); err == nil {
+		t.Errorf("expected force push to master to be refused")
+	}
+	if err := tlf.CheckForcePush("feature"); err != nil {
+		t.Errorf("expected force push to an unprotected branch to be allowed, got %v", err)
+	}
+
+	err = tlf.CheckDelete()
+	if e, ok := err.(ProtectedBranchError); !ok || e.Rule != ProtectionNoDelete || e.Branch != "release" {
+		t.Errorf("expected delete to be refused because of release, got %v", err)
+	}
+
+	err = tlf.CheckMetadata("", "abc", Metadata{"ticket": "DM-1", "reviewer": ""})
+	expected := "Branch admin/db@master is protected by the required-metadata rule: commit abc must have metadata for reviewer"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+	if err := tlf.CheckMetadata("", "", Metadata{"ticket": "DM-1", "reviewer": "bob"}); err != nil {
+		t.Errorf("expected complete metadata to be allowed, got %v", err)
+	}
+}
+
+func TestCheckNewCommits(t *testing.T) {
+	tlf := TopLevelFilesystem{
+		MasterBranch: DotmeshVolume{Name: VolumeName{Namespace: "admin", Name: "db"}},
+		Protections: map[string]BranchProtection{
+			"master": {RequiredMetadata: []string{"ticket"}},
+		},
+	}
+	existing := []Snapshot{
+		{Id: "c1", Metadata: Metadata{}},
+	}
+	tagged := &Snapshot{Id: "c2", Metadata: Metadata{"ticket": "DM-2"}}
+	untagged := &Snapshot{Id: "c3", Metadata: Metadata{}}
+
+	tests := []struct {
+		name     string
+		branch   string
+		incoming []*Snapshot
+		allowed  bool
+	}{
+		{"commits the branch already has aren't checked", "", []*Snapshot{{Id: "c1", Metadata: Metadata{}}, tagged}, true},
+		{"a new commit missing metadata", "", []*Snapshot{{Id: "c1"}, tagged, untagged}, false},
+		{"an unprotected branch", "feature", []*Snapshot{untagged}, true},
+		{"nothing new", "", []*Snapshot{}, true},
+	}
+	for _, tt := range tests {
+		err := tlf.CheckNewCommits(tt.branch, existing, tt.incoming)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: expected allowed=%t, got: %v", tt.name, tt.allowed, err)
+		}
+	}
+
+	err := tlf.CheckNewCommits("", existing, []*Snapshot{untagged})
+	if e, ok := err.(ProtectedBranchError); !ok || e.Rule != ProtectionRequiredMetadata {
+		t.Errorf("expected the required-metadata rule to be named, got %v", err)
+	}
+}
@@ -13,6 +13,39 @@ type DotmeshVolume struct {
 	ServerStatuses       map[string]string // serverId => status
 	ForkParentId         string
 	ForkParentSnapshotId string
+	// QuotaBytes is the dot's storage quota (0 if unlimited), and
+	// QuotaUsedBytes is the usage it's measured against: the total size of
+	// all of the dot's branches
+	QuotaBytes     int64
+	QuotaUsedBytes int64
+	// Origin is the commit a branch was made from (empty for master)
+	Origin Origin
+}
+
+// SpaceUsage - ZFS space accounting for a branch or commit, in bytes. Used
+// is the space that would be freed by deleting just it, Referenced the size
+// of the data it holds (shared or not), Written the data written since the
+// previous commit and LogicalUsed the size of Used before compression.
+type SpaceUsage struct {
+	Used        int64
+	Referenced  int64
+	Written     int64
+	LogicalUsed int64
+}
+
+type CommitSpaceUsage struct {
+	CommitId string
+	SpaceUsage
+}
+
+// BranchSpaceUsage - space accounting for a branch, whose own SpaceUsage
+// includes its commits and uncommitted changes, and for each of its commits,
+// oldest first
+type BranchSpaceUsage struct {
+	Branch       string
+	FilesystemId string
+	SpaceUsage
+	Commits []CommitSpaceUsage
 }
 
 type VolumeName struct {
@@ -30,6 +30,7 @@ type ZFS interface {
 	DeleteFilesystemInZFS(fs string) error
 	GetDirtyDelta(filesystemId, latestSnap string) (int64, int64, error)
 	Snapshot(filesystemId, snapshotId string, metadataEncoded []string) ([]byte, error)
+	DestroySnapshot(filesystemId, snapshotId string) ([]byte, error)
 	List(filesystemId, snapshotId string) ([]byte, error)
 	FQ(filesystemId string) string
 	DiscoverSystem(fs string) (*types.Filesystem, error)
@@ -42,8 +43,12 @@ type ZFS interface {
 	ApplyPrelude(prelude types.Prelude, fs string) error
 	Send(fromFilesystemId, fromSnapshotId, toFilesystemId, toSnapshotId string, preludeEncoded []byte) (*io.PipeReader, chan error)
 	SetCanmount(filesystemId, snapshotId string) ([]byte, error)
+	SetQuota(filesystemId string, quotaBytes int64) ([]byte, error)
+	SetReadonly(filesystemId string, readonly bool) ([]byte, error)
 	Mount(filesystemId, snapshotId string, options string, mountPath string) ([]byte, error)
 	Fork(filesystemId, latestSnapshot, forkFilesystemId string) error
+	SpaceUsage(filesystemId string) (types.SpaceUsage, []types.CommitSpaceUsage, error)
+	PredictReclaim(filesystemId, fromSnapshotId, toSnapshotId string) (int64, error)
 }
 
 type zfs struct {
@@ -131,6 +136,26 @@ func (z *zfs) SetCanmount(filesystemId, snapshotId string) ([]byte, error) {
 	return z.runOnFilesystem(filesystemId, snapshotId, []string{"set", "canmount=noauto"})
 }
 
+// SetQuota - sets refquota on the filesystem, so that snapshots don't count
+// towards it. Zero or negative quotaBytes removes the quota.
+func (z *zfs) SetQuota(filesystemId string, quotaBytes int64) ([]byte, error) {
+	refquota := "none"
+	if quotaBytes > 0 {
+		refquota = strconv.FormatInt(quotaBytes, 10)
+	}
+	return z.runOnFilesystem(filesystemId, "", []string{"set", "refquota=" + refquota})
+}
+
+// SetReadonly - makes the filesystem read-only, or writable again, which
+// takes effect on it immediately even if it's mounted
+func (z *zfs) SetReadonly(filesystemId string, readonly bool) ([]byte, error) {
+	value := "off"
+	if readonly {
+		value = "on"
+	}
+	return z.runOnFilesystem(filesystemId, "", []string{"set", "readonly=" + value})
+}
+
 func (z *zfs) Mount(filesystemId, snapshotId, options, mountPath string) ([]byte, error) {
 	fullFilesystemId := FullIdWithSnapshot(filesystemId, snapshotId)
 	zfsFullId := z.fullZFSFilesystemPath(filesystemId, snapshotId)
@@ -166,6 +191,16 @@ func (z *zfs) Snapshot(filesystemId string, snapshotId string, metadataEncoded [
 	return z.runOnFilesystem(filesystemId, snapshotId, args)
 }
 
+// DestroySnapshot - destroys a single snapshot, which mustn't be mounted or
+// have clones
+func (z *zfs) DestroySnapshot(filesystemId, snapshotId string) ([]byte, error) {
+	if snapshotId == "" {
+		// that would be the filesystem itself
+		return nil, fmt.Errorf("No snapshot of %s to destroy", filesystemId)
+	}
+	return z.runOnFilesystem(filesystemId, snapshotId, []string{"destroy"})
+}
+
 func (z *zfs) List(filesystemId, snapshotId string) ([]byte, error) {
 	return z.runOnFilesystem(filesystemId, snapshotId, []string{"list"})
 }
@@ -343,7 +378,7 @@ func (z *zfs) FindFilesystemIdsOnSystem() []string {
 			z.zfsPath, "create", "-o", "mountpoint=legacy", z.poolName+"/"+types.RootFS).CombinedOutput()
 		if err != nil {
 			utils.Out("Unable to create", z.poolName+"/"+types.RootFS, "- does ZFS pool '"+z.poolName+"' exist?\n")
-			log.Printf(string(output))
+			log.Print(string(output))
 			log.Fatal(err)
 		}
 	}
@@ -428,6 +463,149 @@ func (z *zfs) GetDirtyDelta(filesystemId, latestSnap string) (int64, int64, erro
 	return intDiff(referDataset, referLatestSnap) + usedLatestSnap, usedDataset, nil
 }
 
+// SpaceUsage - the space used by a filesystem, and by each of its snapshots
+// in the order they were taken
+func (z *zfs) SpaceUsage(filesystemId string) (types.SpaceUsage, []types.CommitSpaceUsage, error) {
+	fq := FQ(z.poolName, filesystemId)
+	o, err := exec.Command(
+		z.zfsPath, "list", "-pH", "-r", "-d", "1", "-t", "filesystem,snapshot", "-s", "createtxg",
+		"-o", "name,used,referenced,written,logicalused", fq,
+	).CombinedOutput()
+	if err != nil {
+		return types.SpaceUsage{}, nil, fmt.Errorf(
+			"'zfs list' of space used by %s errored with: %s %s", fq, err, o,
+		)
+	}
+	var usage types.SpaceUsage
+	commits := []types.CommitSpaceUsage{}
+	for _, line := range strings.Split(string(o), "\n") {
+		shrap := strings.Fields(line)
+		if len(shrap) == 0 {
+			continue
+		}
+		if len(shrap) != 5 {
+			return types.SpaceUsage{}, nil, fmt.Errorf("Unexpected 'zfs list' output: %q", line)
+		}
+		values := [4]int64{}
+		for i := range values {
+			// written is "-" for snapshots whose filesystem no longer has
+			// their predecessor
+			if shrap[i+1] == "-" {
+				continue
+			}
+			values[i], err = strconv.ParseInt(shrap[i+1], 10, 64)
+			if err != nil {
+				return types.SpaceUsage{}, nil, err
+			}
+		}
+		u := types.SpaceUsage{Used: values[0], Referenced: values[1], Written: values[2], LogicalUsed: values[3]}
+		if shrap[0] == fq {
+			usage = u
+		} else if strings.HasPrefix(shrap[0], fq+"@") {
+			commits = append(commits, types.CommitSpaceUsage{CommitId: shrap[0][len(fq)+1:], SpaceUsage: u})
+		}
+	}
+	return usage, commits, nil
+}
+
+// PredictReclaim - how many bytes would be freed by deleting the snapshots
+// fromSnapshotId to toSnapshotId inclusive or, if fromSnapshotId is empty,
+// the whole filesystem along with any clones of it
+func (z *zfs) PredictReclaim(filesystemId, fromSnapshotId, toSnapshotId string) (int64, error) {
+	if fromSnapshotId == "" {
+		return z.predictFilesystemReclaim(filesystemId)
+	}
+	args := []string{"destroy", "-nvp"}
+	if toSnapshotId == "" || toSnapshotId == fromSnapshotId {
+		args = append(args, FQ(z.poolName, filesystemId)+"@"+fromSnapshotId)
+	} else {
+		args = append(args, FQ(z.poolName, filesystemId)+"@"+fromSnapshotId+"%"+toSnapshotId)
+	}
+	o, err := exec.Command(z.zfsPath, args...).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("'zfs %s' errored with: %s %s", strings.Join(args, " "), err, o)
+	}
+	return parseDestroyReclaim(string(o))
+}
+
+// parseDestroyReclaim - the reclaim estimate in the output of
+// 'zfs destroy -nvp' of some snapshots:
+//
+//	destroy	pool/dmfs/x@a
+//	destroy	pool/dmfs/x@b
+//	reclaim	104938496
+func parseDestroyReclaim(output string) (int64, error) {
+	for _, line := range strings.Split(output, "\n") {
+		shrap := strings.Fields(line)
+		if len(shrap) == 2 && shrap[0] == "reclaim" {
+			return strconv.ParseInt(shrap[1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("No reclaim estimate in 'zfs destroy' output: %s", output)
+}
+
+// predictFilesystemReclaim - the space used by a filesystem and its
+// snapshots, and by every filesystem cloned from it, directly or not. zfs
+// destroy -nv doesn't estimate that for filesystems, only for snapshots.
+func (z *zfs) predictFilesystemReclaim(filesystemId string) (int64, error) {
+	root := z.poolName + "/" + types.RootFS
+	o, err := exec.Command(
+		z.zfsPath, "list", "-pH", "-r", "-d", "1", "-t", "filesystem", "-o", "name,used,origin", root,
+	).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("'zfs list' of filesystems in %s errored with: %s %s", root, err, o)
+	}
+	return filesystemReclaim(string(o), FQ(z.poolName, filesystemId))
+}
+
+// filesystemReclaim - given 'zfs list -pH -o name,used,origin' output, the
+// used bytes of fq and of each filesystem whose origin is a snapshot of fq or
+// of one of its clones
+func filesystemReclaim(output, fq string) (int64, error) {
+	type filesystem struct {
+		used   int64
+		origin string
+	}
+	filesystems := map[string]filesystem{}
+	for _, line := range strings.Split(output, "\n") {
+		shrap := strings.Fields(line)
+		if len(shrap) == 0 {
+			continue
+		}
+		if len(shrap) != 3 {
+			return 0, fmt.Errorf("Unexpected 'zfs list' output: %q", line)
+		}
+		used, err := strconv.ParseInt(shrap[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		origin := ""
+		if shrap[2] != "-" {
+			origin = strings.SplitN(shrap[2], "@", 2)[0]
+		}
+		filesystems[shrap[0]] = filesystem{used: used, origin: origin}
+	}
+	if _, ok := filesystems[fq]; !ok {
+		return 0, fmt.Errorf("Filesystem %s not found in 'zfs list' output", fq)
+	}
+
+	doomed := map[string]bool{fq: true}
+	for changed := true; changed; {
+		changed = false
+		for name, fs := range filesystems {
+			if !doomed[name] && doomed[fs.origin] {
+				doomed[name] = true
+				changed = true
+			}
+		}
+	}
+	var reclaim int64
+	for name := range doomed {
+		reclaim += filesystems[name].used
+	}
+	return reclaim, nil
+}
+
 func intDiff(a, b int64) int64 {
 	if a-b < 0 {
 		return b - a
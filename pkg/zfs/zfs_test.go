@@ -0,0 +1,61 @@
+package zfs
+
+import (
+	"testing"
+)
+
+// output of 'zfs destroy -nvp pool/dmfs/a1@s1%s2' on zfs 0.7
+const destroyOutput = "destroy\tpool/dmfs/a1@s1\n" +
+	"destroy\tpool/dmfs/a1@s2\n" +
+	"reclaim\t104938496\n"
+
+// output of 'zfs list -pH -r -d 1 -t filesystem -o name,used,origin pool/dmfs',
+// where b2 is a branch of a1, c3 a branch of b2, and d4 another dot
+const listOutput = "pool/dmfs\t129019904\t-\n" +
+	"pool/dmfs/a1\t20971520\t-\n" +
+	"pool/dmfs/b2\t1048576\tpool/dmfs/a1@s1\n" +
+	"pool/dmfs/c3\t524288\tpool/dmfs/b2@s2\n" +
+	"pool/dmfs/d4\t104857600\t-\n"
+
+func TestParseDestroyReclaim(t *testing.T) {
+	reclaim, err := parseDestroyReclaim(destroyOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaim != 104938496 {
+		t.Errorf("expected 104938496, got %d", reclaim)
+	}
+
+	// destroying a filesystem, rather than snapshots, gives no estimate
+	_, err = parseDestroyReclaim("destroy\tpool/dmfs/a1\n")
+	if err == nil {
+		t.Error("expected output without a reclaim line to be an error")
+	}
+}
+
+func TestFilesystemReclaim(t *testing.T) {
+	tests := []struct {
+		fq   string
+		want int64
+	}{
+		{"pool/dmfs/a1", 20971520 + 1048576 + 524288},
+		{"pool/dmfs/b2", 1048576 + 524288},
+		{"pool/dmfs/c3", 524288},
+		{"pool/dmfs/d4", 104857600},
+	}
+	for _, tt := range tests {
+		reclaim, err := filesystemReclaim(listOutput, tt.fq)
+		if err != nil {
+			t.Errorf("%s: %s", tt.fq, err)
+			continue
+		}
+		if reclaim != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.fq, tt.want, reclaim)
+		}
+	}
+
+	_, err := filesystemReclaim(listOutput, "pool/dmfs/missing")
+	if err == nil {
+		t.Error("expected a filesystem that isn't listed to be an error")
+	}
+}
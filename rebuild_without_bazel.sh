@@ -36,6 +36,7 @@ export STABLE_CI_DOCKER_SERVER_IMAGE=${STABLE_CI_DOCKER_SERVER_IMAGE:=$HOSTNAME:
 export CI_DOCKER_PROVISIONER_IMAGE=${CI_DOCKER_PROVISIONER_IMAGE:=$HOSTNAME:80/dotmesh/dotmesh-dynamic-provisioner:latest}
 export CI_DOCKER_DIND_PROVISIONER_IMAGE=${CI_DOCKER_DIND_PROVISIONER_IMAGE:=$HOSTNAME:80/dotmesh/dind-dynamic-provisioner:latest}
 export CI_DOCKER_OPERATOR_IMAGE=${CI_DOCKER_OPERATOR_IMAGE:=$HOSTNAME:80/dotmesh/dotmesh-operator:latest}
+export CI_DOCKER_CSI_IMAGE=${CI_DOCKER_CSI_IMAGE:=$HOSTNAME:80/dotmesh/dotmesh-csi:latest}
 
 export GOCACHE=`pwd`/.gocache
 
@@ -84,6 +85,21 @@ then
         docker push ${CI_DOCKER_PROVISIONER_IMAGE}
     fi
 
+    ## csi
+
+    go build -ldflags '-linkmode external -extldflags "-static"' -o ./target/csi ./cmd/csi
+
+    echo "building image: ${CI_DOCKER_CSI_IMAGE}"
+    echo 'FROM scratch' > target/Dockerfile
+    echo 'COPY ./csi /' >> target/Dockerfile
+    echo 'CMD ["/csi"]' >> target/Dockerfile
+    docker build -f target/Dockerfile -t "${CI_DOCKER_CSI_IMAGE}" target
+
+    if [ -n "${PUSH}" ]; then
+        echo "pushing image"
+        docker push ${CI_DOCKER_CSI_IMAGE}
+    fi
+
     ## yaml
 
     (cd kubernetes; ./rebuild.sh)